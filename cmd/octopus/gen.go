@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/degreane/octopus/internal/bindings"
+)
+
+// generators are the targets of `octopus gen`
+var generators = map[string]func(args []string) int{
+	"lua-defs": runGenLuaDefs,
+}
+
+// runGen implements `octopus gen <target>`
+func runGen(args []string) int {
+	if len(args) > 0 {
+		if gen, ok := generators[args[0]]; ok {
+			return gen(args[1:])
+		}
+		fmt.Fprintf(os.Stderr, "octopus gen: unknown target %q\n", args[0])
+	}
+	fmt.Fprintln(os.Stderr, "Usage: octopus gen lua-defs [-dir dir] [-lua file] [-html file] [-check]")
+	return 2
+}
+
+// runGenLuaDefs implements `octopus gen lua-defs`: it writes the EmmyLua
+// definitions and the HTML reference of the eocto table from the binding
+// registry. With -check it only reports files that are out of date.
+func runGenLuaDefs(args []string) int {
+	flags := flag.NewFlagSet("gen lua-defs", flag.ExitOnError)
+	dir := flags.String("dir", ".", "project root holding config/ and views/")
+	luaOut := flags.String("lua", "lua-definitions/eocto.lua", "EmmyLua definition file")
+	htmlOut := flags.String("html", "views/OC/pages/partials/luaDocs.html", "HTML reference partial")
+	check := flags.Bool("check", false, "fail when the files are out of date instead of writing them")
+	flags.Parse(args)
+
+	if err := chdir(*dir); err != nil {
+		fmt.Fprintf(os.Stderr, "octopus gen: %v\n", err)
+		return 1
+	}
+
+	status := 0
+	for _, target := range []struct {
+		path  string
+		write func(w io.Writer) error
+	}{{*luaOut, bindings.WriteLuaDefs}, {*htmlOut, bindings.WriteHTMLDocs}} {
+		var buf bytes.Buffer
+		if err := target.write(&buf); err != nil {
+			fmt.Fprintf(os.Stderr, "octopus gen: %s: %v\n", target.path, err)
+			return 1
+		}
+		current, _ := os.ReadFile(target.path)
+		switch {
+		case bytes.Equal(current, buf.Bytes()):
+			fmt.Printf("  %s is up to date\n", target.path)
+		case *check:
+			fmt.Printf("✗ %s is out of date, run `octopus gen lua-defs`\n", target.path)
+			status = 1
+		default:
+			if err := os.MkdirAll(filepath.Dir(target.path), 0o755); err != nil {
+				fmt.Fprintf(os.Stderr, "octopus gen: %v\n", err)
+				return 1
+			}
+			if err := os.WriteFile(target.path, buf.Bytes(), 0o644); err != nil {
+				fmt.Fprintf(os.Stderr, "octopus gen: %v\n", err)
+				return 1
+			}
+			fmt.Printf("✓ wrote %s\n", target.path)
+		}
+	}
+	return status
+}
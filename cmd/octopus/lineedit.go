@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// errInterrupt is returned by readLine when Ctrl-C is pressed
+var errInterrupt = errors.New("interrupt")
+
+// completedWord is the dotted name before the cursor that Tab completes
+var completedWord = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_.:]*$`)
+
+// lineEditor reads the lines of the repl. On a terminal it edits them in raw
+// mode with history and Tab completion; otherwise it reads plain lines.
+type lineEditor struct {
+	in       *os.File
+	out      io.Writer
+	reader   *bufio.Reader
+	terminal bool
+	history  []string
+	// complete returns full-name completions of the name ending line
+	complete func(line string) []string
+}
+
+func newLineEditor(in *os.File, out io.Writer, complete func(string) []string) *lineEditor {
+	return &lineEditor{
+		in:       in,
+		out:      out,
+		reader:   bufio.NewReader(in),
+		terminal: isTerminal(int(in.Fd())),
+		complete: complete,
+	}
+}
+
+// remember adds line to the history
+func (e *lineEditor) remember(line string) {
+	if strings.TrimSpace(line) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+}
+
+// readLine reads a line after showing prompt; it returns io.EOF at the end of
+// the input (Ctrl-D on an empty line) and errInterrupt on Ctrl-C
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	if e.terminal {
+		if restore, err := makeRaw(int(e.in.Fd())); err == nil {
+			defer restore()
+			return e.edit(prompt)
+		}
+	}
+	fmt.Fprint(e.out, prompt)
+	line, err := e.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// edit reads a line in raw mode
+func (e *lineEditor) edit(prompt string) (string, error) {
+	var buf []rune
+	pos := 0
+	index := len(e.history)
+	draft := ""
+
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r\x1b[K%s%s", prompt, string(buf))
+		if back := len(buf) - pos; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	replace := func(line string) {
+		buf = []rune(line)
+		pos = len(buf)
+		redraw()
+	}
+	redraw()
+
+	for {
+		r, _, err := e.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(buf), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupt
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 21: // Ctrl-U
+			buf, pos = buf[pos:], 0
+		case '\t':
+			buf, pos = e.completeAt(buf, pos, prompt)
+		case 27: // escape sequences: arrows, Home, End, Delete
+			if next, _, _ := e.reader.ReadRune(); next != '[' && next != 'O' {
+				continue
+			}
+			key, _, _ := e.reader.ReadRune()
+			if key >= '0' && key <= '9' {
+				e.reader.ReadRune() // the closing '~'
+			}
+			switch key {
+			case 'A':
+				if index > 0 {
+					if index == len(e.history) {
+						draft = string(buf)
+					}
+					index--
+					replace(e.history[index])
+				}
+				continue
+			case 'B':
+				if index < len(e.history) {
+					index++
+					if index == len(e.history) {
+						replace(draft)
+					} else {
+						replace(e.history[index])
+					}
+				}
+				continue
+			case 'C':
+				if pos < len(buf) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			case 'H', '1':
+				pos = 0
+			case 'F', '4':
+				pos = len(buf)
+			case '3':
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if r < ' ' {
+				continue
+			}
+			buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+			pos++
+		}
+		redraw()
+	}
+}
+
+// completeAt completes the name before pos up to the longest common prefix of
+// its completions, listing them when there are several
+func (e *lineEditor) completeAt(buf []rune, pos int, prompt string) ([]rune, int) {
+	if e.complete == nil {
+		return buf, pos
+	}
+	before := string(buf[:pos])
+	word := completedWord.FindString(before)
+	list := e.complete(before)
+	if word == "" || len(list) == 0 {
+		return buf, pos
+	}
+	common := list[0]
+	for _, item := range list[1:] {
+		for !strings.HasPrefix(item, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(common) > len(word) {
+		insert := []rune(common[len(word):])
+		buf = append(buf[:pos], append(insert, buf[pos:]...)...)
+		return buf, pos + len(insert)
+	}
+	if len(list) > 1 {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(list, "  "))
+	}
+	return buf, pos
+}
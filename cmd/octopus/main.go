@@ -0,0 +1,69 @@
+// Command octopus provides developer tooling for Octopus projects.
+//
+// Usage:
+//
+//	octopus <command> [flags]
+//
+// Commands:
+//
+//	validate   check config.yaml, modules.yaml, preCheck scripts and views
+//	gen        generate lua-definitions/eocto.lua and the Lua API docs page
+//	test       run the *_spec.lua files of the modules
+//	repl       open an interactive Lua console on a module
+//
+// Commands run from the project root (the directory holding config/ and
+// views/); use -dir to point them at another project.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// command is an octopus sub-command
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{name: "validate", summary: "check config.yaml, modules.yaml, preCheck scripts and views", run: runValidate},
+	{name: "gen", summary: "generate lua-definitions/eocto.lua and the Lua API docs page", run: runGen},
+	{name: "test", summary: "run the *_spec.lua files of the modules", run: runTest},
+	{name: "repl", summary: "open an interactive Lua console on a module", run: runRepl},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+	}
+	if os.Args[1] != "help" && os.Args[1] != "-h" && os.Args[1] != "--help" {
+		fmt.Fprintf(os.Stderr, "octopus: unknown command %q\n\n", os.Args[1])
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: octopus <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+}
+
+// chdir moves to the project root given by -dir
+func chdir(dir string) error {
+	if dir == "" || dir == "." {
+		return nil
+	}
+	return os.Chdir(dir)
+}
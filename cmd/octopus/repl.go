@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/console"
+	"github.com/degreane/octopus/internal/database"
+	"github.com/degreane/octopus/internal/middleware"
+	"github.com/degreane/octopus/internal/service/cache"
+	"github.com/degreane/octopus/internal/service/capture"
+	"github.com/degreane/octopus/internal/service/lock"
+	"github.com/degreane/octopus/internal/service/sandbox"
+	"github.com/gofiber/storage/redis/v3"
+	"github.com/joho/godotenv"
+)
+
+// historyFile keeps the lines typed in the repl, relative to the project root
+const historyFile = ".octopus/history"
+
+const replHelp = `Lua is evaluated in the module's preCheck environment; expressions print their value.
+Commands:
+  :request METHOD PATH [BODY]   bind a synthetic request
+  :header NAME VALUE            set a header of the bound request
+  :show                         show the bound request
+  :requests                     list the requests captured by the development server
+  :replay ID                    replay a captured request, pausing before each preCheck script
+  :step                         run the next script of the replay
+  :continue                     run the rest of the replay
+  :abort                        stop the replay
+  :reset                        open a fresh Lua state
+  :help                         show this help
+  :quit                         leave (or Ctrl-D)`
+
+// runRepl implements `octopus repl`: an interactive Lua console on a module,
+// with its eocto bindings reaching the configured MongoDB and Redis
+func runRepl(args []string) int {
+	flags := flag.NewFlagSet("repl", flag.ExitOnError)
+	dir := flags.String("dir", ".", "project root holding config/ and views/")
+	name := flags.String("module", "", "module to open, by name or base path (default: the first module)")
+	requests := flags.String("requests", capture.DefaultFile, "file the development server mirrors captured requests to")
+	flags.Parse(args)
+
+	if err := chdir(*dir); err != nil {
+		fmt.Fprintf(os.Stderr, "octopus repl: %v\n", err)
+		return 1
+	}
+	godotenv.Load()
+	appConfig, err := config.ParseServerConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "octopus repl: config/config.yaml: %v\n", err)
+		return 1
+	}
+	connect(appConfig)
+	modules, err := config.ParseModulesConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "octopus repl: config/modules.yaml: %v\n", err)
+		return 1
+	}
+	module, ok := findModule(modules, *name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "octopus repl: no module %q in config/modules.yaml\n", *name)
+		return 1
+	}
+
+	session := console.New(module)
+	defer func() { session.Close() }()
+	editor := newLineEditor(os.Stdin, os.Stdout, func(line string) []string { return session.Complete(line) })
+	editor.history = loadHistory()
+	history, _ := openHistory()
+	if history != nil {
+		defer history.Close()
+	}
+
+	fmt.Printf("Lua console on module %s (%s); :help lists the commands\n", module.Name, module.BasePath)
+	var pending []string
+	for {
+		prompt := "> "
+		if len(pending) > 0 {
+			prompt = ">> "
+		}
+		line, err := editor.readLine(prompt)
+		if errors.Is(err, errInterrupt) {
+			pending = nil
+			continue
+		}
+		if err != nil {
+			return 0
+		}
+		editor.remember(line)
+		if history != nil && strings.TrimSpace(line) != "" {
+			fmt.Fprintln(history, line)
+		}
+
+		if len(pending) == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			if quit := replCommand(&session, module, *requests, strings.Fields(strings.TrimSpace(line))); quit {
+				return 0
+			}
+			continue
+		}
+		pending = append(pending, line)
+		res := session.Eval(strings.Join(pending, "\n"))
+		if res.Incomplete {
+			continue
+		}
+		pending = nil
+		fmt.Print(res.Output)
+		if res.Error != "" {
+			fmt.Println(res.Error)
+		} else if len(res.Values) > 0 {
+			fmt.Println(strings.Join(res.Values, "\t"))
+		}
+	}
+}
+
+// replCommand runs a ":" command; it returns true to leave the repl
+func replCommand(session **console.Session, module config.ModulesConfig, requests string, args []string) bool {
+	s := *session
+	switch args[0] {
+	case ":quit", ":q", ":exit":
+		return true
+	case ":help", ":h":
+		fmt.Println(replHelp)
+	case ":request":
+		if len(args) < 3 {
+			fmt.Println("usage: :request METHOD PATH [BODY]")
+			break
+		}
+		req := s.Request()
+		req.Method, req.Path = strings.ToUpper(args[1]), args[2]
+		req.Body = strings.Join(args[3:], " ")
+		s.SetRequest(req)
+		printRequest(s.Request())
+	case ":header":
+		if len(args) < 3 {
+			fmt.Println("usage: :header NAME VALUE")
+			break
+		}
+		req := s.Request()
+		headers := make(map[string]string, len(req.Headers)+1)
+		for k, v := range req.Headers {
+			headers[k] = v
+		}
+		headers[args[1]] = strings.Join(args[2:], " ")
+		req.Headers = headers
+		s.SetRequest(req)
+		printRequest(s.Request())
+	case ":show":
+		printRequest(s.Request())
+	case ":requests":
+		list, err := capture.Load(requests)
+		if err != nil {
+			fmt.Printf("no captured requests (%v); enable Console in config/config.yaml and run the server\n", err)
+			break
+		}
+		for _, req := range list {
+			if req.Module == module.Name {
+				fmt.Printf("%s  %s  %-6s %s\n", req.ID, req.Time.Format("15:04:05"), req.Method, req.Path)
+			}
+		}
+	case ":replay":
+		if len(args) < 2 {
+			fmt.Println("usage: :replay ID")
+			break
+		}
+		list, err := capture.Load(requests)
+		if err != nil {
+			fmt.Println(err)
+			break
+		}
+		req, ok := capture.Find(list, args[1])
+		if !ok || req.Module != module.Name {
+			fmt.Printf("no captured request %q for module %s\n", args[1], module.Name)
+			break
+		}
+		printEvent(s.Replay(req))
+	case ":step", ":s":
+		printEvent(s.Step())
+	case ":continue", ":c":
+		printEvent(s.Continue())
+	case ":abort":
+		s.Abort()
+	case ":reset":
+		req := s.Request()
+		s.Close()
+		*session = console.New(module)
+		(*session).SetRequest(req)
+		fmt.Println("fresh Lua state")
+	default:
+		fmt.Printf("unknown command %s; :help lists the commands\n", args[0])
+	}
+	return false
+}
+
+func printRequest(req capture.Request) {
+	fmt.Printf("%s %s\n", req.Method, req.Path)
+	for k, v := range req.Headers {
+		fmt.Printf("  %s: %s\n", k, v)
+	}
+	if req.Body != "" {
+		fmt.Printf("  %s\n", req.Body)
+	}
+}
+
+func printEvent(ev console.Event, err error) {
+	if err == nil {
+		fmt.Print(ev.Output)
+	}
+	switch {
+	case err != nil:
+		fmt.Println(err)
+	case ev.Done:
+		fmt.Printf("done: %d", ev.Status)
+		if ev.View != "" {
+			fmt.Printf(", rendered %s", ev.View)
+		}
+		fmt.Println()
+		if ev.Body != "" {
+			fmt.Println(ev.Body)
+		}
+	default:
+		if ev.Next != "" {
+			fmt.Printf("paused before script %d/%d: %s\n", ev.Step+1, ev.Total, ev.Next)
+		} else {
+			fmt.Println("paused before the route handler")
+		}
+		keys := make([]string, 0, len(ev.Locals))
+		for k := range ev.Locals {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("  %s = %s\n", k, ev.Locals[k])
+		}
+	}
+}
+
+// findModule returns the module named name, or based at it; the first module when name is empty
+func findModule(modules []config.ModulesConfig, name string) (config.ModulesConfig, bool) {
+	for _, m := range modules {
+		if name == "" || m.Name == name || m.BasePath == name {
+			return m, true
+		}
+	}
+	return config.ModulesConfig{}, false
+}
+
+// connect sets up the stores and the Redis client like the server does, so the
+// eocto bindings reach the same services. Unlike the server it keeps going
+// without Redis, with sessions and the cache in memory.
+func connect(appConfig *config.AppConfig) {
+	sandbox.SetSharedDir(appConfig.LuaLib)
+	if appConfig.Storage != config.Redis && os.Getenv("REDIS_HOST") == "" {
+		middleware.InitStores(appConfig)
+		return
+	}
+	redisDB, _ := strconv.Atoi(os.Getenv("REDIS_DB"))
+	if err := database.InitRedis(os.Getenv("REDIS_HOST"), os.Getenv("REDIS_PASSWORD"), redisDB); err != nil {
+		fmt.Fprintf(os.Stderr, "octopus repl: redis: %v; sessions and the cache stay in memory\n", err)
+		return
+	}
+	middleware.InitStores(appConfig)
+	if appConfig.Storage == config.Redis {
+		cache.Init(redis.NewFromConnection(database.GetRedisClient()), "redis")
+		if locker, err := lock.NewRedisLocker(database.GetRedisClient()); err == nil {
+			lock.Init(locker)
+		}
+	}
+}
+
+// loadHistory returns the last lines of the history file
+func loadHistory() []string {
+	f, err := os.Open(historyFile)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) > 500 {
+		lines = lines[len(lines)-500:]
+	}
+	return lines
+}
+
+func openHistory() (io.WriteCloser, error) {
+	if err := os.MkdirAll(filepath.Dir(historyFile), 0o755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(historyFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+}
@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+// makeRaw is not supported here: the repl reads plain lines
+func makeRaw(int) (func(), error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+func isTerminal(int) bool {
+	return false
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/luatest"
+	"github.com/degreane/octopus/internal/service/coverage"
+	"github.com/degreane/octopus/internal/service/sandbox"
+)
+
+// reporters are the output formats of `octopus test`
+var reporters = map[string]func(w io.Writer, suites []luatest.Suite, verbose bool) error{
+	"text": luatest.WriteText,
+	"tap": func(w io.Writer, suites []luatest.Suite, _ bool) error {
+		return luatest.WriteTAP(w, suites)
+	},
+	"junit": func(w io.Writer, suites []luatest.Suite, _ bool) error {
+		return luatest.WriteJUnit(w, suites)
+	},
+}
+
+// runTest implements `octopus test [spec or dir ...]`: it runs the
+// *_spec.lua files of every module, or of the given paths, and exits with 1
+// when a test fails.
+func runTest(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	dir := flags.String("dir", ".", "project root holding config/ and views/")
+	module := flags.String("module", "", "only run the specs of this module")
+	format := flags.String("format", "text", "report format: text, tap or junit")
+	out := flags.String("out", "", "write the report to this file instead of stdout")
+	verbose := flags.Bool("v", false, "show the output of passing tests")
+	withCoverage := flags.Bool("coverage", false, "record the lines of module scripts the specs run")
+	coverageDir := flags.String("coverage-dir", "coverage", "directory receiving lcov.info and index.html")
+	coverageMin := flags.Float64("coverage-min", 0, "fail when a module's line coverage is below this percentage")
+	flags.Parse(args)
+
+	report, ok := reporters[*format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "octopus test: unknown format %q\n", *format)
+		return 2
+	}
+	if err := chdir(*dir); err != nil {
+		fmt.Fprintf(os.Stderr, "octopus test: %v\n", err)
+		return 1
+	}
+
+	if appConfig, err := config.ParseServerConfig(); err == nil {
+		sandbox.SetSharedDir(appConfig.LuaLib)
+	}
+	modules, err := config.ParseModulesConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "octopus test: config/modules.yaml: %v\n", err)
+		return 1
+	}
+	if *module != "" {
+		var matching []config.ModulesConfig
+		for _, m := range modules {
+			if m.Name == *module {
+				matching = append(matching, m)
+			}
+		}
+		modules = matching
+	}
+	if *withCoverage || *coverageMin > 0 {
+		coverage.Enable()
+		coverage.TrackModules(modules)
+	}
+	specs, err := luatest.Discover(modules)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "octopus test: %v\n", err)
+		return 1
+	}
+
+	var suites []luatest.Suite
+	for _, spec := range specs {
+		if !selected(spec.Path, flags.Args()) {
+			continue
+		}
+		suites = append(suites, luatest.Run(spec))
+	}
+	if len(suites) == 0 {
+		fmt.Fprintln(os.Stderr, "octopus test: no *_spec.lua files found")
+		return 1
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "octopus test: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := report(w, suites, *verbose); err != nil {
+		fmt.Fprintf(os.Stderr, "octopus test: %v\n", err)
+		return 1
+	}
+
+	status := 0
+	if _, failed, _ := luatest.Totals(suites); failed > 0 {
+		status = 1
+	}
+	if coverage.Enabled() {
+		summary := io.Writer(os.Stderr)
+		if *format == "text" && *out == "" {
+			summary = os.Stdout
+		}
+		if !reportCoverage(summary, *coverageDir, *coverageMin) {
+			status = 1
+		}
+	}
+	return status
+}
+
+// reportCoverage writes the coverage reports to dir and prints the coverage
+// of each module; it returns false when a module is below min
+func reportCoverage(w io.Writer, dir string, min float64) bool {
+	files := coverage.Snapshot()
+	if err := coverage.WriteFiles(dir, files); err != nil {
+		fmt.Fprintf(os.Stderr, "octopus test: coverage: %v\n", err)
+		return false
+	}
+	ok := true
+	fmt.Fprintln(w, "\nLua coverage:")
+	for _, m := range coverage.Modules(files) {
+		mark := " "
+		if m.Percent < min {
+			mark = "✗"
+			ok = false
+		}
+		fmt.Fprintf(w, "%s %-24s %6.1f%%  (%d/%d lines, %d files)\n", mark, m.Module, m.Percent, m.Hit, m.Lines, m.Files)
+	}
+	fmt.Fprintf(w, "  reports written to %s/lcov.info and %s/index.html\n", dir, dir)
+	if !ok {
+		fmt.Fprintf(w, "✗ coverage is below %.1f%%\n", min)
+	}
+	return ok
+}
+
+// selected reports whether path is one of paths or below one of them; no
+// paths select everything
+func selected(path string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		if path == p || strings.HasPrefix(path, p+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/service/sandbox"
+	"github.com/degreane/octopus/internal/service/scheduler"
+	"github.com/degreane/octopus/internal/service/scriptcache"
+	"github.com/degreane/octopus/internal/service/workers"
+)
+
+// validMethods are the HTTP methods accepted on module routes
+var validMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true,
+	"DELETE": true, "OPTIONS": true, "CONNECT": true, "TRACE": true,
+}
+
+// report collects validation problems
+type report struct {
+	errors   int
+	warnings int
+}
+
+func (r *report) errorf(format string, args ...interface{}) {
+	r.errors++
+	fmt.Printf("✗ "+format+"\n", args...)
+}
+
+func (r *report) warnf(format string, args ...interface{}) {
+	r.warnings++
+	fmt.Printf("! "+format+"\n", args...)
+}
+
+// runValidate implements `octopus validate`: it loads the server and module
+// configuration and compiles every preCheck script, exiting with 1 when
+// anything is broken so deployments fail before serving traffic.
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	dir := flags.String("dir", ".", "project root holding config/ and views/")
+	flags.Parse(args)
+
+	if err := chdir(*dir); err != nil {
+		fmt.Fprintf(os.Stderr, "octopus validate: %v\n", err)
+		return 1
+	}
+
+	r := &report{}
+	if _, err := config.ParseServerConfig(); err != nil {
+		r.errorf("config/config.yaml: %v", err)
+	}
+
+	modules, err := config.ParseModulesConfig()
+	if err != nil {
+		r.errorf("config/modules.yaml: %v", err)
+		return summarize(r, 0)
+	}
+
+	scripts := 0
+	compiled := make(map[string]error)
+	for _, module := range modules {
+		scripts += validateModule(r, module, compiled)
+	}
+	return summarize(r, scripts)
+}
+
+// validateModule checks the routes of one module and returns the number of scripts checked
+func validateModule(r *report, module config.ModulesConfig, compiled map[string]error) int {
+	// The server skips a module only when it has neither; an empty BasePath
+	// serves the module at the root
+	if module.Name == "" && module.BasePath == "" {
+		r.errorf("module without a Name or BasePath: it is not served")
+		return 0
+	}
+	name := module.Name
+	if name == "" {
+		name = module.BasePath
+		r.warnf("module %s: no Name", module.BasePath)
+	}
+	if _, err := os.Stat(module.ViewsDir()); err != nil {
+		r.errorf("module %s: views directory %s not found", name, module.ViewsDir())
+	}
+	for _, err := range sandbox.Check(module.Sandbox) {
+		r.errorf("module %s: %v", name, err)
+	}
+	validateErrorPolicy(r, module, "module "+name, module.OnError)
+
+	scripts := 0
+	used := make(map[string]bool)
+	for _, check := range module.Use {
+		if check.Script == "" {
+			continue
+		}
+		used[check.Script] = true
+		scripts += validateScript(r, module, fmt.Sprintf("module %s: use", name), check.Script, compiled)
+	}
+	if module.Init != "" {
+		scripts += validateScript(r, module, fmt.Sprintf("module %s: init", name), module.Init, compiled)
+	}
+
+	jobs := make(map[string]bool)
+	for _, job := range module.Jobs {
+		where := fmt.Sprintf("module %s: job %s", name, job.Name)
+		if err := scheduler.Check(module, job); err != nil {
+			r.errorf("%v", err)
+		}
+		if jobs[job.Name] {
+			r.errorf("%s: job declared twice", where)
+		}
+		jobs[job.Name] = true
+		if job.Script != "" {
+			scripts += validateScript(r, module, where, job.Script, compiled)
+		}
+	}
+
+	queues := make(map[string]bool)
+	for _, worker := range module.Workers {
+		where := fmt.Sprintf("module %s: worker %s", name, worker.Queue)
+		if err := workers.Check(module, worker); err != nil {
+			r.errorf("%v", err)
+		}
+		if queues[worker.Queue] {
+			r.errorf("%s: queue has two workers", where)
+		}
+		queues[worker.Queue] = true
+		if worker.Script != "" {
+			scripts += validateScript(r, module, where, worker.Script, compiled)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, route := range module.Routes {
+		method := strings.ToUpper(route.Method)
+		where := fmt.Sprintf("module %s: %s %s", name, method, route.Path)
+
+		if !validMethods[method] {
+			r.errorf("%s: unknown method %q", where, route.Method)
+		}
+		if route.Path == "" {
+			r.errorf("%s: path is required", where)
+		}
+		if seen[method+" "+route.Path] {
+			r.errorf("%s: route declared twice", where)
+		}
+		seen[method+" "+route.Path] = true
+
+		if route.WebSocket && method != "GET" {
+			r.errorf("%s: websocket routes must use GET", where)
+		}
+		if route.WebSocket && (route.Cache != nil || route.Idempotency != nil) {
+			r.warnf("%s: cache and idempotency are ignored on websocket routes", where)
+		}
+		if route.SSE {
+			if route.WebSocket {
+				r.errorf("%s: a route cannot be both sse and websocket", where)
+			}
+			if method != "GET" {
+				r.errorf("%s: sse routes must use GET", where)
+			}
+			if route.Cache != nil || route.Idempotency != nil || route.View != "" {
+				r.warnf("%s: view, cache and idempotency are ignored on sse routes", where)
+			}
+		}
+
+		if route.Limits != nil && (route.Limits.CallStack > 0 || route.Limits.Registry > 0) {
+			r.warnf("%s: limits.callStack and limits.registry only apply at module level", where)
+		}
+
+		validateErrorPolicy(r, module, where, route.OnError)
+
+		if route.View != "" && !route.WebSocket && !route.SSE {
+			view := filepath.Join(module.ViewsDir(), route.View+".html")
+			if _, err := os.Stat(view); err != nil {
+				r.errorf("%s: view %s not found", where, view)
+			}
+		}
+
+		for _, name := range route.SkipUse.Scripts {
+			if !used[name] {
+				r.warnf("%s: skipUse names %s, which is not a use script of the module", where, name)
+			}
+		}
+
+		for _, check := range route.PreCheck {
+			if check.Script != "" {
+				scripts += validateScript(r, module, where, check.Script, compiled)
+			}
+		}
+	}
+	return scripts
+}
+
+// validateScript compiles a script of the module once and returns 1 when it
+// was not checked before
+func validateScript(r *report, module config.ModulesConfig, where, script string, compiled map[string]error) int {
+	path := filepath.Join(module.ScriptsDir(), script)
+	err, done := compiled[path]
+	checked := 0
+	if !done {
+		_, err = scriptcache.Compile(path)
+		compiled[path] = err
+		checked = 1
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			r.errorf("%s: script %s not found", where, path)
+		} else {
+			r.errorf("%s: %v", where, err)
+		}
+	}
+	return checked
+}
+
+// validateErrorPolicy checks an onError policy and the files it refers to
+func validateErrorPolicy(r *report, module config.ModulesConfig, where string, policy *config.ErrorPolicy) {
+	if policy == nil {
+		return
+	}
+	switch policy.Policy {
+	case "", config.OnErrorFail, config.OnErrorContinue:
+	case config.OnErrorScript:
+		if policy.Script == "" {
+			r.errorf("%s: onError policy script requires a script", where)
+		}
+	default:
+		r.errorf("%s: unknown onError policy %q", where, policy.Policy)
+	}
+	if policy.Script != "" {
+		path := filepath.Join(module.ScriptsDir(), policy.Script)
+		if _, err := scriptcache.Compile(path); err != nil {
+			r.errorf("%s: onError script: %v", where, err)
+		}
+	}
+	if policy.View != "" {
+		view := filepath.Join(module.ViewsDir(), policy.View+".html")
+		if _, err := os.Stat(view); err != nil {
+			r.errorf("%s: onError view %s not found", where, view)
+		}
+	}
+}
+
+// summarize prints the totals and returns the exit code
+func summarize(r *report, scripts int) int {
+	fmt.Printf("\n%d script(s) compiled, %d error(s), %d warning(s)\n", scripts, r.errors, r.warnings)
+	if r.errors > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/degreane/octopus/config"
+)
+
+func TestValidateModule(t *testing.T) {
+	t.Chdir(t.TempDir())
+	for _, dir := range []string{"views", "views/Shop"} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name     string
+		module   config.ModulesConfig
+		errors   int
+		warnings int
+	}{
+		{"module", config.ModulesConfig{Name: "Shop", BasePath: "/Shop"}, 0, 0},
+		{"root module", config.ModulesConfig{Name: "Admin"}, 0, 0},
+		{"no name", config.ModulesConfig{BasePath: "/Shop"}, 0, 1},
+		{"neither", config.ModulesConfig{}, 1, 0},
+		{"missing views", config.ModulesConfig{Name: "Blog", BasePath: "/Blog"}, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &report{}
+			validateModule(r, tt.module, make(map[string]error))
+			if r.errors != tt.errors || r.warnings != tt.warnings {
+				t.Errorf("errors = %d, warnings = %d, want %d and %d", r.errors, r.warnings, tt.errors, tt.warnings)
+			}
+		})
+	}
+}
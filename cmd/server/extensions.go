@@ -0,0 +1,8 @@
+package main
+
+// Extensions adding eocto namespaces through pkg/api are linked into the
+// server by importing their packages here; each registers itself from init:
+//
+//	import (
+//		_ "example.com/acme/octopus-acme" // eocto.acme.*
+//	)
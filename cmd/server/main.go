@@ -7,10 +7,26 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/console"
+	"github.com/degreane/octopus/internal/database"
+	"github.com/degreane/octopus/internal/middleware"
 	"github.com/degreane/octopus/internal/routes"
+	"github.com/degreane/octopus/internal/service/broadcast"
+	"github.com/degreane/octopus/internal/service/cache"
+	"github.com/degreane/octopus/internal/service/capture"
+	"github.com/degreane/octopus/internal/service/coverage"
+	"github.com/degreane/octopus/internal/service/lock"
 	lgr "github.com/degreane/octopus/internal/service/logger"
+	"github.com/degreane/octopus/internal/service/luapool"
+	"github.com/degreane/octopus/internal/service/metrics"
+	"github.com/degreane/octopus/internal/service/queue"
+	"github.com/degreane/octopus/internal/service/sandbox"
+	"github.com/degreane/octopus/internal/service/scheduler"
+	"github.com/degreane/octopus/internal/service/scriptcache"
+	"github.com/degreane/octopus/internal/service/workers"
 	"github.com/degreane/octopus/internal/utilities"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/compress"
@@ -19,6 +35,9 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/monitor"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/gofiber/storage/memory/v2"
+	"github.com/gofiber/storage/redis/v3"
 	"github.com/joho/godotenv"
 )
 
@@ -61,6 +80,68 @@ func main() {
 		// Fatal error stops execution as the server cannot run without proper configuration
 		logr.Fatal(fmt.Sprintf("Error initializing config %+v", err))
 	}
+	// Sessions and CSRF tokens follow the configured Storage; the stores are
+	// built here rather than at package init, before any route is set up
+	middleware.InitStores(appConfig)
+
+	// Connect the shared Redis client used by the Lua eocto.redis API
+	// Connection failures are logged but not fatal so the server can still run without Redis
+	if appConfig.Storage == config.Redis || appConfig.Broadcast == "redis" || appConfig.Queue == "redis" {
+		redisDB, _ := strconv.Atoi(os.Getenv("REDIS_DB"))
+		if err := database.InitRedis(os.Getenv("REDIS_HOST"), os.Getenv("REDIS_PASSWORD"), redisDB); err != nil {
+			logr.Error(fmt.Sprintf("Error connecting to Redis %+v", err))
+		}
+	}
+
+	// Back the Lua eocto.cache API with the configured storage
+	// Redis shares the client above; anything else falls back to process memory
+	if appConfig.Storage == config.Redis && database.GetRedisClient() != nil {
+		cache.Init(redis.NewFromConnection(database.GetRedisClient()), "redis")
+		// Locks (eocto.lock, idempotency keys) must exclude every process as well
+		if locker, err := lock.NewRedisLocker(database.GetRedisClient()); err == nil {
+			lock.Init(locker)
+		}
+	} else {
+		cache.Init(memory.New(), "memory")
+	}
+	metrics.Register("cache", func() interface{} { return cache.Get().Stats() })
+	metrics.Register("routeCache", func() interface{} { return cache.RouteStats() })
+
+	// Size the pools of pre-warmed Lua states before the module routes create them
+	luapool.Configure(luapool.Config{Size: appConfig.LuaPool.Size, MaxReuse: appConfig.LuaPool.MaxReuse})
+	metrics.Register("luaPool", func() interface{} { return luapool.AllStats() })
+
+	// Compiled scripts are checked for changes on every use, except in production
+	scriptcache.SetPinned(config.New().Environment == "production")
+	metrics.Register("scripts", func() interface{} { return scriptcache.GetStats() })
+
+	// Development servers can record which lines of the module scripts run
+	if appConfig.LuaCoverage && config.New().Environment != "production" {
+		coverage.Enable()
+		metrics.Register("luaCoverage", func() interface{} { return coverage.Modules(coverage.Snapshot()) })
+	}
+
+	// Development servers can mount the web Lua console; the requests module
+	// routes serve are captured so the console can replay them
+	withConsole := appConfig.Console.Enabled && config.New().Environment != "production"
+	if withConsole {
+		capture.Enable("")
+	}
+
+	// Modules may require from their scripts/ directory and the shared Lua library
+	sandbox.SetSharedDir(appConfig.LuaLib)
+
+	// Fan Socket.IO emits and room membership out to every process through Redis pub/sub
+	// Falls back to the in-memory adapter (single process only) when Redis is unavailable
+	if appConfig.Broadcast == "redis" {
+		adapter, err := broadcast.NewRedisAdapter(database.GetRedisClient())
+		if err == nil {
+			err = utilities.GetSocketClients().UseAdapter(adapter)
+		}
+		if err != nil {
+			logr.Error(fmt.Sprintf("Error initializing Redis broadcast adapter, using memory %+v", err))
+		}
+	}
 
 	// Initialize the HTML template engine with the views directory and .html extension
 	// This engine will be used to render HTML templates for web pages
@@ -158,6 +239,10 @@ func main() {
 					"Path":  c.Path(),
 				})
 			}
+			if code != fiber.StatusInternalServerError {
+				// e.g. Lua scripts aborted by their execution limits or error handlers
+				return c.Status(code).SendString(utils.StatusMessage(code))
+			}
 			return c.Status(code).SendString("Internal Server Error")
 		},
 	})
@@ -270,6 +355,12 @@ func main() {
 	// Add metrics endpoint for monitoring server performance
 	// This adds a /metrics endpoint with real-time server statistics
 	app.Get("/metrics", monitor.New())
+	// Application statistics (cache hit/miss, ...) as JSON
+	app.Get("/metrics/stats", metrics.Handler())
+	// Line coverage of the module scripts, as HTML or ?format=lcov
+	if coverage.Enabled() {
+		app.Get("/metrics/coverage", coverage.Handler())
+	}
 
 	// Add compression middleware to reduce response size
 	// This compresses responses using gzip or other algorithms to save bandwidth
@@ -290,12 +381,15 @@ func main() {
 	// Iterate through all loaded modules and set up their respective routes
 	// Each module's routes are configured using the SetupRoutes function, which maps endpoints
 	// to the application. If route setup fails for any module, the server will terminate
+	if coverage.Enabled() {
+		coverage.TrackModules(modules)
+	}
 	cwd, err := os.Getwd()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for _, module := range modules {
+	for i, module := range modules {
 		// logr.Info("Setting up routes for module: % +v", module)
 		// Set up the routes for the current module by registering them with the Fiber application
 		// This connects the module's handlers to specific HTTP endpoints
@@ -303,6 +397,7 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
+		modules[i].AbsolutePath = module.AbsolutePath
 		err := routes.SetupRoutes(app, module)
 		if err != nil {
 			// If routes cannot be set up for a module, the application cannot function correctly
@@ -311,6 +406,42 @@ func main() {
 		}
 	}
 
+	// Scheduled jobs run in one process: never in Prefork children, and with
+	// Redis storage in the process of the cluster holding the scheduler lease.
+	// Their history is kept in Redis as well so every node lists it. The
+	// children serve the HTTP requests, /metrics/stats among them, so they
+	// build the scheduler without starting it to publish its jobs and history.
+	var history scheduler.History
+	if appConfig.Storage == config.Redis && database.GetRedisClient() != nil {
+		history, _ = scheduler.NewRedisHistory(database.GetRedisClient())
+	}
+	var jobs *scheduler.Scheduler
+	if fiber.IsChild() {
+		jobs, _ = scheduler.Build(modules, history)
+	} else {
+		jobs = scheduler.Start(modules, history)
+	}
+	metrics.Register("jobs", func() interface{} { return jobs.Stats() })
+
+	// Queue workers run in every process. Memory queues only reach the
+	// workers of the process that enqueued; Redis queues are shared, every
+	// message is claimed by one process of the cluster.
+	if appConfig.Queue == "redis" {
+		backend, err := queue.NewRedisBackend(database.GetRedisClient())
+		if err != nil {
+			logr.Error(fmt.Sprintf("Error initializing Redis queues, using memory %+v", err))
+		} else {
+			queue.Init(backend)
+		}
+	}
+	pool := workers.Start(modules)
+	metrics.Register("queues", func() interface{} { return pool.Stats() })
+
+	// The console evaluates Lua against the modules and replays captured requests
+	if withConsole {
+		console.Mount(app, appConfig.Console, modules)
+	}
+
 	// Configure static file serving for public assets
 	// This serves files from the ./public directory at the /public URL path
 	app.Static("/public", "./public")
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -25,6 +27,126 @@ type Route struct {
 	PreCheck  []Check `yaml:"preCheck"`
 	View      string  `yaml:"view"`
 	WebSocket bool    `yaml:"websocket,omitempty"`
+	// SSE keeps the connection open as a text/event-stream once the preCheck
+	// scripts have run, in place of the view
+	SSE bool `yaml:"sse,omitempty"`
+	// Cache enables response caching for GET/HEAD requests on this route
+	Cache *RouteCache `yaml:"cache,omitempty"`
+	// Idempotency replays the first response for requests repeating an Idempotency-Key
+	Idempotency *RouteIdempotency `yaml:"idempotency,omitempty"`
+	// Limits overrides the module's script timeout and instruction budget for this route
+	Limits *LuaLimits `yaml:"limits,omitempty"`
+	// OnError overrides the module's policy for failing preCheck scripts on this route
+	OnError *ErrorPolicy `yaml:"onError,omitempty"`
+	// SkipUse opts the route out of the module's use scripts: true skips them
+	// all, a list skips the scripts named in it
+	SkipUse SkipUse `yaml:"skipUse,omitempty"`
+}
+
+// SkipUse is the opt-out of a route from its module's use scripts
+type SkipUse struct {
+	All     bool
+	Scripts []string
+}
+
+// UnmarshalYAML accepts a boolean or a list of script names
+func (s *SkipUse) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&s.Scripts)
+	}
+	return node.Decode(&s.All)
+}
+
+// MarshalYAML writes the list of script names, or true
+func (s SkipUse) MarshalYAML() (interface{}, error) {
+	if s.All || len(s.Scripts) == 0 {
+		return s.All, nil
+	}
+	return s.Scripts, nil
+}
+
+// Skips reports whether the route opts out of the use script
+func (s SkipUse) Skips(script string) bool {
+	if s.All {
+		return true
+	}
+	for _, name := range s.Scripts {
+		if name == script {
+			return true
+		}
+	}
+	return false
+}
+
+// Error policies for failing preCheck scripts
+const (
+	// OnErrorFail aborts the request with the module's error view (default)
+	OnErrorFail = "fail"
+	// OnErrorContinue logs the error and runs the rest of the chain
+	OnErrorContinue = "continue"
+	// OnErrorScript hands the error to an error-handler script
+	OnErrorScript = "script"
+)
+
+// ErrorPolicy decides what happens when a preCheck script is missing, does not
+// compile or raises an error. The default fails closed, so a broken
+// authentication check never lets a request through.
+type ErrorPolicy struct {
+	// Policy is "fail" (default), "continue" or "script"
+	Policy string `yaml:"policy"`
+	// Script is the error-handler script, relative to the module's scripts directory
+	Script string `yaml:"script,omitempty"`
+	// View is the error view rendered by "fail", relative to the module's views (default "pages/error")
+	View string `yaml:"view,omitempty"`
+}
+
+// LuaLimits bounds the execution of Lua scripts.
+// Timeout and Instructions apply per script run and can be set per module or
+// per route; CallStack and Registry size the module's Lua states and are only
+// read at module level.
+type LuaLimits struct {
+	// Timeout is the wall-clock limit of a script run in milliseconds (default 5000)
+	Timeout int `yaml:"timeout,omitempty"`
+	// Instructions is the number of VM instructions a script run may execute; 0 disables the budget
+	Instructions int64 `yaml:"instructions,omitempty"`
+	// CallStack is the maximum Lua call depth (default 256)
+	CallStack int `yaml:"callStack,omitempty"`
+	// Registry is the maximum number of slots of the Lua data stack (default 5120)
+	Registry int `yaml:"registry,omitempty"`
+}
+
+// RouteCache configures the response cache of a route.
+// A cached response replaces the view once the route's preChecks have run.
+// Responses rendered for a session holding data are only stored when the route
+// lists the session keys its output depends on in VarySession.
+type RouteCache struct {
+	// TTL is the lifetime of a cached response in seconds
+	TTL int `yaml:"ttl"`
+	// Store selects "memory" or "redis"; empty follows AppConfig.Storage
+	Store string `yaml:"store,omitempty"`
+	// VaryHeaders lists request headers that produce distinct cache entries
+	VaryHeaders []string `yaml:"varyHeaders,omitempty"`
+	// VaryQuery lists the query parameters that produce distinct cache entries;
+	// when empty the whole query string is part of the key
+	VaryQuery []string `yaml:"varyQuery,omitempty"`
+	// VarySession lists session keys that produce distinct cache entries
+	VarySession []string `yaml:"varySession,omitempty"`
+}
+
+// RouteIdempotency configures idempotency keys on a route.
+// The first successful response for a key is stored and replayed to every
+// duplicate request the same caller sends within Window; concurrent duplicates
+// wait for the first one.
+type RouteIdempotency struct {
+	// Window is how long a response is replayed, in seconds (default 86400)
+	Window int `yaml:"window,omitempty"`
+	// Header carries the key (default "Idempotency-Key")
+	Header string `yaml:"header,omitempty"`
+	// Required rejects requests without a key with 400
+	Required bool `yaml:"required,omitempty"`
+	// Principal lists the session keys identifying the caller a key belongs
+	// to; when empty keys belong to the session cookie, or the client address
+	Principal []string `yaml:"principal,omitempty"`
 }
 
 // Check represents a pre-check configuration for routes, containing headers and script validation details.
@@ -51,7 +173,130 @@ type ModulesConfig struct {
 	LocalPath    string   `yaml:"LocalPath,omitempty"`
 	AbsolutePath string   `yaml:"AbsolutePath,omitempty"`
 	DB           string   `yaml:"db"`
-	Routes       []Route  `yaml:"Routes,omitempty"`
+	RedisPrefix  string   `yaml:"RedisPrefix,omitempty"`
+	// Sandbox restricts the Lua standard library and require paths of the module's scripts
+	Sandbox *SandboxConfig `yaml:"Sandbox,omitempty"`
+	// Limits bounds the execution time and memory of the module's scripts
+	Limits *LuaLimits `yaml:"Limits,omitempty"`
+	// OnError is the policy for failing preCheck scripts of the module's routes
+	OnError *ErrorPolicy `yaml:"OnError,omitempty"`
+	// Use lists preCheck scripts run before the preChecks of every route
+	Use []Check `yaml:"use,omitempty"`
+	// Init is a script run once when the module is loaded; request scripts
+	// read the value it returns through eocto.init
+	Init string `yaml:"init,omitempty"`
+	// Jobs are scripts run on a cron schedule
+	Jobs []Job `yaml:"jobs,omitempty"`
+	// Workers process the module's queues filled by eocto.queue.enqueue
+	Workers []Worker `yaml:"workers,omitempty"`
+	Routes  []Route  `yaml:"Routes,omitempty"`
+}
+
+// Worker processes the messages of a queue of its module with a Lua script.
+// A message whose script raises an error is retried with an exponential
+// backoff, then moved to the queue's dead letters.
+type Worker struct {
+	// Queue is the name scripts of the module enqueue to
+	Queue string `yaml:"queue"`
+	// Script is the worker's script, relative to the module's scripts directory
+	Script string `yaml:"script"`
+	// Concurrency is the number of messages processed at once per process (default 1)
+	Concurrency int `yaml:"concurrency,omitempty"`
+	// Retries is the number of retries of messages enqueued without one (default 3)
+	Retries *int `yaml:"retries,omitempty"`
+	// Backoff is the delay before the first retry in seconds, doubled on every retry (default 10)
+	Backoff int `yaml:"backoff,omitempty"`
+	// Visibility is how long a claimed message stays hidden from other
+	// workers after its worker last renewed the claim, in seconds; it also
+	// bounds the script's run (default 60)
+	Visibility int `yaml:"visibility,omitempty"`
+	// Disabled keeps the worker from starting
+	Disabled bool `yaml:"disabled,omitempty"`
+}
+
+// Overlap policies of a job whose previous run has not finished
+const (
+	// OverlapSkip drops the run (default)
+	OverlapSkip = "skip"
+	// OverlapQueue runs once more when the previous run finishes
+	OverlapQueue = "queue"
+	// OverlapAllow runs alongside the previous run
+	OverlapAllow = "allow"
+)
+
+// Job is a Lua script of a module run on a cron schedule, outside of any
+// request. With Redis storage a single process of the cluster runs the jobs.
+type Job struct {
+	// Name identifies the job within the module
+	Name string `yaml:"name"`
+	// Schedule is a cron expression ("*/5 * * * *") or a macro such as
+	// "@hourly" or "@every 30s"
+	Schedule string `yaml:"schedule"`
+	// Script is the job's script, relative to the module's scripts directory
+	Script string `yaml:"script"`
+	// Timezone is the IANA zone the schedule is read in (default: the server's)
+	Timezone string `yaml:"timezone,omitempty"`
+	// Timeout bounds a run in seconds (default 300)
+	Timeout int `yaml:"timeout,omitempty"`
+	// Overlap is "skip" (default), "queue" or "allow"
+	Overlap string `yaml:"overlap,omitempty"`
+	// Disabled keeps the job from being scheduled
+	Disabled bool `yaml:"disabled,omitempty"`
+}
+
+// Checks returns the preChecks of route: the module's use scripts it does not
+// opt out of, then its own
+func (m ModulesConfig) Checks(route Route) []Check {
+	checks := make([]Check, 0, len(m.Use)+len(route.PreCheck))
+	for _, check := range m.Use {
+		if !route.SkipUse.Skips(check.Script) {
+			checks = append(checks, check)
+		}
+	}
+	return append(checks, route.PreCheck...)
+}
+
+// SandboxConfig is the sandbox policy of a module's Lua scripts.
+// Without it scripts get base, package, table, string, math, os and coroutine,
+// with the os functions that reach the host removed and require confined to
+// the module's scripts/ directory and the shared Lua library directory.
+type SandboxConfig struct {
+	// Libs lists the standard libraries opened besides base and package
+	// (table, string, math, os, io, coroutine, channel, debug)
+	Libs []string `yaml:"libs,omitempty"`
+	// Allow re-enables restricted functions by name, e.g. "os.getenv" or "io.open"
+	Allow []string `yaml:"allow,omitempty"`
+	// Disabled runs the module's scripts with the full standard library and an unconfined require
+	Disabled bool `yaml:"disabled,omitempty"`
+}
+
+// ViewsDir returns the module's directory under views/; LocalPath takes precedence over BasePath
+func (m ModulesConfig) ViewsDir() string {
+	if strings.TrimSpace(m.LocalPath) != "" {
+		return filepath.Join("views", m.LocalPath)
+	}
+	return filepath.Join("views", m.BasePath)
+}
+
+// FilesDir returns the directory eocto.sendFile may serve files from: the
+// module's directory, or views/files for a module at the root of views,
+// whose directory holds the other modules
+func (m ModulesConfig) FilesDir() string {
+	dir := m.ViewsDir()
+	if filepath.Clean(dir) == "views" {
+		return filepath.Join(dir, "files")
+	}
+	return dir
+}
+
+// ScriptsDir returns the directory holding the module's Lua scripts
+func (m ModulesConfig) ScriptsDir() string {
+	return filepath.Join(m.ViewsDir(), "scripts")
+}
+
+// LibDir returns the directory holding the module's Lua libraries
+func (m ModulesConfig) LibDir() string {
+	return filepath.Join(m.ViewsDir(), "lib")
 }
 
 type Storage string
@@ -73,6 +318,39 @@ type AppConfig struct {
 	Storage      Storage `yaml:"Storage"`
 	Debug        bool    `yaml:"Debug"`
 	ServerHeader string  `yaml:"ServerHeader"`
+	// Broadcast selects the Socket.IO broadcast adapter: "memory" (default) or "redis".
+	// Use "redis" when Prefork is enabled or several nodes serve the same sockets.
+	Broadcast string `yaml:"Broadcast,omitempty"`
+	// LuaPool sizes the per-module pools of pre-warmed Lua states
+	LuaPool LuaPoolConfig `yaml:"LuaPool,omitempty"`
+	// LuaLib is the shared Lua library directory every module may require from (default "views/utils")
+	LuaLib string `yaml:"LuaLib,omitempty"`
+	// LuaCoverage records the lines of module scripts the server runs and serves
+	// the report under /metrics/coverage; ignored in production
+	LuaCoverage bool `yaml:"LuaCoverage,omitempty"`
+	// Console mounts the web Lua console and captures requests for replay; ignored in production
+	Console ConsoleConfig `yaml:"Console,omitempty"`
+	// Queue selects the backend of eocto.queue: "memory" (default) or "redis".
+	// Memory queues are lost on restart and only reach the workers of the
+	// process that enqueued.
+	Queue string `yaml:"Queue,omitempty"`
+}
+
+// ConsoleConfig configures the web Lua console of development servers
+type ConsoleConfig struct {
+	Enabled bool `yaml:"Enabled,omitempty"`
+	// Token authenticates the console; a random one is logged at startup when empty
+	Token string `yaml:"Token,omitempty"`
+	// Path is where the console is mounted (default "/_console")
+	Path string `yaml:"Path,omitempty"`
+}
+
+// LuaPoolConfig sizes the pools of pre-warmed Lua states; zero values keep the defaults
+type LuaPoolConfig struct {
+	// Size is the number of idle states kept per module (default 8)
+	Size int `yaml:"Size,omitempty"`
+	// MaxReuse is the number of requests a state serves before it is closed (default 1000)
+	MaxReuse int `yaml:"MaxReuse,omitempty"`
 }
 
 // New creates and returns a new Config instance with environment-specific configuration values.
@@ -0,0 +1,278 @@
+package bindings
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// Documented returns one binding per documented eocto entry, in registration
+// order. Registrations sharing a name are merged: their contexts are joined
+// and the first registration carrying a Doc describes them.
+func Documented() []Binding {
+	var list []Binding
+	index := map[string]int{}
+	for _, b := range Registered() {
+		if b.Contexts == 0 {
+			continue
+		}
+		i, ok := index[b.Name]
+		if !ok {
+			index[b.Name] = len(list)
+			list = append(list, b)
+			continue
+		}
+		contexts := list[i].Contexts | b.Contexts
+		if list[i].Doc == "" && b.Doc != "" {
+			list[i] = b
+		}
+		list[i].Contexts = contexts
+	}
+	return list
+}
+
+// argName strips the optional marker from a parameter name
+func argName(p Param) string {
+	return strings.TrimSuffix(p.Name, "?")
+}
+
+// Signature renders a call of b under prefix, e.g. "eocto.redis.get(key)"
+func Signature(prefix string, b Binding) string {
+	if b.Table != nil || b.Type != "" {
+		return prefix + "." + b.Name
+	}
+	args := make([]string, len(b.Params))
+	for i, p := range b.Params {
+		args[i] = argName(p)
+	}
+	return fmt.Sprintf("%s.%s(%s)", prefix, b.Name, strings.Join(args, ", "))
+}
+
+// WriteLuaDefs writes the EmmyLua (LuaLS) definitions of the eocto table
+func WriteLuaDefs(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("---@meta\n")
+	buf.WriteString("-- Code generated by \"octopus gen lua-defs\" from the binding registry. DO NOT EDIT.\n\n")
+	buf.WriteString("---Global eocto table containing all exposed functions\n---@class eocto\neocto = {}\n")
+
+	for _, b := range Documented() {
+		buf.WriteString("\n")
+		writeLuaDoc(&buf, b.Doc, b.Contexts)
+		if b.Table == nil {
+			writeLuaFunction(&buf, "eocto", b)
+			continue
+		}
+		class := "eocto." + b.Name
+		fmt.Fprintf(&buf, "---@class %s\n", class)
+		for _, f := range b.Fields {
+			if f.Type != "" {
+				fmt.Fprintf(&buf, "---@field %s %s %s\n", f.Name, f.Type, f.Doc)
+			}
+		}
+		fmt.Fprintf(&buf, "%s = {}\n", class)
+		for _, f := range b.Fields {
+			if f.Type != "" {
+				continue
+			}
+			buf.WriteString("\n")
+			writeLuaDoc(&buf, f.Doc, 0)
+			writeLuaFunction(&buf, class, f)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeLuaDoc writes a doc comment, followed by the contexts when known
+func writeLuaDoc(buf *bytes.Buffer, doc string, contexts Context) {
+	if doc != "" {
+		for _, line := range strings.Split(doc, "\n") {
+			fmt.Fprintf(buf, "---%s\n", line)
+		}
+	}
+	if contexts != 0 && contexts != All {
+		if doc != "" {
+			buf.WriteString("---\n")
+		}
+		fmt.Fprintf(buf, "---Available in: %s\n", contexts)
+	}
+}
+
+// writeLuaFunction writes the annotations and the stub of a function
+func writeLuaFunction(buf *bytes.Buffer, prefix string, b Binding) {
+	for _, p := range b.Params {
+		fmt.Fprintln(buf, strings.TrimRight(fmt.Sprintf("---@param %s %s %s", p.Name, p.Type, p.Doc), " "))
+	}
+	for _, r := range b.Returns {
+		fmt.Fprintln(buf, strings.TrimRight(fmt.Sprintf("---@return %s %s %s", r.Type, r.Name, r.Doc), " "))
+	}
+	fmt.Fprintf(buf, "function %s end\n", Signature(prefix, b))
+}
+
+// htmlSection is a group of the API reference page
+type htmlSection struct {
+	Group   string
+	Anchor  string
+	Entries []htmlEntry
+}
+
+// htmlEntry is a documented function or table of the API reference page
+type htmlEntry struct {
+	Signature string
+	Contexts  string
+	Doc       []string
+	Params    []Param
+	Returns   []Param
+	Example   string
+	Fields    []htmlEntry
+}
+
+func newHTMLEntry(prefix string, b Binding) htmlEntry {
+	e := htmlEntry{
+		Signature: Signature(prefix, b),
+		Params:    b.Params,
+		Returns:   b.Returns,
+		Example:   b.Example,
+	}
+	if b.Doc != "" {
+		e.Doc = strings.Split(b.Doc, "\n")
+	}
+	if b.Contexts != 0 {
+		e.Contexts = b.Contexts.String()
+	}
+	if b.Type != "" {
+		e.Returns = []Param{{Type: b.Type}}
+	}
+	for _, f := range b.Fields {
+		e.Fields = append(e.Fields, newHTMLEntry(prefix+"."+b.Name, f))
+	}
+	return e
+}
+
+// WriteHTMLDocs writes the API reference partial rendered by the OC module
+func WriteHTMLDocs(w io.Writer) error {
+	var sections []htmlSection
+	index := map[string]int{}
+	for _, b := range Documented() {
+		group := b.Group
+		if group == "" {
+			group = "Other"
+		}
+		i, ok := index[group]
+		if !ok {
+			i = len(sections)
+			index[group] = i
+			anchor := "eocto-" + strings.ToLower(strings.ReplaceAll(group, " ", "-"))
+			sections = append(sections, htmlSection{Group: group, Anchor: anchor})
+		}
+		sections[i].Entries = append(sections[i].Entries, newHTMLEntry("eocto", b))
+	}
+
+	var buf bytes.Buffer
+	if err := htmlDocs.Execute(&buf, sections); err != nil {
+		return err
+	}
+	// The partial is itself parsed as a view template
+	page := strings.ReplaceAll(buf.String(), "{{", "&#123;&#123;")
+	_, err := io.WriteString(w, "{{/* Code generated by \"octopus gen lua-defs\" from the binding registry. DO NOT EDIT. */}}\n"+page)
+	return err
+}
+
+var htmlDocs = template.Must(template.New("luaDocs").Parse(`<div
+  class="p-6 mx-auto overflow-y-auto border border-blue-200 rounded-lg shadow-lg max-w-8/12 bg-gradient-to-br from-blue-50 to-indigo-100"
+>
+  <div class="flex items-center mb-8">
+    <div
+      class="p-4 mr-6 rounded-full shadow-lg bg-gradient-to-br from-blue-600 to-slate-600"
+    >
+      <svg class="w-10 h-10 text-white" fill="currentColor" viewBox="0 0 24 24">
+        <path d="M12 2L2 7l10 5 10-5-10-5zM2 17l10 5 10-5M2 12l10 5 10-5" />
+      </svg>
+    </div>
+    <div>
+      <h1 class="mb-2 text-4xl font-bold text-slate-800">
+        Eocto Lua API Reference
+      </h1>
+      <p class="text-lg text-slate-600">
+        Every function of the <code class="px-1 rounded bg-slate-100">eocto</code> table, generated from the server's binding registry
+      </p>
+    </div>
+  </div>
+
+  <div class="flex flex-wrap gap-2 mb-8">
+    {{- range .}}
+    <a href="#{{.Anchor}}" class="px-3 py-1 text-sm font-medium text-blue-800 bg-blue-100 rounded-full hover:bg-blue-200">{{.Group}}</a>
+    {{- end}}
+  </div>
+
+  <div class="grid gap-8">
+    {{- range .}}
+    <div id="{{.Anchor}}" class="overflow-hidden bg-white border rounded-lg shadow-sm border-slate-200">
+      <div class="px-6 py-4 bg-gradient-to-r from-blue-500 to-blue-600">
+        <h2 class="text-xl font-semibold text-white">{{.Group}}</h2>
+      </div>
+      <div class="p-6 space-y-4">
+        {{- range .Entries}}
+        {{- template "entry" .}}
+        {{- end}}
+      </div>
+    </div>
+    {{- end}}
+  </div>
+
+  <div class="pt-8 mt-12 text-center border-t border-slate-200">
+    <p class="text-sm text-slate-600">
+      All functions are available within the
+      <code class="px-2 py-1 rounded bg-slate-100 text-slate-800">eocto</code>
+      global table in your Lua scripts. Editor definitions live in
+      <code class="px-2 py-1 rounded bg-slate-100 text-slate-800">lua-definitions/eocto.lua</code>.
+    </p>
+  </div>
+</div>
+{{define "entry"}}
+        <div class="p-4 rounded-lg bg-slate-50">
+          <div class="flex flex-wrap items-center justify-between gap-2 mb-2">
+            <h3 class="font-mono font-semibold text-slate-800">{{.Signature}}</h3>
+            {{- if .Contexts}}
+            <span class="px-2 py-1 text-xs text-purple-800 bg-purple-100 rounded">{{.Contexts}}</span>
+            {{- end}}
+          </div>
+          {{- range .Doc}}
+          <p class="mb-1 text-sm text-slate-600">{{.}}</p>
+          {{- end}}
+          {{- if .Params}}
+          <table class="w-full mt-3 text-sm">
+            <tbody>
+              {{- range .Params}}
+              <tr class="border-t border-slate-200">
+                <td class="py-1 pr-4 font-mono text-slate-800">{{.Name}}</td>
+                <td class="py-1 pr-4 font-mono text-blue-700">{{.Type}}</td>
+                <td class="py-1 text-slate-600">{{.Doc}}</td>
+              </tr>
+              {{- end}}
+            </tbody>
+          </table>
+          {{- end}}
+          {{- if .Returns}}
+          <p class="mt-3 text-xs font-semibold tracking-wide uppercase text-slate-500">Returns</p>
+          <ul class="text-sm">
+            {{- range .Returns}}
+            <li><span class="font-mono text-blue-700">{{.Type}}</span>{{if .Name}} <span class="font-mono text-slate-800">{{.Name}}</span>{{end}}{{if .Doc}} <span class="text-slate-600">{{.Doc}}</span>{{end}}</li>
+            {{- end}}
+          </ul>
+          {{- end}}
+          {{- if .Example}}
+          <pre class="p-3 mt-3 overflow-x-auto text-sm text-green-400 rounded bg-slate-800"><code>{{.Example}}</code></pre>
+          {{- end}}
+          {{- if .Fields}}
+          <div class="mt-4 ml-4 space-y-3">
+            {{- range .Fields}}
+            {{- template "entry" .}}
+            {{- end}}
+          </div>
+          {{- end}}
+        </div>
+{{- end}}
+`))
@@ -0,0 +1,664 @@
+package bindings
+
+import (
+	"time"
+
+	"github.com/degreane/octopus/internal/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// section registers bindings under one group of the API reference
+func section(group string, bindings ...Binding) {
+	for _, b := range bindings {
+		b.Group = group
+		Register(b)
+	}
+}
+
+// init registers the core eocto bindings
+func init() {
+	// debug Messages
+	section("Debug",
+		Binding{Name: "debug", Contexts: All, Func: utilities.Debug,
+			Doc: "Log a message through the server logger",
+			Params: []Param{
+				{"level", `"info"|"warning"|"error"|"important"`, "Log level"},
+				{"message", "string|number|boolean|table", "Message to log"},
+			},
+			Example: `eocto.debug("info", "User logged in successfully")`},
+	)
+
+	// sessions
+	section("Sessions",
+		Binding{Name: "getSession", Contexts: HTTP, Bound: Request(utilities.GetSession),
+			Doc:     "Get a session value",
+			Params:  []Param{{"key", "string", "Session key"}},
+			Returns: []Param{{"value", "any", "Session value or nil if not found"}},
+			Example: `local user = eocto.getSession("user")`},
+		Binding{Name: "getSession", Contexts: WS, Bound: Socket(utilities.GetWsSession)},
+		Binding{Name: "setSession", Contexts: HTTP, Bound: Request(utilities.SetSession),
+			Doc: "Set a session value",
+			Params: []Param{
+				{"key", "string", "Session key"},
+				{"value", "string|number|boolean|table", "Session value"},
+			},
+			Example: `eocto.setSession("user", {id = 42, name = "ada"})`},
+		Binding{Name: "setSession", Contexts: WS, Bound: Socket(utilities.SetWsSession)},
+		Binding{Name: "deleteSession", Contexts: HTTP, Bound: Request(utilities.DeleteSession),
+			Doc:    "Delete a session value",
+			Params: []Param{{"key", "string", "Session key to delete"}},
+			Returns: []Param{
+				{"ok", "boolean", "True when the session was saved"},
+				{"err", "string?", "Error message"},
+			}},
+		Binding{Name: "deleteSession", Contexts: WS, Bound: Socket(utilities.DeleteWsSession)},
+		Binding{Name: "setSessionExpiry", Contexts: HTTP, Bound: Request(utilities.SetSessionExpiry),
+			Doc: "Set a session value and the session expiry",
+			Params: []Param{
+				{"key", "string", "Session key"},
+				{"value", "string|number|boolean|table", "Session value"},
+				{"expiry", "number", "Expiry in seconds"},
+			},
+			Example: `eocto.setSessionExpiry("user_token", "abc123", 3600)`},
+		Binding{Name: "setSessionExpiry", Contexts: WS, Bound: Socket(utilities.SetWsSessionExpiry)},
+	)
+
+	// Cookie Handlers
+	section("Cookies",
+		Binding{Name: "getCookie", Contexts: HTTP, Bound: Request(utilities.GetCookie),
+			Doc:     "Get a request cookie",
+			Params:  []Param{{"name", "string", "Cookie name"}},
+			Returns: []Param{{"value", "string|nil", "Cookie value or nil if not found"}}},
+		Binding{Name: "getCookie", Contexts: WS, Bound: Socket(utilities.GetWsCookie)},
+		Binding{Name: "setCookie", Contexts: HTTP, Bound: Request(utilities.SetCookie),
+			Doc: "Set a secure, HTTP-only response cookie",
+			Params: []Param{
+				{"name", "string", "Cookie name"},
+				{"value", "string", "Cookie value"},
+				{"sessionOnly?", "boolean", "Session cookie without expiry (default true)"},
+				{"expiry?", "number", "Hours until the cookie expires when not session-only (default 744)"},
+				{"path?", "string", `Cookie path (default "/")`},
+			},
+			Example: `eocto.setCookie("theme", "dark", false, 24)`},
+		Binding{Name: "setCookie", Contexts: WS, Bound: Socket(utilities.SetWsCookie)},
+		Binding{Name: "getAllCookies", Contexts: HTTP, Bound: Request(utilities.GetAllCookies),
+			Doc:     "Get all request cookies",
+			Returns: []Param{{"cookies", "table", "Cookie values keyed by name"}}},
+		Binding{Name: "getAllCookies", Contexts: WS, Bound: Socket(utilities.GetWsAllCookies)},
+		Binding{Name: "deleteCookie", Contexts: HTTP, Bound: Request(utilities.DeleteCookie),
+			Doc:    "Expire a cookie",
+			Params: []Param{{"name", "string", "Cookie name to delete"}}},
+		Binding{Name: "deleteCookie", Contexts: WS, Bound: Socket(utilities.DeleteWsCookie)},
+		Binding{Name: "clearAllCookies", Contexts: HTTP, Bound: Request(utilities.ClearAllCookies),
+			Doc: "Expire every request cookie"},
+		Binding{Name: "clearAllCookies", Contexts: WS, Bound: Socket(utilities.ClearWsAllCookies)},
+	)
+
+	// webSockets
+	section("WebSockets",
+		Binding{Name: "wsAddRoom", Contexts: All, Func: Stateless(utilities.WsAddRoom),
+			Doc: "Add a user to a WebSocket room",
+			Params: []Param{
+				{"userId", "string", "User ID"},
+				{"roomId", "string", "Room ID"},
+			},
+			Returns: []Param{
+				{"success", "boolean", "Success status"},
+				{"message", "string", "Info message"},
+			}},
+		Binding{Name: "wsRemoveRoom", Contexts: All, Func: Stateless(utilities.WsRemoveRoom),
+			Doc: "Remove a user from a WebSocket room",
+			Params: []Param{
+				{"userId", "string", "User ID"},
+				{"roomId", "string", "Room ID"},
+			},
+			Returns: []Param{
+				{"success", "boolean", "Success status"},
+				{"message", "string", "Info message"},
+			}},
+		Binding{Name: "wsGetUserRooms", Contexts: All, Func: Stateless(utilities.WsGetUserRooms),
+			Doc:    "Get the rooms of a user",
+			Params: []Param{{"userId", "string", "User ID"}},
+			Returns: []Param{
+				{"rooms", "table", "List of room names"},
+				{"message", "string", "Info message"},
+			}},
+		Binding{Name: "wsIsUserInRoom", Contexts: All, Func: Stateless(utilities.WsIsUserInRoom),
+			Doc: "Check whether a user is in a room",
+			Params: []Param{
+				{"userId", "string", "User ID"},
+				{"roomId", "string", "Room ID"},
+			},
+			Returns: []Param{
+				{"inRoom", "boolean", "True if the user is in the room"},
+				{"message", "string", "Info message"},
+			}},
+		Binding{Name: "wsEmitToRoom", Contexts: All, Func: Stateless(utilities.WsEmitToRoom),
+			Doc: "Emit an event to every member of a room, across all server processes.\nSSE connections subscribed to the room get it too; they are not counted and excludeUsers does not apply to them.",
+			Params: []Param{
+				{"roomId", "string", "Room ID"},
+				{"event", "string", "Event name"},
+				{"data", "any", "Data to send (string, number, boolean, table or nil)"},
+				{"excludeUsers?", "table", "Array of user IDs to skip"},
+			},
+			Returns: []Param{
+				{"deliveredCount", "number", "Number of WebSocket room members targeted"},
+				{"message", "string", "Info message"},
+			},
+			Example: `eocto.wsEmitToRoom("lobby", "chat", {text = "hi"})`},
+		Binding{Name: "wsEmitToUser", Contexts: All, Func: Stateless(utilities.WsEmitToUser),
+			Doc: "Emit an event to a user, on whichever server process holds its socket",
+			Params: []Param{
+				{"userId", "string", "User ID"},
+				{"event", "string", "Event name"},
+				{"data", "any", "Data to send (string, number, boolean or table)"},
+			},
+			Returns: []Param{
+				{"success", "boolean", "True if the message was published"},
+				{"message", "string", "Success or error message"},
+			}},
+	)
+
+	section("Request",
+		// csrf
+		Binding{Name: "getCsrfToken", Contexts: HTTP, Bound: Request(utilities.GetCsrfToken),
+			Doc:     "Get the CSRF token of the request",
+			Returns: []Param{{"token", "string|nil", "CSRF token"}}},
+		// headers
+		Binding{Name: "getHeaders", Contexts: HTTP, Bound: Request(utilities.GetHeaders),
+			Doc:     "Get all request headers",
+			Returns: []Param{{"headers", "table", "Header values keyed by name"}}},
+		Binding{Name: "getHeader", Contexts: HTTP, Bound: Request(utilities.GetHeader),
+			Doc:     "Get a request header",
+			Params:  []Param{{"name", "string", "Header name"}},
+			Returns: []Param{{"value", "string|nil", "Header value or nil if not set"}}},
+		Binding{Name: "setHeader", Contexts: HTTP, Bound: Request(utilities.SetHeader),
+			Doc: "Set a response header",
+			Params: []Param{
+				{"name", "string", "Header name"},
+				{"value", "string", "Header value"},
+			}},
+		Binding{Name: "deleteHeader", Contexts: HTTP, Bound: Request(utilities.DeleteHeader),
+			Doc:    "Delete a response header",
+			Params: []Param{{"name", "string", "Header name to delete"}}},
+		Binding{Name: "getPath", Contexts: HTTP, Bound: Request(utilities.GetPath),
+			Doc:     "Get the request path",
+			Returns: []Param{{"path", "string", "Request path"}}},
+		Binding{Name: "getHost", Contexts: HTTP, Bound: Request(utilities.GetHost),
+			Doc:     "Get the request host",
+			Returns: []Param{{"host", "string", "Request host"}}},
+		Binding{Name: "getSchema", Contexts: HTTP, Bound: Request(utilities.GetSchema),
+			Doc:     "Get the request scheme (http or https)",
+			Returns: []Param{{"schema", "string", "Request scheme"}}},
+		// query and path parameters
+		Binding{Name: "getQueryParams", Contexts: HTTP, Bound: Request(utilities.GetQueryParams),
+			Doc:     "Get the query string parameters",
+			Returns: []Param{{"params", "table", "Parameter values keyed by name"}}},
+		Binding{Name: "getPathParams", Contexts: HTTP, Bound: Request(utilities.GetPathParams),
+			Doc:     "Get the route path parameters",
+			Returns: []Param{{"params", "table", "Parameter values keyed by name"}}},
+		Binding{Name: "getPathParam", Contexts: HTTP, Bound: Request(utilities.GetPathParam),
+			Doc:     "Get a route path parameter",
+			Params:  []Param{{"name", "string", "Parameter name"}},
+			Returns: []Param{{"value", "string", "Parameter value, empty if not set"}},
+			Example: `local id = eocto.getPathParam("id")`},
+		// posted data
+		Binding{Name: "getPostBody", Contexts: HTTP, Bound: Request(utilities.GetPostBody),
+			Doc:     "Get the request body, parsed from JSON or form data",
+			Returns: []Param{{"body", "table", "Body fields"}}},
+		Binding{Name: "getMethod", Contexts: HTTP, Bound: Request(utilities.GetMethod),
+			Doc:     "Get the request method",
+			Returns: []Param{{"method", "string", "HTTP method (GET, POST, etc.)"}}},
+	)
+
+	// locals
+	section("Locals",
+		Binding{Name: "getLocal", Contexts: HTTP, Bound: func(env *Env) lua.LGFunction { return utilities.GetLocal(env.Ctx, env.Module.BasePath) },
+			Doc:     "Get a value shared with the next preChecks and the view",
+			Params:  []Param{{"key", "string", "Local key"}},
+			Returns: []Param{{"value", "any", "Local value"}}},
+		Binding{Name: "getLocal", Contexts: WS, Bound: Socket(utilities.GetWsLocal)},
+		Binding{Name: "setLocal", Contexts: HTTP, Bound: func(env *Env) lua.LGFunction { return utilities.SetLocal(env.Ctx, env.Module.BasePath) },
+			Doc: "Set a value shared with the next preChecks and the view",
+			Params: []Param{
+				{"key", "string", "Local key"},
+				{"value", "any", "Local value"},
+			},
+			Returns: []Param{{"ok", "boolean", "Always true"}},
+			Example: `eocto.setLocal("currentPage", "home")`},
+		Binding{Name: "setLocal", Contexts: WS, Bound: Socket(utilities.SetWsLocal)},
+		Binding{Name: "deleteLocal", Contexts: HTTP, Bound: func(env *Env) lua.LGFunction { return utilities.DeleteLocal(env.Ctx, env.Module.BasePath) },
+			Doc:    "Delete a local value",
+			Params: []Param{{"key", "string", "Local key to delete"}},
+			Returns: []Param{
+				{"ok", "boolean", "True when the key was deleted"},
+				{"err", "string?", "Error message"},
+			}},
+		Binding{Name: "deleteLocal", Contexts: WS, Bound: Socket(utilities.DeleteWsLocal)},
+		Binding{Name: "getLocals", Contexts: HTTP, Bound: func(env *Env) lua.LGFunction { return utilities.GetLocals(env.Ctx, env.Module.BasePath) },
+			Doc:     "Get all local values",
+			Returns: []Param{{"locals", "table", "Local values keyed by name"}}},
+		Binding{Name: "getLocals", Contexts: WS, Bound: Socket(utilities.GetWsLocals)},
+	)
+
+	// encryption/decryption, JSON and base 32
+	section("Encoding",
+		Binding{Name: "decryptData", Contexts: All, Func: Stateless(utilities.GetDecryptData),
+			Doc: "Decrypt data encrypted with encryptData",
+			Params: []Param{
+				{"data", "string", "Encrypted data"},
+				{"minute?", "number", "Minutes to look back for the timestamp (default 0)"},
+			},
+			Returns: []Param{{"decrypted", "string", "Decrypted data"}}},
+		Binding{Name: "encryptData", Contexts: All, Func: Stateless(utilities.GetEncryptData),
+			Doc: "Encrypt data with the time-based cipher",
+			Params: []Param{
+				{"data", "string", "Data to encrypt"},
+				{"minute?", "number", "Timestamp offset in minutes (default 0)"},
+			},
+			Returns: []Param{{"encrypted", "string", "Encrypted data"}}},
+		Binding{Name: "decodeJSON", Contexts: All, Func: Stateless(utilities.GetDecodeJSON),
+			Doc:     "Decode a JSON string",
+			Params:  []Param{{"json", "string", "JSON string"}},
+			Returns: []Param{{"decoded", "any", "Decoded value or nil on error"}},
+			Example: `local data = eocto.decodeJSON(eocto.readYamlFile("config/app.yaml"))`},
+		Binding{Name: "encodeJSON", Contexts: All, Func: Stateless(utilities.GetEncodeJSON),
+			Doc:    "Encode a table as JSON",
+			Params: []Param{{"data", "table", "Table to encode"}},
+			Returns: []Param{
+				{"json", "string|nil", "JSON string or nil on error"},
+				{"err", "string?", "Error message"},
+			}},
+		Binding{Name: "encodeBase32", Contexts: All, Func: Stateless(utilities.GetEncodeBase32),
+			Doc:     "Encode a string as Base32",
+			Params:  []Param{{"data", "string", "Data to encode"}},
+			Returns: []Param{{"encoded", "string", "Base32 encoded string"}}},
+		Binding{Name: "decodeBase32", Contexts: All, Func: Stateless(utilities.GetDecodeBase32),
+			Doc:     "Decode a Base32 string",
+			Params:  []Param{{"data", "string", "Base32 encoded string"}},
+			Returns: []Param{{"decoded", "string|nil", "Decoded string or nil on error"}}},
+	)
+
+	// mongodbDatabase functionalities
+	section("MongoDB",
+		Binding{Name: "getDataFromCollection", Contexts: All, Func: utilities.GetDataFromCollectionLua,
+			Doc: "Find the documents of a collection matching a filter",
+			Params: []Param{
+				{"uri", "string", "MongoDB connection URI"},
+				{"database", "string", "Database name"},
+				{"collection", "string", "Collection name"},
+				{"filter", "table", "Query filter"},
+			},
+			Returns: []Param{
+				{"result", "string|nil", "Matching documents as JSON, or nil on error"},
+				{"err", "string?", "Error message"},
+			}},
+		Binding{Name: "setDataToCollection", Contexts: All, Func: utilities.SetDataToCollectionLua,
+			Doc: "Update the documents of a collection matching a filter",
+			Params: []Param{
+				{"uri", "string", "MongoDB connection URI"},
+				{"database", "string", "Database name"},
+				{"collection", "string", "Collection name"},
+				{"filter", "table", "Query filter"},
+				{"update", "table", "Update document"},
+			},
+			Returns: []Param{
+				{"result", "string|nil", "Update result, or nil on error"},
+				{"err", "string?", "Error message"},
+			}},
+		Binding{Name: "delDataFromCollection", Contexts: All, Func: utilities.DelDataFromCollectionLua,
+			Doc: "Delete the documents of a collection matching a filter",
+			Params: []Param{
+				{"uri", "string", "MongoDB connection URI"},
+				{"database", "string", "Database name"},
+				{"collection", "string", "Collection name"},
+				{"filter", "table", "Query filter"},
+			},
+			Returns: []Param{
+				{"result", "string|nil", "Delete result, or nil on error"},
+				{"err", "string?", "Error message"},
+			}},
+		Binding{Name: "insertDataToCollection", Contexts: All, Func: utilities.InsertDataToCollectionLua,
+			Doc: "Insert a document into a collection",
+			Params: []Param{
+				{"uri", "string", "MongoDB connection URI"},
+				{"database", "string", "Database name"},
+				{"collection", "string", "Collection name"},
+				{"document", "table", "Document to insert"},
+			},
+			Returns: []Param{
+				{"result", "string|nil", "Insert result, or nil on error"},
+				{"err", "string?", "Error message"},
+			}},
+	)
+
+	// make http Requests to other servers
+	section("HTTP Client",
+		Binding{Name: "makeRequest", Contexts: All, Func: Stateless(utilities.GetRequest),
+			Doc: "Send an HTTP request to another server",
+			Params: []Param{
+				{"method", "string", "HTTP method"},
+				{"url", "string", "Request URL"},
+				{"headers?", "table", "Request headers"},
+				{"body?", "string", "Request body"},
+			},
+			Returns: []Param{{"response", "{status: number, headers: table, cookies: table, body: string, contentType: string, error: string}", "Response"}},
+			Example: `local res = eocto.makeRequest("GET", "https://api.example.com", {Authorization = "Bearer token"})`},
+		Binding{Name: "proxy", Contexts: HTTP, Bound: Request(utilities.ProxyRequestLua),
+			Doc: "Forward the current request to another server and answer with its response",
+			Params: []Param{
+				{"url", "string", "Target URL"},
+				{"headers?", "table", "Extra request headers"},
+				{"rewritePath?", "string", "Path sent upstream instead of the request path"},
+				{"skipTLS?", "boolean", "Skip TLS certificate verification"},
+			},
+			Returns: []Param{
+				{"ok", "boolean", "True when the response was proxied"},
+				{"err", "string?", "Error message"},
+			}},
+		Binding{Name: "http", Contexts: All, Table: func(L *lua.LState, current func() *Env) *lua.LTable {
+			tbl := L.NewTable()
+			tbl.RawSetString("all", L.NewFunction(utilities.HTTPAllLua))
+			return tbl
+		},
+			Doc: "Concurrent HTTP client",
+			Fields: []Binding{
+				{Name: "all",
+					Doc: "Send requests concurrently and return their responses in order, shaped like eocto.makeRequest's.\nA request that failed or did not finish before the timeout has a non-empty error.",
+					Params: []Param{
+						{"requests", "{method?: string, url: string, headers?: table, body?: string}[]", "Requests; method defaults to GET"},
+						{"options?", "{timeout?: number}", "Seconds before the unfinished requests are aborted"},
+					},
+					Returns: []Param{{"responses", "table[]", "Responses, in the order of requests"}},
+					Example: "local a, b = unpack(eocto.http.all({\n    {url = \"https://api.example.com/a\"},\n    {url = \"https://api.example.com/b\"},\n}, {timeout = 2}))"},
+			}},
+		Binding{Name: "parallel", Contexts: All, Func: utilities.ParallelLua,
+			Doc: "Run functions concurrently: their HTTP, MongoDB and Redis calls overlap while the Lua code runs one function at a time.\nReturns the first value each function returned, in order, and the errors of the functions that failed or did not finish before the timeout (nil when all succeeded).",
+			Params: []Param{
+				{"tasks", "function[]", "Functions to run"},
+				{"options?", "{timeout?: number}", "Seconds before the unfinished functions are abandoned"},
+			},
+			Returns: []Param{
+				{"results", "table", "First return value of each function, by index"},
+				{"errs", "table|nil", "Error messages by index"},
+			},
+			Example: "local res, errs = eocto.parallel({\n    function() return eocto.makeRequest(\"GET\", \"https://api.example.com/a\") end,\n    function() return eocto.redis.get(\"b\") end,\n}, {timeout = 2})"},
+		// Register the WhatsApp function
+		Binding{Name: "sendWhatsAppMessage", Contexts: All, Func: utilities.SendWhatsAppMessageLua,
+			Doc: "Send a WhatsApp message through Twilio",
+			Params: []Param{
+				{"to", "string", "Recipient number in WhatsApp format"},
+				{"message", "string", "Message content"},
+			},
+			Returns: []Param{{"err", "string?", "Error message, nothing on success"}}},
+	)
+
+	// responses and rendering
+	section("Responses",
+		Binding{Name: "response", Contexts: HTTP, Table: func(L *lua.LState, current func() *Env) *lua.LTable {
+			return utilities.NewResponseTable(L, func() *fiber.Ctx { return current().Ctx })
+		},
+			Doc:    "The response of the request, sent once the scripts have run.\nA body replaces the route view and anything eocto.render/renderJson sent; status and headers apply to whatever is sent, the view included.\neocto.abort and eocto.redirect replace the status and body but keep the headers.",
+			Fields: responseFields},
+		Binding{Name: "sendFile", Contexts: HTTP, Bound: func(env *Env) lua.LGFunction {
+			return utilities.SendFile(env.Ctx, env.Module.ViewsDir(), env.Module.FilesDir())
+		},
+			Doc: "Answer with a file instead of the view, as a download unless inline is set.\nRelative paths start at the module's directory (views/<module>), which the file must stay inside; a module at the root of views serves files from views/files only. Range requests are answered with the requested part.",
+			Params: []Param{
+				{"path", "string", "File path"},
+				{"options?", "{name?: string, inline?: boolean, contentType?: string}", "Download name (default: the file name), display in the browser, content type (default: from the name's extension)"},
+			},
+			Returns: []Param{
+				{"ok", "boolean|nil", "True when the file will be sent"},
+				{"err", "string?", "Error message: missing file, or a path outside the module"},
+			},
+			Example: `local ok, err = eocto.sendFile("files/invoices/" .. id .. ".pdf", {name = "invoice.pdf", inline = true})
+if not ok then eocto.abort(404) end`},
+		Binding{Name: "stream", Contexts: HTTP, Bound: Request(utilities.Stream),
+			Doc: "Answer with chunks written by a function instead of the view.\nThe function runs once the scripts are done and the headers are sent, so the request functions (sessions, cookies, eocto.response) are unavailable inside it; writing after the client went away raises an error.",
+			Params: []Param{
+				{"fn", "fun(write: fun(...: string), flush: fun())", "Writes the body; output is sent in chunks, flush sends what is buffered"},
+				{"contentType?", "string", "Content type (default text/plain)"},
+			},
+			Example: `eocto.response.header("Content-Disposition", 'attachment; filename="orders.csv"')
+eocto.stream(function(write, flush)
+    write("id,total\n")
+    for _, order in ipairs(orders) do
+        write(order.id, ",", order.total, "\n")
+    end
+end, "text/csv")`},
+		Binding{Name: "sse", Contexts: HTTP, Table: func(L *lua.LState, current func() *Env) *lua.LTable {
+			return utilities.NewSSETable(L, func() *fiber.Ctx { return current().Ctx })
+		},
+			Doc:    "The event stream of a route declared with sse: true, only available on such routes.\nOnce the scripts have run, the events they sent are written and the connection stays open, receiving the events eocto.wsEmitToRoom emits to the rooms it subscribed to. A response set through eocto.response, eocto.abort or eocto.redirect is sent instead of the stream.",
+			Fields: sseFields},
+		Binding{Name: "setResponse", Contexts: HTTP, Bound: Request(utilities.GetResponse),
+			Doc: "Answer with a JSON body instead of the view, the same as eocto.response.json(body, status)",
+			Params: []Param{
+				{"status", "number", "HTTP status code"},
+				{"body", "table", "Response body"},
+			}},
+		Binding{Name: "render", Contexts: HTTP, Bound: Request(utilities.GetRender),
+			Doc: "Render a template instead of the route view",
+			Params: []Param{
+				{"template", "string", "Template name"},
+				{"data", "table", "Template data"},
+			},
+			Returns: []Param{
+				{"success", "boolean", "Success status"},
+				{"err", "string?", "Error message"},
+			}},
+		Binding{Name: "renderJson", Contexts: HTTP, Bound: Request(utilities.GetRenderJson),
+			Doc: "Answer with a table encoded as JSON",
+			Params: []Param{
+				{"data", "table", "Data to send"},
+				{"status?", "number", "HTTP status code (default 200)"},
+			},
+			Returns: []Param{
+				{"success", "boolean", "Success status"},
+				{"err", "string?", "Error message"},
+			},
+			Example: `eocto.renderJson({error = "not found"}, 404)`},
+	)
+
+	// control flow
+	section("Control Flow",
+		Binding{Name: "halt", Contexts: HTTP, Bound: Request(utilities.Halt),
+			Doc:     "Stop the request: skip the remaining preChecks and the view, sending the response already set",
+			Example: "eocto.renderJson({error = \"quota exceeded\"}, 429)\neocto.halt()"},
+		Binding{Name: "abort", Contexts: HTTP, Bound: Request(utilities.Abort),
+			Doc: "Stop the request with a status and an optional body.\nA string body is sent as text, a table as JSON; {view = \"pages/x\", data = {...}} renders a module view.",
+			Params: []Param{
+				{"status", "number", "HTTP status code"},
+				{"body?", "string|table", "Response body or view"},
+			},
+			Example: `eocto.abort(403, {view = "pages/forbidden", data = {reason = "plan"}})`},
+		Binding{Name: "redirect", Contexts: HTTP, Bound: Request(utilities.Redirect),
+			Doc: "Stop the request with a redirect; HTMX requests receive an HX-Redirect header instead",
+			Params: []Param{
+				{"url", "string", "Target URL"},
+				{"status?", "number", "Redirect status (default 302)"},
+			},
+			Example: `eocto.redirect("/OC/login")`},
+		Binding{Name: "next", Contexts: HTTP, Bound: Request(utilities.Next),
+			Doc:     "End the current script and continue with the next preCheck or the view",
+			Example: `if eocto.getSession("user") then eocto.next() end`},
+	)
+
+	// the run of a scheduled job
+	section("Jobs",
+		Binding{Name: "job", Contexts: Job, Table: func(L *lua.LState, current func() *Env) *lua.LTable {
+			return jobTable(L, current)
+		},
+			Doc: "The scheduled job run or queue message the script serves",
+			Fields: []Binding{
+				{Name: "name", Type: "string", Doc: "Job or queue name"},
+				{Name: "schedule", Type: "string", Doc: "Cron expression of a scheduled job"},
+				{Name: "scheduled", Type: "number", Doc: "Unix time the run or message was due"},
+				{Name: "id", Type: "string", Doc: "Id of the queue message"},
+				{Name: "attempt", Type: "number", Doc: "Attempt at the queue message, 1 the first time"},
+				{Name: "payload", Type: "any", Doc: "Payload of the queue message"},
+			},
+			Example: `eocto.debug("info", eocto.job.name .. " due at " .. os.date("%H:%M", eocto.job.scheduled))`},
+		Binding{Name: "queue", Contexts: All, Table: func(L *lua.LState, current func() *Env) *lua.LTable {
+			return utilities.NewQueueTable(L, current().Module.Name)
+		},
+			Doc:    "Background queues processed by the workers of the module (Queue in config.yaml selects memory or Redis).\nQueue names are namespaced with the module name.",
+			Fields: queueFields},
+	)
+
+	section("Utilities",
+		Binding{Name: "getUUID", Contexts: All, Func: func(L *lua.LState) int {
+			L.Push(lua.LString(utils.UUIDv4()))
+			return 1
+		},
+			Doc:     "Generate a UUID v4",
+			Returns: []Param{{"uuid", "string", "Generated UUID"}}},
+		Binding{Name: "getSettings", Contexts: All, Bound: func(env *Env) lua.LGFunction {
+			return func(L *lua.LState) int {
+				tbl := L.NewTable()
+				tbl.RawSetString("BasePath", lua.LString(env.Module.BasePath))
+				tbl.RawSetString("LocalPath", lua.LString(env.Module.LocalPath))
+				L.Push(tbl)
+				return 1
+			}
+		},
+			Doc:     "Get the settings of the module running the script",
+			Returns: []Param{{"settings", "{BasePath: string, LocalPath: string}", "Module settings"}}},
+		Binding{Name: "init", Contexts: HTTP | WS | Job, Table: func(L *lua.LState, current func() *Env) *lua.LTable {
+			return utilities.NewInitTable(L, current().Module.Name)
+		},
+			Doc:     "Read-only value returned by the module's init script (an empty table without one).\nWrites raise an error; call the table, or a table inside it, for a copy to change or iterate.",
+			Example: `local plan = eocto.init.plans[user.plan]` + "\n" + `for name, role in pairs(eocto.init.roles()) do print(name) end`},
+		Binding{Name: "timeStampNano", Contexts: All, Func: func(L *lua.LState) int {
+			L.Push(lua.LNumber(time.Now().UnixNano()))
+			return 1
+		},
+			Doc:     "Get the current Unix time in nanoseconds",
+			Returns: []Param{{"timestamp", "number", "Unix timestamp in nanoseconds"}}},
+		Binding{Name: "timeStampMilli", Contexts: All, Func: func(L *lua.LState) int {
+			L.Push(lua.LNumber(time.Now().UnixMilli()))
+			return 1
+		},
+			Doc:     "Get the current Unix time in milliseconds",
+			Returns: []Param{{"timestamp", "number", "Unix timestamp in milliseconds"}}},
+		Binding{Name: "timeStamp", Contexts: All, Func: func(L *lua.LState) int {
+			L.Push(lua.LNumber(time.Now().Unix()))
+			return 1
+		},
+			Doc:     "Get the current Unix time in seconds",
+			Returns: []Param{{"timestamp", "number", "Unix timestamp in seconds"}}},
+	)
+
+	// Redis functionalities
+	section("Redis",
+		Binding{Name: "getRedis", Contexts: All, Func: utilities.GetRedisValueLua,
+			Doc:     "Get a Redis string value",
+			Params:  []Param{{"key", "string", "Redis key"}},
+			Returns: []Param{{"value", "string|nil", "Value or nil if not found"}}},
+		Binding{Name: "setRedis", Contexts: All, Func: utilities.SetRedisValueLua,
+			Doc: "Set a Redis string value",
+			Params: []Param{
+				{"key", "string", "Redis key"},
+				{"value", "string", "Value"},
+				{"ttl?", "number", "Expiry in seconds"},
+			},
+			Returns: []Param{{"success", "boolean", "Success status"}}},
+		Binding{Name: "deleteRedis", Contexts: All, Func: utilities.DeleteRedisKeyLua,
+			Doc:     "Delete a Redis key",
+			Params:  []Param{{"key", "string", "Redis key to delete"}},
+			Returns: []Param{{"success", "boolean", "Success status"}}},
+		Binding{Name: "redis", Contexts: All, Table: func(L *lua.LState, current func() *Env) *lua.LTable {
+			return utilities.NewRedisTable(L, utilities.RedisKeyPrefix(current().Module.RedisPrefix))
+		},
+			Doc:    "Redis data-structure API. Keys are namespaced with the module's RedisPrefix.\nFunctions return typed values (numbers, booleans, tables) or nil plus an error message.",
+			Fields: redisFields},
+		// named locks with fencing tokens (shared across processes with Redis storage)
+		Binding{Name: "lock", Contexts: All, Func: utilities.LockLua,
+			Doc: "Run fn while holding a named lock (shared across processes with Redis storage).\nThe lock is released when fn returns or raises, and expires after ttl seconds otherwise.",
+			Params: []Param{
+				{"name", "string", "Lock name"},
+				{"ttl", "number", "Seconds before the lock expires"},
+				{"fn", "fun(fence: number): ...", "Receives the fencing token, increasing on every acquisition"},
+				{"options?", "{wait?: number}", "Seconds to wait for the lock (default ttl)"},
+			},
+			Returns: []Param{{"...", "any", "fn's return values, or nil and an error message"}},
+			Example: "eocto.lock(\"invoice:\" .. id, 10, function(fence)\n    -- one process at a time\nend)"},
+	)
+
+	// cache (memory or Redis following AppConfig.Storage), namespaced per module
+	section("Cache",
+		Binding{Name: "cache", Contexts: All, Table: func(L *lua.LState, current func() *Env) *lua.LTable {
+			return utilities.NewCacheTable(L, current().Module.Name)
+		},
+			Doc:    "Application cache backed by the configured Storage (memory or Redis).\nKeys and tags are namespaced with the module name. Values keep their Lua types.",
+			Fields: cacheFields},
+	)
+
+	// FileSystem functionalities (the working directory is kept in the session)
+	section("File System",
+		Binding{Name: "getCWD", Contexts: HTTP, Bound: Request(utilities.GetCWD),
+			Doc:     "Get the session working directory",
+			Returns: []Param{{"cwd", "string|nil", "Working directory path"}}},
+		Binding{Name: "resetWD", Contexts: HTTP, Bound: Request(utilities.ResetWD),
+			Doc: "Reset the session working directory to the server's working directory"},
+		Binding{Name: "setWD", Contexts: HTTP, Bound: Request(utilities.SetWD),
+			Doc:    "Set the session working directory",
+			Params: []Param{{"path", "string", "Absolute or relative directory path"}}},
+		Binding{Name: "listFiles", Contexts: HTTP, Bound: Request(utilities.ListFiles),
+			Doc:     "List the files and directories of a path",
+			Params:  []Param{{"path?", "string", "Path to list; defaults to the session working directory"}},
+			Returns: []Param{{"items", "table|nil", "Array of names or nil on error"}}},
+		Binding{Name: "resetProjectPath", Contexts: HTTP, Bound: func(env *Env) lua.LGFunction {
+			return utilities.ResetProjectWD(env.Ctx, env.Module.AbsolutePath)
+		},
+			Doc: "Reset the session working directory to the module's AbsolutePath"},
+		Binding{Name: "setProjectWD", Contexts: HTTP, Bound: Request(utilities.SetProjectWD),
+			Doc:    "Set the session and process working directory",
+			Params: []Param{{"path", "string", "Directory path"}}},
+		// YAML and CSV utilities
+		Binding{Name: "readYamlFile", Contexts: All, Func: utilities.ReadYamlFileLua,
+			Doc:     "Read a YAML file and return its content as a JSON string",
+			Params:  []Param{{"path", "string", "File path"}},
+			Returns: []Param{{"json", "string|nil", "JSON string or nil on error"}}},
+		Binding{Name: "readCsvFile", Contexts: All, Func: utilities.ReadCsvFileLua,
+			Doc:     "Read a CSV file and return its content as a JSON string (array of objects)",
+			Params:  []Param{{"path", "string", "File path"}},
+			Returns: []Param{{"json", "string|nil", "JSON string or nil on error"}}},
+	)
+}
+
+// jobTable builds eocto.job; its fields read the job run the state is bound
+// to when they are looked up
+func jobTable(L *lua.LState, current func() *Env) *lua.LTable {
+	tbl := L.NewTable()
+	meta := L.NewTable()
+	meta.RawSetString("__index", L.NewFunction(func(L *lua.LState) int {
+		job := current().Job
+		if job == nil {
+			L.Push(lua.LNil)
+			return 1
+		}
+		switch L.CheckString(2) {
+		case "name":
+			L.Push(lua.LString(job.Name))
+		case "schedule":
+			L.Push(lua.LString(job.Schedule))
+		case "scheduled":
+			L.Push(lua.LNumber(job.Scheduled.Unix()))
+		case "id":
+			L.Push(lua.LString(job.ID))
+		case "attempt":
+			L.Push(lua.LNumber(job.Attempt))
+		case "payload":
+			L.Push(utilities.DecodePayload(L, job.Payload))
+		default:
+			L.Push(lua.LNil)
+		}
+		return 1
+	}))
+	L.SetMetatable(tbl, meta)
+	return tbl
+}
@@ -0,0 +1,205 @@
+// Package bindings is the registry of the functions exposed to Lua scripts
+// through the eocto table.
+//
+// Every binding declares the execution contexts it supports: HTTP preChecks,
+// WebSocket scripts, scheduled jobs and the command line. The handlers of
+// each context build their eocto table from the registry, so a binding
+// registered once reaches every context it declares.
+package bindings
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	"github.com/gofiber/contrib/socketio"
+	"github.com/gofiber/fiber/v2"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Context is a set of execution contexts
+type Context uint8
+
+const (
+	// HTTP is a preCheck script serving a request
+	HTTP Context = 1 << iota
+	// WS is a script serving a WebSocket connection
+	WS
+	// Job is a scheduled or queued job
+	Job
+	// CLI is a script run from the octopus command
+	CLI
+
+	// All is every context
+	All = HTTP | WS | Job | CLI
+)
+
+// String lists the contexts, e.g. "http|ws"
+func (ctx Context) String() string {
+	names := ""
+	for _, c := range []struct {
+		ctx  Context
+		name string
+	}{{HTTP, "http"}, {WS, "ws"}, {Job, "job"}, {CLI, "cli"}} {
+		if ctx&c.ctx != 0 {
+			if names != "" {
+				names += "|"
+			}
+			names += c.name
+		}
+	}
+	return names
+}
+
+// Env is what the state running a script is bound to. Ctx is set in the
+// HTTP context, Ws in the WebSocket context and Job in the Job context;
+// pooled states swap them between requests.
+type Env struct {
+	Context Context
+	Module  config.ModulesConfig
+	Ctx     *fiber.Ctx
+	Ws      *socketio.Websocket
+	Job     *JobInfo
+}
+
+// JobInfo describes the job run a Job context state serves: a run of a
+// scheduled job or a message of a queue
+type JobInfo struct {
+	// Name is the name of the scheduled job, or the queue
+	Name string
+	// Schedule is the cron expression of a scheduled job
+	Schedule string
+	// Scheduled is when the run was due
+	Scheduled time.Time
+	// ID, Attempt and Payload describe a queue message
+	ID      string
+	Attempt int
+	Payload []byte
+}
+
+// Param documents a parameter or a return value. As in EmmyLua annotations,
+// a name ending in "?" is optional and "..." is variadic.
+type Param struct {
+	Name string
+	Type string
+	Doc  string
+}
+
+// Binding is one entry of the eocto table. Exactly one of Func, Bound and
+// Table is set; Fields entries only carry documentation.
+type Binding struct {
+	// Name is the key in the eocto table
+	Name string
+	// Contexts are the contexts the binding is installed in
+	Contexts Context
+	// Func is an implementation that needs neither the request nor the module
+	Func lua.LGFunction
+	// Bound returns the implementation for the env the state is bound to when
+	// the binding is called
+	Bound func(env *Env) lua.LGFunction
+	// Table builds a table of functions once per state; its functions call
+	// current() for the env the state is bound to at call time
+	Table func(L *lua.LState, current func() *Env) *lua.LTable
+
+	// Group is the section of the API reference listing the binding
+	Group string
+	// Doc, Params, Returns and Example feed `octopus gen lua-defs`. A name
+	// registered once per context is documented by its first registration
+	// carrying a Doc.
+	Doc     string
+	Params  []Param
+	Returns []Param
+	Example string
+	// Type is the Lua type of a value entry of a Table binding
+	Type string
+	// Fields document the entries of a Table binding
+	Fields []Binding
+}
+
+var (
+	mutex    sync.RWMutex
+	registry []Binding
+)
+
+// Register adds a binding. A binding with the same name and an overlapping
+// context is replaced for the overlapping contexts.
+func Register(b Binding) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for i := range registry {
+		existing := &registry[i]
+		if existing.Name != b.Name || existing.Contexts&b.Contexts == 0 {
+			continue
+		}
+		debug.Debug(debug.Warning, fmt.Sprintf("bindings: eocto.%s redefined for %s", b.Name, existing.Contexts&b.Contexts))
+		existing.Contexts &^= b.Contexts
+	}
+	registry = append(registry, b)
+}
+
+// For returns the bindings installed in ctx, in registration order
+func For(ctx Context) []Binding {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	var list []Binding
+	for _, b := range registry {
+		if b.Contexts&ctx != 0 {
+			list = append(list, b)
+		}
+	}
+	return list
+}
+
+// Registered returns every binding, in registration order
+func Registered() []Binding {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return append([]Binding(nil), registry...)
+}
+
+// Install builds the eocto table for the context of current() and sets it as
+// a global. Bound bindings call current() on every call, so a pooled state
+// follows the request it is checked out for.
+func Install(L *lua.LState, current func() *Env) *lua.LTable {
+	env := current()
+	eocto := L.NewTable()
+	for _, b := range For(env.Context) {
+		switch {
+		case b.Func != nil:
+			eocto.RawSetString(b.Name, L.NewFunction(b.Func))
+		case b.Bound != nil:
+			bound := b.Bound
+			eocto.RawSetString(b.Name, L.NewFunction(func(L *lua.LState) int {
+				return bound(current())(L)
+			}))
+		case b.Table != nil:
+			eocto.RawSetString(b.Name, b.Table(L, current))
+		}
+	}
+	L.SetGlobal("eocto", eocto)
+	return eocto
+}
+
+// Request adapts a request-bound factory to a Bound binding
+func Request(factory func(c *fiber.Ctx) lua.LGFunction) func(env *Env) lua.LGFunction {
+	return func(env *Env) lua.LGFunction {
+		return factory(env.Ctx)
+	}
+}
+
+// Socket adapts a connection-bound factory to a Bound binding
+func Socket(factory func(c *socketio.Websocket) lua.LGFunction) func(env *Env) lua.LGFunction {
+	return func(env *Env) lua.LGFunction {
+		return factory(env.Ws)
+	}
+}
+
+// Stateless adapts a factory that ignores its request to a Func binding
+func Stateless(factory func(c *fiber.Ctx) lua.LGFunction) lua.LGFunction {
+	return factory(nil)
+}
@@ -0,0 +1,638 @@
+package bindings
+
+// redisFields document the entries of eocto.redis
+var redisFields = []Binding{
+	{Name: "prefix", Type: "string", Doc: "Key prefix applied to every key of this module"},
+	{Name: "get",
+		Doc: "Get a string value",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"value", "string|nil", ""},
+		}},
+	{Name: "set",
+		Doc: "Set a value (tables are stored as JSON)",
+		Params: []Param{
+			{"key", "string", ""},
+			{"value", "string|number|boolean|table", ""},
+			{"ttl?", "number", "Optional expiry in seconds"},
+		},
+		Returns: []Param{
+			{"ok", "boolean|nil", ""},
+		}},
+	{Name: "setNX",
+		Doc: "Set a value only if the key does not exist",
+		Params: []Param{
+			{"key", "string", ""},
+			{"value", "string|number|boolean|table", ""},
+			{"ttl?", "number", "Optional expiry in seconds"},
+		},
+		Returns: []Param{
+			{"set", "boolean|nil", "True when the key was set"},
+		}},
+	{Name: "getJSON",
+		Doc: "Read a value stored from a table back as a table",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"value", "table|nil", ""},
+		}},
+	{Name: "del",
+		Doc: "Delete one or more keys",
+		Params: []Param{
+			{"...", "string", "Keys"},
+		},
+		Returns: []Param{
+			{"deleted", "number|nil", "Number of keys removed"},
+		}},
+	{Name: "exists",
+		Doc: "Count how many of the given keys exist",
+		Params: []Param{
+			{"...", "string", "Keys"},
+		},
+		Returns: []Param{
+			{"count", "number|nil", ""},
+		}},
+	{Name: "incr",
+		Doc: "Increment a counter",
+		Params: []Param{
+			{"key", "string", ""},
+			{"by?", "number", "Increment (default 1)"},
+		},
+		Returns: []Param{
+			{"value", "number|nil", "New value"},
+		}},
+	{Name: "decr",
+		Doc: "Decrement a counter",
+		Params: []Param{
+			{"key", "string", ""},
+			{"by?", "number", "Decrement (default 1)"},
+		},
+		Returns: []Param{
+			{"value", "number|nil", "New value"},
+		}},
+	{Name: "incrByFloat",
+		Doc: "Increment a counter by a float",
+		Params: []Param{
+			{"key", "string", ""},
+			{"by", "number", ""},
+		},
+		Returns: []Param{
+			{"value", "number|nil", "New value"},
+		}},
+	{Name: "expire",
+		Doc: "Set a key's time to live",
+		Params: []Param{
+			{"key", "string", ""},
+			{"seconds", "number", ""},
+		},
+		Returns: []Param{
+			{"ok", "boolean|nil", ""},
+		}},
+	{Name: "ttl",
+		Doc: "Get a key's time to live in seconds (-1 without expiry, -2 if missing)",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"ttl", "number|nil", ""},
+		}},
+	{Name: "persist",
+		Doc: "Remove a key's expiry",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"ok", "boolean|nil", ""},
+		}},
+	{Name: "hget",
+		Doc: "Get a hash field",
+		Params: []Param{
+			{"key", "string", ""},
+			{"field", "string", ""},
+		},
+		Returns: []Param{
+			{"value", "string|nil", ""},
+		}},
+	{Name: "hset",
+		Doc: "Set hash fields: hset(key, field, value) or hset(key, {field = value, ...})",
+		Params: []Param{
+			{"key", "string", ""},
+			{"field", "string|table", ""},
+			{"value?", "any", ""},
+		},
+		Returns: []Param{
+			{"added", "number|nil", "Number of new fields"},
+		}},
+	{Name: "hgetAll",
+		Doc: "Get all fields of a hash",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"fields", "table|nil", ""},
+		}},
+	{Name: "hdel",
+		Doc: "Delete hash fields",
+		Params: []Param{
+			{"key", "string", ""},
+			{"...", "string", "Fields"},
+		},
+		Returns: []Param{
+			{"removed", "number|nil", ""},
+		}},
+	{Name: "hexists",
+		Doc: "Check if a hash field exists",
+		Params: []Param{
+			{"key", "string", ""},
+			{"field", "string", ""},
+		},
+		Returns: []Param{
+			{"exists", "boolean|nil", ""},
+		}},
+	{Name: "hincr",
+		Doc: "Increment a hash field",
+		Params: []Param{
+			{"key", "string", ""},
+			{"field", "string", ""},
+			{"by?", "number", "Increment (default 1)"},
+		},
+		Returns: []Param{
+			{"value", "number|nil", ""},
+		}},
+	{Name: "hkeys",
+		Doc: "Get the field names of a hash",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"fields", "table|nil", ""},
+		}},
+	{Name: "hlen",
+		Doc: "Get the number of fields in a hash",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"count", "number|nil", ""},
+		}},
+	{Name: "lpush",
+		Doc: "Prepend values to a list",
+		Params: []Param{
+			{"key", "string", ""},
+			{"...", "any", "Values (or a single array table)"},
+		},
+		Returns: []Param{
+			{"length", "number|nil", ""},
+		}},
+	{Name: "rpush",
+		Doc: "Append values to a list",
+		Params: []Param{
+			{"key", "string", ""},
+			{"...", "any", "Values (or a single array table)"},
+		},
+		Returns: []Param{
+			{"length", "number|nil", ""},
+		}},
+	{Name: "lpop",
+		Doc: "Pop the first element of a list",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"value", "string|nil", ""},
+		}},
+	{Name: "rpop",
+		Doc: "Pop the last element of a list",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"value", "string|nil", ""},
+		}},
+	{Name: "lrange",
+		Doc: "Get a range of list elements",
+		Params: []Param{
+			{"key", "string", ""},
+			{"start?", "number", "Default 0"},
+			{"stop?", "number", "Default -1"},
+		},
+		Returns: []Param{
+			{"values", "table|nil", ""},
+		}},
+	{Name: "llen",
+		Doc: "Get the length of a list",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"length", "number|nil", ""},
+		}},
+	{Name: "ltrim",
+		Doc: "Trim a list to the given range",
+		Params: []Param{
+			{"key", "string", ""},
+			{"start", "number", ""},
+			{"stop", "number", ""},
+		},
+		Returns: []Param{
+			{"ok", "boolean|nil", ""},
+		}},
+	{Name: "lrem",
+		Doc: "Remove list elements equal to value",
+		Params: []Param{
+			{"key", "string", ""},
+			{"count", "number", ""},
+			{"value", "any", ""},
+		},
+		Returns: []Param{
+			{"removed", "number|nil", ""},
+		}},
+	{Name: "sadd",
+		Doc: "Add members to a set",
+		Params: []Param{
+			{"key", "string", ""},
+			{"...", "any", "Members (or a single array table)"},
+		},
+		Returns: []Param{
+			{"added", "number|nil", ""},
+		}},
+	{Name: "srem",
+		Doc: "Remove members from a set",
+		Params: []Param{
+			{"key", "string", ""},
+			{"...", "any", "Members"},
+		},
+		Returns: []Param{
+			{"removed", "number|nil", ""},
+		}},
+	{Name: "smembers",
+		Doc: "Get all members of a set",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"members", "table|nil", ""},
+		}},
+	{Name: "sismember",
+		Doc: "Check set membership",
+		Params: []Param{
+			{"key", "string", ""},
+			{"member", "any", ""},
+		},
+		Returns: []Param{
+			{"isMember", "boolean|nil", ""},
+		}},
+	{Name: "scard",
+		Doc: "Get the number of members in a set",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"count", "number|nil", ""},
+		}},
+	{Name: "zadd",
+		Doc: "Add to a sorted set: zadd(key, score, member) or zadd(key, {member = score, ...})",
+		Params: []Param{
+			{"key", "string", ""},
+			{"score", "number|table", ""},
+			{"member?", "string", ""},
+		},
+		Returns: []Param{
+			{"added", "number|nil", ""},
+		}},
+	{Name: "zincr",
+		Doc: "Increment a member's score",
+		Params: []Param{
+			{"key", "string", ""},
+			{"member", "string", ""},
+			{"by?", "number", "Increment (default 1)"},
+		},
+		Returns: []Param{
+			{"score", "number|nil", "New score"},
+		}},
+	{Name: "zrem",
+		Doc: "Remove members from a sorted set",
+		Params: []Param{
+			{"key", "string", ""},
+			{"...", "string", "Members"},
+		},
+		Returns: []Param{
+			{"removed", "number|nil", ""},
+		}},
+	{Name: "zscore",
+		Doc: "Get a member's score",
+		Params: []Param{
+			{"key", "string", ""},
+			{"member", "string", ""},
+		},
+		Returns: []Param{
+			{"score", "number|nil", ""},
+		}},
+	{Name: "zrank",
+		Doc: "Get a member's rank (ascending, 0-based)",
+		Params: []Param{
+			{"key", "string", ""},
+			{"member", "string", ""},
+		},
+		Returns: []Param{
+			{"rank", "number|nil", ""},
+		}},
+	{Name: "zrevrank",
+		Doc: "Get a member's rank (descending, 0-based)",
+		Params: []Param{
+			{"key", "string", ""},
+			{"member", "string", ""},
+		},
+		Returns: []Param{
+			{"rank", "number|nil", ""},
+		}},
+	{Name: "zcard",
+		Doc: "Get the number of members in a sorted set",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"count", "number|nil", ""},
+		}},
+	{Name: "zrange",
+		Doc: "Get members by rank, ascending",
+		Params: []Param{
+			{"key", "string", ""},
+			{"start?", "number", "Default 0"},
+			{"stop?", "number", "Default -1"},
+			{"withScores?", "boolean", "Return {member=..., score=...} entries"},
+		},
+		Returns: []Param{
+			{"members", "table|nil", ""},
+		}},
+	{Name: "zrevrange",
+		Doc: "Get members by rank, descending",
+		Params: []Param{
+			{"key", "string", ""},
+			{"start?", "number", "Default 0"},
+			{"stop?", "number", "Default -1"},
+			{"withScores?", "boolean", "Return {member=..., score=...} entries"},
+		},
+		Returns: []Param{
+			{"members", "table|nil", ""},
+		}},
+	{Name: "zrangeByScore",
+		Doc: "Get members with scores between min and max",
+		Params: []Param{
+			{"key", "string", ""},
+			{"min?", "string", "Default \"-inf\""},
+			{"max?", "string", "Default \"+inf\""},
+			{"offset?", "number", ""},
+			{"count?", "number", ""},
+		},
+		Returns: []Param{
+			{"members", "table|nil", "Array of {member=..., score=...}"},
+		}},
+	{Name: "eval",
+		Doc: "Run a server-side Lua script (keys are prefixed)",
+		Params: []Param{
+			{"script", "string", ""},
+			{"keys?", "table", ""},
+			{"args?", "table", ""},
+		},
+		Returns: []Param{
+			{"result", "any", ""},
+		}},
+	{Name: "scan",
+		Doc: "Scan one page of keys matching pattern",
+		Params: []Param{
+			{"cursor?", "number", "Default 0"},
+			{"pattern?", "string", "Default \"*\""},
+			{"count?", "number", "Default 100"},
+		},
+		Returns: []Param{
+			{"keys", "table|nil", ""},
+			{"nextCursor", "number", "0 when complete"},
+		}},
+	{Name: "keys",
+		Doc: "Collect all keys matching pattern using SCAN",
+		Params: []Param{
+			{"pattern?", "string", "Default \"*\""},
+			{"limit?", "number", "Maximum number of keys (default 10000)"},
+		},
+		Returns: []Param{
+			{"keys", "table|nil", ""},
+		}},
+	{Name: "pipeline",
+		Doc: "Queue commands and send them in one round trip",
+		Params: []Param{
+			{"fn", "fun(p: eocto.redis)", "Function queuing commands on p"},
+		},
+		Returns: []Param{
+			{"results", "table|nil", "Array of results with n = command count"},
+			{"errors", "table", "Per-command errors keyed by index"},
+		}},
+	{Name: "multi",
+		Doc: "Queue commands and run them atomically in MULTI/EXEC",
+		Params: []Param{
+			{"fn", "fun(p: eocto.redis)", "Function queuing commands on p"},
+		},
+		Returns: []Param{
+			{"results", "table|nil", "Array of results with n = command count"},
+			{"errors", "table", "Per-command errors keyed by index"},
+		}},
+}
+
+// cacheFields document the entries of eocto.cache
+var cacheFields = []Binding{
+	{Name: "get",
+		Doc: "Get a cached value",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"value", "any|nil", "nil on a miss"},
+			{"error", "string?", ""},
+		}},
+	{Name: "set",
+		Doc: "Store a value",
+		Params: []Param{
+			{"key", "string", ""},
+			{"value", "any", "String, number, boolean or table"},
+			{"ttl?", "number", "Seconds (0 or nil keeps the value until deleted)"},
+			{"tags?", "string|table", "Tags used by invalidateTags"},
+		},
+		Returns: []Param{
+			{"success", "boolean", ""},
+			{"error", "string?", ""},
+		}},
+	{Name: "delete",
+		Doc: "Delete a cached value",
+		Params: []Param{
+			{"key", "string", ""},
+		},
+		Returns: []Param{
+			{"success", "boolean", ""},
+			{"error", "string?", ""},
+		}},
+	{Name: "remember",
+		Doc: "Return the cached value or compute, store and return fn()'s result.\nConcurrent misses on the same key share a single fn call; with Redis storage the processes of every node take turns, so one computes and the others read its value. Errors and nil results are not cached.",
+		Params: []Param{
+			{"key", "string", ""},
+			{"ttl", "number", "Seconds"},
+			{"fn", "fun(): any", "Computes the value on a miss"},
+			{"tags?", "string|table", ""},
+		},
+		Returns: []Param{
+			{"value", "any|nil", ""},
+			{"error", "string?", ""},
+		},
+		Example: `local cfg = eocto.cache.remember("modules", 60, function()
+    return eocto.decodeJSON(eocto.readYamlFile("config/modules.yaml"))
+end, {"config"})`},
+	{Name: "invalidateTags",
+		Doc: "Expire every value stored with one of the tags",
+		Params: []Param{
+			{"...", "string|table", "Tags"},
+		},
+		Returns: []Param{
+			{"success", "boolean", ""},
+			{"error", "string?", ""},
+		}},
+	{Name: "purgeRoute",
+		Doc: "Expire the cached responses of a route with a `cache:` block",
+		Params: []Param{
+			{"path", "string", "Route pattern (\"/OC/users/:id\") or request path (\"/OC/users/42\")"},
+		},
+		Returns: []Param{
+			{"success", "boolean", ""},
+			{"error", "string?", ""},
+		}},
+	{Name: "stats",
+		Doc: "Cache counters (also served by /metrics/stats)",
+		Returns: []Param{
+			{"", "{backend: string, hits: number, misses: number, hitRatio: number, sets: number, deletes: number, invalidations: number, shared: number}", ""},
+		}},
+}
+
+// queueFields document the entries of eocto.queue
+var queueFields = []Binding{
+	{Name: "enqueue",
+		Doc: "Add a message for the queue's worker; the worker retries it with a backoff when its script raises an error",
+		Params: []Param{
+			{"name", "string", "Queue name"},
+			{"payload", "any", "Value handed to the worker as eocto.job.payload (stored as JSON)"},
+			{"options?", "{delay?: number, retries?: number}", "Seconds before the message is due, retries after a failure (default: the worker's)"},
+		},
+		Returns: []Param{
+			{"id", "string|nil", "Message id"},
+			{"err", "string?", "Error message"},
+		},
+		Example: `eocto.queue.enqueue("whatsapp", {to = phone, text = "Welcome"}, {delay = 5})`},
+	{Name: "stats",
+		Doc:    "Count the messages of a queue",
+		Params: []Param{{"name", "string", "Queue name"}},
+		Returns: []Param{
+			{"stats", "{ready: number, delayed: number, inFlight: number, dead: number}|nil", ""},
+			{"err", "string?", "Error message"},
+		}},
+	{Name: "dead",
+		Doc: "List the dead letters of a queue, newest first: the messages that failed every retry",
+		Params: []Param{
+			{"name", "string", "Queue name"},
+			{"limit?", "number", "Maximum number of letters (default 20)"},
+		},
+		Returns: []Param{
+			{"letters", "{id: string, payload: any, attempts: number, error: string, enqueued: number}[]|nil", ""},
+			{"err", "string?", "Error message"},
+		}},
+	{Name: "requeue",
+		Doc:    "Make the dead letters of a queue due again",
+		Params: []Param{{"name", "string", "Queue name"}},
+		Returns: []Param{
+			{"count", "number", "Number of letters requeued"},
+			{"err", "string?", "Error message"},
+		}},
+}
+
+// responseFields document the entries of eocto.response
+var responseFields = []Binding{
+	{Name: "status",
+		Doc:    "Set the status of the response",
+		Params: []Param{{"code", "number", "HTTP status code"}}},
+	{Name: "header",
+		Doc: "Set a response header, replacing the value set before",
+		Params: []Param{
+			{"name", "string", "Header name"},
+			{"value", "string|string[]|nil", "Value, list of values, or nil to drop the header set before"},
+		},
+		Example: `eocto.response.header("Cache-Control", "no-store")`},
+	{Name: "json",
+		Doc: "Answer with a value encoded as JSON, nested tables, numbers and booleans included (an empty table is sent as [])",
+		Params: []Param{
+			{"value", "any", "Value to encode"},
+			{"status?", "number", "HTTP status code"},
+		},
+		Example: `eocto.response.json({id = id, tags = {"a", "b"}, price = 9.5}, 201)`},
+	{Name: "text",
+		Doc: "Answer with plain text",
+		Params: []Param{
+			{"body", "string", "Text"},
+			{"status?", "number", "HTTP status code"},
+		}},
+	{Name: "html",
+		Doc: "Answer with HTML",
+		Params: []Param{
+			{"body", "string", "HTML"},
+			{"status?", "number", "HTTP status code"},
+		}},
+	{Name: "xml",
+		Doc: "Answer with XML",
+		Params: []Param{
+			{"body", "string", "XML document"},
+			{"status?", "number", "HTTP status code"},
+		}},
+	{Name: "csv",
+		Doc: "Answer with rows encoded as CSV",
+		Params: []Param{
+			{"rows", "any[][]", "Rows, each a list of values"},
+			{"status?", "number", "HTTP status code"},
+		},
+		Example: "eocto.response.header(\"Content-Disposition\", 'attachment; filename=\"users.csv\"')\neocto.response.csv({{\"name\", \"age\"}, {\"Ann\", 31}})"},
+	{Name: "bytes",
+		Doc: "Answer with raw data",
+		Params: []Param{
+			{"data", "string", "Body"},
+			{"contentType", "string", "Content type of the body"},
+			{"status?", "number", "HTTP status code"},
+		}},
+	{Name: "send",
+		Doc:     "Stop the request and send the response set so far, skipping the remaining preChecks and the view",
+		Example: "eocto.response.text(\"pong\")\neocto.response.send()"},
+}
+
+var sseFields = []Binding{
+	{Name: "send",
+		Doc: "Send an event when the stream opens; events are sent in the order of the calls",
+		Params: []Param{
+			{"event", "string", "Event name (\"message\" for EventSource.onmessage)"},
+			{"data", "any", "Data: strings are sent as they are, other values as JSON"},
+			{"id?", "string", "Event ID, sent back as Last-Event-ID when the client reconnects"},
+		},
+		Example: `eocto.sse.send("progress", {done = 3, total = 10}, "3")`},
+	{Name: "subscribe",
+		Doc:     "Subscribe the connection to a room: events emitted to it with eocto.wsEmitToRoom, from any process, are sent while the connection is open. Room events carry an ID, and a client that reconnects first receives the ones emitted after its Last-Event-ID (the last 100 per room, up to 5 minutes old)",
+		Params:  []Param{{"room", "string", "Room ID"}},
+		Example: `eocto.sse.subscribe("job:" .. id)`},
+	{Name: "unsubscribe",
+		Doc:    "Drop a room subscribed to before",
+		Params: []Param{{"room", "string", "Room ID"}}},
+	{Name: "lastEventId",
+		Doc:     "The ID of the last event the client received, sent by EventSource when it reconnects; missed room events are replayed without the script's help",
+		Returns: []Param{{"id", "string|nil", "Last-Event-ID header, nil on the first connection"}},
+		Example: `local last = tonumber(eocto.sse.lastEventId() or "0")
+for i = last + 1, #events do
+    eocto.sse.send("notice", events[i], tostring(i))
+end`},
+	{Name: "retry",
+		Doc:    "Set how long the client waits before reconnecting after the connection drops",
+		Params: []Param{{"ms", "number", "Delay in milliseconds"}}},
+}
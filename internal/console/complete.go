@@ -0,0 +1,98 @@
+package console
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/degreane/octopus/internal/bindings"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// word is the dotted name being typed at the end of a line
+var word = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*(?:[.:][A-Za-z_][A-Za-z0-9_]*)*[.:]?$`)
+
+// Complete returns the completions of the name at the end of line, as full
+// names (e.g. "eocto.getSession"). Members of eocto come from the binding
+// registry; other names from the tables of the session's state.
+func (s *Session) Complete(line string) []string {
+	name := word.FindString(line)
+	if name == "" {
+		return nil
+	}
+	cut := strings.LastIndexAny(name, ".:")
+	parent, prefix := "", name
+	if cut >= 0 {
+		parent, prefix = name[:cut], name[cut+1:]
+	}
+
+	candidates := map[string]bool{}
+	for _, key := range registryKeys(parent) {
+		candidates[key] = true
+	}
+	s.mu.Lock()
+	for _, key := range s.tableKeys(parent) {
+		candidates[key] = true
+	}
+	s.mu.Unlock()
+
+	var out []string
+	for key := range candidates {
+		if strings.HasPrefix(key, prefix) {
+			if cut >= 0 {
+				key = name[:cut+1] + key
+			}
+			out = append(out, key)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// registryKeys returns the documented members of eocto or of one of its tables
+func registryKeys(parent string) []string {
+	path := strings.Split(parent, ".")
+	if path[0] != "eocto" || len(path) > 2 {
+		return nil
+	}
+	var keys []string
+	for _, b := range bindings.Documented() {
+		if b.Contexts&bindings.HTTP == 0 {
+			continue
+		}
+		if len(path) == 1 {
+			keys = append(keys, b.Name)
+		} else if b.Name == path[1] {
+			for _, f := range b.Fields {
+				keys = append(keys, f.Name)
+			}
+		}
+	}
+	return keys
+}
+
+// tableKeys returns the string keys of the table reached by parent from the
+// globals (the globals themselves when parent is empty)
+func (s *Session) tableKeys(parent string) []string {
+	var v lua.LValue = s.L.G.Global
+	if parent != "" {
+		for _, part := range strings.Split(strings.ReplaceAll(parent, ":", "."), ".") {
+			tbl, ok := v.(*lua.LTable)
+			if !ok {
+				return nil
+			}
+			v = tbl.RawGetString(part)
+		}
+	}
+	tbl, ok := v.(*lua.LTable)
+	if !ok {
+		return nil
+	}
+	var keys []string
+	tbl.ForEach(func(k, _ lua.LValue) {
+		if name, isString := k.(lua.LString); isString && identifier(string(name)) {
+			keys = append(keys, string(name))
+		}
+	})
+	return keys
+}
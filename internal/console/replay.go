@@ -0,0 +1,221 @@
+package console
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/routes"
+	"github.com/degreane/octopus/internal/service/capture"
+	"github.com/degreane/octopus/internal/service/luapool"
+	"github.com/degreane/octopus/internal/utilities"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Event reports where a replay stands
+type Event struct {
+	// Step is the index of the preCheck script about to run
+	Step  int `json:"step"`
+	Total int `json:"total"`
+	// Next is the script about to run, empty when the route handler is next
+	Next string `json:"next,omitempty"`
+	// Locals are the request locals at this point
+	Locals map[string]string `json:"locals,omitempty"`
+	// Output is what the scripts run since the last pause printed
+	Output string `json:"output,omitempty"`
+
+	// Done is set once the response is written
+	Done    bool              `json:"done"`
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	// View is the template the response rendered
+	View string `json:"view,omitempty"`
+}
+
+// replay is a request running through a route's handler chain, paused
+// between its preCheck scripts
+type replay struct {
+	resume chan bool
+	events chan Event
+	all    bool
+	done   bool
+	views  *views
+}
+
+// next lets the chain run to the next pause and returns it
+func (r *replay) next(all bool) Event {
+	r.all = all
+	r.resume <- true
+	ev := <-r.events
+	r.done = ev.Done
+	return ev
+}
+
+func (r *replay) abort() {
+	if !r.done {
+		r.resume <- false
+		<-r.events
+		r.done = true
+	}
+}
+
+// Replay runs req through the preCheck chain of its route on the session's
+// state and pauses before the first script. Step and Continue move it on;
+// evaluated code sees the replayed request until it is done.
+func (s *Session) Replay(req capture.Request) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var route *config.Route
+	for i, r := range s.Module.Routes {
+		if strings.EqualFold(r.Method, req.Method) && r.Path == req.Route && !r.WebSocket {
+			route = &s.Module.Routes[i]
+			break
+		}
+	}
+	if route == nil {
+		return Event{}, fmt.Errorf("module %s has no %s %s route", s.Module.Name, req.Method, req.Route)
+	}
+	var scripts []string
+	for _, check := range s.Module.Checks(*route) {
+		if check.Script != "" {
+			scripts = append(scripts, check.Script)
+		}
+	}
+	if s.replay != nil {
+		s.replay.abort()
+	}
+
+	r := &replay{resume: make(chan bool), events: make(chan Event), views: &views{}}
+	app := fiber.New(fiber.Config{Views: r.views})
+	handlers := []fiber.Handler{func(c *fiber.Ctx) error {
+		s.env.Ctx = c
+		c.Locals("luaState", &luapool.State{L: s.L, Ctx: c})
+		defer func() {
+			c.Locals("luaState", nil)
+			s.env.Ctx = s.ctx
+			utilities.DetachStream(c, func() {})
+		}()
+		return c.Next()
+	}}
+	for i, script := range scripts {
+		handlers = append(handlers, r.gate(i, len(scripts), script), routes.BoundScript(script, s.Module, *route, s.Module.ScriptsDir()))
+	}
+	if route.SSE {
+		// The replay shows the events queued by the scripts, not a live stream
+		handlers = append([]fiber.Handler{utilities.OpenSSE}, handlers...)
+		handlers = append(handlers, r.gate(len(scripts), len(scripts), ""), routes.SSE(false))
+	} else {
+		handlers = append(handlers, r.gate(len(scripts), len(scripts), ""), routes.View(s.Module, *route))
+	}
+	app.Group(s.Module.BasePath).Add(route.Method, route.Path, handlers...)
+
+	httpReq := httptest.NewRequest(req.Method, req.Path, strings.NewReader(req.Body))
+	for k, v := range req.Headers {
+		httpReq.Header.Set(k, v)
+	}
+	go func() {
+		ev := Event{Done: true, Total: len(scripts), Step: len(scripts)}
+		resp, err := app.Test(httpReq, -1)
+		if err != nil {
+			ev.Body = err.Error()
+		} else {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			ev.Status = resp.StatusCode
+			ev.Body = string(body)
+			ev.Headers = make(map[string]string)
+			for k, v := range resp.Header {
+				ev.Headers[k] = strings.Join(v, ", ")
+			}
+			ev.View = r.views.name
+		}
+		r.events <- ev
+	}()
+
+	s.replay = r
+	ev := <-r.events
+	r.done = ev.Done
+	if ev.Done {
+		s.replay = nil
+	}
+	return s.flush(ev), nil
+}
+
+// Step runs the next preCheck script of the replay
+func (s *Session) Step() (Event, error) {
+	return s.advance(false)
+}
+
+// Continue runs the rest of the replay
+func (s *Session) Continue() (Event, error) {
+	return s.advance(true)
+}
+
+func (s *Session) advance(all bool) (Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replay == nil || s.replay.done {
+		return Event{}, fmt.Errorf("no replay is paused")
+	}
+	ev := s.replay.next(all)
+	if ev.Done {
+		s.replay = nil
+	}
+	return s.flush(ev), nil
+}
+
+// flush moves what the replayed scripts printed to ev
+func (s *Session) flush(ev Event) Event {
+	ev.Output = s.out.String()
+	s.out.Reset()
+	return ev
+}
+
+// Abort stops the replay without running the rest of the chain
+func (s *Session) Abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replay != nil {
+		s.replay.abort()
+		s.replay = nil
+	}
+}
+
+// gate pauses the chain before step i
+func (r *replay) gate(i, total int, script string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if r.all {
+			return c.Next()
+		}
+		ev := Event{Step: i, Total: total, Next: script, Locals: make(map[string]string)}
+		c.Context().VisitUserValues(func(k []byte, v interface{}) {
+			if key := string(k); key != "luaState" && v != nil {
+				ev.Locals[key] = fmt.Sprint(v)
+			}
+		})
+		r.events <- ev
+		if !<-r.resume {
+			return fiber.NewError(fiber.StatusRequestTimeout, "replay aborted")
+		}
+		return c.Next()
+	}
+}
+
+// views is the view engine of replays: it renders nothing and keeps the
+// name of the view rendered
+type views struct {
+	name string
+}
+
+func (v *views) Load() error {
+	return nil
+}
+
+func (v *views) Render(_ io.Writer, name string, _ interface{}, _ ...string) error {
+	v.name = name
+	return nil
+}
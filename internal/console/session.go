@@ -0,0 +1,257 @@
+// Package console evaluates Lua interactively against a module.
+//
+// A Session is a Lua state built like the module's preCheck states, with the
+// module's eocto bindings reaching the configured MongoDB and Redis, bound to
+// a synthetic request that can be replaced at any time. A captured request can
+// be replayed through its route's preCheck chain one script at a time; while
+// the replay is paused, evaluated code sees the request being replayed.
+//
+// Sessions back both `octopus repl` and the web console a development server
+// mounts with Mount.
+package console
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/bindings"
+	"github.com/degreane/octopus/internal/routes"
+	"github.com/degreane/octopus/internal/service/capture"
+	"github.com/degreane/octopus/internal/service/limits"
+	"github.com/degreane/octopus/internal/service/sandbox"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Session is an interactive Lua state bound to a module
+type Session struct {
+	Module config.ModulesConfig
+
+	mu      sync.Mutex
+	L       *lua.LState
+	limit   limits.Limits
+	env     *bindings.Env
+	app     *fiber.App
+	ctx     *fiber.Ctx
+	request capture.Request
+	out     strings.Builder
+	replay  *replay
+}
+
+// Result is the outcome of an evaluation
+type Result struct {
+	// Values are the values the code returned, formatted
+	Values []string `json:"values"`
+	// Output is what the code printed
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+	// Incomplete is set when the code ends before a statement does, so a
+	// console can read more lines before evaluating it
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// New opens a session on module, bound to a GET request for its base path
+func New(module config.ModulesConfig) *Session {
+	if module.AbsolutePath == "" {
+		// The server sets it to its working directory when it sets up the routes
+		module.AbsolutePath, _ = os.Getwd()
+	}
+	s := &Session{
+		Module: module,
+		limit:  limits.Resolve(module.Limits, nil),
+		app:    fiber.New(),
+	}
+	if err := routes.RunInit(module); err != nil {
+		debug.Debug(debug.Error, err.Error())
+	}
+	s.L = lua.NewState(s.limit.Options())
+	sandbox.Open(s.L, sandbox.PolicyFor(module))
+	s.env = &bindings.Env{Context: bindings.HTTP, Module: module}
+	bindings.Install(s.L, func() *bindings.Env { return s.env })
+	s.L.SetGlobal("print", s.L.NewFunction(s.print))
+	s.SetRequest(capture.Request{Method: fiber.MethodGet, Path: module.BasePath})
+	return s
+}
+
+// Close aborts a running replay and closes the state
+func (s *Session) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replay != nil {
+		s.replay.abort()
+		s.replay = nil
+	}
+	s.app.ReleaseCtx(s.ctx)
+	s.L.Close()
+}
+
+// SetRequest binds the session to a synthetic request
+func (s *Session) SetRequest(req capture.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.Method == "" {
+		req.Method = fiber.MethodGet
+	}
+	if req.Path == "" {
+		req.Path = s.Module.BasePath
+	}
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.Header.SetMethod(req.Method)
+	fctx.Request.SetRequestURI(req.Path)
+	for k, v := range req.Headers {
+		fctx.Request.Header.Set(k, v)
+	}
+	fctx.Request.SetBodyString(req.Body)
+
+	if s.ctx != nil {
+		s.app.ReleaseCtx(s.ctx)
+	}
+	s.ctx = s.app.AcquireCtx(fctx)
+	s.request = req
+	if s.replay == nil {
+		s.env.Ctx = s.ctx
+	}
+}
+
+// Request returns the synthetic request the session is bound to
+func (s *Session) Request() capture.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.request
+}
+
+// print captures the output of the code being evaluated
+func (s *Session) print(L *lua.LState) int {
+	for i := 1; i <= L.GetTop(); i++ {
+		if i > 1 {
+			s.out.WriteByte('\t')
+		}
+		s.out.WriteString(L.ToStringMeta(L.Get(i)).String())
+	}
+	s.out.WriteByte('\n')
+	return 0
+}
+
+// Eval runs code, as an expression when it is one, under the module's script limits
+func (s *Session) Eval(code string) Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	L := s.L
+	fn, err := L.Load(strings.NewReader("return "+code), "console")
+	if err != nil {
+		fn, err = L.Load(strings.NewReader(code), "console")
+	}
+	if err != nil {
+		return Result{Error: err.Error(), Incomplete: strings.Contains(err.Error(), "at EOF:")}
+	}
+
+	top := L.GetTop()
+	err = limits.Run(context.Background(), L, s.limit, "console", func() error {
+		L.Push(fn)
+		return L.PCall(0, lua.MultRet, nil)
+	})
+	res := Result{Output: s.out.String()}
+	s.out.Reset()
+	if err != nil {
+		res.Error = err.Error()
+	} else {
+		for i := top + 1; i <= L.GetTop(); i++ {
+			res.Values = append(res.Values, Format(L.Get(i)))
+		}
+	}
+	L.SetTop(top)
+	return res
+}
+
+// Format renders a Lua value for display; tables are shown three levels deep
+func Format(v lua.LValue) string {
+	var b strings.Builder
+	format(&b, v, 0, map[*lua.LTable]bool{})
+	return b.String()
+}
+
+func format(b *strings.Builder, v lua.LValue, depth int, seen map[*lua.LTable]bool) {
+	tbl, ok := v.(*lua.LTable)
+	if !ok {
+		if s, isString := v.(lua.LString); isString {
+			fmt.Fprintf(b, "%q", string(s))
+			return
+		}
+		b.WriteString(v.String())
+		return
+	}
+	if seen[tbl] || depth >= 3 {
+		b.WriteString("{...}")
+		return
+	}
+	seen[tbl] = true
+	defer delete(seen, tbl)
+
+	var keys []lua.LValue
+	tbl.ForEach(func(k, _ lua.LValue) { keys = append(keys, k) })
+	n := tbl.Len()
+	sort.SliceStable(keys, func(i, j int) bool {
+		ki, iInt := arrayIndex(keys[i], n)
+		kj, jInt := arrayIndex(keys[j], n)
+		if iInt != jInt {
+			return iInt
+		}
+		if iInt {
+			return ki < kj
+		}
+		return keys[i].String() < keys[j].String()
+	})
+
+	b.WriteString("{")
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if i == 50 {
+			fmt.Fprintf(b, "... (%d more)", len(keys)-i)
+			break
+		}
+		if _, isIndex := arrayIndex(k, n); !isIndex {
+			if s, isString := k.(lua.LString); isString && identifier(string(s)) {
+				b.WriteString(string(s))
+			} else {
+				b.WriteString("[")
+				format(b, k, depth+1, seen)
+				b.WriteString("]")
+			}
+			b.WriteString(" = ")
+		}
+		format(b, tbl.RawGet(k), depth+1, seen)
+	}
+	b.WriteString("}")
+}
+
+// arrayIndex reports whether k is an index of the array part of a table of length n
+func arrayIndex(k lua.LValue, n int) (int, bool) {
+	num, ok := k.(lua.LNumber)
+	if !ok || float64(num) != float64(int(num)) || int(num) < 1 || int(num) > n {
+		return 0, false
+	}
+	return int(num), true
+}
+
+func identifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,312 @@
+package console
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/service/capture"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	"github.com/gofiber/fiber/v2"
+)
+
+//go:embed console.html
+var page string
+
+const (
+	// DefaultPath is where the web console is mounted when no Path is configured
+	DefaultPath = "/_console"
+
+	tokenCookie   = "octopus_console"
+	sessionCookie = "octopus_console_session"
+
+	// idle is how long a web console session lives without being used
+	idle = 30 * time.Minute
+)
+
+// web serves the console over HTTP; every browser gets its own session per module
+type web struct {
+	path    string
+	token   string
+	modules []config.ModulesConfig
+
+	mu       sync.Mutex
+	sessions map[string]*webSession
+}
+
+type webSession struct {
+	*Session
+	used time.Time
+}
+
+// Mount serves the web console under cfg.Path. It refuses to in production:
+// the console runs arbitrary Lua against the module's databases.
+func Mount(app *fiber.App, cfg config.ConsoleConfig, modules []config.ModulesConfig) {
+	if config.New().Environment == "production" {
+		debug.Debug(debug.Warning, "Lua console is not mounted in production")
+		return
+	}
+	w := &web{path: cfg.Path, token: cfg.Token, modules: modules, sessions: make(map[string]*webSession)}
+	if w.path == "" {
+		w.path = DefaultPath
+	}
+	w.path = "/" + strings.Trim(w.path, "/")
+	if w.token == "" {
+		w.token = randomHex(16)
+		debug.Debug(debug.Important, fmt.Sprintf("Lua console: %s?token=%s", w.path, w.token))
+	}
+	go w.prune()
+
+	group := app.Group(w.path)
+	group.Get("/", w.index)
+	group.Post("/login", w.login)
+
+	api := group.Group("/api", w.auth)
+	api.Get("/modules", w.listModules)
+	api.Post("/eval", w.eval)
+	api.Get("/complete", w.complete)
+	api.Get("/request", w.request)
+	api.Post("/request", w.setRequest)
+	api.Get("/requests", func(c *fiber.Ctx) error {
+		return c.JSON(capture.List())
+	})
+	api.Post("/replay", w.replay)
+	api.Post("/step", w.advance((*Session).Step))
+	api.Post("/continue", w.advance((*Session).Continue))
+	api.Post("/abort", func(c *fiber.Ctx) error {
+		s, err := w.session(c, c.Query("module"))
+		if err != nil {
+			return failure(c, fiber.StatusBadRequest, err)
+		}
+		s.Abort()
+		return c.JSON(fiber.Map{"aborted": true})
+	})
+}
+
+// authorized checks the console cookie or an "Authorization: Bearer" header
+func (w *web) authorized(c *fiber.Ctx) bool {
+	given := c.Cookies(tokenCookie)
+	if auth := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+		given = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(w.token)) == 1
+}
+
+func (w *web) auth(c *fiber.Ctx) error {
+	if !w.authorized(c) {
+		return failure(c, fiber.StatusUnauthorized, fmt.Errorf("console token required"))
+	}
+	return c.Next()
+}
+
+// index serves the console page, logging in with ?token= first when given
+func (w *web) index(c *fiber.Ctx) error {
+	if token := c.Query("token"); token != "" {
+		return w.signIn(c, token)
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Type("html", "utf-8")
+	if !w.authorized(c) {
+		return c.SendString(strings.ReplaceAll(loginPage, "{{path}}", w.path))
+	}
+	return c.SendString(page)
+}
+
+func (w *web) login(c *fiber.Ctx) error {
+	return w.signIn(c, c.FormValue("token"))
+}
+
+func (w *web) signIn(c *fiber.Ctx, token string) error {
+	if subtle.ConstantTimeCompare([]byte(token), []byte(w.token)) != 1 {
+		return c.Status(fiber.StatusUnauthorized).Type("html", "utf-8").
+			SendString(strings.ReplaceAll(loginPage, "{{path}}", w.path))
+	}
+	c.Cookie(&fiber.Cookie{Name: tokenCookie, Value: w.token, Path: w.path, HTTPOnly: true, SameSite: fiber.CookieSameSiteStrictMode})
+	c.Cookie(&fiber.Cookie{Name: sessionCookie, Value: randomHex(8), Path: w.path, HTTPOnly: true, SameSite: fiber.CookieSameSiteStrictMode})
+	return c.Redirect(w.path + "/")
+}
+
+// session returns the caller's session on the module named (or based at) name
+func (w *web) session(c *fiber.Ctx, name string) (*Session, error) {
+	module, ok := w.module(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown module %q", name)
+	}
+	id := c.Cookies(sessionCookie)
+	if id == "" {
+		id = c.Get(fiber.HeaderAuthorization)
+	}
+	key := id + "\x00" + module.Name
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s, ok := w.sessions[key]
+	if !ok {
+		s = &webSession{Session: New(module)}
+		w.sessions[key] = s
+	}
+	s.used = time.Now()
+	return s.Session, nil
+}
+
+func (w *web) module(name string) (config.ModulesConfig, bool) {
+	for _, m := range w.modules {
+		if m.Name == name || (m.BasePath != "" && m.BasePath == name) {
+			return m, true
+		}
+	}
+	return config.ModulesConfig{}, false
+}
+
+// prune closes the sessions left idle
+func (w *web) prune() {
+	for range time.Tick(time.Minute) {
+		w.mu.Lock()
+		for key, s := range w.sessions {
+			if time.Since(s.used) > idle {
+				s.Close()
+				delete(w.sessions, key)
+			}
+		}
+		w.mu.Unlock()
+	}
+}
+
+func (w *web) listModules(c *fiber.Ctx) error {
+	type route struct {
+		Method  string   `json:"method"`
+		Path    string   `json:"path"`
+		Scripts []string `json:"scripts"`
+	}
+	type module struct {
+		Name     string  `json:"name"`
+		BasePath string  `json:"basePath"`
+		Routes   []route `json:"routes"`
+	}
+	list := make([]module, 0, len(w.modules))
+	for _, m := range w.modules {
+		entry := module{Name: m.Name, BasePath: m.BasePath}
+		for _, r := range m.Routes {
+			if r.WebSocket {
+				continue
+			}
+			rt := route{Method: r.Method, Path: r.Path}
+			for _, check := range m.Checks(r) {
+				if check.Script != "" {
+					rt.Scripts = append(rt.Scripts, check.Script)
+				}
+			}
+			entry.Routes = append(entry.Routes, rt)
+		}
+		list = append(list, entry)
+	}
+	return c.JSON(list)
+}
+
+func (w *web) eval(c *fiber.Ctx) error {
+	var body struct {
+		Module string `json:"module"`
+		Code   string `json:"code"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return failure(c, fiber.StatusBadRequest, err)
+	}
+	s, err := w.session(c, body.Module)
+	if err != nil {
+		return failure(c, fiber.StatusBadRequest, err)
+	}
+	return c.JSON(s.Eval(body.Code))
+}
+
+func (w *web) complete(c *fiber.Ctx) error {
+	s, err := w.session(c, c.Query("module"))
+	if err != nil {
+		return failure(c, fiber.StatusBadRequest, err)
+	}
+	list := s.Complete(c.Query("text"))
+	if list == nil {
+		list = []string{}
+	}
+	return c.JSON(list)
+}
+
+func (w *web) request(c *fiber.Ctx) error {
+	s, err := w.session(c, c.Query("module"))
+	if err != nil {
+		return failure(c, fiber.StatusBadRequest, err)
+	}
+	return c.JSON(s.Request())
+}
+
+func (w *web) setRequest(c *fiber.Ctx) error {
+	var req capture.Request
+	if err := c.BodyParser(&req); err != nil {
+		return failure(c, fiber.StatusBadRequest, err)
+	}
+	s, err := w.session(c, req.Module)
+	if err != nil {
+		return failure(c, fiber.StatusBadRequest, err)
+	}
+	s.SetRequest(req)
+	return c.JSON(s.Request())
+}
+
+func (w *web) replay(c *fiber.Ctx) error {
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return failure(c, fiber.StatusBadRequest, err)
+	}
+	req, ok := capture.Find(capture.List(), body.ID)
+	if !ok {
+		return failure(c, fiber.StatusNotFound, fmt.Errorf("no captured request %q", body.ID))
+	}
+	s, err := w.session(c, req.Module)
+	if err != nil {
+		return failure(c, fiber.StatusBadRequest, err)
+	}
+	ev, err := s.Replay(req)
+	if err != nil {
+		return failure(c, fiber.StatusBadRequest, err)
+	}
+	return c.JSON(ev)
+}
+
+func (w *web) advance(step func(*Session) (Event, error)) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		s, err := w.session(c, c.Query("module"))
+		if err != nil {
+			return failure(c, fiber.StatusBadRequest, err)
+		}
+		ev, err := step(s)
+		if err != nil {
+			return failure(c, fiber.StatusConflict, err)
+		}
+		return c.JSON(ev)
+	}
+}
+
+func failure(c *fiber.Ctx, status int, err error) error {
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+const loginPage = `<!doctype html>
+<html><head><meta charset="utf-8"><title>Octopus Lua console</title>
+<style>body{font:14px system-ui,sans-serif;background:#1e1e1e;color:#ddd;display:flex;justify-content:center;margin-top:20vh}
+input,button{font:inherit;padding:6px 10px;background:#2d2d2d;color:#ddd;border:1px solid #555}</style></head>
+<body><form method="post" action="{{path}}/login"><p>Console token</p>
+<input type="password" name="token" autofocus> <button>Open</button></form></body></html>
+`
@@ -22,12 +22,16 @@ func NewRedisStorage() *redis.Storage {
 
 var redisClient *rds.Client
 
-// InitRedis initializes the Redis client connection
+// InitRedis initializes the Redis client connection.
+// An empty addr falls back to the local default "0.0.0.0:6379".
 func InitRedis(addr, password string, db int) error {
+	if addr == "" {
+		addr = "0.0.0.0:6379"
+	}
 	redisClient = rds.NewClient(&rds.Options{
-		Addr:     "0.0.0.0:6379",
-		Password: "",
-		DB:       0,
+		Addr:     addr,
+		Password: password,
+		DB:       db,
 	})
 
 	// Test the connection
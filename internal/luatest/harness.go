@@ -0,0 +1,385 @@
+package luatest
+
+import (
+	_ "embed"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/bindings"
+	"github.com/degreane/octopus/internal/middleware"
+	"github.com/degreane/octopus/internal/routes"
+	"github.com/degreane/octopus/internal/service/limits"
+	"github.com/degreane/octopus/internal/service/luapool"
+	"github.com/degreane/octopus/internal/service/sandbox"
+	"github.com/degreane/octopus/internal/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+//go:embed prelude.lua
+var prelude string
+
+// offline are the bindings reaching external services; specs must stub them
+var offline = []string{
+	"getDataFromCollection", "setDataToCollection", "delDataFromCollection", "insertDataToCollection",
+	"makeRequest", "proxy", "sendWhatsAppMessage",
+	"getRedis", "setRedis", "deleteRedis",
+}
+
+// harness is the state a spec file runs in
+type harness struct {
+	module config.ModulesConfig
+	L      *lua.LState
+	env    *bindings.Env
+	views  *recorder
+	runner lua.LValue
+	out    strings.Builder
+
+	// idle is the request bindings see outside run()
+	app  *fiber.App
+	idle *fiber.Ctx
+
+	// real bindings the harness itself relies on, kept from stubs
+	setSession lua.LValue
+	getSession lua.LValue
+	getLocals  lua.LValue
+	encodeJSON lua.LValue
+	decodeJSON lua.LValue
+}
+
+// newHarness builds a state like the module's preCheck states and installs
+// the test API
+func newHarness(module config.ModulesConfig) *harness {
+	h := &harness{module: module, views: &recorder{}}
+	h.app = fiber.New()
+	h.idle = h.app.AcquireCtx(&fasthttp.RequestCtx{})
+	h.idle.Request().SetRequestURI(module.BasePath)
+	h.env = &bindings.Env{Context: bindings.HTTP, Module: module, Ctx: h.idle}
+
+	h.L = lua.NewState(limits.Resolve(module.Limits, nil).Options())
+	sandbox.Open(h.L, sandbox.PolicyFor(module))
+	eocto := bindings.Install(h.L, func() *bindings.Env { return h.env })
+	h.setSession = eocto.RawGetString("setSession")
+	h.getSession = eocto.RawGetString("getSession")
+	h.getLocals = eocto.RawGetString("getLocals")
+	h.encodeJSON = eocto.RawGetString("encodeJSON")
+	h.decodeJSON = eocto.RawGetString("decodeJSON")
+
+	for _, name := range offline {
+		if eocto.RawGetString(name) != lua.LNil {
+			eocto.RawSetString(name, h.notStubbed("eocto", name))
+		}
+	}
+	for _, name := range []string{"redis", "http"} {
+		if tbl, ok := eocto.RawGetString(name).(*lua.LTable); ok {
+			tbl.ForEach(func(k, v lua.LValue) {
+				if v.Type() == lua.LTFunction {
+					tbl.RawSet(k, h.notStubbed("eocto."+name, k.String()))
+				}
+			})
+		}
+	}
+
+	h.L.SetGlobal("print", h.L.NewFunction(h.print))
+	h.L.SetGlobal("run", h.L.NewFunction(h.run))
+	return h
+}
+
+func (h *harness) close() {
+	h.app.ReleaseCtx(h.idle)
+	h.L.Close()
+}
+
+// notStubbed raises when a spec reaches an external service
+func (h *harness) notStubbed(table, name string) *lua.LFunction {
+	return h.L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("%s.%s reaches an external service; replace it with stub(%s, %q, fn)", table, name, table, name)
+		return 0
+	})
+}
+
+// print captures the output of the current test
+func (h *harness) print(L *lua.LState) int {
+	for i := 1; i <= L.GetTop(); i++ {
+		if i > 1 {
+			h.out.WriteByte('\t')
+		}
+		h.out.WriteString(L.ToStringMeta(L.Get(i)).String())
+	}
+	h.out.WriteByte('\n')
+	return 0
+}
+
+func (h *harness) output() string {
+	return h.out.String()
+}
+
+// load installs the test API and runs the spec file, registering its tests
+func (h *harness) load(path string) error {
+	fn, err := h.L.Load(strings.NewReader(prelude), "luatest")
+	if err != nil {
+		return err
+	}
+	if err := h.L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}); err != nil {
+		return err
+	}
+	h.runner = h.L.Get(-1)
+	h.L.Pop(1)
+	return h.L.DoFile(path)
+}
+
+// runTests runs the registered tests
+func (h *harness) runTests() []Case {
+	var cases []Case
+	var started time.Time
+	report := h.L.NewFunction(func(L *lua.LState) int {
+		name, status, message := L.CheckString(1), L.CheckString(2), L.CheckString(3)
+		if status == "start" {
+			h.out.Reset()
+			started = time.Now()
+			return 0
+		}
+		c := Case{Name: name, Status: Status(status), Message: message}
+		if c.Status != Pending {
+			c.Output = h.out.String()
+			c.Duration = time.Since(started)
+		}
+		cases = append(cases, c)
+		return 0
+	})
+	if err := h.L.CallByParam(lua.P{Fn: h.runner, NRet: 0, Protect: true}, report); err != nil {
+		cases = append(cases, Case{Name: "runner", Status: Error, Message: err.Error(), Output: h.out.String()})
+	}
+	return cases
+}
+
+// call calls a Lua function and returns its first result, nil on error
+func (h *harness) call(fn lua.LValue, args ...lua.LValue) lua.LValue {
+	if err := h.L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, args...); err != nil {
+		return lua.LNil
+	}
+	ret := h.L.Get(-1)
+	h.L.Pop(1)
+	return ret
+}
+
+// fakeRequest is a request built by request{...} in a spec
+type fakeRequest struct {
+	method  string
+	path    string
+	headers map[string]string
+	cookies map[string]string
+	body    string
+	session *lua.LTable
+}
+
+// run implements run([script,] req): it serves req through the module's
+// routes, running script, or the preCheck chain of the matching route, on the
+// spec's state, and returns what the request produced
+func (h *harness) run(L *lua.LState) int {
+	var scripts []string
+	arg := 1
+	if L.Get(1).Type() == lua.LTString {
+		scripts = []string{L.CheckString(1)}
+		arg = 2
+	}
+	req := h.parseRequest(L.OptTable(arg, L.NewTable()))
+	// Scripts run on this state with run's frame on top; a script's return
+	// value must not be mistaken for run's arguments
+	L.SetTop(0)
+
+	res, err := h.serve(scripts, req)
+	if err != nil {
+		L.RaiseError("run: %v", err)
+	}
+	L.Push(res)
+	return 1
+}
+
+// parseRequest reads a request table
+func (h *harness) parseRequest(tbl *lua.LTable) fakeRequest {
+	req := fakeRequest{
+		method:  strings.ToUpper(lua.LVAsString(tbl.RawGetString("method"))),
+		path:    lua.LVAsString(tbl.RawGetString("path")),
+		headers: stringMap(tbl.RawGetString("headers")),
+		cookies: stringMap(tbl.RawGetString("cookies")),
+	}
+	if req.method == "" {
+		req.method = fiber.MethodGet
+	}
+	if req.path == "" {
+		req.path = h.module.BasePath
+	}
+	if query, ok := tbl.RawGetString("query").(*lua.LTable); ok {
+		values := url.Values{}
+		query.ForEach(func(k, v lua.LValue) { values.Set(k.String(), v.String()) })
+		sep := "?"
+		if strings.Contains(req.path, "?") {
+			sep = "&"
+		}
+		req.path += sep + values.Encode()
+	}
+	if session, ok := tbl.RawGetString("session").(*lua.LTable); ok {
+		req.session = session
+	}
+
+	switch body := tbl.RawGetString("body").(type) {
+	case lua.LString:
+		req.body = string(body)
+	case *lua.LTable:
+		req.body = lua.LVAsString(h.call(h.encodeJSON, body))
+		setDefault(req.headers, fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	if form, ok := tbl.RawGetString("form").(*lua.LTable); ok {
+		values := url.Values{}
+		form.ForEach(func(k, v lua.LValue) { values.Set(k.String(), v.String()) })
+		req.body = values.Encode()
+		setDefault(req.headers, fiber.HeaderContentType, fiber.MIMEApplicationForm)
+	}
+	return req
+}
+
+// exchange is what one run() observed inside the handler chain
+type exchange struct {
+	seed    *lua.LTable
+	reached bool
+	locals  lua.LValue
+	session lua.LValue
+	returns *lua.LTable
+}
+
+// serve runs req through a throwaway app holding the module's routes
+func (h *harness) serve(scripts []string, req fakeRequest) (*lua.LTable, error) {
+	h.views.reset()
+	app := fiber.New(fiber.Config{Views: h.views})
+	ex := &exchange{seed: req.session, returns: h.L.NewTable()}
+
+	group := app.Group(h.module.BasePath)
+	for _, route := range h.module.Routes {
+		if route.WebSocket {
+			continue
+		}
+		if route.SSE {
+			// The events queued by the scripts make the body; the stream ends there
+			handlers := h.chain(ex, scripts, route, routes.SSE(false))
+			group.Add(route.Method, route.Path, append([]fiber.Handler{utilities.OpenSSE}, handlers...)...)
+			continue
+		}
+		group.Add(route.Method, route.Path, h.chain(ex, scripts, route, routes.View(h.module, route))...)
+	}
+	if scripts != nil {
+		// A script can be tested on a path no route serves; there is no view
+		// to render, but responses set from Lua are sent
+		fallback := config.Route{Method: req.method, Path: req.path}
+		view := routes.View(h.module, fallback)
+		app.All("/*", h.chain(ex, scripts, fallback, func(c *fiber.Ctx) error {
+			if utilities.ResponseOf(c) == nil {
+				return nil
+			}
+			return view(c)
+		})...)
+	}
+
+	httpReq := httptest.NewRequest(req.method, req.path, strings.NewReader(req.body))
+	for k, v := range req.headers {
+		httpReq.Header.Set(k, v)
+	}
+	for k, v := range req.cookies {
+		httpReq.AddCookie(&http.Cookie{Name: k, Value: v})
+	}
+	resp, err := app.Test(httpReq, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	L := h.L
+	res := L.NewTable()
+	res.RawSetString("status", lua.LNumber(resp.StatusCode))
+	res.RawSetString("body", lua.LString(body))
+	headers := L.NewTable()
+	for k, v := range resp.Header {
+		headers.RawSetString(strings.ToLower(k), lua.LString(strings.Join(v, ", ")))
+	}
+	res.RawSetString("headers", headers)
+	if strings.Contains(resp.Header.Get(fiber.HeaderContentType), "json") {
+		res.RawSetString("json", h.call(h.decodeJSON, lua.LString(body)))
+	}
+	if h.views.name != "" {
+		res.RawSetString("view", lua.LString(h.views.name))
+		res.RawSetString("data", toLua(L, h.views.bind))
+	}
+	res.RawSetString("next", lua.LBool(ex.reached))
+	res.RawSetString("locals", ex.locals)
+	res.RawSetString("session", ex.session)
+	res.RawSetString("returns", ex.returns)
+	return res, nil
+}
+
+// chain builds the handlers of a route: the harness binds the request to the
+// spec's state, the scripts run, and last comes the route handler
+func (h *harness) chain(ex *exchange, scripts []string, route config.Route, last fiber.Handler) []fiber.Handler {
+	if scripts == nil {
+		for _, check := range h.module.Checks(route) {
+			if check.Script != "" {
+				scripts = append(scripts, check.Script)
+			}
+		}
+	}
+
+	handlers := []fiber.Handler{h.bind(ex, scripts)}
+	for _, script := range scripts {
+		handlers = append(handlers, routes.BoundScript(script, h.module, route, h.module.ScriptsDir()))
+	}
+	return append(handlers, func(c *fiber.Ctx) error {
+		ex.reached = true
+		return last(c)
+	})
+}
+
+// bind points the spec's state at the request, seeds its session and records
+// what the chain left behind
+func (h *harness) bind(ex *exchange, scripts []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		h.env.Ctx = c
+		c.Locals("luaState", &luapool.State{L: h.L, Ctx: c})
+		defer func() {
+			c.Locals("luaState", nil)
+			h.env.Ctx = h.idle
+			utilities.DetachStream(c, func() {})
+		}()
+
+		if ex.seed != nil {
+			ex.seed.ForEach(func(k, v lua.LValue) { h.call(h.setSession, k, v) })
+		}
+
+		err := c.Next()
+
+		ex.locals = h.call(h.getLocals)
+		values := h.L.NewTable()
+		if sess, serr := middleware.Store.Get(c); serr == nil {
+			for _, key := range sess.Keys() {
+				values.RawSetString(key, h.call(h.getSession, lua.LString(key)))
+			}
+		}
+		ex.session = values
+		for _, script := range scripts {
+			if v := c.Locals(filepath.Join(h.module.ScriptsDir(), script)); v != nil {
+				ex.returns.RawSetString(script, lua.LString(fmt.Sprint(v)))
+			}
+		}
+		return err
+	}
+}
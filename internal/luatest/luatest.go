@@ -0,0 +1,134 @@
+// Package luatest runs the Lua spec files of Octopus modules.
+//
+// A spec is a file named *_spec.lua below a module's views directory. It runs
+// in a state built like the module's preCheck states (sandbox, limits and the
+// HTTP eocto table) extended with a busted-style API:
+//
+//	describe("currentPage", function()
+//	  it("names the lua page", function()
+//	    local res = run("currentPage.lua", request{ path = "/OC/lua" })
+//	    assert.equals("lua", res.locals.currentPage)
+//	  end)
+//	end)
+//
+// run executes a preCheck script, or the preCheck chain of the matching
+// route, against a fake request and returns what it produced. Bindings that
+// reach MongoDB, Redis or remote HTTP servers raise an error until a test
+// replaces them with stub, so specs never touch real services.
+package luatest
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/degreane/octopus/config"
+)
+
+// Status is the outcome of a test case
+type Status string
+
+const (
+	Pass    Status = "pass"
+	Fail    Status = "fail"
+	Pending Status = "pending"
+	// Error is a spec file that failed before its tests could run
+	Error Status = "error"
+)
+
+// Case is the result of one `it` block
+type Case struct {
+	Name     string
+	Status   Status
+	Message  string
+	Output   string
+	Duration time.Duration
+}
+
+// Suite is the result of one spec file
+type Suite struct {
+	Path     string
+	Module   string
+	Cases    []Case
+	Duration time.Duration
+}
+
+// Counts returns the number of passed, failed and pending cases
+func (s Suite) Counts() (passed, failed, pending int) {
+	for _, c := range s.Cases {
+		switch c.Status {
+		case Pass:
+			passed++
+		case Pending:
+			pending++
+		default:
+			failed++
+		}
+	}
+	return
+}
+
+// Spec is a spec file and the module it belongs to
+type Spec struct {
+	Path   string
+	Module config.ModulesConfig
+}
+
+// Discover returns the spec files of modules, sorted by path. A file below
+// the views directory of several modules belongs to the most specific one.
+func Discover(modules []config.ModulesConfig) ([]Spec, error) {
+	owners := make(map[string]config.ModulesConfig)
+	for _, module := range modules {
+		if module.Name == "" && module.BasePath == "" {
+			continue
+		}
+		root := module.ViewsDir()
+		if _, err := os.Stat(root); err != nil {
+			continue
+		}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !strings.HasSuffix(d.Name(), "_spec.lua") {
+				return nil
+			}
+			if owner, ok := owners[path]; ok && len(owner.ViewsDir()) > len(root) {
+				return nil
+			}
+			owners[path] = module
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module.Name, err)
+		}
+	}
+
+	specs := make([]Spec, 0, len(owners))
+	for path, module := range owners {
+		specs = append(specs, Spec{Path: path, Module: module})
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Path < specs[j].Path })
+	return specs, nil
+}
+
+// Run runs the spec file and returns its results. A spec that fails to load
+// is reported as a single case with status Error.
+func Run(spec Spec) Suite {
+	started := time.Now()
+	suite := Suite{Path: spec.Path, Module: spec.Module.Name}
+
+	h := newHarness(spec.Module)
+	defer h.close()
+	if err := h.load(spec.Path); err != nil {
+		suite.Cases = []Case{{Name: spec.Path, Status: Error, Message: err.Error(), Output: h.output()}}
+	} else {
+		suite.Cases = h.runTests()
+	}
+	suite.Duration = time.Since(started)
+	return suite
+}
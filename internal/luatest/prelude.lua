@@ -0,0 +1,277 @@
+-- Busted-style test API installed in the state of every spec file.
+-- Running the spec registers its tests; the runner then calls the run
+-- function this chunk returns.
+
+local root = { before_each = {}, after_each = {} }
+local stack = { root }
+local tests = {}
+local stubs = {}
+
+local function current()
+  return stack[#stack]
+end
+
+local function fullname(name)
+  local parts = {}
+  for i = 2, #stack do
+    parts[#parts + 1] = stack[i].name
+  end
+  parts[#parts + 1] = name
+  return table.concat(parts, " ")
+end
+
+function describe(name, fn)
+  local block = { name = tostring(name), before_each = {}, after_each = {}, parent = current() }
+  stack[#stack + 1] = block
+  fn()
+  stack[#stack] = nil
+end
+context = describe
+
+function before_each(fn)
+  table.insert(current().before_each, fn)
+end
+
+function after_each(fn)
+  table.insert(current().after_each, fn)
+end
+
+function it(name, fn)
+  tests[#tests + 1] = { name = fullname(tostring(name)), fn = fn, block = current() }
+end
+
+function pending(name)
+  tests[#tests + 1] = { name = fullname(tostring(name)), pending = true }
+end
+
+-- stub replaces tbl[key] for the current test. impl is the replacement
+-- function, or a value the stub returns. Calls are recorded in s.calls.
+function stub(tbl, key, impl)
+  local original = tbl[key]
+  if type(impl) ~= "function" then
+    local value = impl
+    impl = function() return value end
+  end
+  local s = { calls = {}, original = original }
+  function s.revert()
+    tbl[key] = original
+  end
+  tbl[key] = function(...)
+    s.calls[#s.calls + 1] = { ... }
+    return impl(...)
+  end
+  stubs[#stubs + 1] = s
+  return s
+end
+
+-- spy records the calls of tbl[key] and lets them through
+function spy(tbl, key)
+  local original = tbl[key]
+  return stub(tbl, key, function(...) return original(...) end)
+end
+
+-- request builds a fake request for run(); unset fields get defaults
+function request(opts)
+  local req = {}
+  for k, v in pairs(opts or {}) do
+    req[k] = v
+  end
+  req.method = string.upper(req.method or "GET")
+  req.headers = req.headers or {}
+  req.session = req.session or {}
+  req.cookies = req.cookies or {}
+  return req
+end
+
+-- assertions -----------------------------------------------------------------
+
+local function show(v)
+  if type(v) == "string" then
+    return string.format("%q", v)
+  end
+  if type(v) == "table" then
+    local ok, encoded = pcall(eocto.encodeJSON, v)
+    if ok and encoded then
+      return encoded
+    end
+  end
+  return tostring(v)
+end
+
+local function same(a, b, seen)
+  if a == b then
+    return true
+  end
+  if type(a) ~= "table" or type(b) ~= "table" then
+    return false
+  end
+  seen = seen or {}
+  if seen[a] == b then
+    return true
+  end
+  seen[a] = b
+  for k, v in pairs(a) do
+    if not same(v, b[k], seen) then
+      return false
+    end
+  end
+  for k in pairs(b) do
+    if a[k] == nil then
+      return false
+    end
+  end
+  return true
+end
+
+-- Each predicate takes its arity in arguments, optionally followed by a
+-- custom failure message, and returns ok, message, negated message
+local predicates = {
+  equals = { 2, function(expected, actual)
+    return expected == actual,
+      string.format("expected %s, got %s", show(expected), show(actual)),
+      string.format("expected a value other than %s", show(expected))
+  end },
+  same = { 2, function(expected, actual)
+    return same(expected, actual),
+      string.format("expected %s, got %s", show(expected), show(actual)),
+      string.format("expected a value other than %s", show(expected))
+  end },
+  near = { 3, function(expected, actual, tolerance)
+    return type(actual) == "number" and math.abs(expected - actual) <= tolerance,
+      string.format("expected %s ± %s, got %s", show(expected), show(tolerance), show(actual)),
+      string.format("expected a value outside %s ± %s, got %s", show(expected), show(tolerance), show(actual))
+  end },
+  truthy = { 1, function(v)
+    return v ~= nil and v ~= false,
+      string.format("expected a truthy value, got %s", show(v)),
+      string.format("expected a falsy value, got %s", show(v))
+  end },
+  falsy = { 1, function(v)
+    return v == nil or v == false,
+      string.format("expected a falsy value, got %s", show(v)),
+      string.format("expected a truthy value, got %s", show(v))
+  end },
+  is_true = { 1, function(v)
+    return v == true, string.format("expected true, got %s", show(v)), "expected a value other than true"
+  end },
+  is_false = { 1, function(v)
+    return v == false, string.format("expected false, got %s", show(v)), "expected a value other than false"
+  end },
+  is_nil = { 1, function(v)
+    return v == nil, string.format("expected nil, got %s", show(v)), "expected a value, got nil"
+  end },
+  matches = { 2, function(pattern, s)
+    return type(s) == "string" and string.find(s, pattern) ~= nil,
+      string.format("expected %s to match %s", show(s), show(pattern)),
+      string.format("expected %s not to match %s", show(s), show(pattern))
+  end },
+  has_error = { 2, function(fn, expected)
+    local ok, err = pcall(fn)
+    if ok then
+      return false, "expected an error", "expected no error"
+    end
+    if expected ~= nil and not string.find(tostring(err), expected, 1, true) then
+      return false, string.format("expected error containing %s, got %s", show(expected), show(err)),
+        string.format("expected no error, got %s", show(err))
+    end
+    return true, "expected an error", string.format("expected no error, got %s", show(err))
+  end },
+}
+predicates.equal = predicates.equals
+predicates.error = predicates.has_error
+
+local function assertion(arity, predicate, negate)
+  return function(...)
+    local ok, msg, negated = predicate(...)
+    if negate then
+      ok, msg = not ok, negated
+    end
+    if not ok then
+      local custom = select(arity + 1, ...)
+      -- gopher-lua counts error levels from error itself: 3 is the test
+      error(custom or msg, 3)
+    end
+    return ...
+  end
+end
+
+local positive, negative = {}, {}
+for name, p in pairs(predicates) do
+  positive[name] = assertion(p[1], p[2], false)
+  negative[name] = assertion(p[1], p[2], true)
+end
+
+assert = setmetatable({}, {
+  __call = function(_, v, msg, ...)
+    if v == nil or v == false then
+      error(msg or "assertion failed!", 3)
+    end
+    return v, msg, ...
+  end,
+})
+for name, fn in pairs(positive) do
+  assert[name] = fn
+  assert["not_" .. name] = negative[name]
+end
+assert.is_not_nil = negative.is_nil
+assert.is_not_true = negative.is_true
+assert.is_not_false = negative.is_false
+for _, modifier in ipairs({ "is", "are", "has", "does" }) do
+  assert[modifier] = positive
+end
+for _, modifier in ipairs({ "is_not", "are_not", "has_no", "does_not" }) do
+  assert[modifier] = negative
+end
+
+-- runner ---------------------------------------------------------------------
+
+local function chain(block)
+  local blocks = {}
+  while block do
+    table.insert(blocks, 1, block)
+    block = block.parent
+  end
+  return blocks
+end
+
+local function revert()
+  for i = #stubs, 1, -1 do
+    stubs[i].revert()
+  end
+  stubs = {}
+end
+
+-- run runs every registered test, reporting each with
+-- report(name, status, message); status is "pass", "fail" or "pending"
+return function(report)
+  for _, test in ipairs(tests) do
+    if test.pending then
+      report(test.name, "pending", "")
+    else
+      report(test.name, "start", "")
+      local blocks = chain(test.block)
+      local ok, err = pcall(function()
+        for _, block in ipairs(blocks) do
+          for _, fn in ipairs(block.before_each) do
+            fn()
+          end
+        end
+        test.fn()
+      end)
+      for i = #blocks, 1, -1 do
+        for _, fn in ipairs(blocks[i].after_each) do
+          local after, afterErr = pcall(fn)
+          if not after and ok then
+            ok, err = false, afterErr
+          end
+        end
+      end
+      revert()
+      if ok then
+        report(test.name, "pass", "")
+      else
+        report(test.name, "fail", tostring(err))
+      end
+    end
+  end
+end
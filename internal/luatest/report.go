@@ -0,0 +1,171 @@
+package luatest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Totals sums the cases of suites
+func Totals(suites []Suite) (passed, failed, pending int) {
+	for _, s := range suites {
+		p, f, n := s.Counts()
+		passed, failed, pending = passed+p, failed+f, pending+n
+	}
+	return
+}
+
+// WriteText writes a human readable report; verbose includes the output
+// printed by passing tests
+func WriteText(w io.Writer, suites []Suite, verbose bool) error {
+	var b strings.Builder
+	for _, s := range suites {
+		fmt.Fprintf(&b, "%s (%s)\n", s.Path, s.Module)
+		for _, c := range s.Cases {
+			switch c.Status {
+			case Pass:
+				fmt.Fprintf(&b, "  ✓ %s\n", c.Name)
+			case Pending:
+				fmt.Fprintf(&b, "  - %s (pending)\n", c.Name)
+			default:
+				fmt.Fprintf(&b, "  ✗ %s\n", c.Name)
+				b.WriteString(indent(c.Message, "      "))
+			}
+			if c.Output != "" && (verbose || (c.Status != Pass && c.Status != Pending)) {
+				b.WriteString(indent(c.Output, "      | "))
+			}
+		}
+	}
+	passed, failed, pending := Totals(suites)
+	fmt.Fprintf(&b, "\n%d passed, %d failed, %d pending\n", passed, failed, pending)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteTAP writes a TAP version 13 report
+func WriteTAP(w io.Writer, suites []Suite) error {
+	var b strings.Builder
+	total := 0
+	for _, s := range suites {
+		total += len(s.Cases)
+	}
+	fmt.Fprintf(&b, "TAP version 13\n1..%d\n", total)
+	n := 0
+	for _, s := range suites {
+		for _, c := range s.Cases {
+			n++
+			name := s.Path + ": " + c.Name
+			switch c.Status {
+			case Pass:
+				fmt.Fprintf(&b, "ok %d - %s\n", n, name)
+			case Pending:
+				fmt.Fprintf(&b, "ok %d - %s # SKIP pending\n", n, name)
+			default:
+				fmt.Fprintf(&b, "not ok %d - %s\n", n, name)
+				b.WriteString("  ---\n")
+				fmt.Fprintf(&b, "  status: %s\n", c.Status)
+				b.WriteString("  message: |\n" + indent(c.Message, "    "))
+				if c.Output != "" {
+					b.WriteString("  output: |\n" + indent(c.Output, "    "))
+				}
+				b.WriteString("  ...\n")
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Package  string      `xml:"package,attr,omitempty"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes a JUnit XML report, one testsuite per spec file
+func WriteJUnit(w io.Writer, suites []Suite) error {
+	report := junitSuites{}
+	var total float64
+	for _, s := range suites {
+		js := junitSuite{Name: s.Path, Package: s.Module, Time: seconds(s.Duration.Seconds())}
+		total += s.Duration.Seconds()
+		for _, c := range s.Cases {
+			jc := junitCase{Name: c.Name, Classname: s.Path, Time: seconds(c.Duration.Seconds()), SystemOut: c.Output}
+			first := strings.SplitN(c.Message, "\n", 2)[0]
+			switch c.Status {
+			case Fail:
+				jc.Failure = &junitMessage{Message: first, Text: c.Message}
+				js.Failures++
+			case Error:
+				jc.Error = &junitMessage{Message: first, Text: c.Message}
+				js.Errors++
+			case Pending:
+				jc.Skipped = &struct{}{}
+				js.Skipped++
+			}
+			js.Tests++
+			js.Cases = append(js.Cases, jc)
+		}
+		report.Tests += js.Tests
+		report.Failures += js.Failures
+		report.Errors += js.Errors
+		report.Skipped += js.Skipped
+		report.Suites = append(report.Suites, js)
+	}
+	report.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
+
+// indent prefixes every line of s
+func indent(s, prefix string) string {
+	s = strings.TrimRight(s, "\n")
+	if s == "" {
+		return ""
+	}
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix) + "\n"
+}
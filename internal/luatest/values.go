@@ -0,0 +1,95 @@
+package luatest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+
+	"github.com/gofiber/fiber/v2"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// recorder is the view engine of the harness: it renders nothing and keeps
+// the name and data of the last view rendered
+type recorder struct {
+	name string
+	bind interface{}
+}
+
+func (r *recorder) Load() error {
+	return nil
+}
+
+func (r *recorder) Render(_ io.Writer, name string, bind interface{}, _ ...string) error {
+	r.name = name
+	r.bind = bind
+	return nil
+}
+
+func (r *recorder) reset() {
+	r.name = ""
+	r.bind = nil
+}
+
+// toLua converts view data to a Lua value; values without a Lua counterpart
+// become their string form
+func toLua(L *lua.LState, value interface{}) lua.LValue {
+	switch v := value.(type) {
+	case nil:
+		return lua.LNil
+	case lua.LValue:
+		return v
+	case fiber.Map:
+		return toLua(L, map[string]interface{}(v))
+	case string:
+		return lua.LString(v)
+	case []byte:
+		return lua.LString(v)
+	case bool:
+		return lua.LBool(v)
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return lua.LNumber(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return lua.LNumber(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return lua.LNumber(rv.Float())
+	case reflect.Map:
+		tbl := L.NewTable()
+		iter := rv.MapRange()
+		for iter.Next() {
+			tbl.RawSetString(fmt.Sprint(iter.Key().Interface()), toLua(L, iter.Value().Interface()))
+		}
+		return tbl
+	case reflect.Slice, reflect.Array:
+		tbl := L.NewTable()
+		for i := 0; i < rv.Len(); i++ {
+			tbl.RawSetInt(i+1, toLua(L, rv.Index(i).Interface()))
+		}
+		return tbl
+	}
+	return lua.LString(fmt.Sprint(value))
+}
+
+// stringMap reads a table of strings, such as the headers of a request
+func stringMap(value lua.LValue) map[string]string {
+	m := make(map[string]string)
+	if tbl, ok := value.(*lua.LTable); ok {
+		tbl.ForEach(func(k, v lua.LValue) { m[k.String()] = v.String() })
+	}
+	return m
+}
+
+// setDefault sets key unless it is set, ignoring case
+func setDefault(m map[string]string, key, value string) {
+	for k := range m {
+		if http.CanonicalHeaderKey(k) == key {
+			return
+		}
+	}
+	m[key] = value
+}
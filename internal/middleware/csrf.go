@@ -5,7 +5,6 @@ import (
 	// "fmt"
 	"log"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 
@@ -69,16 +68,13 @@ type EoctoCsrfStore struct {
 // 	CookieSameSite:    "lax",
 
 // })
-var CsrfStore = NewCsrfStore()
+// CsrfStore holds the CSRF tokens. Like Store it starts in memory until
+// InitStores applies the configured Storage.
+var CsrfStore = NewCsrfStore(&config.AppConfig{Storage: config.Memory})
 
-func NewCsrfStore() *EoctoCsrfStore {
-	appConfig, err := config.ParseServerConfig()
-	if err != nil {
-		//debug.Debug(debug.Error, fmt.Sprintf("<<MW>,sessions.go> Error parsing config file: %v", err))
-		os.Exit(-1)
-		return nil
-
-	} else if appConfig.Storage == config.Redis {
+// NewCsrfStore builds the CSRF token store for the configured Storage
+func NewCsrfStore(appConfig *config.AppConfig) *EoctoCsrfStore {
+	if appConfig.Storage == config.Redis {
 		return &EoctoCsrfStore{
 			Store: session.New(session.Config{
 				Storage:           database.NewRedisStorage(),
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/service/cache"
+	"github.com/degreane/octopus/internal/service/lock"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultIdempotencyHeader = "Idempotency-Key"
+	defaultIdempotencyWindow = 24 * time.Hour
+	// idempotencyLockTTL bounds how long duplicates wait for the first request
+	idempotencyLockTTL = 30 * time.Second
+)
+
+// idempotentResponse is the stored first response for an idempotency key
+type idempotentResponse struct {
+	cachedResponse
+	// Fingerprint identifies the request body the key was first used with
+	Fingerprint string `json:"fingerprint"`
+}
+
+// CreateIdempotency returns a Fiber middleware handler implementing the
+// `idempotency:` option of a route.
+//
+// The first request carrying a given key runs normally and its response is
+// stored (Redis or memory, following AppConfig.Storage) for the configured
+// window. Duplicates wait while the first request is in flight, then receive
+// the stored response with an Idempotent-Replayed header. Reusing a key with a
+// different request body is rejected with 422. Only 2xx and 3xx responses are
+// stored, so clients can retry failures with the same key.
+//
+// Keys are scoped to the caller, so two callers sending the same key never
+// see each other's response: by the session values listed in principal when
+// the route sets it, otherwise by the session cookie, or the client address
+// for callers without one.
+//
+// Usage:
+//
+//	group.Add("POST", "/pay", middleware.CreateIdempotency(*route.Idempotency), handlers...)
+func CreateIdempotency(settings config.RouteIdempotency) fiber.Handler {
+	header := settings.Header
+	if header == "" {
+		header = defaultIdempotencyHeader
+	}
+	window := time.Duration(settings.Window) * time.Second
+	if window <= 0 {
+		window = defaultIdempotencyWindow
+	}
+
+	return func(c *fiber.Ctx) error {
+		key := c.Get(header)
+		if key == "" {
+			if settings.Required {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": header + " header is required"})
+			}
+			return c.Next()
+		}
+
+		storeKey := fmt.Sprintf("idempotency:%s:%s:%s:%s", c.Method(), c.Route().Path, idempotencyCaller(c, settings), key)
+		sum := sha1.Sum(c.Body())
+		fingerprint := hex.EncodeToString(sum[:])
+
+		locker := lock.Get()
+		lease, err := lock.Acquire(c.Context(), locker, storeKey, idempotencyLockTTL, idempotencyLockTTL)
+		if err != nil {
+			debug.Debug(debug.Warning, fmt.Sprintf("Idempotency key %s still in progress: %v", key, err))
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "a request with this " + header + " is still in progress"})
+		}
+		defer func() {
+			if err := locker.Release(c.Context(), lease); err != nil {
+				debug.Debug(debug.Error, fmt.Sprintf("Error releasing idempotency lock %s: %v", key, err))
+			}
+		}()
+
+		store := cache.Get()
+		payload, found, err := store.Get(storeKey)
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error reading idempotency key %s: %v", key, err))
+		}
+		if found {
+			var stored idempotentResponse
+			if err := json.Unmarshal(payload, &stored); err == nil {
+				if stored.Fingerprint != fingerprint {
+					return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": header + " was already used with a different request"})
+				}
+				for name, value := range stored.Headers {
+					c.Set(name, value)
+				}
+				c.Set("Idempotent-Replayed", "true")
+				c.Status(stored.Status)
+				return c.Send(stored.Body)
+			}
+			debug.Debug(debug.Warning, fmt.Sprintf("Discarding invalid idempotency entry %s", key))
+		}
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		// Streamed bodies (eocto.stream, eocto.sendFile) are not buffered to be stored
+		status := c.Response().StatusCode()
+		if status < fiber.StatusOK || status >= fiber.StatusBadRequest || c.Context().IsBodyStream() {
+			return nil
+		}
+		stored := idempotentResponse{
+			cachedResponse: cachedResponse{
+				Status:  status,
+				Headers: make(map[string]string),
+				Body:    append([]byte(nil), c.Response().Body()...),
+			},
+			Fingerprint: fingerprint,
+		}
+		for _, name := range cachedHeaders {
+			if value := c.GetRespHeader(name); value != "" {
+				stored.Headers[name] = value
+			}
+		}
+		payload, err = json.Marshal(stored)
+		if err == nil {
+			err = store.Set(storeKey, payload, window)
+		}
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error storing idempotency key %s: %v", key, err))
+		}
+		return nil
+	}
+}
+
+// idempotencyCaller identifies the caller of c for the idempotency key scope
+func idempotencyCaller(c *fiber.Ctx, settings config.RouteIdempotency) string {
+	var caller string
+	switch {
+	case len(settings.Principal) > 0:
+		sess, err := Store.Get(c)
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error getting session for the idempotency key: %v", err))
+		}
+		for _, name := range settings.Principal {
+			var value interface{}
+			if sess != nil {
+				value = sess.Get(name)
+			}
+			caller += fmt.Sprintf("|%s=%v", name, value)
+		}
+	case c.Cookies(strings.TrimPrefix(Store.KeyLookup, "cookie:")) != "":
+		caller = "session=" + c.Cookies(strings.TrimPrefix(Store.KeyLookup, "cookie:"))
+	default:
+		caller = "ip=" + c.IP()
+	}
+	sum := sha1.Sum([]byte(caller))
+	return hex.EncodeToString(sum[:])
+}
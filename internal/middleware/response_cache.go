@@ -0,0 +1,272 @@
+package middleware
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/service/cache"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/session"
+)
+
+// cachedResponse is the stored form of a rendered route response
+type cachedResponse struct {
+	Status       int               `json:"status"`
+	Headers      map[string]string `json:"headers"`
+	Body         []byte            `json:"body"`
+	ETag         string            `json:"etag"`
+	LastModified string            `json:"lastModified"`
+}
+
+// cachedHeaders are the response headers replayed on a cache hit
+var cachedHeaders = []string{
+	fiber.HeaderContentType,
+	fiber.HeaderContentLanguage,
+	fiber.HeaderContentDisposition,
+	fiber.HeaderLocation,
+	"HX-Redirect",
+	"HX-Trigger",
+	"HX-Push-Url",
+}
+
+// CreateResponseCache returns a Fiber middleware handler that caches the
+// responses of a route as configured by its `cache:` block.
+//
+// It runs after the route's preChecks and CreateSession, in front of the
+// view: a hit is answered from the store (memory or Redis) without rendering
+// the template, while the scripts still run on every request. Only successful
+// GET responses are stored, and the cache is bypassed for responses that may
+// belong to a visitor: ones that set a cookie other than the session cookie,
+// and ones for a session holding data unless the route lists the session keys
+// it depends on in varySession. Every response
+// carries an ETag and Last-Modified header, and conditional requests
+// (If-None-Match / If-Modified-Since) are answered with 304.
+//
+// Entries are tagged with the route pattern and the request path so
+// eocto.cache.purgeRoute(path) can expire them.
+//
+// Usage:
+//
+//	group.Add("GET", "/lua", append(preChecks, middleware.CreateSession(), middleware.CreateResponseCache(*route.Cache), view)...)
+func CreateResponseCache(settings config.RouteCache) fiber.Handler {
+	ttl := time.Duration(settings.TTL) * time.Second
+
+	return func(c *fiber.Ctx) error {
+		if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead {
+			return c.Next()
+		}
+
+		// The preChecks have run: a visitor whose session holds data, or
+		// whose response got a cookie, neither reads nor fills the cache
+		if reason := privateResponse(c, settings); reason != "" {
+			debug.Debug(debug.Info, fmt.Sprintf("Bypassing the response cache of %s: %s", c.Path(), reason))
+			c.Set("X-Cache", "BYPASS")
+			return c.Next()
+		}
+
+		store := cache.Route(settings.Store)
+		key := responseCacheKey(c, settings)
+
+		payload, found, err := store.Get(key)
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error reading response cache for %s: %v", c.Path(), err))
+		}
+		if found {
+			var cached cachedResponse
+			if err := json.Unmarshal(payload, &cached); err == nil {
+				return writeCachedResponse(c, settings, &cached)
+			}
+			debug.Debug(debug.Warning, fmt.Sprintf("Discarding invalid response cache entry for %s", c.Path()))
+		}
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		if c.Method() != fiber.MethodGet || c.Response().StatusCode() != fiber.StatusOK {
+			return nil
+		}
+		// A streamed body (eocto.stream, eocto.sendFile) is written after the
+		// handlers return; reading it here would wait for itself
+		if c.Context().IsBodyStream() {
+			c.Set("X-Cache", "BYPASS")
+			return nil
+		}
+		if reason := privateResponse(c, settings); reason != "" {
+			debug.Debug(debug.Info, fmt.Sprintf("Not caching the response of %s: %s", c.Path(), reason))
+			c.Set("X-Cache", "BYPASS")
+			return nil
+		}
+
+		body := append([]byte(nil), c.Response().Body()...)
+		sum := sha1.Sum(body)
+		cached := cachedResponse{
+			Status:       fiber.StatusOK,
+			Headers:      make(map[string]string),
+			Body:         body,
+			ETag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+			LastModified: time.Now().UTC().Format(http.TimeFormat),
+		}
+		for _, header := range cachedHeaders {
+			if value := c.GetRespHeader(header); value != "" {
+				cached.Headers[header] = value
+			}
+		}
+
+		payload, err = json.Marshal(cached)
+		if err == nil {
+			tags := []string{cache.RouteTag(c.Route().Path), cache.RouteTag(c.Path())}
+			err = store.Set(key, payload, ttl, tags...)
+		}
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error storing response cache for %s: %v", c.Path(), err))
+		}
+
+		// The response was rendered already; only the validators and the 304 are added
+		c.Set(fiber.HeaderETag, cached.ETag)
+		c.Set(fiber.HeaderLastModified, cached.LastModified)
+		c.Set("X-Cache", "MISS")
+		setVaryHeader(c, settings)
+		if isFresh(c, cached.ETag, cached.LastModified) {
+			c.Response().ResetBody()
+			c.Status(fiber.StatusNotModified)
+		}
+		return nil
+	}
+}
+
+// writeCachedResponse answers the request from a stored response
+func writeCachedResponse(c *fiber.Ctx, settings config.RouteCache, cached *cachedResponse) error {
+	for header, value := range cached.Headers {
+		c.Set(header, value)
+	}
+	c.Set(fiber.HeaderETag, cached.ETag)
+	c.Set(fiber.HeaderLastModified, cached.LastModified)
+	c.Set("X-Cache", "HIT")
+	setVaryHeader(c, settings)
+
+	if isFresh(c, cached.ETag, cached.LastModified) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	c.Status(cached.Status)
+	return c.Send(cached.Body)
+}
+
+// privateResponse returns why the response of c may belong to its visitor,
+// "" when it can be shared
+func privateResponse(c *fiber.Ctx, settings config.RouteCache) string {
+	sessionCookie := strings.TrimPrefix(Store.KeyLookup, "cookie:")
+	var cookie string
+	c.Response().Header.VisitAllCookie(func(key, _ []byte) {
+		if cookie == "" && string(key) != sessionCookie {
+			cookie = string(key)
+		}
+	})
+	if cookie != "" {
+		return "it sets the " + cookie + " cookie"
+	}
+	if len(settings.VarySession) == 0 {
+		if sess := currentSession(c); sess != nil && len(sess.Keys()) > 0 {
+			return "the session holds data and the route has no varySession"
+		}
+	}
+	return ""
+}
+
+// currentSession returns the session of c, the one CreateSession rotated to
+// when it ran
+func currentSession(c *fiber.Ctx) *session.Session {
+	sess, err := Store.Get(c)
+	if err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("Error getting session for the response cache: %v", err))
+		return nil
+	}
+	return sess
+}
+
+// isFresh reports whether the client's validators match the response,
+// If-None-Match taking precedence over If-Modified-Since
+func isFresh(c *fiber.Ctx, etag, lastModified string) bool {
+	if noneMatch := c.Get(fiber.HeaderIfNoneMatch); noneMatch != "" {
+		for _, candidate := range strings.Split(noneMatch, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	modifiedSince := c.Get(fiber.HeaderIfModifiedSince)
+	if modifiedSince == "" {
+		return false
+	}
+	since, err := http.ParseTime(modifiedSince)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	return !modified.After(since)
+}
+
+// setVaryHeader announces the request headers the cached response depends on
+func setVaryHeader(c *fiber.Ctx, settings config.RouteCache) {
+	for _, header := range settings.VaryHeaders {
+		c.Vary(header)
+	}
+	c.Vary("HX-Request")
+}
+
+// responseCacheKey builds the store key from the request path and the
+// headers, query parameters and session values the route varies on
+func responseCacheKey(c *fiber.Ctx, settings config.RouteCache) string {
+	var b strings.Builder
+	b.WriteString(c.Path())
+
+	// HTMX requests get partials, regular requests full pages
+	fmt.Fprintf(&b, "|hx=%s", c.Get("HX-Request"))
+	for _, header := range settings.VaryHeaders {
+		fmt.Fprintf(&b, "|h:%s=%s", strings.ToLower(header), c.Get(header))
+	}
+
+	if len(settings.VaryQuery) == 0 {
+		query := c.Queries()
+		names := make([]string, 0, len(query))
+		for name := range query {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&b, "|q:%s=%s", name, query[name])
+		}
+	} else {
+		for _, name := range settings.VaryQuery {
+			fmt.Fprintf(&b, "|q:%s=%s", name, c.Query(name))
+		}
+	}
+
+	if len(settings.VarySession) > 0 {
+		sess := currentSession(c)
+		for _, name := range settings.VarySession {
+			var value interface{}
+			if sess != nil {
+				value = sess.Get(name)
+			}
+			fmt.Fprintf(&b, "|s:%s=%v", name, value)
+		}
+	}
+
+	sum := sha1.Sum([]byte(b.String()))
+	return "response:" + hex.EncodeToString(sum[:])
+}
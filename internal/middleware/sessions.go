@@ -5,8 +5,10 @@
 package middleware
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/degreane/octopus/config"
@@ -18,7 +20,33 @@ import (
 	"github.com/gofiber/storage/redis/v3"
 )
 
-var Store = newSessionStore()
+// SessionIDLocal is the Fiber local holding the session ID CreateSession
+// saved, which a WebSocket keeps from its handshake
+const SessionIDLocal = "eocto_session_id"
+
+// rotatedPrefix prefixes the storage keys that lead from a rotated session ID
+// to the ID that replaced it
+const rotatedPrefix = "eocto:session:rotated:"
+
+// maxRotations bounds how many rotations LoadSession follows
+const maxRotations = 32
+
+// Store is the session store. It starts in memory and the server replaces it
+// with InitStores once its configuration is read. It used to be built from
+// config/config.yaml when the package was initialised, which exited the
+// process when the file was not found from the working directory, and with
+// Storage: redis made the Redis storage ping (and panic) before main ran:
+// the octopus command and package tests import the middleware without a
+// server configuration or a Redis server.
+var Store = newSessionStore(&config.AppConfig{Storage: config.Memory})
+
+// InitStores builds the session and CSRF stores for the configured Storage.
+// It must run before the routes are set up; until then sessions live in
+// memory.
+func InitStores(appConfig *config.AppConfig) {
+	Store = newSessionStore(appConfig)
+	CsrfStore = NewCsrfStore(appConfig)
+}
 
 func GetAllSessions() []string {
 
@@ -30,11 +58,13 @@ func GetAllSessions() []string {
 			debug.Debug(debug.Error, fmt.Sprintf("Error getting session keys: %+v", err))
 			return nil
 		}
-		keys := make([]string, len(byteKeys))
-		for i, key := range byteKeys {
+		keys := make([]string, 0, len(byteKeys))
+		for _, key := range byteKeys {
 			// val, _ := store.Get(string(key))
 			// debug.Debug(debug.Warning, fmt.Sprintf("Session key: %s => % +v", key, string(val)))
-			keys[i] = string(key)
+			if !strings.HasPrefix(string(key), rotatedPrefix) {
+				keys = append(keys, string(key))
+			}
 		}
 		return keys
 	}
@@ -48,9 +78,11 @@ func GetAllSessions() []string {
 			return nil
 		}
 		// Convert [][]byte to []string for memory store as well
-		keys := make([]string, len(byteKeys))
-		for i, byteKey := range byteKeys {
-			keys[i] = string(byteKey)
+		keys := make([]string, 0, len(byteKeys))
+		for _, byteKey := range byteKeys {
+			if !strings.HasPrefix(string(byteKey), rotatedPrefix) {
+				keys = append(keys, string(byteKey))
+			}
 		}
 		return keys
 	}
@@ -58,15 +90,9 @@ func GetAllSessions() []string {
 	return nil
 }
 
-func newSessionStore() *session.Store {
+func newSessionStore(appConfig *config.AppConfig) *session.Store {
 	//debug.Debug(debug.Warning, fmt.Sprintf("<<MW>,sessions.go> Calling New Session Store : %v", "123"))
-	appConfig, err := config.ParseServerConfig()
-	if err != nil {
-		//debug.Debug(debug.Error, fmt.Sprintf("<<MW>,sessions.go> Error parsing config file: %v", err))
-		os.Exit(-1)
-		return nil
-
-	} else if appConfig.Storage == config.Redis {
+	if appConfig.Storage == config.Redis {
 		//debug.Debug(debug.Warning, "<<MW>,sessions.go> Config file parsed successfully, Using Redis as storage")
 		return session.New(session.Config{
 			Storage:           database.NewRedisStorage(),
@@ -117,6 +143,7 @@ func CreateSession() fiber.Handler {
 		}
 
 		// Store current session data
+		oldID, rotated := sess.ID(), !sess.Fresh()
 		keys := sess.Keys()
 		// debug.Debug(debug.Error, fmt.Sprintf("Session Old = %+v", sess.Keys()))
 		sessionData := make(map[string]interface{})
@@ -147,14 +174,60 @@ func CreateSession() fiber.Handler {
 		}
 
 		// debug.Debug(debug.Important, fmt.Sprintf("Rotating session: %s", newSess.ID()))
+		newID := newSess.ID()
 		if err := newSess.Save(); err != nil {
 			debug.Debug(debug.Error, fmt.Sprintf("Error saving new session: %v", err))
 			// log.Printf("Error saving new session: %v", err)
 			return err
 		}
-		c.Locals("c_session", newSess)
+		c.Locals(SessionIDLocal, newID)
+		// WebSockets opened with the old ID follow it to the new one
+		if rotated && oldID != newID {
+			if err := Store.Storage.Set(rotatedPrefix+oldID, []byte(newID), Store.Expiration); err != nil {
+				debug.Debug(debug.Error, fmt.Sprintf("Error recording session rotation: %v", err))
+			}
+		}
 		// log.Printf("Rotated session: %s", newSess.ID())
 
 		return c.Next()
 	}
 }
+
+// LoadSession returns the data of the session id outside of a request, for
+// the WebSockets that keep the session of their handshake. CreateSession gives
+// a session a new ID on every request, so the rotations are followed: current
+// is the session's ID now, and data is nil when the session is gone.
+func LoadSession(id string) (current string, data map[string]interface{}, err error) {
+	for i := 0; id != "" && i < maxRotations; i++ {
+		raw, err := Store.Storage.Get(id)
+		if err != nil {
+			return id, nil, err
+		}
+		if raw != nil {
+			data = make(map[string]interface{})
+			if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&data); err != nil {
+				return id, nil, fmt.Errorf("failed to decode session data: %w", err)
+			}
+			return id, data, nil
+		}
+		next, err := Store.Storage.Get(rotatedPrefix + id)
+		if err != nil || next == nil {
+			return id, nil, err
+		}
+		id = string(next)
+	}
+	return id, nil, nil
+}
+
+// SaveSession stores data as the session id, encoded as the session store
+// does. An expiry of zero keeps the store's default.
+func SaveSession(id string, data map[string]interface{}, expiry time.Duration) error {
+	if expiry <= 0 {
+		expiry = Store.Expiration
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&data); err != nil {
+		return fmt.Errorf("failed to encode session data: %w", err)
+	}
+	return Store.Storage.Set(id, buf.Bytes(), expiry)
+}
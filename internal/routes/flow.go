@@ -0,0 +1,47 @@
+package routes
+
+import (
+	"strings"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// applyFlow carries out a control-flow decision taken by a script
+// (eocto.halt, eocto.abort, eocto.redirect, eocto.next)
+func applyFlow(c *fiber.Ctx, module config.ModulesConfig, flow *utilities.Flow) error {
+	switch flow.Action {
+	case utilities.FlowNext:
+		return c.Next()
+	case utilities.FlowRedirect:
+		utilities.ApplyResponseHeaders(c)
+		return utilities.ApplyRedirect(c, flow.URL, flow.Status)
+	case utilities.FlowAbort:
+		// The abort's status and body replace those set through eocto.response
+		utilities.ApplyResponseHeaders(c)
+		c.Status(flow.Status)
+		switch {
+		case flow.View != "":
+			data := fiber.Map{
+				"basePath":  strings.TrimSpace(module.BasePath),
+				"localPath": strings.TrimSpace(module.LocalPath),
+				"status":    flow.Status,
+			}
+			for k, v := range flow.Data {
+				data[k] = v
+			}
+			return c.Render(templatePath(module, flow.View), data)
+		case flow.Body == nil:
+			return c.SendString(utils.StatusMessage(flow.Status))
+		}
+		if text, ok := flow.Body.(string); ok {
+			return c.SendString(text)
+		}
+		return c.JSON(flow.Body)
+	}
+	// halt: send whatever response the script already set
+	_, err := utilities.SendResponse(c)
+	return err
+}
@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/bindings"
+	"github.com/degreane/octopus/internal/service/limits"
+	"github.com/degreane/octopus/internal/service/sandbox"
+	"github.com/degreane/octopus/internal/service/scriptcache"
+	"github.com/degreane/octopus/internal/utilities"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	lua "github.com/yuin/gopher-lua"
+)
+
+var (
+	initMutex sync.Mutex
+	// initDone records the modules whose init script ran, by name
+	initDone = make(map[string]error)
+)
+
+// RunInit runs the module's init script once, on a state of its own with the
+// bindings of jobs, and keeps the value it returns for eocto.init. Later calls
+// return the error of the first run.
+func RunInit(module config.ModulesConfig) error {
+	if module.Init == "" {
+		return nil
+	}
+	initMutex.Lock()
+	defer initMutex.Unlock()
+	if err, done := initDone[module.Name]; done {
+		return err
+	}
+	err := runInit(module)
+	initDone[module.Name] = err
+	return err
+}
+
+func runInit(module config.ModulesConfig) error {
+	limit := limits.Resolve(module.Limits, nil)
+	L := lua.NewState(limit.Options())
+	defer L.Close()
+	sandbox.Open(L, sandbox.PolicyFor(module))
+	env := &bindings.Env{Context: bindings.Job, Module: module}
+	bindings.Install(L, func() *bindings.Env { return env })
+
+	path := filepath.Join(module.ScriptsDir(), module.Init)
+	err := limits.Run(context.Background(), L, limit, path, func() error {
+		return scriptcache.Run(L, path)
+	})
+	if err != nil {
+		return fmt.Errorf("module %s: init script %s: %w", module.Name, path, err)
+	}
+	value := lua.LValue(lua.LNil)
+	if L.GetTop() > 0 {
+		value = L.Get(1)
+	}
+	utilities.SetInitValue(module.Name, value)
+	debug.Debug(debug.Important, fmt.Sprintf("module %s: init script %s ran", module.Name, module.Init))
+	return nil
+}
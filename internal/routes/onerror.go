@@ -0,0 +1,166 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/service/limits"
+	"github.com/degreane/octopus/internal/service/scriptcache"
+	"github.com/degreane/octopus/internal/utilities"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	"github.com/gofiber/fiber/v2"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// defaultErrorView is the module view rendered when a preCheck script fails
+const defaultErrorView = "pages/error"
+
+// scriptFailure describes a failed preCheck script to error views and handlers
+type scriptFailure struct {
+	Script    string
+	Kind      string // missing, compile, runtime or the exceeded limit
+	Message   string
+	Traceback string
+	Status    int
+}
+
+// errorPolicy resolves the route's error policy over the module's; anything
+// invalid falls back to failing closed
+func errorPolicy(module config.ModulesConfig, route config.Route) config.ErrorPolicy {
+	policy := config.ErrorPolicy{Policy: config.OnErrorFail, View: defaultErrorView}
+	for _, p := range []*config.ErrorPolicy{module.OnError, route.OnError} {
+		if p == nil {
+			continue
+		}
+		if p.Policy != "" {
+			policy.Policy = p.Policy
+		}
+		if p.Script != "" {
+			policy.Script = p.Script
+		}
+		if p.View != "" {
+			policy.View = p.View
+		}
+	}
+	switch policy.Policy {
+	case config.OnErrorFail, config.OnErrorContinue:
+	case config.OnErrorScript:
+		if policy.Script == "" {
+			debug.Debug(debug.Warning, fmt.Sprintf("module %s: %s %s: onError script policy without a script, failing closed", module.Name, route.Method, route.Path))
+			policy.Policy = config.OnErrorFail
+		}
+	default:
+		debug.Debug(debug.Warning, fmt.Sprintf("module %s: %s %s: unknown onError policy %q, failing closed", module.Name, route.Method, route.Path, policy.Policy))
+		policy.Policy = config.OnErrorFail
+	}
+	return policy
+}
+
+// newScriptFailure classifies the error returned by a preCheck script
+func newScriptFailure(scriptPath string, err error) scriptFailure {
+	failure := scriptFailure{Script: scriptPath, Kind: "runtime", Message: err.Error(), Status: fiber.StatusInternalServerError}
+
+	var compileErr *scriptcache.CompileError
+	var limitErr *limits.Error
+	var apiErr *lua.ApiError
+	switch {
+	case os.IsNotExist(err):
+		failure.Kind = "missing"
+		failure.Message = fmt.Sprintf("script %s does not exist", scriptPath)
+	case errors.As(err, &compileErr):
+		failure.Kind = "compile"
+	case errors.As(err, &limitErr):
+		failure.Kind = limitErr.Kind
+		failure.Status = limitErr.Status()
+	}
+	if errors.As(err, &apiErr) {
+		failure.Message = apiErr.Object.String()
+		failure.Traceback = apiErr.StackTrace
+	}
+	return failure
+}
+
+// handleScriptError applies the error policy to a failed preCheck script
+func handleScriptError(c *fiber.Ctx, L *lua.LState, module config.ModulesConfig, policy config.ErrorPolicy, limit limits.Limits, failure scriptFailure) error {
+	switch policy.Policy {
+	case config.OnErrorContinue:
+		return c.Next()
+	case config.OnErrorScript:
+		proceed, status, err := runErrorHandler(c, L, module, policy.Script, limit, failure)
+		if flow := utilities.TakeFlow(c); flow != nil {
+			return applyFlow(c, module, flow)
+		}
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error handler %s failed, failing closed: %v", policy.Script, err))
+			break
+		}
+		if proceed {
+			return c.Next()
+		}
+		if status > 0 {
+			failure.Status = status
+		}
+	}
+	return renderScriptError(c, module, policy.View, failure)
+}
+
+// runErrorHandler runs the error-handler script with the failure in
+// eocto.error. Returning true from the handler continues the chain, returning
+// a number answers with that status, anything else fails the request. The
+// handler may also decide with eocto.abort, eocto.redirect or eocto.next.
+func runErrorHandler(c *fiber.Ctx, L *lua.LState, module config.ModulesConfig, script string, limit limits.Limits, failure scriptFailure) (bool, int, error) {
+	handlerPath := filepath.Join(module.ScriptsDir(), script)
+
+	info := L.NewTable()
+	info.RawSetString("script", lua.LString(failure.Script))
+	info.RawSetString("kind", lua.LString(failure.Kind))
+	info.RawSetString("message", lua.LString(failure.Message))
+	info.RawSetString("traceback", lua.LString(failure.Traceback))
+	info.RawSetString("status", lua.LNumber(failure.Status))
+	eocto, ok := L.GetGlobal("eocto").(*lua.LTable)
+	if !ok {
+		eocto = L.NewTable()
+		L.SetGlobal("eocto", eocto)
+	}
+	eocto.RawSetString("error", info)
+	defer eocto.RawSetString("error", lua.LNil)
+
+	top := L.GetTop()
+	err := limits.Run(c.UserContext(), L, limit, handlerPath, func() error {
+		return scriptcache.Run(L, handlerPath)
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	defer L.SetTop(top)
+	if L.GetTop() == top {
+		return false, 0, nil
+	}
+	switch ret := L.Get(-1).(type) {
+	case lua.LBool:
+		return bool(ret), 0, nil
+	case lua.LNumber:
+		return false, int(ret), nil
+	}
+	return false, 0, nil
+}
+
+// renderScriptError answers a failed preCheck with the module's error view,
+// or with a bare status when the module has none. Details are only shown
+// outside production.
+func renderScriptError(c *fiber.Ctx, module config.ModulesConfig, view string, failure scriptFailure) error {
+	if _, err := os.Stat(filepath.Join(module.ViewsDir(), view+".html")); err != nil {
+		return fiber.NewError(failure.Status, failure.Message)
+	}
+	return c.Status(failure.Status).Render(templatePath(module, view), fiber.Map{
+		"basePath":  strings.TrimSpace(module.BasePath),
+		"localPath": strings.TrimSpace(module.LocalPath),
+		"status":    failure.Status,
+		"error":     failure,
+		"debug":     config.New().Environment != "production",
+	})
+}
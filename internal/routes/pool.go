@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/bindings"
+	"github.com/degreane/octopus/internal/service/limits"
+	"github.com/degreane/octopus/internal/service/luapool"
+	"github.com/degreane/octopus/internal/service/sandbox"
+)
+
+// scriptPool returns the pool of pre-warmed Lua states used by the module's preCheck scripts
+func scriptPool(settings config.ModulesConfig) *luapool.Pool {
+	opts := limits.Resolve(settings.Limits, nil).Options()
+	return luapool.For(settings.Name+"@"+settings.BasePath, opts, buildScriptState(settings))
+}
+
+// buildScriptState installs the sandboxed standard library and the HTTP
+// bindings. Request-bound bindings resolve the request the pooled state is
+// checked out for at call time, so the eocto table is built once per state
+// instead of once per request.
+func buildScriptState(settings config.ModulesConfig) luapool.Builder {
+	return func(st *luapool.State) {
+		sandbox.Open(st.L, sandbox.PolicyFor(settings))
+		env := &bindings.Env{Context: bindings.HTTP, Module: settings}
+		bindings.Install(st.L, func() *bindings.Env {
+			env.Ctx = st.Ctx
+			return env
+		})
+	}
+}
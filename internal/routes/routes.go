@@ -5,22 +5,28 @@
 package routes
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/bindings"
 	"github.com/degreane/octopus/internal/middleware"
+	"github.com/degreane/octopus/internal/service/capture"
+	"github.com/degreane/octopus/internal/service/limits"
+	"github.com/degreane/octopus/internal/service/luapool"
+	"github.com/degreane/octopus/internal/service/sandbox"
+	"github.com/degreane/octopus/internal/service/scriptcache"
 	"github.com/degreane/octopus/internal/utilities"
 	"github.com/degreane/octopus/internal/utilities/debug"
 	"github.com/gofiber/contrib/socketio"
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/utils"
 	lua "github.com/yuin/gopher-lua"
 )
 
@@ -39,6 +45,7 @@ type RouteInfo struct {
 	Group     string // Group/module name for route organization
 	View      string // Associated view template name
 	WebSocket bool   // Indicates if the route is a WebSocket route
+	SSE       bool   // Indicates if the route is a server-sent events route
 }
 
 // MessageObject represents the envelope for messages exchanged over Socket.IO
@@ -60,38 +67,17 @@ var (
 	registerOnce sync.Once
 )
 
-func WsScript(luaFile string, settings config.ModulesConfig, moduleBasePath ...string) func(*socketio.Websocket) error {
+func WsScript(luaFile string, settings config.ModulesConfig, route config.Route, moduleBasePath ...string) func(*socketio.Websocket) error {
+	limit := limits.Resolve(settings.Limits, route.Limits)
 	return func(c *socketio.Websocket) error {
 		luaState, ok := c.Locals("luaState").(*lua.LState)
 		var L *lua.LState
 
 		if !ok {
-			L = lua.NewState()
-			eoctoTable := luaState.NewTable()
-			// debugging output
-			eoctoTable.RawSetString("debug", L.NewFunction(utilities.Debug))
-			// locals
-			// L.SetGlobal("lua_getLocal", L.NewFunction(utilities.GetLocal(c)))
-			eoctoTable.RawSetString("getLocal", L.NewFunction(utilities.GetWsLocal(c)))
-			// L.SetGlobal("lua_setLocal", L.NewFunction(utilities.SetLocal(c)))
-			eoctoTable.RawSetString("setLocal", L.NewFunction(utilities.SetWsLocal(c)))
-			// L.SetGlobal("lua_deleteLocal", L.NewFunction(utilities.DeleteLocal(c)))
-			eoctoTable.RawSetString("deleteLocal", L.NewFunction(utilities.DeleteWsLocal(c)))
-			// L.SetGlobal("lua_getLocals", L.NewFunction(utilities.GetLocals(c)))
-			eoctoTable.RawSetString("getLocals", L.NewFunction(utilities.GetWsLocals(c)))
-
-			eoctoTable.RawSetString("getCookie", L.NewFunction(utilities.GetWsCookie(c)))
-			eoctoTable.RawSetString("setCookie", L.NewFunction(utilities.SetWsCookie(c)))
-			eoctoTable.RawSetString("getAllCookies", L.NewFunction(utilities.GetWsAllCookies(c)))
-			eoctoTable.RawSetString("deleteCookie", L.NewFunction(utilities.DeleteWsCookie(c)))
-			eoctoTable.RawSetString("clearAllCookies", L.NewFunction(utilities.ClearWsAllCookies(c)))
-
-			eoctoTable.RawSetString("getUUID", L.NewFunction(func(L *lua.LState) int {
-				uuid := utils.UUIDv4()
-				L.Push(lua.LString(uuid))
-				return 1
-			}))
-			L.SetGlobal("eocto", eoctoTable)
+			L = lua.NewState(limit.Options())
+			sandbox.Open(L, sandbox.PolicyFor(settings))
+			env := &bindings.Env{Context: bindings.WS, Module: settings, Ws: c}
+			bindings.Install(L, func() *bindings.Env { return env })
 			c.Conn.Locals("luaState", L)
 		} else {
 			L = luaState
@@ -108,8 +94,17 @@ func WsScript(luaFile string, settings config.ModulesConfig, moduleBasePath ...s
 			return err
 		}
 		debug.Debug(debug.Info, fmt.Sprintf("script file %s,\n\t ScriptFilePath %s", luaFile, scriptPath))
-		if err := L.DoFile(scriptPath); err != nil {
-			debug.Debug(debug.Error, fmt.Sprintf("Error executing Lua script: %v", err))
+		err := limits.Run(context.Background(), L, limit, scriptPath, func() error {
+			return scriptcache.Run(L, scriptPath)
+		})
+		if err != nil {
+			var compileErr *scriptcache.CompileError
+			var limitErr *limits.Error
+			if errors.As(err, &limitErr) {
+				debug.Debug(debug.Error, limitErr.Error())
+			} else if !errors.As(err, &compileErr) {
+				debug.Debug(debug.Error, fmt.Sprintf("Error executing Lua script: %v", err))
+			}
 			return err
 		}
 		if L.GetTop() > 0 {
@@ -128,185 +123,89 @@ func WsScript(luaFile string, settings config.ModulesConfig, moduleBasePath ...s
 //
 // Parameters:
 //   - luaFile: path to the Lua script file
+//   - settings: configuration of the module owning the route
+//   - route: the route the script guards, for its limits and error policy
 //   - moduleBasePath: optional base path for module-specific scripts
 //
 // The handler sets up a Lua environment with:
 //   - Session management functions (getSession, setSession)
 //   - Script execution results in context locals
-func Script(luaFile string, settings config.ModulesConfig, moduleBasePath ...string) fiber.Handler {
+//
+// Scripts control the chain with eocto.halt, eocto.abort, eocto.redirect and
+// eocto.next. A script that is missing, does not compile or raises an error is handled by
+// the route's error policy, which by default fails the request. A script
+// exceeding its time or instruction budget is aborted and answered with 504
+// (timeout) or 503 (other limits); its stack trace is logged.
+func Script(luaFile string, settings config.ModulesConfig, route config.Route, moduleBasePath ...string) fiber.Handler {
 	//debug.Debug(debug.Info, fmt.Sprintf("Script for lua file %s", luaFile))
+	return script(scriptPool(settings), luaFile, settings, route, moduleBasePath...)
+}
+
+// BoundScript is Script for callers that own the Lua state: the script runs on
+// the *luapool.State an earlier handler stored in c.Locals("luaState"), and no
+// pool is created for the module. `octopus test` uses it to run preChecks on
+// the state of a spec file.
+func BoundScript(luaFile string, settings config.ModulesConfig, route config.Route, moduleBasePath ...string) fiber.Handler {
+	return script(nil, luaFile, settings, route, moduleBasePath...)
+}
+
+// script builds the preCheck handler; states are checked out from pool unless
+// the request already carries one
+func script(pool *luapool.Pool, luaFile string, settings config.ModulesConfig, route config.Route, moduleBasePath ...string) fiber.Handler {
+	limit := limits.Resolve(settings.Limits, route.Limits)
+	policy := errorPolicy(settings, route)
+	scriptPath := luaFile
+	if len(moduleBasePath) > 0 && moduleBasePath[0] != "" {
+		scriptPath = filepath.Join(moduleBasePath[0], luaFile)
+	} else {
+		scriptPath = filepath.Join("modules", luaFile)
+	}
+	// Compile at load so syntax errors are reported once, before any request
+	if _, err := scriptcache.Load(scriptPath); os.IsNotExist(err) {
+		debug.Debug(debug.Error, fmt.Sprintf("Script file %s does not exist ", scriptPath))
+	}
 	return func(c *fiber.Ctx) error {
-		luaState, ok := c.Locals("luaState").(*lua.LState)
 		var L *lua.LState
-
-		if !ok {
-			// log.Printf("Lua state not found in context locals Script Handler")
-			L = lua.NewState()
-			eoctoTable := luaState.NewTable()
-			// debug Messages
-			eoctoTable.RawSetString("debug", L.NewFunction(utilities.Debug))
-
-			// sessions
-			// L.SetGlobal("lua_getSession", L.NewFunction(utilities.GetSession(c)))
-			eoctoTable.RawSetString("getSession", L.NewFunction(utilities.GetSession(c)))
-			// L.SetGlobal("lua_setSession", L.NewFunction(utilities.SetSession(c)))
-			eoctoTable.RawSetString("setSession", L.NewFunction(utilities.SetSession(c)))
-			// L.SetGlobal("lua_deleteSession", L.NewFunction(utilities.DeleteSession(c)))
-			eoctoTable.RawSetString("deleteSession", L.NewFunction(utilities.DeleteSession(c)))
-			eoctoTable.RawSetString("setSessionExpiry", L.NewFunction(utilities.SetSessionExpiry(c)))
-			// Cookie Handlers
-			eoctoTable.RawSetString("getCookie", L.NewFunction(utilities.GetCookie(c)))
-			eoctoTable.RawSetString("setCookie", L.NewFunction(utilities.SetCookie(c)))
-			eoctoTable.RawSetString("getAllCookies", L.NewFunction(utilities.GetAllCookies(c)))
-			eoctoTable.RawSetString("deleteCookie", L.NewFunction(utilities.DeleteCookie(c)))
-			eoctoTable.RawSetString("clearAllCookies", L.NewFunction(utilities.ClearAllCookies(c)))
-
-			// webSockets
-			eoctoTable.RawSetString("wsAddRoom", L.NewFunction(utilities.WsAddRoom(c)))
-			eoctoTable.RawSetString("wsRemoveRoom", L.NewFunction(utilities.WsRemoveRoom(c)))
-			eoctoTable.RawSetString("wsGetUserRooms", L.NewFunction(utilities.WsGetUserRooms(c)))
-			eoctoTable.RawSetString("wsIsUserInRoom", L.NewFunction(utilities.WsIsUserInRoom(c)))
-			eoctoTable.RawSetString("wsEmitToRoom", L.NewFunction(utilities.WsEmitToRoom(c)))
-			//eoctoTable.RawSetString()
-
-			// csrf
-			eoctoTable.RawSetString("getCsrfToken", L.NewFunction(utilities.GetCsrfToken(c)))
-			// locals
-			// L.SetGlobal("lua_getLocal", L.NewFunction(utilities.GetLocal(c)))
-			eoctoTable.RawSetString("getLocal", L.NewFunction(utilities.GetLocal(c, settings.BasePath)))
-			// L.SetGlobal("lua_setLocal", L.NewFunction(utilities.SetLocal(c)))
-			eoctoTable.RawSetString("setLocal", L.NewFunction(utilities.SetLocal(c, settings.BasePath)))
-			// L.SetGlobal("lua_deleteLocal", L.NewFunction(utilities.DeleteLocal(c)))
-			eoctoTable.RawSetString("deleteLocal", L.NewFunction(utilities.DeleteLocal(c, settings.BasePath)))
-			// L.SetGlobal("lua_getLocals", L.NewFunction(utilities.GetLocals(c)))
-			eoctoTable.RawSetString("getLocals", L.NewFunction(utilities.GetLocals(c, settings.BasePath)))
-			// headers
-			// L.SetGlobal("lua_getHeaders", L.NewFunction(utilities.GetHeaders(c)))
-			eoctoTable.RawSetString("getHeaders", L.NewFunction(utilities.GetHeaders(c)))
-			// L.SetGlobal("lua_getHeader", L.NewFunction(utilities.GetHeader(c)))
-			eoctoTable.RawSetString("getHeader", L.NewFunction(utilities.GetHeader(c)))
-			// L.SetGlobal("lua_setHeader", L.NewFunction(utilities.SetHeader(c)))
-			eoctoTable.RawSetString("setHeader", L.NewFunction(utilities.SetHeader(c)))
-			// L.SetGlobal("lua_deleteHeader", L.NewFunction(utilities.DeleteHeader(c)))
-			eoctoTable.RawSetString("deleteHeader", L.NewFunction(utilities.DeleteHeader(c)))
-			// L.SetGlobal("lua_getPath", L.NewFunction(utilities.GetPath(c)))
-			eoctoTable.RawSetString("getPath", L.NewFunction(utilities.GetPath(c)))
-			// L.SetGlobal("lua_getHost", L.NewFunction(utilities.GetHost(c)))
-			eoctoTable.RawSetString("getHost", L.NewFunction(utilities.GetHost(c)))
-			// L.SetGlobal("lua_getSchema", L.NewFunction(utilities.GetSchema(c)))
-			eoctoTable.RawSetString("getSchema", L.NewFunction(utilities.GetSchema(c)))
-			// query parameters
-			// L.SetGlobal("lua_getQueryParams", L.NewFunction(utilities.GetQueryParams(c)))
-			eoctoTable.RawSetString("getQueryParams", L.NewFunction(utilities.GetQueryParams(c)))
-
-			// path parameters
-			eoctoTable.RawSetString("getPathParams", L.NewFunction(utilities.GetPathParams(c)))
-			// get specific path param
-			eoctoTable.RawSetString("getPathParam", L.NewFunction(utilities.GetPathParam(c)))
-			// posted data
-			// L.SetGlobal("lua_getPostBody", L.NewFunction(utilities.GetPostBody(c)))
-			eoctoTable.RawSetString("getPostBody", L.NewFunction(utilities.GetPostBody(c)))
-			// get the method
-			// L.SetGlobal("lua_getMethod", L.NewFunction(utilities.GetMethod(c)))
-			eoctoTable.RawSetString("getMethod", L.NewFunction(utilities.GetMethod(c)))
-			// encryption/decryption
-			// L.SetGlobal("lua_decryptData", L.NewFunction(utilities.GetDecryptData(c)))
-			eoctoTable.RawSetString("decryptData", L.NewFunction(utilities.GetDecryptData(c)))
-			// L.SetGlobal("lua_encryptData", L.NewFunction(utilities.GetEncryptData(c)))
-			eoctoTable.RawSetString("encryptData", L.NewFunction(utilities.GetEncryptData(c)))
-			// L.SetGlobal("lua_decodeJSON", L.NewFunction(utilities.GetDecodeJSON(c)))
-			eoctoTable.RawSetString("decodeJSON", L.NewFunction(utilities.GetDecodeJSON(c)))
-			eoctoTable.RawSetString("encodeJSON", L.NewFunction(utilities.GetEncodeJSON(c)))
-			// base 32
-			eoctoTable.RawSetString("encodeBase32", L.NewFunction(utilities.GetEncodeBase32(c)))
-			eoctoTable.RawSetString("decodeBase32", L.NewFunction(utilities.GetDecodeBase32(c)))
-
-			// mongodbDatabase functionalities
-			eoctoTable.RawSetString("getDataFromCollection", L.NewFunction(utilities.GetDataFromCollectionLua))
-			eoctoTable.RawSetString("setDataToCollection", L.NewFunction(utilities.SetDataToCollectionLua))
-			eoctoTable.RawSetString("delDataFromCollection", L.NewFunction(utilities.DelDataFromCollectionLua))
-			eoctoTable.RawSetString("insertDataToCollection", L.NewFunction(utilities.InsertDataToCollectionLua))
-
-			// make http Requests to other servers
-			// L.SetGlobal("lua_makeRequest", L.NewFunction(utilities.GetRequest(c)))
-			eoctoTable.RawSetString("makeRequest", L.NewFunction(utilities.GetRequest(c)))
-			eoctoTable.RawSetString("proxy", L.NewFunction(utilities.ProxyRequestLua(c)))
-			// Register Twilio account
-			// Register the WhatsApp function
-			eoctoTable.RawSetString("sendWhatsAppMessage", L.NewFunction(utilities.SendWhatsAppMessageLua))
-
-			// set lua response
-			// L.SetGlobal("lua_setResponse", L.NewFunction(utilities.GetResponse(c)))
-			eoctoTable.RawSetString("setResponse", L.NewFunction(utilities.GetResponse(c)))
-			// expose c.Render to lua
-			eoctoTable.RawSetString("render", L.NewFunction(utilities.GetRender(c)))
-			eoctoTable.RawSetString("renderJson", L.NewFunction(utilities.GetRenderJson(c)))
-
-			// Expose getUUID function
-			eoctoTable.RawSetString("getUUID", L.NewFunction(func(L *lua.LState) int {
-				uuid := utils.UUIDv4()
-				L.Push(lua.LString(uuid))
-				return 1
-			}))
-			eoctoTable.RawSetString("getSettings", L.NewFunction(func(l *lua.LState) int {
-				tbl := L.NewTable()
-
-				tbl.RawSetString("BasePath", lua.LString(settings.BasePath))
-				tbl.RawSetString("LocalPath", lua.LString(settings.LocalPath))
-				L.Push(tbl)
-				return 1
-			}))
-			// Redis functionalities
-			eoctoTable.RawSetString("getRedis", L.NewFunction(utilities.GetRedisValueLua))
-			eoctoTable.RawSetString("setRedis", L.NewFunction(utilities.SetRedisValueLua))
-			eoctoTable.RawSetString("deleteRedis", L.NewFunction(utilities.DeleteRedisKeyLua))
-			eoctoTable.RawSetString("timeStampNano", L.NewFunction(func(l *lua.LState) int {
-				tstamp := time.Now().UnixNano()
-				L.Push(lua.LNumber(tstamp))
-				return 1
-			}))
-			eoctoTable.RawSetString("timeStampMilli", L.NewFunction(func(l *lua.LState) int {
-				tstamp := time.Now().UnixMilli()
-				L.Push(lua.LNumber(tstamp))
-				return 1
-			}))
-			eoctoTable.RawSetString("timeStamp", L.NewFunction(func(l *lua.LState) int {
-				tstamp := time.Now().Unix()
-				L.Push(lua.LNumber(tstamp))
-				return 1
-			}))
-			// FileSystem functionalities
-			eoctoTable.RawSetString("getCWD", L.NewFunction(utilities.GetCWD(c)))
-			eoctoTable.RawSetString("resetWD", L.NewFunction(utilities.ResetWD(c)))
-			eoctoTable.RawSetString("setWD", L.NewFunction(utilities.SetWD(c)))
-			eoctoTable.RawSetString("listFiles", L.NewFunction(utilities.ListFiles(c)))
-			eoctoTable.RawSetString("resetProjectPath", L.NewFunction(utilities.ResetProjectWD(c, settings.AbsolutePath)))
-			eoctoTable.RawSetString("setProjectWD", L.NewFunction(utilities.SetProjectWD(c)))
-			// YAML utilities
-			eoctoTable.RawSetString("readYamlFile", L.NewFunction(utilities.ReadYamlFileLua))
-			// CSV utilities
-			eoctoTable.RawSetString("readCsvFile", L.NewFunction(utilities.ReadCsvFileLua))
-			// Set the eocto table a a global
-			L.SetGlobal("eocto", eoctoTable)
-			c.Locals("luaState", L)
-		} else {
-			L = luaState
-		}
-		scriptPath := luaFile
-		if len(moduleBasePath) > 0 && moduleBasePath[0] != "" {
-			scriptPath = filepath.Join(moduleBasePath[0], luaFile)
+		if st, ok := c.Locals("luaState").(*luapool.State); ok {
+			L = st.L
+		} else if pool == nil {
+			return fmt.Errorf("script %s: no Lua state bound to the request", scriptPath)
 		} else {
-			scriptPath = filepath.Join("modules", luaFile)
-		}
-		if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
-			debug.Debug(debug.Error, fmt.Sprintf("Script file %s does not exist ", scriptPath))
-
-			return c.Next()
+			// First script of the request: check out a pre-warmed state and
+			// return it once the rest of the handler chain has run
+			st := pool.Get(c)
+			defer func() {
+				c.Locals("luaState", nil)
+				// A streamed response keeps the state until it is written
+				st.Ctx = nil
+				if !utilities.DetachStream(c, func() { pool.Put(st) }) {
+					pool.Put(st)
+				}
+			}()
+			c.Locals("luaState", st)
+			L = st.L
 		}
 		debug.Debug(debug.Info, fmt.Sprintf("script file %s,\n\t ScriptFilePath %s", luaFile, scriptPath))
-		if err := L.DoFile(scriptPath); err != nil {
-			debug.Debug(debug.Error, fmt.Sprintf("Error executing Lua script: %v", err))
-			return c.Next()
+		err := limits.Run(c.UserContext(), L, limit, scriptPath, func() error {
+			return scriptcache.Run(L, scriptPath)
+		})
+		// eocto.halt/abort/redirect/next unwind the script with an error; the decision wins
+		if flow := utilities.TakeFlow(c); flow != nil {
+			L.SetTop(0)
+			return applyFlow(c, settings, flow)
+		}
+		if err != nil {
+			var compileErr *scriptcache.CompileError
+			var limitErr *limits.Error
+			if errors.As(err, &limitErr) {
+				debug.Debug(debug.Error, limitErr.Error())
+			} else if os.IsNotExist(err) {
+				debug.Debug(debug.Error, fmt.Sprintf("Script file %s does not exist ", scriptPath))
+			} else if !errors.As(err, &compileErr) {
+				// Compilation errors were reported when the script was loaded
+				debug.Debug(debug.Error, fmt.Sprintf("Error executing Lua script: %v", err))
+			}
+			return handleScriptError(c, L, settings, policy, limit, newScriptFailure(scriptPath, err))
 		}
 		if L.GetTop() > 0 {
 			returnValue := L.Get(-1).String()
@@ -319,6 +218,71 @@ func Script(luaFile string, settings config.ModulesConfig, moduleBasePath ...str
 	}
 }
 
+// View returns the last handler of a route. A body set through
+// eocto.response (or eocto.setResponse) is sent in place of the view and of
+// anything eocto.render/renderJson sent; without one, a response rendered
+// from Lua is left alone and otherwise the route's view is rendered. The
+// status and headers set through eocto.response apply in every case.
+func View(module config.ModulesConfig, route config.Route) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// l1 := c.Locals("l1").(string)
+		if sent, err := utilities.SendResponse(c); sent {
+			return err
+		}
+		if c.Locals("rendered_from_lua") != nil {
+			return nil
+		}
+		log.Println("__ CSRF TOKEN")
+		_tkn := c.Locals("csrf_token")
+		var tkn string = ""
+		if _tkn != nil {
+			tkn = _tkn.(string)
+		}
+		log.Println(tkn)
+		log.Println("^^ CSRF TOKEN")
+		//debug.Debug(debug.Warning, fmt.Sprintf("GetAllSessions: % +v", middleware.GetAllSessions()))
+
+		thePath := templatePath(module, route.View)
+		debug.Debug(debug.Warning, fmt.Sprintf("%s", thePath))
+		return c.Render(thePath, fiber.Map{
+			"basePath":  strings.TrimSpace(module.BasePath),
+			"localPath": strings.TrimSpace(module.LocalPath),
+			"csrf":      tkn,
+		})
+	}
+}
+
+// SSE returns the last handler of an sse route. A response set from Lua is
+// sent as View sends it, so a script can refuse the stream with
+// eocto.response; otherwise the events queued by the scripts are sent and,
+// when live, the connection stays open for the events of its rooms.
+func SSE(live bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if sent, err := utilities.SendResponse(c); sent {
+			return err
+		}
+		if c.Locals("rendered_from_lua") != nil {
+			return nil
+		}
+		return utilities.ServeSSE(c, live)
+	}
+}
+
+// templatePath returns the template name of a module view
+func templatePath(module config.ModulesConfig, view string) string {
+	basePath := module.BasePath
+	if strings.TrimSpace(module.LocalPath) != "" && strings.TrimSpace(module.LocalPath) != "/" {
+		basePath = strings.TrimSpace(module.LocalPath)
+	} else if strings.TrimSpace(module.LocalPath) != "" && strings.TrimSpace(module.LocalPath) == "/" {
+		basePath = ""
+	} else if strings.TrimSpace(module.BasePath) != "" && strings.TrimSpace(module.BasePath) == "/" {
+		basePath = ""
+	}
+
+	thePath := fmt.Sprintf("%s/%s", strings.TrimPrefix(basePath, "/"), view)
+	return strings.TrimPrefix(thePath, "/")
+}
+
 func CreateSocketIOWIthMessageMiddlewares(c *fiber.Ctx, middlewares ...func(*socketio.Websocket) error) fiber.Handler {
 	registerOnce.Do(registerGlobalHandlers)
 	//registerGlobalHandlers()
@@ -349,6 +313,13 @@ func CreateSocketIOWIthMessageMiddlewares(c *fiber.Ctx, middlewares ...func(*soc
 			middlewares: middlewares,
 		})
 
+		// Register the client so presence, rooms and emits reach it from any process
+		userID := socketUserID(kws)
+		kws.SetAttribute("user_id", userID)
+		clients := utilities.GetSocketClients()
+		clients.AddClient(userID, kws.UUID)
+		clients.SetSocket(userID, kws)
+
 		// Optionally expose the connection on the context
 		//if ctx != nil {
 		//	c.Locals("socketio_connection", kws)
@@ -459,6 +430,7 @@ func registerGlobalHandlers() {
 		if mwKey != "" {
 			connStore.Delete(mwKey)
 		}
+		removeSocketClient(ep.Kws)
 	})
 
 	// Ensure cleanup on close as well
@@ -467,9 +439,35 @@ func registerGlobalHandlers() {
 		if mwKey != "" {
 			connStore.Delete(mwKey)
 		}
+		removeSocketClient(ep.Kws)
 	})
 }
 
+// socketUserID returns the user a connection belongs to: the "userId" query
+// parameter, the ":id" route parameter, or the connection UUID.
+func socketUserID(kws *socketio.Websocket) string {
+	if userID := kws.Query("userId"); userID != "" {
+		return userID
+	}
+	if userID := kws.Params("id"); userID != "" {
+		return userID
+	}
+	return kws.UUID
+}
+
+// removeSocketClient unregisters the client, unless the user already
+// reconnected with a different socket.
+func removeSocketClient(kws *socketio.Websocket) {
+	userID := kws.GetStringAttribute("user_id")
+	if userID == "" {
+		return
+	}
+	clients := utilities.GetSocketClients()
+	if client, exists := clients.GetClient(userID); exists && client.UUID == kws.UUID {
+		clients.RemoveClient(userID)
+	}
+}
+
 // sendSocketIOJSON marshals v to JSON and sends it as a text message
 func sendSocketIOJSON(kws *socketio.Websocket, v interface{}) error {
 	b, err := json.Marshal(v)
@@ -568,13 +566,24 @@ func SetupRoutes(app *fiber.App, module config.ModulesConfig) error {
 	group.Static("/fonts", fontsPath)
 	group.Static("/icons", iconsPath)
 
+	for _, err := range sandbox.Check(module.Sandbox) {
+		debug.Debug(debug.Warning, fmt.Sprintf("module %s: %v", module.Name, err))
+	}
+
+	// The init script runs before any pooled state is built, so every state
+	// sees its value in eocto.init
+	if err := RunInit(module); err != nil {
+		return err
+	}
+
 	for _, route := range module.Routes {
 		var middlewares []fiber.Handler
 		var wsmiddlewares []func(*socketio.Websocket) error
 
 		// middlewares = append(middlewares, middleware.CreateSession())
 		// middlewares = append(middlewares, middleware.CreateEoctoCSRFMiddleware())
-		if len(route.PreCheck) > 0 {
+		// The module's use scripts run first, unless the route opts out
+		if checks := module.Checks(route); len(checks) > 0 {
 			// log.Printf("Pre-check for route %s", route.Path)
 			//if route.WebSocket {
 			//	middlewares = append(middlewares, websocket.New(func(c *websocket.Conn) {
@@ -595,34 +604,35 @@ func SetupRoutes(app *fiber.App, module config.ModulesConfig) error {
 			//
 			//	}))
 			//}
-			for _, check := range route.PreCheck {
+			for _, check := range checks {
 				if check.Script != "" {
 					if route.WebSocket {
 						//debug.Debug(debug.Important, fmt.Sprintf("Script for route %s=> %s", route.Path, check.Script))
-						if strings.TrimSpace(module.LocalPath) != "" {
-							wsmiddlewares = append(wsmiddlewares, WsScript(check.Script, module, filepath.Join("views", module.LocalPath, "scripts")))
-						} else {
-							wsmiddlewares = append(wsmiddlewares, WsScript(check.Script, module, filepath.Join("views", module.BasePath, "scripts")))
-						}
+						wsmiddlewares = append(wsmiddlewares, WsScript(check.Script, module, route, module.ScriptsDir()))
 					} else {
 						//debug.Debug(debug.Important, fmt.Sprintf("Script for route %s=> %s", route.Path, check.Script))
-						if strings.TrimSpace(module.LocalPath) != "" {
-							middlewares = append(middlewares, Script(check.Script, module, filepath.Join("views", module.LocalPath, "scripts")))
-						} else {
-							middlewares = append(middlewares, Script(check.Script, module, filepath.Join("views", module.BasePath, "scripts")))
-						}
+						middlewares = append(middlewares, Script(check.Script, module, route, module.ScriptsDir()))
 					}
 
 				}
 
 			}
 		}
+		// Replay idempotent responses before any preCheck runs
+		if route.Idempotency != nil && !route.WebSocket && !route.SSE {
+			middlewares = append([]fiber.Handler{middleware.CreateIdempotency(*route.Idempotency)}, middlewares...)
+		}
+		// Development servers capture requests for replay in the Lua console
+		if capture.Enabled() && !route.WebSocket {
+			middlewares = append([]fiber.Handler{capture.Handler(module, route)}, middlewares...)
+		}
 		routeInfo := RouteInfo{
 			Method:    route.Method,
 			Path:      route.Path,
 			Group:     module.BasePath, // Store the base path as the group name
 			View:      route.View,      // Set the default view to an empty string
 			WebSocket: route.WebSocket,
+			SSE:       route.SSE,
 		}
 		routes = append(routes, routeInfo)
 		// middleware.NewCSRFMiddleware(store)
@@ -632,47 +642,17 @@ func SetupRoutes(app *fiber.App, module config.ModulesConfig) error {
 				return CreateSocketIOWIthMessageMiddlewares(c, wsmiddlewares...)(c)
 			})
 
+		} else if route.SSE {
+			handlers := append([]fiber.Handler{utilities.OpenSSE}, middlewares...)
+			group.Add(route.Method, route.Path, append(handlers, middleware.CreateSession(), SSE(true))...)
 		} else {
-			group.Add(route.Method, route.Path, append(middlewares, middleware.CreateSession(), func(c *fiber.Ctx) error {
-				// l1 := c.Locals("l1").(string)
-				if luaResp := c.Locals("lua_response"); luaResp != nil {
-					if respMap, ok := luaResp.(map[int]fiber.Map); ok {
-						for status, jsonMap := range respMap {
-							return c.Status(status).JSON(jsonMap)
-						}
-					}
-				}
-				if c.Locals("rendered_from_lua") != nil {
-					return nil
-				}
-				log.Println("__ CSRF TOKEN")
-				_tkn := c.Locals("csrf_token")
-				var tkn string = ""
-				if _tkn != nil {
-					tkn = _tkn.(string)
-				}
-				log.Println(tkn)
-				log.Println("^^ CSRF TOKEN")
-				//debug.Debug(debug.Warning, fmt.Sprintf("GetAllSessions: % +v", middleware.GetAllSessions()))
-
-				basePath := module.BasePath
-				if strings.TrimSpace(module.LocalPath) != "" && strings.TrimSpace(module.LocalPath) != "/" {
-					basePath = strings.TrimSpace(module.LocalPath)
-				} else if strings.TrimSpace(module.LocalPath) != "" && strings.TrimSpace(module.LocalPath) == "/" {
-					basePath = ""
-				} else if strings.TrimSpace(module.BasePath) != "" && strings.TrimSpace(module.BasePath) == "/" {
-					basePath = ""
-				}
-
-				thePath := fmt.Sprintf("%s/%s", strings.TrimPrefix(basePath, "/"), route.View)
-				thePath = fmt.Sprintf("%s", strings.TrimPrefix(thePath, "/"))
-				debug.Debug(debug.Warning, fmt.Sprintf("%s", thePath))
-				return c.Render(thePath, fiber.Map{
-					"basePath":  strings.TrimSpace(module.BasePath),
-					"localPath": strings.TrimSpace(module.LocalPath),
-					"csrf":      tkn,
-				})
-			})...)
+			handlers := append(middlewares, middleware.CreateSession())
+			// Cached responses stand in for the view only: the preChecks, the
+			// auth scripts among them, run on every request
+			if route.Cache != nil {
+				handlers = append(handlers, middleware.CreateResponseCache(*route.Cache))
+			}
+			group.Add(route.Method, route.Path, append(handlers, View(module, route))...)
 		}
 
 	}
@@ -0,0 +1,89 @@
+// Package broadcast provides pluggable adapters that fan Socket.IO traffic out
+// across server processes.
+//
+// With Prefork enabled every child process keeps its own socket connections,
+// so room emits, direct-user emits and room membership changes are published
+// through an Adapter and applied by every process that holds the affected
+// sockets. Presence (who is connected, who is in which room) is stored by the
+// adapter so lookups see the global view rather than the local process only.
+package broadcast
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Kind identifies the type of a broadcast message
+type Kind string
+
+const (
+	// KindRoom delivers an event to every member of a room
+	KindRoom Kind = "room"
+	// KindUser delivers an event to a single user
+	KindUser Kind = "user"
+	// KindJoin announces that a user joined a room
+	KindJoin Kind = "join"
+	// KindLeave announces that a user left a room
+	KindLeave Kind = "leave"
+)
+
+// Message is the envelope exchanged between processes
+type Message struct {
+	Kind    Kind     `json:"kind"`
+	Room    string   `json:"room,omitempty"`
+	UserID  string   `json:"userId,omitempty"`
+	Event   string   `json:"event,omitempty"`
+	Data    string   `json:"data,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	Origin  string   `json:"origin"`
+	// ID numbers room events so sse clients can resume after Last-Event-ID
+	ID int64 `json:"id,omitempty"`
+}
+
+// Handler processes a message received from the adapter
+type Handler func(Message)
+
+// Adapter publishes messages to every server process and stores the shared
+// presence and room membership state.
+type Adapter interface {
+	// Name returns the adapter name ("memory", "redis")
+	Name() string
+	// Publish sends msg to every subscribed process, including this one
+	Publish(ctx context.Context, msg Message) error
+	// Subscribe registers the handler invoked for every published message
+	Subscribe(handler Handler) error
+	// NextID returns an event ID greater than every ID returned before by
+	// any process sharing the adapter
+	NextID(ctx context.Context) (int64, error)
+
+	// SetOnline records (or clears) the presence of a user on nodeID
+	SetOnline(ctx context.Context, userID, nodeID string, online bool) error
+	// IsOnline reports whether the user is connected to any process
+	IsOnline(ctx context.Context, userID string) (bool, error)
+	// OnlineUsers lists the users connected to any process
+	OnlineUsers(ctx context.Context) ([]string, error)
+
+	// Join adds userID to room
+	Join(ctx context.Context, userID, room string) error
+	// Leave removes userID from room
+	Leave(ctx context.Context, userID, room string) error
+	// LeaveAll removes userID from every room it joined
+	LeaveAll(ctx context.Context, userID string) error
+	// Rooms lists the rooms userID joined
+	Rooms(ctx context.Context, userID string) ([]string, error)
+	// Members lists the users in room
+	Members(ctx context.Context, room string) ([]string, error)
+
+	// Close releases the adapter's resources
+	Close() error
+}
+
+// NewNodeID returns a random identifier for the current process
+func NewNodeID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "node"
+	}
+	return hex.EncodeToString(b)
+}
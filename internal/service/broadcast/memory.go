@@ -0,0 +1,173 @@
+package broadcast
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// MemoryAdapter keeps presence and membership in process memory and delivers
+// published messages synchronously. It is the default adapter and is only
+// suitable for a single process (Prefork disabled).
+type MemoryAdapter struct {
+	mutex    sync.RWMutex
+	handlers []Handler
+	online   map[string]string
+	rooms    map[string]map[string]bool // room -> userID set
+	joined   map[string]map[string]bool // userID -> room set
+	lastID   atomic.Int64
+}
+
+// NewMemoryAdapter creates an in-memory adapter
+func NewMemoryAdapter() *MemoryAdapter {
+	return &MemoryAdapter{
+		online: make(map[string]string),
+		rooms:  make(map[string]map[string]bool),
+		joined: make(map[string]map[string]bool),
+	}
+}
+
+// Name returns "memory"
+func (m *MemoryAdapter) Name() string {
+	return "memory"
+}
+
+// Publish invokes every subscribed handler with msg
+func (m *MemoryAdapter) Publish(_ context.Context, msg Message) error {
+	m.mutex.RLock()
+	handlers := append([]Handler(nil), m.handlers...)
+	m.mutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(msg)
+	}
+	return nil
+}
+
+// Subscribe registers handler for published messages
+func (m *MemoryAdapter) Subscribe(handler Handler) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.handlers = append(m.handlers, handler)
+	return nil
+}
+
+// NextID returns the next event ID of this process
+func (m *MemoryAdapter) NextID(_ context.Context) (int64, error) {
+	return m.lastID.Add(1), nil
+}
+
+// SetOnline records or clears the presence of userID
+func (m *MemoryAdapter) SetOnline(_ context.Context, userID, nodeID string, online bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if online {
+		m.online[userID] = nodeID
+	} else {
+		delete(m.online, userID)
+	}
+	return nil
+}
+
+// IsOnline reports whether userID is connected
+func (m *MemoryAdapter) IsOnline(_ context.Context, userID string) (bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	_, exists := m.online[userID]
+	return exists, nil
+}
+
+// OnlineUsers lists the connected users
+func (m *MemoryAdapter) OnlineUsers(_ context.Context) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	users := make([]string, 0, len(m.online))
+	for userID := range m.online {
+		users = append(users, userID)
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// Join adds userID to room
+func (m *MemoryAdapter) Join(_ context.Context, userID, room string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.rooms[room] == nil {
+		m.rooms[room] = make(map[string]bool)
+	}
+	if m.joined[userID] == nil {
+		m.joined[userID] = make(map[string]bool)
+	}
+	m.rooms[room][userID] = true
+	m.joined[userID][room] = true
+	return nil
+}
+
+// Leave removes userID from room
+func (m *MemoryAdapter) Leave(_ context.Context, userID, room string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.leave(userID, room)
+	return nil
+}
+
+// LeaveAll removes userID from every room
+func (m *MemoryAdapter) LeaveAll(_ context.Context, userID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for room := range m.joined[userID] {
+		m.leave(userID, room)
+	}
+	return nil
+}
+
+// leave removes userID from room; the caller must hold the lock
+func (m *MemoryAdapter) leave(userID, room string) {
+	delete(m.rooms[room], userID)
+	if len(m.rooms[room]) == 0 {
+		delete(m.rooms, room)
+	}
+	delete(m.joined[userID], room)
+	if len(m.joined[userID]) == 0 {
+		delete(m.joined, userID)
+	}
+}
+
+// Rooms lists the rooms userID joined
+func (m *MemoryAdapter) Rooms(_ context.Context, userID string) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return sortedKeys(m.joined[userID]), nil
+}
+
+// Members lists the users in room
+func (m *MemoryAdapter) Members(_ context.Context, room string) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return sortedKeys(m.rooms[room]), nil
+}
+
+// Close is a no-op for the memory adapter
+func (m *MemoryAdapter) Close() error {
+	return nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
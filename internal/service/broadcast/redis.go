@@ -0,0 +1,255 @@
+package broadcast
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/degreane/octopus/internal/utilities/debug"
+	rds "github.com/redis/go-redis/v9"
+)
+
+const (
+	redisChannel     = "eocto:broadcast"
+	redisPresenceKey = "eocto:ws:online"
+	redisRoomPrefix  = "eocto:ws:room:"
+	redisUserPrefix  = "eocto:ws:rooms:"
+	redisEventIDKey  = "eocto:ws:event"
+
+	// presenceTTL is how long a user stays online without a heartbeat from
+	// the node holding its socket, so the users of a crashed node expire
+	presenceTTL = 90 * time.Second
+	// presenceHeartbeat is how often a node refreshes its users' presence
+	presenceHeartbeat = 30 * time.Second
+)
+
+// RedisAdapter publishes messages over Redis pub/sub and stores presence and
+// room membership in Redis, so every process and node shares the same view.
+// Presence is a sorted set scored by the time of the last heartbeat: each node
+// refreshes the users connected to it, and entries older than presenceTTL are
+// pruned when presence is read.
+type RedisAdapter struct {
+	client *rds.Client
+	pubsub *rds.PubSub
+	cancel context.CancelFunc
+
+	mutex sync.Mutex
+	// local holds the users online on this node
+	local map[string]bool
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// NewRedisAdapter creates an adapter on top of an initialized Redis client
+// and starts refreshing the presence of the users connected to this node
+func NewRedisAdapter(client *rds.Client) (*RedisAdapter, error) {
+	if client == nil {
+		return nil, errors.New("redis client not initialized")
+	}
+	r := &RedisAdapter{client: client, local: make(map[string]bool), stop: make(chan struct{})}
+	go r.heartbeat()
+	return r, nil
+}
+
+// heartbeat refreshes the presence of this node's users until Close
+func (r *RedisAdapter) heartbeat() {
+	ticker := time.NewTicker(presenceHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+		r.mutex.Lock()
+		users := make([]string, 0, len(r.local))
+		for userID := range r.local {
+			users = append(users, userID)
+		}
+		r.mutex.Unlock()
+		if len(users) == 0 {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), presenceHeartbeat)
+		if err := r.touch(ctx, users...); err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("broadcast: presence heartbeat: %v", err))
+		}
+		cancel()
+	}
+}
+
+// touch marks users as seen now
+func (r *RedisAdapter) touch(ctx context.Context, users ...string) error {
+	now := float64(time.Now().UnixMilli())
+	members := make([]rds.Z, len(users))
+	for i, userID := range users {
+		members[i] = rds.Z{Score: now, Member: userID}
+	}
+	return r.client.ZAdd(ctx, redisPresenceKey, members...).Err()
+}
+
+// prune drops the users whose node stopped refreshing them
+func (r *RedisAdapter) prune(ctx context.Context) error {
+	expired := time.Now().Add(-presenceTTL).UnixMilli()
+	return r.client.ZRemRangeByScore(ctx, redisPresenceKey, "-inf", "("+strconv.FormatInt(expired, 10)).Err()
+}
+
+// Name returns "redis"
+func (r *RedisAdapter) Name() string {
+	return "redis"
+}
+
+// Publish sends msg on the broadcast channel
+func (r *RedisAdapter) Publish(ctx context.Context, msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, redisChannel, payload).Err()
+}
+
+// NextID increments the event counter shared by every node
+func (r *RedisAdapter) NextID(ctx context.Context) (int64, error) {
+	return r.client.Incr(ctx, redisEventIDKey).Result()
+}
+
+// Subscribe starts a goroutine delivering broadcast channel messages to handler
+func (r *RedisAdapter) Subscribe(handler Handler) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	pubsub := r.client.Subscribe(ctx, redisChannel)
+	// Wait for the subscription to be confirmed so no early publish is lost
+	if _, err := pubsub.Receive(ctx); err != nil {
+		cancel()
+		_ = pubsub.Close()
+		return fmt.Errorf("error subscribing to %s: %w", redisChannel, err)
+	}
+	r.pubsub = pubsub
+	r.cancel = cancel
+
+	go func() {
+		for payload := range pubsub.Channel() {
+			var msg Message
+			if err := json.Unmarshal([]byte(payload.Payload), &msg); err != nil {
+				debug.Debug(debug.Error, fmt.Sprintf("broadcast: invalid message on %s: %v", redisChannel, err))
+				continue
+			}
+			handler(msg)
+		}
+	}()
+	return nil
+}
+
+// SetOnline records or clears the presence of userID. Users set online are
+// refreshed by this node's heartbeat until they are set offline.
+func (r *RedisAdapter) SetOnline(ctx context.Context, userID, nodeID string, online bool) error {
+	r.mutex.Lock()
+	if online {
+		r.local[userID] = true
+	} else {
+		delete(r.local, userID)
+	}
+	r.mutex.Unlock()
+	if online {
+		return r.touch(ctx, userID)
+	}
+	return r.client.ZRem(ctx, redisPresenceKey, userID).Err()
+}
+
+// IsOnline reports whether userID is connected to any node
+func (r *RedisAdapter) IsOnline(ctx context.Context, userID string) (bool, error) {
+	seen, err := r.client.ZScore(ctx, redisPresenceKey, userID).Result()
+	if errors.Is(err, rds.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return time.Since(time.UnixMilli(int64(seen))) < presenceTTL, nil
+}
+
+// OnlineUsers lists the users connected to any node
+func (r *RedisAdapter) OnlineUsers(ctx context.Context) ([]string, error) {
+	if err := r.prune(ctx); err != nil {
+		return nil, err
+	}
+	users, err := r.client.ZRange(ctx, redisPresenceKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// Join adds userID to room
+func (r *RedisAdapter) Join(ctx context.Context, userID, room string) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe rds.Pipeliner) error {
+		pipe.SAdd(ctx, redisRoomPrefix+room, userID)
+		pipe.SAdd(ctx, redisUserPrefix+userID, room)
+		return nil
+	})
+	return err
+}
+
+// Leave removes userID from room
+func (r *RedisAdapter) Leave(ctx context.Context, userID, room string) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe rds.Pipeliner) error {
+		pipe.SRem(ctx, redisRoomPrefix+room, userID)
+		pipe.SRem(ctx, redisUserPrefix+userID, room)
+		return nil
+	})
+	return err
+}
+
+// LeaveAll removes userID from every room it joined
+func (r *RedisAdapter) LeaveAll(ctx context.Context, userID string) error {
+	rooms, err := r.client.SMembers(ctx, redisUserPrefix+userID).Result()
+	if err != nil {
+		return err
+	}
+	_, err = r.client.TxPipelined(ctx, func(pipe rds.Pipeliner) error {
+		for _, room := range rooms {
+			pipe.SRem(ctx, redisRoomPrefix+room, userID)
+		}
+		pipe.Del(ctx, redisUserPrefix+userID)
+		return nil
+	})
+	return err
+}
+
+// Rooms lists the rooms userID joined
+func (r *RedisAdapter) Rooms(ctx context.Context, userID string) ([]string, error) {
+	rooms, err := r.client.SMembers(ctx, redisUserPrefix+userID).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(rooms)
+	return rooms, nil
+}
+
+// Members lists the users in room
+func (r *RedisAdapter) Members(ctx context.Context, room string) ([]string, error) {
+	members, err := r.client.SMembers(ctx, redisRoomPrefix+room).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// Close stops the subscription and the presence heartbeat; the shared Redis
+// client is left open
+func (r *RedisAdapter) Close() error {
+	r.once.Do(func() { close(r.stop) })
+	if r.cancel != nil {
+		r.cancel()
+	}
+	if r.pubsub != nil {
+		return r.pubsub.Close()
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+// Package cache implements the application cache used by the Lua eocto.cache API.
+//
+// Entries are stored in a fiber.Storage (memory or Redis, following
+// AppConfig.Storage) wrapped in a small envelope recording the version of every
+// tag attached to the entry. Invalidating a tag bumps its version, which turns
+// every entry stored under an older version into a miss without scanning keys.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/degreane/octopus/internal/service/lock"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/storage/memory/v2"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	entryPrefix = "eocto:cache:"
+	tagPrefix   = "eocto:cache-tag:"
+
+	// rememberLockTTL bounds how long the other processes missing a key wait
+	// for the one computing it
+	rememberLockTTL = 30 * time.Second
+)
+
+// Cache stores values with optional TTL and tags
+type Cache struct {
+	storage fiber.Storage
+	backend string
+	group   singleflight.Group
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	sets          atomic.Int64
+	deletes       atomic.Int64
+	invalidations atomic.Int64
+	shared        atomic.Int64
+}
+
+// Stats is a snapshot of the cache counters
+type Stats struct {
+	Backend       string  `json:"backend"`
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	HitRatio      float64 `json:"hitRatio"`
+	Sets          int64   `json:"sets"`
+	Deletes       int64   `json:"deletes"`
+	Invalidations int64   `json:"invalidations"`
+	// Shared counts remember calls served by a computation already in flight
+	Shared int64 `json:"shared"`
+}
+
+// entry is the envelope persisted for every cached value
+type entry struct {
+	Value []byte            `json:"v"`
+	Tags  map[string]string `json:"t,omitempty"`
+}
+
+var (
+	instance *Cache
+	mutex    sync.RWMutex
+)
+
+// New creates a cache on top of storage; backend names the storage in stats
+func New(storage fiber.Storage, backend string) *Cache {
+	return &Cache{storage: storage, backend: backend}
+}
+
+// Init sets the application wide cache instance
+func Init(storage fiber.Storage, backend string) *Cache {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	instance = New(storage, backend)
+	return instance
+}
+
+// Get returns the application wide cache, creating an in-memory one on first use
+func Get() *Cache {
+	mutex.RLock()
+	c := instance
+	mutex.RUnlock()
+	if c != nil {
+		return c
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if instance == nil {
+		instance = New(memory.New(), "memory")
+	}
+	return instance
+}
+
+// Get returns the value stored under key and whether it was found
+func (c *Cache) Get(key string) ([]byte, bool, error) {
+	value, found, err := c.load(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if found {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return value, found, nil
+}
+
+// Set stores value under key for ttl (0 keeps it until deleted) and attaches tags
+func (c *Cache) Set(key string, value []byte, ttl time.Duration, tags ...string) error {
+	e := entry{Value: value}
+	if len(tags) > 0 {
+		e.Tags = make(map[string]string, len(tags))
+		for _, tag := range tags {
+			version, err := c.tagVersion(tag)
+			if err != nil {
+				return err
+			}
+			e.Tags[tag] = version
+		}
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := c.storage.Set(entryPrefix+key, payload, ttl); err != nil {
+		return err
+	}
+	c.sets.Add(1)
+	return nil
+}
+
+// Delete removes key
+func (c *Cache) Delete(key string) error {
+	if err := c.storage.Delete(entryPrefix + key); err != nil {
+		return err
+	}
+	c.deletes.Add(1)
+	return nil
+}
+
+// Remember returns the value stored under key, computing and storing it with fn
+// on a miss. Concurrent callers missing the same key wait for a single fn call
+// instead of all hitting the upstream (stampede protection): the callers of a
+// process share one call, and with the Redis backend the processes take turns
+// through the lock service, so the others find the stored value.
+// The boolean result reports whether the value came from the cache.
+func (c *Cache) Remember(key string, ttl time.Duration, tags []string, fn func() ([]byte, error)) ([]byte, bool, error) {
+	value, found, err := c.Get(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if found {
+		return value, true, nil
+	}
+
+	result, err, shared := c.group.Do(key, func() (interface{}, error) {
+		// Another caller may have filled the key while we were waiting
+		if value, found, err := c.load(key); err == nil && found {
+			return value, nil
+		}
+		if c.backend != "memory" {
+			release := c.lockRemember(key)
+			defer release()
+			if value, found, err := c.load(key); err == nil && found {
+				return value, nil
+			}
+		}
+		value, err := fn()
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Set(key, value, ttl, tags...); err != nil {
+			return nil, err
+		}
+		return value, nil
+	})
+	if shared {
+		c.shared.Add(1)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return result.([]byte), false, nil
+}
+
+// lockRemember takes the lock of the processes computing key and returns its
+// release. When the lock cannot be had in time the value is computed anyway:
+// a slow computation must not turn into errors.
+func (c *Cache) lockRemember(key string) func() {
+	locker := lock.Get()
+	ctx := context.Background()
+	lease, err := lock.Acquire(ctx, locker, "remember:"+key, rememberLockTTL, rememberLockTTL)
+	if err != nil {
+		debug.Debug(debug.Warning, fmt.Sprintf("cache: remember %s without the lock: %v", key, err))
+		return func() {}
+	}
+	return func() {
+		if err := locker.Release(ctx, lease); err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("cache: releasing the remember lock of %s: %v", key, err))
+		}
+	}
+}
+
+// InvalidateTags expires every entry stored with one of tags
+func (c *Cache) InvalidateTags(tags ...string) error {
+	for _, tag := range tags {
+		version := strconv.FormatInt(time.Now().UnixNano(), 36)
+		if err := c.storage.Set(tagPrefix+tag, []byte(version), 0); err != nil {
+			return fmt.Errorf("error invalidating tag %s: %w", tag, err)
+		}
+		c.invalidations.Add(1)
+	}
+	return nil
+}
+
+// Stats returns a snapshot of the cache counters
+func (c *Cache) Stats() Stats {
+	stats := Stats{
+		Backend:       c.backend,
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Sets:          c.sets.Load(),
+		Deletes:       c.deletes.Load(),
+		Invalidations: c.invalidations.Load(),
+		Shared:        c.shared.Load(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// load reads key without touching the hit/miss counters
+func (c *Cache) load(key string) ([]byte, bool, error) {
+	payload, err := c.storage.Get(entryPrefix + key)
+	if err != nil {
+		return nil, false, err
+	}
+	if payload == nil {
+		return nil, false, nil
+	}
+
+	var e entry
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, false, fmt.Errorf("error decoding cache entry %s: %w", key, err)
+	}
+	for tag, version := range e.Tags {
+		current, err := c.tagVersion(tag)
+		if err != nil {
+			return nil, false, err
+		}
+		if current != version {
+			_ = c.storage.Delete(entryPrefix + key)
+			return nil, false, nil
+		}
+	}
+	return e.Value, true, nil
+}
+
+// tagVersion returns the current version of tag ("" until first invalidated)
+func (c *Cache) tagVersion(tag string) (string, error) {
+	version, err := c.storage.Get(tagPrefix + tag)
+	if err != nil {
+		return "", err
+	}
+	return string(version), nil
+}
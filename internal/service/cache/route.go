@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/degreane/octopus/internal/database"
+	"github.com/gofiber/storage/memory/v2"
+	"github.com/gofiber/storage/redis/v3"
+)
+
+// Route response caches, one per store ("memory", "redis"), kept apart from
+// the eocto.cache instance so their statistics are reported separately.
+var (
+	routeMutex  sync.Mutex
+	routeCaches = make(map[string]*Cache)
+)
+
+// RouteTag returns the tag attached to every response cached for path
+func RouteTag(path string) string {
+	return "route:" + path
+}
+
+// Route returns the response cache for store. An empty store follows the
+// backend of the application cache; "redis" falls back to memory when no Redis
+// client is connected.
+func Route(store string) *Cache {
+	if store == "" {
+		store = Get().backend
+	}
+	if store == "redis" && database.GetRedisClient() == nil {
+		store = "memory"
+	}
+
+	routeMutex.Lock()
+	defer routeMutex.Unlock()
+
+	if c, exists := routeCaches[store]; exists {
+		return c
+	}
+	var c *Cache
+	if store == "redis" {
+		c = New(redis.NewFromConnection(database.GetRedisClient()), store)
+	} else {
+		c = New(memory.New(), "memory")
+	}
+	routeCaches[c.backend] = c
+	return c
+}
+
+// PurgeRoute expires every cached response of path (a route pattern such as
+// "/users/:id" or a concrete request path such as "/users/42") in every store
+func PurgeRoute(path string) error {
+	// Make sure the shared store is purged even if this process has not served the route yet
+	Route("")
+
+	routeMutex.Lock()
+	caches := make([]*Cache, 0, len(routeCaches))
+	for _, c := range routeCaches {
+		caches = append(caches, c)
+	}
+	routeMutex.Unlock()
+
+	for _, c := range caches {
+		if err := c.InvalidateTags(RouteTag(path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RouteStats returns the statistics of every route response store in use
+func RouteStats() map[string]Stats {
+	routeMutex.Lock()
+	defer routeMutex.Unlock()
+
+	stats := make(map[string]Stats, len(routeCaches))
+	for store, c := range routeCaches {
+		stats[store] = c.Stats()
+	}
+	return stats
+}
@@ -0,0 +1,146 @@
+// Package capture records the requests served by module routes so they can
+// be replayed in the Lua console.
+//
+// Capturing is a development aid: it is off unless Enable is called, keeps
+// the most recent requests only, and mirrors them to a JSON file so the
+// `octopus repl` command, running in another process, can load them.
+package capture
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/degreane/octopus/config"
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultFile is where captured requests are mirrored, relative to the project root
+const DefaultFile = ".octopus/requests.json"
+
+// Limit is the number of requests kept
+const Limit = 50
+
+// maxBody is the largest request body kept
+const maxBody = 64 << 10
+
+// Request is a captured request
+type Request struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Module string    `json:"module"`
+	Method string    `json:"method"`
+	// Route is the path of the route as configured in modules.yaml
+	Route   string            `json:"route"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body,omitempty"`
+}
+
+var (
+	enabled  atomic.Bool
+	mutex    sync.Mutex
+	file     = DefaultFile
+	requests []Request
+)
+
+// Enable starts capturing and mirrors the captured requests to path (DefaultFile when empty)
+func Enable(path string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if path != "" {
+		file = path
+	}
+	enabled.Store(true)
+}
+
+// Enabled reports whether requests are captured
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Handler captures the requests of a module route before passing them on
+func Handler(module config.ModulesConfig, route config.Route) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		req := Request{
+			ID:      newID(),
+			Time:    time.Now(),
+			Module:  module.Name,
+			Method:  strings.Clone(c.Method()),
+			Route:   route.Path,
+			Path:    string(c.Request().RequestURI()),
+			Headers: make(map[string]string),
+		}
+		c.Request().Header.VisitAll(func(k, v []byte) {
+			req.Headers[string(k)] = string(v)
+		})
+		if body := c.Body(); len(body) <= maxBody {
+			req.Body = string(body)
+		}
+		add(req)
+		return c.Next()
+	}
+}
+
+func add(req Request) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	requests = append(requests, req)
+	if len(requests) > Limit {
+		requests = requests[len(requests)-Limit:]
+	}
+	if data, err := json.MarshalIndent(requests, "", "  "); err == nil {
+		os.MkdirAll(filepath.Dir(file), 0o755)
+		os.WriteFile(file, data, 0o600)
+	}
+}
+
+// List returns the captured requests, most recent last
+func List() []Request {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return append([]Request(nil), requests...)
+}
+
+// Load reads the requests mirrored to path by another process
+func Load(path string) ([]Request, error) {
+	if path == "" {
+		path = DefaultFile
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []Request
+	err = json.Unmarshal(data, &list)
+	return list, err
+}
+
+// Find returns the request with the given id, or a unique id prefix
+func Find(list []Request, id string) (Request, bool) {
+	var found []Request
+	for _, req := range list {
+		if req.ID == id {
+			return req, true
+		}
+		if len(id) > 0 && len(req.ID) > len(id) && req.ID[:len(id)] == id {
+			found = append(found, req)
+		}
+	}
+	if len(found) == 1 {
+		return found[0], true
+	}
+	return Request{}, false
+}
+
+func newID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,380 @@
+// Package coverage records which lines of module Lua scripts run.
+//
+// gopher-lua has no line hooks, so coverage instruments the scripts instead:
+// once enabled, every script compiled by scriptcache gets a call to a counter
+// inserted before each statement, and every state opened by the sandbox gets
+// the counter function. Statements are the unit of coverage; a statement
+// spanning several lines is counted on its first line.
+package coverage
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/service/scriptcache"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// counter is the global the instrumented scripts call with a file id and a line
+const counter = "__coverage"
+
+// file holds the executable lines of a script and their hits
+type file struct {
+	id    int
+	path  string
+	lines map[int]int64
+}
+
+// root is the views directory of a module
+type root struct {
+	dir    string
+	module string
+}
+
+var (
+	enabled atomic.Bool
+	mutex   sync.Mutex
+	files   []*file
+	byPath  = make(map[string]*file)
+	roots   []root
+)
+
+// Enable instruments the scripts compiled from now on; scripts compiled
+// before are recompiled on their next use
+func Enable() {
+	enabled.Store(true)
+	scriptcache.SetTransform(Instrument)
+}
+
+// Enabled reports whether coverage is recorded
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Install adds the counter function to L when coverage is enabled
+func Install(L *lua.LState) {
+	if enabled.Load() {
+		L.SetGlobal(counter, L.NewFunction(hit))
+	}
+}
+
+func hit(L *lua.LState) int {
+	id, line := L.CheckInt(1), L.CheckInt(2)
+	mutex.Lock()
+	if id < len(files) {
+		files[id].lines[line]++
+	}
+	mutex.Unlock()
+	return 0
+}
+
+// Reset forgets the recorded hits
+func Reset() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, f := range files {
+		for line := range f.lines {
+			f.lines[line] = 0
+		}
+	}
+}
+
+// register returns the file of path with its executable lines reset to lines
+func register(path string, lines []int) *file {
+	path = filepath.Clean(path)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	f, ok := byPath[path]
+	if !ok {
+		f = &file{id: len(files), path: path}
+		files = append(files, f)
+		byPath[path] = f
+	}
+	f.lines = make(map[int]int64, len(lines))
+	for _, line := range lines {
+		f.lines[line] = 0
+	}
+	return f
+}
+
+// Instrument inserts a counter call before every statement of chunk. It is
+// the scriptcache transform installed by Enable; a recompiled script starts
+// counting from zero since its lines may have moved.
+func Instrument(path string, chunk []ast.Stmt) []ast.Stmt {
+	var lines []int
+	collect(chunk, func(stmt ast.Stmt) { lines = append(lines, stmt.Line()) })
+	f := register(path, lines)
+	return instrument(chunk, f.id)
+}
+
+// Track records the executable lines of a script that may never run, so it
+// is reported as uncovered instead of missing
+func Track(path string) error {
+	mutex.Lock()
+	_, known := byPath[filepath.Clean(path)]
+	mutex.Unlock()
+	if known {
+		return nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	chunk, err := parse.Parse(bufio.NewReader(src), path)
+	if err != nil {
+		return err
+	}
+	var lines []int
+	collect(chunk, func(stmt ast.Stmt) { lines = append(lines, stmt.Line()) })
+	register(path, lines)
+	return nil
+}
+
+// TrackModules tracks the scripts and libraries of modules (spec files
+// excepted) and attributes files below a module's views directory to it
+func TrackModules(modules []config.ModulesConfig) {
+	for _, module := range modules {
+		if module.Name == "" && module.BasePath == "" {
+			continue
+		}
+		mutex.Lock()
+		roots = append(roots, root{dir: filepath.Clean(module.ViewsDir()), module: module.Name})
+		mutex.Unlock()
+		for _, dir := range []string{module.ScriptsDir(), module.LibDir()} {
+			filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+				if err != nil || d.IsDir() || !strings.HasSuffix(path, ".lua") || strings.HasSuffix(path, "_spec.lua") {
+					return nil
+				}
+				Track(path)
+				return nil
+			})
+		}
+	}
+}
+
+// moduleOf returns the module whose views directory holds path most closely
+func moduleOf(path string) string {
+	module, best := "shared", -1
+	for _, r := range roots {
+		if (path == r.dir || strings.HasPrefix(path, r.dir+string(filepath.Separator))) && len(r.dir) > best {
+			module, best = r.module, len(r.dir)
+		}
+	}
+	return module
+}
+
+// File is the coverage of one script
+type File struct {
+	Path   string
+	Module string
+	// Lines maps every executable line to its hits
+	Lines map[int]int64
+}
+
+// Counts returns the number of executed and executable lines
+func (f File) Counts() (hit, total int) {
+	for _, n := range f.Lines {
+		if n > 0 {
+			hit++
+		}
+	}
+	return hit, len(f.Lines)
+}
+
+// Snapshot returns the coverage of every known script, sorted by path
+func Snapshot() []File {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	list := make([]File, 0, len(files))
+	for _, f := range files {
+		lines := make(map[int]int64, len(f.lines))
+		for line, n := range f.lines {
+			lines[line] = n
+		}
+		list = append(list, File{Path: f.path, Module: moduleOf(f.path), Lines: lines})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// Summary is the coverage of a module
+type Summary struct {
+	Module  string  `json:"module"`
+	Files   int     `json:"files"`
+	Lines   int     `json:"lines"`
+	Hit     int     `json:"hit"`
+	Percent float64 `json:"percent"`
+}
+
+// Modules sums the coverage of files per module, sorted by module name
+func Modules(list []File) []Summary {
+	index := map[string]int{}
+	var summaries []Summary
+	for _, f := range list {
+		i, ok := index[f.Module]
+		if !ok {
+			i = len(summaries)
+			index[f.Module] = i
+			summaries = append(summaries, Summary{Module: f.Module})
+		}
+		hit, total := f.Counts()
+		summaries[i].Files++
+		summaries[i].Hit += hit
+		summaries[i].Lines += total
+	}
+	for i := range summaries {
+		summaries[i].Percent = percent(summaries[i].Hit, summaries[i].Lines)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Module < summaries[j].Module })
+	return summaries
+}
+
+// percent returns hit/total as a percentage; nothing to cover is full coverage
+func percent(hit, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(hit) * 100 / float64(total)
+}
+
+// collect calls fn for every statement of chunk, nested blocks and function
+// bodies included
+func collect(chunk []ast.Stmt, fn func(ast.Stmt)) {
+	for _, stmt := range chunk {
+		fn(stmt)
+		for _, block := range blocks(stmt) {
+			collect(*block, fn)
+		}
+		for _, body := range functions(stmt) {
+			collect(body.Stmts, fn)
+		}
+	}
+}
+
+// instrument returns chunk with a counter call before each statement
+func instrument(chunk []ast.Stmt, id int) []ast.Stmt {
+	out := make([]ast.Stmt, 0, 2*len(chunk))
+	for _, stmt := range chunk {
+		for _, block := range blocks(stmt) {
+			*block = instrument(*block, id)
+		}
+		for _, body := range functions(stmt) {
+			body.Stmts = instrument(body.Stmts, id)
+		}
+		out = append(out, counterCall(id, stmt.Line()), stmt)
+	}
+	return out
+}
+
+// counterCall builds `__coverage(id, line)` positioned at line, so errors
+// raised by the statement keep their line numbers
+func counterCall(id, line int) ast.Stmt {
+	fn := &ast.IdentExpr{Value: counter}
+	idArg := &ast.NumberExpr{Value: strconv.Itoa(id)}
+	lineArg := &ast.NumberExpr{Value: strconv.Itoa(line)}
+	call := &ast.FuncCallExpr{Func: fn, Args: []ast.Expr{idArg, lineArg}}
+	stmt := &ast.FuncCallStmt{Expr: call}
+	for _, node := range []ast.PositionHolder{fn, idArg, lineArg, call, stmt} {
+		node.SetLine(line)
+		node.SetLastLine(line)
+	}
+	return stmt
+}
+
+// blocks returns the statement lists nested directly in stmt
+func blocks(stmt ast.Stmt) []*[]ast.Stmt {
+	switch s := stmt.(type) {
+	case *ast.DoBlockStmt:
+		return []*[]ast.Stmt{&s.Stmts}
+	case *ast.WhileStmt:
+		return []*[]ast.Stmt{&s.Stmts}
+	case *ast.RepeatStmt:
+		return []*[]ast.Stmt{&s.Stmts}
+	case *ast.IfStmt:
+		return []*[]ast.Stmt{&s.Then, &s.Else}
+	case *ast.NumberForStmt:
+		return []*[]ast.Stmt{&s.Stmts}
+	case *ast.GenericForStmt:
+		return []*[]ast.Stmt{&s.Stmts}
+	}
+	return nil
+}
+
+// functions returns the function expressions found in the expressions of
+// stmt itself (not in its nested blocks)
+func functions(stmt ast.Stmt) []*ast.FunctionExpr {
+	var exprs []ast.Expr
+	switch s := stmt.(type) {
+	case *ast.AssignStmt:
+		exprs = append(append(exprs, s.Lhs...), s.Rhs...)
+	case *ast.LocalAssignStmt:
+		exprs = s.Exprs
+	case *ast.FuncCallStmt:
+		exprs = []ast.Expr{s.Expr}
+	case *ast.WhileStmt:
+		exprs = []ast.Expr{s.Condition}
+	case *ast.RepeatStmt:
+		exprs = []ast.Expr{s.Condition}
+	case *ast.IfStmt:
+		exprs = []ast.Expr{s.Condition}
+	case *ast.NumberForStmt:
+		exprs = []ast.Expr{s.Init, s.Limit, s.Step}
+	case *ast.GenericForStmt:
+		exprs = s.Exprs
+	case *ast.FuncDefStmt:
+		exprs = []ast.Expr{s.Func}
+	case *ast.ReturnStmt:
+		exprs = s.Exprs
+	}
+	var found []*ast.FunctionExpr
+	for _, expr := range exprs {
+		found = findFunctions(expr, found)
+	}
+	return found
+}
+
+func findFunctions(expr ast.Expr, found []*ast.FunctionExpr) []*ast.FunctionExpr {
+	switch e := expr.(type) {
+	case *ast.FunctionExpr:
+		return append(found, e)
+	case *ast.AttrGetExpr:
+		return findFunctions(e.Key, findFunctions(e.Object, found))
+	case *ast.TableExpr:
+		for _, field := range e.Fields {
+			found = findFunctions(field.Value, findFunctions(field.Key, found))
+		}
+	case *ast.FuncCallExpr:
+		found = findFunctions(e.Receiver, findFunctions(e.Func, found))
+		for _, arg := range e.Args {
+			found = findFunctions(arg, found)
+		}
+	case *ast.LogicalOpExpr:
+		return findFunctions(e.Rhs, findFunctions(e.Lhs, found))
+	case *ast.RelationalOpExpr:
+		return findFunctions(e.Rhs, findFunctions(e.Lhs, found))
+	case *ast.StringConcatOpExpr:
+		return findFunctions(e.Rhs, findFunctions(e.Lhs, found))
+	case *ast.ArithmeticOpExpr:
+		return findFunctions(e.Rhs, findFunctions(e.Lhs, found))
+	case *ast.UnaryMinusOpExpr:
+		return findFunctions(e.Expr, found)
+	case *ast.UnaryNotOpExpr:
+		return findFunctions(e.Expr, found)
+	case *ast.UnaryLenOpExpr:
+		return findFunctions(e.Expr, found)
+	}
+	return found
+}
@@ -0,0 +1,182 @@
+package coverage
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// WriteLCOV writes files in the lcov tracefile format
+func WriteLCOV(w io.Writer, list []File) error {
+	var b bytes.Buffer
+	for _, f := range list {
+		fmt.Fprintf(&b, "TN:%s\nSF:%s\n", f.Module, f.Path)
+		for _, line := range sortedLines(f) {
+			fmt.Fprintf(&b, "DA:%d,%d\n", line, f.Lines[line])
+		}
+		hit, total := f.Counts()
+		fmt.Fprintf(&b, "LF:%d\nLH:%d\nend_of_record\n", total, hit)
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// WriteFiles writes lcov.info and index.html to dir
+func WriteFiles(dir string, list []File) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, write := range map[string]func(io.Writer, []File) error{
+		"lcov.info":  WriteLCOV,
+		"index.html": WriteHTML,
+	} {
+		var b bytes.Buffer
+		if err := write(&b, list); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), b.Bytes(), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedLines(f File) []int {
+	lines := make([]int, 0, len(f.Lines))
+	for line := range f.Lines {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// htmlLine is a source line of the HTML report
+type htmlLine struct {
+	Number     int
+	Code       string
+	Executable bool
+	Hits       int64
+}
+
+// htmlFile is a script of the HTML report
+type htmlFile struct {
+	Path    string
+	Module  string
+	Anchor  string
+	Hit     int
+	Total   int
+	Percent float64
+	Lines   []htmlLine
+}
+
+// WriteHTML writes a self-contained HTML report: the coverage of every
+// module, then every script with its executed and missed lines
+func WriteHTML(w io.Writer, list []File) error {
+	var files []htmlFile
+	for i, f := range list {
+		hit, total := f.Counts()
+		hf := htmlFile{
+			Path:    f.Path,
+			Module:  f.Module,
+			Anchor:  fmt.Sprintf("file-%d", i),
+			Hit:     hit,
+			Total:   total,
+			Percent: percent(hit, total),
+		}
+		if src, err := os.Open(f.Path); err == nil {
+			scanner := bufio.NewScanner(src)
+			for n := 1; scanner.Scan(); n++ {
+				hits, executable := f.Lines[n]
+				hf.Lines = append(hf.Lines, htmlLine{Number: n, Code: scanner.Text(), Executable: executable, Hits: hits})
+			}
+			src.Close()
+		}
+		files = append(files, hf)
+	}
+	return htmlReport.Execute(w, map[string]interface{}{
+		"Modules": Modules(list),
+		"Files":   files,
+	})
+}
+
+var htmlReport = template.Must(template.New("coverage").Funcs(template.FuncMap{
+	"pct": func(p float64) string { return fmt.Sprintf("%.1f%%", p) },
+	"level": func(p float64) string {
+		switch {
+		case p >= 80:
+			return "high"
+		case p >= 50:
+			return "medium"
+		}
+		return "low"
+	},
+	"blank": func(s string) bool { return strings.TrimSpace(s) == "" },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Lua coverage</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem; color: #1e293b; }
+table { border-collapse: collapse; margin-bottom: 2rem; }
+th, td { padding: .25rem .75rem; text-align: left; border-bottom: 1px solid #e2e8f0; }
+.high { color: #15803d; } .medium { color: #b45309; } .low { color: #b91c1c; }
+.source { font-family: ui-monospace, monospace; font-size: .85rem; width: 100%; }
+.source td { border: none; padding: 0 .5rem; white-space: pre; }
+.source .num, .source .hits { color: #94a3b8; text-align: right; width: 1%; }
+tr.hit { background: #dcfce7; } tr.miss { background: #fee2e2; }
+</style>
+</head>
+<body>
+<h1>Lua coverage</h1>
+<table>
+<tr><th>Module</th><th>Files</th><th>Lines</th><th>Covered</th></tr>
+{{- range .Modules}}
+<tr><td>{{.Module}}</td><td>{{.Files}}</td><td>{{.Hit}}/{{.Lines}}</td><td class="{{level .Percent}}">{{pct .Percent}}</td></tr>
+{{- end}}
+</table>
+<table>
+<tr><th>Script</th><th>Module</th><th>Lines</th><th>Covered</th></tr>
+{{- range .Files}}
+<tr><td><a href="#{{.Anchor}}">{{.Path}}</a></td><td>{{.Module}}</td><td>{{.Hit}}/{{.Total}}</td><td class="{{level .Percent}}">{{pct .Percent}}</td></tr>
+{{- end}}
+</table>
+{{- range .Files}}
+<h2 id="{{.Anchor}}">{{.Path}} <span class="{{level .Percent}}">{{pct .Percent}}</span></h2>
+<table class="source">
+{{- range .Lines}}
+<tr{{if .Executable}} class="{{if .Hits}}hit{{else}}miss{{end}}"{{end}}><td class="num">{{.Number}}</td><td class="hits">{{if .Executable}}{{.Hits}}{{end}}</td><td>{{if blank .Code}} {{else}}{{.Code}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+// Handler serves the live report of a dev server: HTML by default,
+// lcov with ?format=lcov
+func Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var b bytes.Buffer
+		if c.Query("format") == "lcov" {
+			if err := WriteLCOV(&b, Snapshot()); err != nil {
+				return err
+			}
+			c.Type("txt")
+			return c.Send(b.Bytes())
+		}
+		if err := WriteHTML(&b, Snapshot()); err != nil {
+			return err
+		}
+		c.Type("html")
+		return c.Send(b.Bytes())
+	}
+}
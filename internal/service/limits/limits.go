@@ -0,0 +1,207 @@
+// Package limits bounds the execution of Lua scripts.
+//
+// A script run gets a wall-clock timeout and, optionally, an instruction
+// budget, both enforced through the state's context: gopher-lua checks
+// ctx.Done() before every VM instruction, so the budget is a context whose
+// Done channel closes once the allowed number of checks is spent. Call-stack
+// and registry sizes are fixed when a state is created and therefore apply
+// per module.
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/degreane/octopus/config"
+	"github.com/gofiber/fiber/v2"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// DefaultTimeout bounds scripts whose module and route set no timeout
+const DefaultTimeout = 5 * time.Second
+
+// ErrInstructionBudget is reported when a script runs out of instructions
+var ErrInstructionBudget = errors.New("instruction budget exhausted")
+
+// Kinds of exceeded limits
+const (
+	KindTimeout      = "timeout"
+	KindInstructions = "instructions"
+	KindCallStack    = "callStack"
+	KindRegistry     = "registry"
+)
+
+// Limits are the effective limits of a script
+type Limits struct {
+	Timeout      time.Duration
+	Instructions int64
+	CallStack    int
+	Registry     int
+}
+
+// Resolve merges route limits over module limits over the defaults.
+// CallStack and Registry only apply at module level.
+func Resolve(module, route *config.LuaLimits) Limits {
+	l := Limits{Timeout: DefaultTimeout}
+	for _, cfg := range []*config.LuaLimits{module, route} {
+		if cfg == nil {
+			continue
+		}
+		if cfg.Timeout > 0 {
+			l.Timeout = time.Duration(cfg.Timeout) * time.Millisecond
+		}
+		if cfg.Instructions > 0 {
+			l.Instructions = cfg.Instructions
+		}
+	}
+	if module != nil {
+		l.CallStack = module.CallStack
+		l.Registry = module.Registry
+	}
+	return l
+}
+
+// Options returns the state options enforcing the call-stack and registry limits
+func (l Limits) Options() lua.Options {
+	opts := lua.Options{SkipOpenLibs: true, CallStackSize: l.CallStack}
+	if l.Registry > 0 {
+		opts.RegistrySize = l.Registry
+		if l.Registry > lua.RegistrySize {
+			// Start at the default size and grow up to the limit
+			opts.RegistrySize = lua.RegistrySize
+			opts.RegistryMaxSize = l.Registry
+		}
+	}
+	return opts
+}
+
+// Error reports a script aborted because it exceeded a limit
+type Error struct {
+	Kind   string
+	Script string
+	Limit  string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Lua script %s exceeded its %s limit (%s): %v", e.Script, e.Kind, e.Limit, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status is the HTTP status answered for the exceeded limit:
+// 504 for timeouts, 503 for the other limits
+func (e *Error) Status() int {
+	if e.Kind == KindTimeout {
+		return fiber.StatusGatewayTimeout
+	}
+	return fiber.StatusServiceUnavailable
+}
+
+// budget is a context whose Done channel closes after a number of checks.
+// The contexts Inherit derives share the count of the script's context.
+type budget struct {
+	context.Context
+	remaining *atomic.Int64
+}
+
+var exhausted = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+func (b *budget) Done() <-chan struct{} {
+	if b.remaining.Add(-1) < 0 {
+		return exhausted
+	}
+	return b.Context.Done()
+}
+
+func (b *budget) Err() error {
+	if b.remaining.Load() < 0 {
+		return ErrInstructionBudget
+	}
+	return b.Context.Err()
+}
+
+// Inherit returns ctx for a Lua thread or callback started by a script
+// running on parent, the context of its state: when parent carries an
+// instruction budget, the instructions checked through the returned context
+// are taken from it. Deadlines are not inherited; derive ctx from parent, or
+// from Deadline(parent), for that.
+func Inherit(parent, ctx context.Context) context.Context {
+	if b, ok := parent.(*budget); ok {
+		return &budget{Context: ctx, remaining: b.remaining}
+	}
+	return ctx
+}
+
+// Unwrap returns the context under the instruction budget of ctx, or ctx when
+// it has none. I/O started by a script (Redis, HTTP, MongoDB) gets it: those
+// libraries check Done in their own loops, which must not spend the budget
+// meant for the instructions of the state.
+func Unwrap(ctx context.Context) context.Context {
+	if b, ok := ctx.(*budget); ok {
+		return b.Context
+	}
+	return ctx
+}
+
+// Deadline returns a context ending at the deadline of parent, if it has one,
+// and detached from its cancellation: parent may end before the returned
+// context is used, as the context of a script ends before its stream runs
+func Deadline(parent context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := parent.Deadline(); ok {
+		return context.WithDeadline(context.Background(), deadline)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// Run executes run with the limits applied to L and returns an *Error when a
+// limit was exceeded; other errors are returned unchanged
+func Run(parent context.Context, L *lua.LState, l Limits, script string, run func() error) error {
+	if parent == nil {
+		parent = context.Background()
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if l.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, l.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	defer cancel()
+
+	var b *budget
+	if l.Instructions > 0 {
+		b = &budget{Context: ctx, remaining: new(atomic.Int64)}
+		b.remaining.Store(l.Instructions)
+		L.SetContext(b)
+	} else {
+		L.SetContext(ctx)
+	}
+	err := run()
+	L.RemoveContext()
+	if err == nil {
+		return nil
+	}
+
+	switch {
+	case b != nil && b.remaining.Load() < 0:
+		return &Error{Kind: KindInstructions, Script: script, Limit: fmt.Sprintf("%d", l.Instructions), Err: err}
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return &Error{Kind: KindTimeout, Script: script, Limit: l.Timeout.String(), Err: err}
+	case strings.Contains(err.Error(), "stack overflow"):
+		return &Error{Kind: KindCallStack, Script: script, Limit: fmt.Sprintf("%d", L.Options.CallStackSize), Err: err}
+	case strings.Contains(err.Error(), "registry overflow"):
+		return &Error{Kind: KindRegistry, Script: script, Limit: fmt.Sprintf("%d", l.Registry), Err: err}
+	}
+	return err
+}
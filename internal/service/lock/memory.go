@@ -0,0 +1,95 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often the memory locker forgets expired locks
+const pruneInterval = time.Minute
+
+// MemoryLocker keeps locks in process memory. It is the default locker and
+// only excludes requests served by the same process (Prefork disabled).
+type MemoryLocker struct {
+	mutex sync.Mutex
+	held  map[string]memoryLease
+	// pruned is when the expired locks were last forgotten
+	pruned time.Time
+	// fence is shared by every lock name, so nothing is kept per name once
+	// its lock is released
+	fence int64
+}
+
+type memoryLease struct {
+	token   string
+	expires time.Time
+}
+
+// NewMemoryLocker creates an in-memory locker
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{
+		held: make(map[string]memoryLease),
+	}
+}
+
+// Name returns "memory"
+func (m *MemoryLocker) Name() string {
+	return "memory"
+}
+
+// TryAcquire takes the lock for ttl if it is free or expired
+func (m *MemoryLocker) TryAcquire(_ context.Context, name string, ttl time.Duration) (*Lease, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	if current, exists := m.held[name]; exists && now.Before(current.expires) {
+		return nil, false, nil
+	}
+	m.prune(now)
+	lease := &Lease{Name: name, Token: newToken()}
+	m.held[name] = memoryLease{token: lease.Token, expires: now.Add(ttl)}
+	m.fence++
+	lease.Fence = m.fence
+	return lease, true, nil
+}
+
+// prune forgets the expired locks their holders never released, which would
+// otherwise pile up for names that are not taken again. The caller holds
+// m.mutex.
+func (m *MemoryLocker) prune(now time.Time) {
+	if now.Before(m.pruned.Add(pruneInterval)) {
+		return
+	}
+	m.pruned = now
+	for name, lease := range m.held {
+		if !now.Before(lease.expires) {
+			delete(m.held, name)
+		}
+	}
+}
+
+// Extend sets the lock to expire ttl from now if lease still holds it
+func (m *MemoryLocker) Extend(_ context.Context, lease *Lease, ttl time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	current, exists := m.held[lease.Name]
+	if !exists || current.token != lease.Token || !time.Now().Before(current.expires) {
+		return false, nil
+	}
+	m.held[lease.Name] = memoryLease{token: lease.Token, expires: time.Now().Add(ttl)}
+	return true, nil
+}
+
+// Release frees the lock if lease still holds it
+func (m *MemoryLocker) Release(_ context.Context, lease *Lease) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if current, exists := m.held[lease.Name]; exists && current.token == lease.Token {
+		delete(m.held, lease.Name)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	rds "github.com/redis/go-redis/v9"
+)
+
+const (
+	redisLockPrefix = "eocto:lock:"
+	// redisFenceKey is the fencing counter shared by every lock name, so no
+	// key is left behind per name
+	redisFenceKey = "eocto:lock-fence"
+)
+
+// releaseScript deletes the lock only if it still holds the caller's token
+var releaseScript = rds.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// extendScript moves the expiry of the lock only if it still holds the caller's token
+var extendScript = rds.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// RedisLocker keeps locks in Redis so they exclude every process and node
+type RedisLocker struct {
+	client *rds.Client
+}
+
+// NewRedisLocker creates a locker on top of an initialized Redis client
+func NewRedisLocker(client *rds.Client) (*RedisLocker, error) {
+	if client == nil {
+		return nil, errors.New("redis client not initialized")
+	}
+	return &RedisLocker{client: client}, nil
+}
+
+// Name returns "redis"
+func (r *RedisLocker) Name() string {
+	return "redis"
+}
+
+// TryAcquire takes the lock for ttl with SET NX PX and draws a fencing token
+func (r *RedisLocker) TryAcquire(ctx context.Context, name string, ttl time.Duration) (*Lease, bool, error) {
+	lease := &Lease{Name: name, Token: newToken()}
+	ok, err := r.client.SetNX(ctx, redisLockPrefix+name, lease.Token, ttl).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	fence, err := r.client.Incr(ctx, redisFenceKey).Result()
+	if err != nil {
+		_ = r.Release(ctx, lease)
+		return nil, false, err
+	}
+	lease.Fence = fence
+	return lease, true, nil
+}
+
+// Release frees the lock if lease still holds it
+func (r *RedisLocker) Release(ctx context.Context, lease *Lease) error {
+	return releaseScript.Run(ctx, r.client, []string{redisLockPrefix + lease.Name}, lease.Token).Err()
+}
+
+// Extend sets the lock to expire ttl from now if lease still holds it
+func (r *RedisLocker) Extend(ctx context.Context, lease *Lease, ttl time.Duration) (bool, error) {
+	n, err := extendScript.Run(ctx, r.client, []string{redisLockPrefix + lease.Name}, lease.Token, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
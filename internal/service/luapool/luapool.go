@@ -0,0 +1,279 @@
+// Package luapool keeps pre-warmed Lua states for request handling.
+//
+// Building a state (standard library plus the eocto table) is far more
+// expensive than running most preCheck scripts, so every module owns a pool
+// of ready states. A state is bound to the request it serves on checkout;
+// when it is returned its stack, and every table reachable from its globals,
+// the eocto table, the loaded packages and the string metatable, are
+// restored to the snapshot taken after warm-up, so nothing leaks between
+// requests. States are closed once they reach their reuse limit or when the
+// pool already holds enough idle states.
+package luapool
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	// DefaultSize is the number of idle states kept per pool
+	DefaultSize = 8
+	// DefaultMaxReuse is the number of requests a state serves before it is closed
+	DefaultMaxReuse = 1000
+)
+
+// Config sizes a pool
+type Config struct {
+	// Size is the number of idle states kept (and pre-warmed) per module
+	Size int
+	// MaxReuse is the number of checkouts after which a state is closed
+	MaxReuse int
+}
+
+// State is a pooled Lua state and the request it is currently bound to.
+// Bindings installed by the pool's builder read Ctx at call time, so
+// rebinding a state to a new request only swaps Ctx.
+type State struct {
+	L   *lua.LState
+	Ctx *fiber.Ctx
+
+	uses     int
+	snapshot map[*lua.LTable]*tableSnapshot
+}
+
+// tableSnapshot is the pristine content and metatable of a table
+type tableSnapshot struct {
+	content   map[lua.LValue]lua.LValue
+	metatable lua.LValue
+}
+
+// Builder installs the standard library and bindings on a new state.
+// States are created without any library opened, so the builder decides
+// which parts of the standard library scripts get.
+type Builder func(st *State)
+
+// Pool hands out pre-warmed states for one module
+type Pool struct {
+	name  string
+	cfg   Config
+	opts  lua.Options
+	build Builder
+	idle  chan *State
+
+	created   atomic.Int64
+	checkouts atomic.Int64
+	reused    atomic.Int64
+	closed    atomic.Int64
+	inUse     atomic.Int64
+}
+
+// Stats is a snapshot of a pool's counters
+type Stats struct {
+	Size      int   `json:"size"`
+	MaxReuse  int   `json:"maxReuse"`
+	Idle      int   `json:"idle"`
+	InUse     int64 `json:"inUse"`
+	Created   int64 `json:"created"`
+	Checkouts int64 `json:"checkouts"`
+	Reused    int64 `json:"reused"`
+	Closed    int64 `json:"closed"`
+}
+
+var (
+	config   = Config{Size: DefaultSize, MaxReuse: DefaultMaxReuse}
+	registry = make(map[string]*Pool)
+	mutex    sync.Mutex
+
+	// states maps the globals of pooled states, which their threads share,
+	// to the states
+	states sync.Map
+)
+
+// Configure sets the size and reuse limit of the pools created afterwards;
+// zero values keep the defaults
+func Configure(cfg Config) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if cfg.Size > 0 {
+		config.Size = cfg.Size
+	}
+	if cfg.MaxReuse > 0 {
+		config.MaxReuse = cfg.MaxReuse
+	}
+}
+
+// For returns the pool registered under name, creating and pre-warming it
+// with build on first use. States are created with opts; the standard library
+// is never opened by the pool.
+func For(name string, opts lua.Options, build Builder) *Pool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if p, exists := registry[name]; exists {
+		return p
+	}
+	p := &Pool{
+		name:  name,
+		cfg:   config,
+		opts:  opts,
+		build: build,
+		idle:  make(chan *State, config.Size),
+	}
+	for i := 0; i < p.cfg.Size; i++ {
+		p.idle <- p.newState()
+	}
+	registry[name] = p
+	return p
+}
+
+// AllStats returns the statistics of every pool, keyed by pool name
+func AllStats() map[string]Stats {
+	mutex.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pools := make([]*Pool, len(names))
+	for i, name := range names {
+		pools[i] = registry[name]
+	}
+	mutex.Unlock()
+
+	stats := make(map[string]Stats, len(pools))
+	for i, p := range pools {
+		stats[names[i]] = p.Stats()
+	}
+	return stats
+}
+
+// Get checks out a state bound to c, building a new one if none is idle
+func (p *Pool) Get(c *fiber.Ctx) *State {
+	p.checkouts.Add(1)
+	p.inUse.Add(1)
+
+	var st *State
+	select {
+	case st = <-p.idle:
+		p.reused.Add(1)
+	default:
+		st = p.newState()
+	}
+	st.uses++
+	st.Ctx = c
+	return st
+}
+
+// Put returns a state to the pool, resetting it, or closes it when it reached
+// its reuse limit or the pool is full
+func (p *Pool) Put(st *State) {
+	p.inUse.Add(-1)
+	st.Ctx = nil
+
+	if st.uses >= p.cfg.MaxReuse {
+		p.close(st)
+		return
+	}
+	st.reset()
+	select {
+	case p.idle <- st:
+	default:
+		p.close(st)
+	}
+}
+
+// Stats returns a snapshot of the pool counters
+func (p *Pool) Stats() Stats {
+	return Stats{
+		Size:      p.cfg.Size,
+		MaxReuse:  p.cfg.MaxReuse,
+		Idle:      len(p.idle),
+		InUse:     p.inUse.Load(),
+		Created:   p.created.Load(),
+		Checkouts: p.checkouts.Load(),
+		Reused:    p.reused.Load(),
+		Closed:    p.closed.Load(),
+	}
+}
+
+// newState builds a state and snapshots its pristine globals
+func (p *Pool) newState() *State {
+	opts := p.opts
+	opts.SkipOpenLibs = true
+	st := &State{L: lua.NewState(opts)}
+	p.build(st)
+	st.snapshot = make(map[*lua.LTable]*tableSnapshot)
+	st.remember(st.L.G.Global)
+	st.remember(st.L.GetGlobal("eocto"))
+	st.remember(st.L.GetField(st.L.Get(lua.RegistryIndex), "_LOADED"))
+	// getmetatable("") reaches the string metatable without going through _G
+	st.remember(st.L.GetMetatable(lua.LString("")))
+	states.Store(st.L.G, st)
+	p.created.Add(1)
+	return st
+}
+
+func (p *Pool) close(st *State) {
+	states.Delete(st.L.G)
+	st.L.Close()
+	p.closed.Add(1)
+}
+
+// Track adds v, when it is a table, and the tables reachable from it to the
+// pristine state of the pooled state L belongs to, as they are now: every
+// reset restores them. Values cached outside the globals across requests,
+// such as the libraries require keeps, are tracked once built. It does
+// nothing for states that are not pooled.
+func Track(L *lua.LState, v lua.LValue) {
+	if st, ok := states.Load(L.G); ok {
+		st.(*State).remember(v)
+	}
+}
+
+// remember records the current content and metatable of v, when it is a
+// table, and of every table reachable from it so reset can restore them
+func (st *State) remember(v lua.LValue) {
+	tbl, ok := v.(*lua.LTable)
+	if !ok {
+		return
+	}
+	if _, seen := st.snapshot[tbl]; seen {
+		return
+	}
+	snap := &tableSnapshot{content: make(map[lua.LValue]lua.LValue), metatable: tbl.Metatable}
+	st.snapshot[tbl] = snap
+	tbl.ForEach(func(k, v lua.LValue) {
+		snap.content[k] = v
+	})
+	for _, v := range snap.content {
+		st.remember(v)
+	}
+	st.remember(tbl.Metatable)
+}
+
+// reset clears the stack and restores every remembered table, dropping keys
+// added by scripts, restoring values they replaced and their metatables
+func (st *State) reset() {
+	st.L.SetTop(0)
+	st.L.RemoveContext()
+	for tbl, snap := range st.snapshot {
+		var added []lua.LValue
+		tbl.ForEach(func(k, _ lua.LValue) {
+			if _, exists := snap.content[k]; !exists {
+				added = append(added, k)
+			}
+		})
+		for _, k := range added {
+			tbl.RawSet(k, lua.LNil)
+		}
+		for k, v := range snap.content {
+			tbl.RawSet(k, v)
+		}
+		tbl.Metatable = snap.metatable
+	}
+}
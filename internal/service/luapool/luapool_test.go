@@ -0,0 +1,75 @@
+package luapool
+
+import (
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// TestResetRestoresNestedTables checks that changes a script makes below the
+// globals do not survive a checkout
+func TestResetRestoresNestedTables(t *testing.T) {
+	Configure(Config{Size: 1})
+	pool := For(t.Name(), lua.Options{}, func(st *State) {
+		st.L.OpenLibs()
+		eocto := st.L.NewTable()
+		redis := st.L.NewTable()
+		redis.RawSetString("get", st.L.NewFunction(func(L *lua.LState) int {
+			L.Push(lua.LString("pristine"))
+			return 1
+		}))
+		eocto.RawSetString("redis", redis)
+		st.L.SetGlobal("eocto", eocto)
+	})
+
+	st := pool.Get(nil)
+	if err := st.L.DoString(`
+		string.foo = "leak"
+		table.insert = nil
+		math.random = function() return 4 end
+		eocto.redis.get = function() return "leak" end
+		eocto.redis.extra = {}
+		setmetatable(os, {__index = function() return "leak" end})
+		getmetatable("").__index = {upper = function() return "leak" end}
+		lib = {count = 1}
+	`); err != nil {
+		t.Fatal(err)
+	}
+	tracked := st.L.NewTable()
+	tracked.RawSetString("count", lua.LNumber(0))
+	Track(st.L, tracked)
+	st.L.SetGlobal("tracked", tracked)
+	if err := st.L.DoString(`tracked.count = tracked.count + 1`); err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(st)
+
+	again := pool.Get(nil)
+	defer pool.Put(again)
+	if again != st {
+		t.Fatal("the pool did not hand the state out again")
+	}
+	if tracked.RawGetString("count") != lua.LNumber(0) {
+		t.Errorf("tracked table kept count = %v", tracked.RawGetString("count"))
+	}
+	checks := map[string]string{
+		"string.foo":               `return string.foo == nil`,
+		"table.insert":             `return type(table.insert) == "function"`,
+		"math.random":              `return math.random(10, 10) == 10`,
+		"eocto.redis.get":          `return eocto.redis.get() == "pristine"`,
+		"eocto.redis.extra":        `return eocto.redis.extra == nil`,
+		"metatable of os":          `return getmetatable(os) == nil`,
+		"string metatable __index": `return ("a"):upper() == "A"`,
+		"global lib":               `return lib == nil`,
+	}
+	for name, code := range checks {
+		if err := again.L.DoString(code); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if ok := again.L.Get(-1); ok != lua.LTrue {
+			t.Errorf("%s survived the checkout", name)
+		}
+		again.L.Pop(1)
+	}
+}
@@ -0,0 +1,61 @@
+// Package metrics collects application level statistics (cache hit ratios,
+// pool usage, ...) and serves them next to the Fiber monitor under /metrics.
+package metrics
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Collector returns a JSON serializable snapshot of a component's statistics
+type Collector func() interface{}
+
+var (
+	mutex      sync.RWMutex
+	collectors = make(map[string]Collector)
+)
+
+// Register adds (or replaces) the collector published under name
+func Register(name string, collector Collector) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	collectors[name] = collector
+}
+
+// Snapshot returns the current statistics of every registered collector
+func Snapshot() map[string]interface{} {
+	mutex.RLock()
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	mutex.RUnlock()
+	sort.Strings(names)
+
+	snapshot := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		mutex.RLock()
+		collector := collectors[name]
+		mutex.RUnlock()
+		snapshot[name] = collector()
+	}
+	return snapshot
+}
+
+// Handler serves the snapshot as JSON; the optional ?name= query limits it to one collector
+func Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		snapshot := Snapshot()
+		if name := c.Query("name"); name != "" {
+			stats, exists := snapshot[name]
+			if !exists {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "unknown metrics collector " + name})
+			}
+			return c.JSON(stats)
+		}
+		return c.JSON(snapshot)
+	}
+}
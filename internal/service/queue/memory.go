@@ -0,0 +1,224 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryBackend keeps the queues in process memory. It is the default
+// backend, meant for development and tests: messages are lost on restart and
+// only reach the workers of the process that enqueued them.
+type MemoryBackend struct {
+	mutex  sync.Mutex
+	queues map[string]*memoryQueue
+}
+
+type memoryQueue struct {
+	messages map[string]*Message
+	// pending holds the due time of the waiting messages, inflight the
+	// claims of the claimed ones
+	pending  map[string]time.Time
+	inflight map[string]memoryClaim
+	// dead is newest first
+	dead []Message
+}
+
+// memoryClaim is the current claim of a message
+type memoryClaim struct {
+	token    string
+	deadline time.Time
+}
+
+// owns reports whether msg holds the current claim of its message
+func (q *memoryQueue) owns(msg *Message) bool {
+	claim, ok := q.inflight[msg.ID]
+	return ok && claim.token == msg.Claim
+}
+
+// NewMemoryBackend creates an in-memory backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{queues: make(map[string]*memoryQueue)}
+}
+
+// Name returns "memory"
+func (m *MemoryBackend) Name() string {
+	return "memory"
+}
+
+// queue returns the named queue, creating it; the caller holds the mutex
+func (m *MemoryBackend) queue(name string) *memoryQueue {
+	q, ok := m.queues[name]
+	if !ok {
+		q = &memoryQueue{
+			messages: make(map[string]*Message),
+			pending:  make(map[string]time.Time),
+			inflight: make(map[string]memoryClaim),
+		}
+		m.queues[name] = q
+	}
+	return q
+}
+
+// Enqueue adds msg, due after delay
+func (m *MemoryBackend) Enqueue(_ context.Context, msg *Message, delay time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q := m.queue(msg.Queue)
+	stored := *msg
+	q.messages[msg.ID] = &stored
+	q.pending[msg.ID] = time.Now().Add(delay)
+	return nil
+}
+
+// Claim takes the next due message of queue and hides it for visibility
+func (m *MemoryBackend) Claim(_ context.Context, queue string, visibility time.Duration) (*Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q := m.queue(queue)
+	now := time.Now()
+	for id, claim := range q.inflight {
+		if !claim.deadline.After(now) {
+			delete(q.inflight, id)
+			q.pending[id] = now
+		}
+	}
+
+	var next string
+	var due time.Time
+	for id, at := range q.pending {
+		if at.After(now) {
+			continue
+		}
+		if next == "" || at.Before(due) || (at.Equal(due) && q.messages[id].Enqueued.Before(q.messages[next].Enqueued)) {
+			next, due = id, at
+		}
+	}
+	if next == "" {
+		return nil, nil
+	}
+	delete(q.pending, next)
+	token := newID()
+	q.inflight[next] = memoryClaim{token: token, deadline: now.Add(visibility)}
+	msg := q.messages[next]
+	msg.Attempts++
+	claimed := *msg
+	claimed.Claim = token
+	return &claimed, nil
+}
+
+// Extend keeps a claimed message hidden for visibility from now
+func (m *MemoryBackend) Extend(_ context.Context, msg *Message, visibility time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q := m.queue(msg.Queue)
+	if !q.owns(msg) {
+		return ErrClaimLost
+	}
+	q.inflight[msg.ID] = memoryClaim{token: msg.Claim, deadline: time.Now().Add(visibility)}
+	return nil
+}
+
+// Ack removes a processed message
+func (m *MemoryBackend) Ack(_ context.Context, msg *Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q := m.queue(msg.Queue)
+	if !q.owns(msg) {
+		return ErrClaimLost
+	}
+	delete(q.inflight, msg.ID)
+	delete(q.pending, msg.ID)
+	delete(q.messages, msg.ID)
+	return nil
+}
+
+// Retry makes a claimed message due again after delay
+func (m *MemoryBackend) Retry(_ context.Context, msg *Message, delay time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q := m.queue(msg.Queue)
+	if !q.owns(msg) {
+		return ErrClaimLost
+	}
+	stored := *msg
+	stored.Claim = ""
+	q.messages[msg.ID] = &stored
+	delete(q.inflight, msg.ID)
+	q.pending[msg.ID] = time.Now().Add(delay)
+	return nil
+}
+
+// Bury moves a claimed message to the dead letters of its queue
+func (m *MemoryBackend) Bury(_ context.Context, msg *Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q := m.queue(msg.Queue)
+	if !q.owns(msg) {
+		return ErrClaimLost
+	}
+	delete(q.inflight, msg.ID)
+	delete(q.pending, msg.ID)
+	delete(q.messages, msg.ID)
+	dead := *msg
+	dead.Claim = ""
+	q.dead = append([]Message{dead}, q.dead...)
+	if len(q.dead) > deadLimit {
+		q.dead = q.dead[:deadLimit]
+	}
+	return nil
+}
+
+// Dead returns the last dead letters of queue, newest first
+func (m *MemoryBackend) Dead(_ context.Context, queue string, limit int) ([]Message, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	dead := m.queue(queue).dead
+	if limit > 0 && len(dead) > limit {
+		dead = dead[:limit]
+	}
+	return append([]Message(nil), dead...), nil
+}
+
+// Requeue makes the dead letters of queue due again
+func (m *MemoryBackend) Requeue(_ context.Context, queue string) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q := m.queue(queue)
+	now := time.Now()
+	for _, msg := range q.dead {
+		msg.Attempts = 0
+		stored := msg
+		q.messages[msg.ID] = &stored
+		q.pending[msg.ID] = now
+	}
+	n := len(q.dead)
+	q.dead = nil
+	return n, nil
+}
+
+// Stats counts the messages of queue
+func (m *MemoryBackend) Stats(_ context.Context, queue string) (Stats, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q := m.queue(queue)
+	now := time.Now()
+	stats := Stats{InFlight: int64(len(q.inflight)), Dead: int64(len(q.dead))}
+	for _, at := range q.pending {
+		if at.After(now) {
+			stats.Delayed++
+		} else {
+			stats.Ready++
+		}
+	}
+	return stats, nil
+}
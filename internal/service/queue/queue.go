@@ -0,0 +1,122 @@
+// Package queue stores the messages of the background queues filled by
+// eocto.queue.enqueue and processed by the workers modules declare.
+//
+// Delivery is at least once: a worker claims a message, which stays hidden
+// from the other workers for the visibility timeout while the worker extends
+// it, and acknowledges it once processed. A message that is neither
+// acknowledged nor retried in time, because its worker died or stalled,
+// becomes visible again; the claim of the first worker is then lost, and its
+// late Ack, Retry or Bury fails with ErrClaimLost instead of settling the
+// message another worker owns.
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrClaimLost is returned when settling or extending a message whose
+// visibility expired and which may since have been claimed again
+var ErrClaimLost = errors.New("queue: claim lost, the message became visible again")
+
+// Message is a job waiting in a queue
+type Message struct {
+	ID    string `json:"id"`
+	Queue string `json:"queue"`
+	// Payload is the JSON encoded value handed to the worker
+	Payload json.RawMessage `json:"payload"`
+	// Retries is the number of retries after a failure; negative uses the worker's default
+	Retries int `json:"retries"`
+	// Attempts counts the times the message was claimed, this one included
+	Attempts int       `json:"attempts"`
+	Enqueued time.Time `json:"enqueued"`
+	// Error is the failure of the last attempt
+	Error string `json:"error,omitempty"`
+	// Claim is the token of the claim that returned the message; it is never stored
+	Claim string `json:"-"`
+}
+
+// Stats counts the messages of a queue
+type Stats struct {
+	// Ready messages are due and waiting for a worker
+	Ready int64 `json:"ready"`
+	// Delayed messages wait for their delay or backoff
+	Delayed  int64 `json:"delayed"`
+	InFlight int64 `json:"inFlight"`
+	Dead     int64 `json:"dead"`
+}
+
+// Backend stores the queues. Extend, Ack, Retry and Bury return ErrClaimLost,
+// and leave the message alone, when the claim of msg is no longer the current
+// one.
+type Backend interface {
+	// Enqueue adds msg, due after delay
+	Enqueue(ctx context.Context, msg *Message, delay time.Duration) error
+	// Claim takes the next due message of queue and hides it for visibility;
+	// it returns nil when no message is due
+	Claim(ctx context.Context, queue string, visibility time.Duration) (*Message, error)
+	// Extend keeps a claimed message hidden for visibility from now
+	Extend(ctx context.Context, msg *Message, visibility time.Duration) error
+	// Ack removes a processed message
+	Ack(ctx context.Context, msg *Message) error
+	// Retry makes a claimed message due again after delay
+	Retry(ctx context.Context, msg *Message, delay time.Duration) error
+	// Bury moves a claimed message to the dead letters of its queue
+	Bury(ctx context.Context, msg *Message) error
+	// Dead returns the last dead letters of queue, newest first
+	Dead(ctx context.Context, queue string, limit int) ([]Message, error)
+	// Requeue makes the dead letters of queue due again and returns how many there were
+	Requeue(ctx context.Context, queue string) (int, error)
+	// Stats counts the messages of queue
+	Stats(ctx context.Context, queue string) (Stats, error)
+	// Name returns the backend name ("memory", "redis")
+	Name() string
+}
+
+// deadLimit is how many dead letters are kept per queue
+const deadLimit = 1000
+
+var (
+	instance Backend = NewMemoryBackend()
+	mutex    sync.RWMutex
+)
+
+// Init sets the application wide backend
+func Init(backend Backend) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	instance = backend
+}
+
+// Get returns the application wide backend
+func Get() Backend {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return instance
+}
+
+// Enqueue adds payload to queue on the application wide backend and returns
+// the message id. A negative retries leaves the count to the worker.
+func Enqueue(ctx context.Context, queue string, payload json.RawMessage, delay time.Duration, retries int) (string, error) {
+	msg := &Message{ID: newID(), Queue: queue, Payload: payload, Retries: retries, Enqueued: time.Now()}
+	if err := Get().Enqueue(ctx, msg, delay); err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
+
+// newID returns a random message id
+func newID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
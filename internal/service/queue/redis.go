@@ -0,0 +1,281 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	rds "github.com/redis/go-redis/v9"
+)
+
+// redisQueuePrefix namespaces the keys of a queue: a hash of the messages, a
+// hash of their attempts, sorted sets of the pending (by due time) and
+// claimed (by visibility deadline) ids, a hash of the claim tokens and a list
+// of dead letters
+const redisQueuePrefix = "eocto:queue:"
+
+// claimScript makes the claimed messages whose visibility expired due again,
+// dropping their claims, then moves the first due message to the claimed set
+// under the claim token ARGV[3] and counts the attempt
+var claimScript = rds.NewScript(`
+local now = tonumber(ARGV[1])
+for _, id in ipairs(redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", now)) do
+	redis.call("ZREM", KEYS[2], id)
+	redis.call("HDEL", KEYS[5], id)
+	redis.call("ZADD", KEYS[1], now, id)
+end
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", now, "LIMIT", 0, 1)
+if #ids == 0 then
+	return false
+end
+local id = ids[1]
+redis.call("ZREM", KEYS[1], id)
+local data = redis.call("HGET", KEYS[3], id)
+if not data then
+	redis.call("HDEL", KEYS[4], id)
+	return false
+end
+redis.call("ZADD", KEYS[2], now + tonumber(ARGV[2]), id)
+redis.call("HSET", KEYS[5], id, ARGV[3])
+local attempts = redis.call("HINCRBY", KEYS[4], id, 1)
+return {data, attempts}`)
+
+// The settle scripts run when the claim token of message ARGV[1] in the
+// claims hash KEYS[1] is ARGV[2], and return 0 otherwise.
+
+// extendScript moves the visibility deadline of the message in the claimed
+// set KEYS[2] to ARGV[3]
+var extendScript = rds.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[1])
+return 1`)
+
+// ackScript removes the message from the claimed set KEYS[2], the messages
+// KEYS[3] and the attempts KEYS[4]
+var ackScript = rds.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call("HDEL", KEYS[1], ARGV[1])
+redis.call("ZREM", KEYS[2], ARGV[1])
+redis.call("HDEL", KEYS[3], ARGV[1])
+redis.call("HDEL", KEYS[4], ARGV[1])
+return 1`)
+
+// retryScript stores the message ARGV[3] in KEYS[3] and moves it from the
+// claimed set KEYS[2] to the pending set KEYS[4], due at ARGV[4]
+var retryScript = rds.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call("HDEL", KEYS[1], ARGV[1])
+redis.call("ZREM", KEYS[2], ARGV[1])
+redis.call("HSET", KEYS[3], ARGV[1], ARGV[3])
+redis.call("ZADD", KEYS[4], ARGV[4], ARGV[1])
+return 1`)
+
+// buryScript removes the message from the claimed set KEYS[2], the messages
+// KEYS[3] and the attempts KEYS[4], and pushes ARGV[3] to the dead letters
+// KEYS[5], keeping the last ARGV[4]
+var buryScript = rds.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call("HDEL", KEYS[1], ARGV[1])
+redis.call("ZREM", KEYS[2], ARGV[1])
+redis.call("HDEL", KEYS[3], ARGV[1])
+redis.call("HDEL", KEYS[4], ARGV[1])
+redis.call("LPUSH", KEYS[5], ARGV[3])
+redis.call("LTRIM", KEYS[5], 0, tonumber(ARGV[4]) - 1)
+return 1`)
+
+// RedisBackend keeps the queues in Redis, shared by every process and node
+type RedisBackend struct {
+	client *rds.Client
+}
+
+// NewRedisBackend creates a backend on top of an initialized Redis client
+func NewRedisBackend(client *rds.Client) (*RedisBackend, error) {
+	if client == nil {
+		return nil, errors.New("redis client not initialized")
+	}
+	return &RedisBackend{client: client}, nil
+}
+
+// Name returns "redis"
+func (r *RedisBackend) Name() string {
+	return "redis"
+}
+
+// redisKeys are the keys of a queue
+type redisKeys struct {
+	messages, attempts, pending, inflight, claims, dead string
+}
+
+func keysOf(queue string) redisKeys {
+	base := redisQueuePrefix + queue
+	return redisKeys{
+		messages: base + ":messages",
+		attempts: base + ":attempts",
+		pending:  base + ":pending",
+		inflight: base + ":inflight",
+		claims:   base + ":claims",
+		dead:     base + ":dead",
+	}
+}
+
+func millis(t time.Time) float64 {
+	return float64(t.UnixMilli())
+}
+
+// Enqueue adds msg, due after delay
+func (r *RedisBackend) Enqueue(ctx context.Context, msg *Message, delay time.Duration) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	keys := keysOf(msg.Queue)
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, keys.messages, msg.ID, data)
+	pipe.ZAdd(ctx, keys.pending, rds.Z{Score: millis(time.Now().Add(delay)), Member: msg.ID})
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Claim takes the next due message of queue and hides it for visibility
+func (r *RedisBackend) Claim(ctx context.Context, queue string, visibility time.Duration) (*Message, error) {
+	keys := keysOf(queue)
+	token := newID()
+	res, err := claimScript.Run(ctx, r.client,
+		[]string{keys.pending, keys.inflight, keys.messages, keys.attempts, keys.claims},
+		time.Now().UnixMilli(), visibility.Milliseconds(), token).Slice()
+	if errors.Is(err, rds.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 2 {
+		return nil, errors.New("queue: unexpected claim reply")
+	}
+	data, _ := res[0].(string)
+	var msg Message
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		return nil, err
+	}
+	attempts, _ := res[1].(int64)
+	msg.Attempts = int(attempts)
+	msg.Claim = token
+	return &msg, nil
+}
+
+// settle runs one of the settle scripts and returns ErrClaimLost when the
+// claim of msg is no longer the current one
+func (r *RedisBackend) settle(ctx context.Context, script *rds.Script, msg *Message, keys []string, args ...interface{}) error {
+	settled, err := script.Run(ctx, r.client, keys, append([]interface{}{msg.ID, msg.Claim}, args...)...).Int()
+	if err != nil {
+		return err
+	}
+	if settled == 0 {
+		return ErrClaimLost
+	}
+	return nil
+}
+
+// Extend keeps a claimed message hidden for visibility from now
+func (r *RedisBackend) Extend(ctx context.Context, msg *Message, visibility time.Duration) error {
+	keys := keysOf(msg.Queue)
+	return r.settle(ctx, extendScript, msg, []string{keys.claims, keys.inflight},
+		time.Now().Add(visibility).UnixMilli())
+}
+
+// Ack removes a processed message
+func (r *RedisBackend) Ack(ctx context.Context, msg *Message) error {
+	keys := keysOf(msg.Queue)
+	return r.settle(ctx, ackScript, msg, []string{keys.claims, keys.inflight, keys.messages, keys.attempts})
+}
+
+// Retry makes a claimed message due again after delay
+func (r *RedisBackend) Retry(ctx context.Context, msg *Message, delay time.Duration) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	keys := keysOf(msg.Queue)
+	return r.settle(ctx, retryScript, msg, []string{keys.claims, keys.inflight, keys.messages, keys.pending},
+		data, time.Now().Add(delay).UnixMilli())
+}
+
+// Bury moves a claimed message to the dead letters of its queue
+func (r *RedisBackend) Bury(ctx context.Context, msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	keys := keysOf(msg.Queue)
+	return r.settle(ctx, buryScript, msg, []string{keys.claims, keys.inflight, keys.messages, keys.attempts, keys.dead},
+		data, deadLimit)
+}
+
+// Dead returns the last dead letters of queue, newest first
+func (r *RedisBackend) Dead(ctx context.Context, queue string, limit int) ([]Message, error) {
+	if limit <= 0 {
+		limit = deadLimit
+	}
+	entries, err := r.client.LRange(ctx, keysOf(queue).dead, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+	dead := make([]Message, 0, len(entries))
+	for _, entry := range entries {
+		var msg Message
+		if json.Unmarshal([]byte(entry), &msg) == nil {
+			dead = append(dead, msg)
+		}
+	}
+	return dead, nil
+}
+
+// Requeue makes the dead letters of queue due again. Letters are popped one
+// by one, so a message buried meanwhile is never lost.
+func (r *RedisBackend) Requeue(ctx context.Context, queue string) (int, error) {
+	keys := keysOf(queue)
+	n := 0
+	for {
+		data, err := r.client.RPop(ctx, keys.dead).Result()
+		if errors.Is(err, rds.Nil) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		var msg Message
+		if json.Unmarshal([]byte(data), &msg) != nil {
+			continue
+		}
+		msg.Attempts = 0
+		if err := r.Enqueue(ctx, &msg, 0); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// Stats counts the messages of queue
+func (r *RedisBackend) Stats(ctx context.Context, queue string) (Stats, error) {
+	keys := keysOf(queue)
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	pipe := r.client.Pipeline()
+	ready := pipe.ZCount(ctx, keys.pending, "-inf", now)
+	delayed := pipe.ZCount(ctx, keys.pending, "("+now, "+inf")
+	inflight := pipe.ZCard(ctx, keys.inflight)
+	dead := pipe.LLen(ctx, keys.dead)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Stats{}, err
+	}
+	return Stats{Ready: ready.Val(), Delayed: delayed.Val(), InFlight: inflight.Val(), Dead: dead.Val()}, nil
+}
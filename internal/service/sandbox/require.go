@@ -0,0 +1,219 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/degreane/octopus/internal/service/luapool"
+	"github.com/degreane/octopus/internal/service/scriptcache"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// library is a Lua module loaded by require from one of the policy roots
+type library struct {
+	path  string
+	proto *lua.FunctionProto
+	value lua.LValue
+	// deps are the libraries required while this one was loading
+	deps []string
+}
+
+// loader resolves require calls of one state and keeps the libraries it
+// loaded. The cache lives outside package.loaded, so it survives the reset of
+// a pooled state: a library runs once per state and again only when its file,
+// or the file of a library it required, changes. The tables of a library are
+// tracked as the pooled state's pristine state once it loaded, so changes a
+// request makes to them are undone with the rest of the state.
+type loader struct {
+	policy  Policy
+	libs    map[string]*library
+	loading []string
+}
+
+// confineRequire replaces require with one that resolves dotted names below
+// the policy roots, in order, and caches the loaded libraries
+func confineRequire(L *lua.LState, policy Policy) {
+	pkg := L.GetGlobal(lua.LoadLibName).(*lua.LTable)
+	pkg.RawSetString("path", lua.LString(""))
+	pkg.RawSetString("cpath", lua.LString(""))
+
+	ld := &loader{policy: policy, libs: make(map[string]*library)}
+	// The stock require still serves the standard libraries and
+	// package.preload; its file loader only reports where it looked
+	if loaders, ok := L.GetField(L.Get(lua.RegistryIndex), "_LOADERS").(*lua.LTable); ok {
+		loaders.RawSetInt(2, L.NewFunction(func(L *lua.LState) int {
+			_, tried := ld.resolve(L.CheckString(1))
+			L.Push(lua.LString(strings.Join(tried, "\n\t")))
+			return 1
+		}))
+	}
+	stock := L.GetGlobal("require")
+	L.SetGlobal("require", L.NewFunction(func(L *lua.LState) int {
+		return ld.require(L, stock)
+	}))
+}
+
+// require returns the cached library, (re)loads it from the roots, or falls
+// back to the stock require
+func (ld *loader) require(L *lua.LState, stock lua.LValue) int {
+	name := L.CheckString(1)
+	for _, loading := range ld.loading {
+		if loading == name {
+			L.RaiseError("require %q: loop while loading %s", name, strings.Join(ld.loading, " -> "))
+		}
+	}
+	ld.depend(name)
+
+	if lib, cached := ld.libs[name]; cached {
+		if ld.fresh(name, make(map[string]bool)) {
+			L.Push(lib.value)
+			return 1
+		}
+		debug.Debug(debug.Info, fmt.Sprintf("sandbox: reloading Lua library %s in module %s", name, ld.policy.Module))
+		delete(ld.libs, name)
+	} else if loaded := ld.loaded(L).RawGetString(name); loaded != lua.LNil {
+		L.Push(loaded)
+		return 1
+	}
+
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		raise(L, ld.policy.Module, fmt.Sprintf("require %q: module names are dotted paths below lib/, scripts/ or the shared library", name))
+	}
+	path, _ := ld.resolve(name)
+	if path == "" {
+		L.Push(stock)
+		L.Push(lua.LString(name))
+		L.Call(1, 1)
+		return 1
+	}
+	L.Push(ld.load(L, name, path))
+	return 1
+}
+
+// resolve returns the first file providing name below the roots, and the
+// candidates tried otherwise
+func (ld *loader) resolve(name string) (string, []string) {
+	rel := filepath.FromSlash(strings.ReplaceAll(name, ".", "/"))
+
+	var tried []string
+	for _, root := range ld.policy.Roots {
+		for _, candidate := range []string{rel + ".lua", filepath.Join(rel, "init.lua")} {
+			path := filepath.Join(root, candidate)
+			if !within(root, path) {
+				tried = append(tried, fmt.Sprintf("no file '%s' (outside %s)", path, root))
+				continue
+			}
+			if _, err := os.Stat(path); err != nil {
+				tried = append(tried, fmt.Sprintf("no file '%s'", path))
+				continue
+			}
+			return path, nil
+		}
+	}
+	return "", tried
+}
+
+// load runs the library at path and caches its value
+func (ld *loader) load(L *lua.LState, name, path string) lua.LValue {
+	proto, err := scriptcache.Load(path)
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+
+	ld.loading = append(ld.loading, name)
+	ld.libs[name] = &library{path: path}
+	L.Push(L.NewFunctionFromProto(proto))
+	L.Push(lua.LString(name))
+	err = L.PCall(1, 1, nil)
+	ld.loading = ld.loading[:len(ld.loading)-1]
+	if err != nil {
+		delete(ld.libs, name)
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			L.Error(apiErr.Object, 0)
+		}
+		L.RaiseError("%v", err)
+	}
+
+	value := L.Get(-1)
+	L.Pop(1)
+	loaded := ld.loaded(L)
+	if value == lua.LNil {
+		// The library may have set package.loaded[name] itself
+		value = loaded.RawGetString(name)
+	}
+	if value == lua.LNil {
+		value = lua.LTrue
+	}
+	loaded.RawSetString(name, value)
+
+	lib := ld.libs[name]
+	lib.proto = proto
+	lib.value = value
+	luapool.Track(L, value)
+	return value
+}
+
+// fresh reports whether name and the libraries it required are unchanged
+func (ld *loader) fresh(name string, seen map[string]bool) bool {
+	if seen[name] {
+		return true
+	}
+	seen[name] = true
+	lib, ok := ld.libs[name]
+	if !ok {
+		return false
+	}
+	if proto, err := scriptcache.Load(lib.path); err != nil || proto != lib.proto {
+		return false
+	}
+	for _, dep := range lib.deps {
+		if !ld.fresh(dep, seen) {
+			return false
+		}
+	}
+	return true
+}
+
+// depend records name as a dependency of the library being loaded
+func (ld *loader) depend(name string) {
+	if len(ld.loading) == 0 {
+		return
+	}
+	parent := ld.libs[ld.loading[len(ld.loading)-1]]
+	for _, dep := range parent.deps {
+		if dep == name {
+			return
+		}
+	}
+	parent.deps = append(parent.deps, name)
+}
+
+// loaded returns package.loaded
+func (ld *loader) loaded(L *lua.LState) *lua.LTable {
+	return L.GetField(L.Get(lua.RegistryIndex), "_LOADED").(*lua.LTable)
+}
+
+// within reports whether path, after resolving symlinks, stays below root;
+// missing files are reported as within so the loader can report them as not found
+func within(root, path string) bool {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return true
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	if r, err := filepath.EvalSymlinks(absRoot); err == nil {
+		absRoot = r
+	}
+	absPath, err := filepath.Abs(resolved)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absRoot, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,233 @@
+// Package sandbox opens a restricted Lua standard library for module scripts.
+//
+// Every module runs its scripts under a policy taken from the Sandbox section
+// of its modules.yaml entry. Only the listed libraries are opened, functions
+// that reach the host (os.execute, io.open, dofile, ...) are replaced by stubs
+// that raise a sandbox violation unless explicitly allowed, and require only
+// resolves modules from the module's lib/ and scripts/ directories and the
+// shared Lua library directory.
+package sandbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/service/coverage"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// DefaultSharedDir holds Lua modules every module may require
+const DefaultSharedDir = "views/utils"
+
+// DefaultLibs are the libraries opened when a module does not list its own
+var DefaultLibs = []string{"table", "string", "math", "os", "coroutine"}
+
+// libraries maps the configurable library names to their openers;
+// base and package are always opened
+var libraries = map[string]lua.LGFunction{
+	lua.TabLibName:       lua.OpenTable,
+	lua.IoLibName:        lua.OpenIo,
+	lua.OsLibName:        lua.OpenOs,
+	lua.StringLibName:    lua.OpenString,
+	lua.MathLibName:      lua.OpenMath,
+	lua.DebugLibName:     lua.OpenDebug,
+	lua.ChannelLibName:   lua.OpenChannel,
+	lua.CoroutineLibName: lua.OpenCoroutine,
+}
+
+// blocked lists the functions removed from opened libraries unless allowed,
+// keyed by library ("" is the base library)
+var blocked = map[string][]string{
+	"":        {"dofile", "loadfile"},
+	"package": {"loadlib"},
+	"os":      {"execute", "exit", "getenv", "remove", "rename", "setenv", "tmpname"},
+	"io":      {"input", "lines", "open", "output", "popen", "tmpfile"},
+}
+
+var (
+	sharedDir = DefaultSharedDir
+	mutex     sync.RWMutex
+)
+
+// SetSharedDir sets the shared Lua library directory; empty keeps the default
+func SetSharedDir(dir string) {
+	if strings.TrimSpace(dir) == "" {
+		return
+	}
+	mutex.Lock()
+	sharedDir = dir
+	mutex.Unlock()
+}
+
+// SharedDir returns the shared Lua library directory
+func SharedDir() string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return sharedDir
+}
+
+// Policy is the sandbox applied to one module's scripts
+type Policy struct {
+	// Module names the module in violation reports
+	Module string
+	// Libs are the libraries opened besides base and package
+	Libs []string
+	// Allow holds the blocked functions re-enabled, e.g. "os.getenv"
+	Allow map[string]bool
+	// Roots are the directories require resolves modules from, in order
+	Roots []string
+	// Disabled opens the full standard library with the stock require
+	Disabled bool
+}
+
+// PolicyFor builds the policy of a module from its configuration
+func PolicyFor(settings config.ModulesConfig) Policy {
+	policy := Policy{
+		Module: settings.Name,
+		Libs:   DefaultLibs,
+		Allow:  make(map[string]bool),
+		Roots:  []string{settings.LibDir(), settings.ScriptsDir(), SharedDir()},
+	}
+	if cfg := settings.Sandbox; cfg != nil {
+		policy.Disabled = cfg.Disabled
+		if len(cfg.Libs) > 0 {
+			policy.Libs = cfg.Libs
+		}
+		for _, name := range cfg.Allow {
+			policy.Allow[name] = true
+		}
+	}
+	return policy
+}
+
+// Check reports unknown library and function names in a module's sandbox configuration
+func Check(cfg *config.SandboxConfig) []error {
+	if cfg == nil {
+		return nil
+	}
+	var errs []error
+	for _, lib := range cfg.Libs {
+		if _, known := libraries[lib]; !known && lib != "base" && lib != "package" {
+			errs = append(errs, fmt.Errorf("sandbox: unknown library %q", lib))
+		}
+	}
+	for _, name := range cfg.Allow {
+		if !isBlocked(name) {
+			errs = append(errs, fmt.Errorf("sandbox: %q is not a restricted function", name))
+		}
+	}
+	return errs
+}
+
+// Open installs the standard library on L according to policy, and the
+// coverage counter when coverage is enabled.
+// L must have been created with lua.Options{SkipOpenLibs: true}.
+func Open(L *lua.LState, policy Policy) {
+	coverage.Install(L)
+	if policy.Disabled {
+		L.OpenLibs()
+		return
+	}
+
+	open(L, lua.LoadLibName, lua.OpenPackage)
+	open(L, lua.BaseLibName, lua.OpenBase)
+	enabled := map[string]bool{"": true, "package": true}
+	for _, lib := range policy.Libs {
+		opener, known := libraries[lib]
+		if !known {
+			if lib != "base" && lib != "package" {
+				debug.Debug(debug.Warning, fmt.Sprintf("sandbox: module %s lists unknown library %q", policy.Module, lib))
+			}
+			continue
+		}
+		open(L, lib, opener)
+		enabled[lib] = true
+	}
+
+	for lib, names := range blocked {
+		if !enabled[lib] {
+			continue
+		}
+		tbl := L.G.Global
+		if lib != "" {
+			tbl = L.GetGlobal(lib).(*lua.LTable)
+		}
+		for _, name := range names {
+			if !policy.Allow[qualified(lib, name)] {
+				tbl.RawSetString(name, violation(L, policy.Module, qualified(lib, name)+" is not allowed"))
+			}
+		}
+	}
+
+	names := make([]string, 0, len(libraries))
+	for lib := range libraries {
+		if !enabled[lib] {
+			names = append(names, lib)
+		}
+	}
+	sort.Strings(names)
+	for _, lib := range names {
+		L.SetGlobal(lib, disabledLib(L, policy.Module, lib))
+	}
+
+	confineRequire(L, policy)
+}
+
+// open calls a library opener the way LState.OpenLibs does
+func open(L *lua.LState, name string, opener lua.LGFunction) {
+	L.Push(L.NewFunction(opener))
+	L.Push(lua.LString(name))
+	L.Call(1, 0)
+}
+
+// qualified returns the dotted name of a library function
+func qualified(lib, name string) string {
+	if lib == "" {
+		return name
+	}
+	return lib + "." + name
+}
+
+func isBlocked(name string) bool {
+	lib, fn := "", name
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		lib, fn = name[:i], name[i+1:]
+	}
+	for _, candidate := range blocked[lib] {
+		if candidate == fn {
+			return true
+		}
+	}
+	return false
+}
+
+// violation returns a function raising a sandbox violation
+func violation(L *lua.LState, module, what string) *lua.LFunction {
+	return L.NewFunction(func(L *lua.LState) int {
+		raise(L, module, what)
+		return 0
+	})
+}
+
+// disabledLib returns a stand-in for a library that is not enabled; indexing it raises a violation
+func disabledLib(L *lua.LState, module, lib string) *lua.LTable {
+	stub := L.NewTable()
+	meta := L.NewTable()
+	meta.RawSetString("__index", L.NewFunction(func(L *lua.LState) int {
+		raise(L, module, fmt.Sprintf("library %s is not enabled (%s.%s)", lib, lib, L.CheckAny(2).String()))
+		return 0
+	}))
+	L.SetMetatable(stub, meta)
+	return stub
+}
+
+// raise logs and raises a sandbox violation, naming the offending script line
+func raise(L *lua.LState, module, what string) {
+	where := L.Where(1)
+	debug.Debug(debug.Warning, fmt.Sprintf("sandbox violation in module %s: %s %s", module, where, what))
+	L.RaiseError("sandbox: %s in module %s", what, module)
+}
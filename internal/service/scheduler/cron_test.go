@@ -0,0 +1,209 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr string
+		ok   bool
+	}{
+		{"* * * * *", true},
+		{"*/15 9-17 * * mon-fri", true},
+		{"0 0 1,15 jan,jul *", true},
+		{"5/20 * * * *", true},
+		{"0 0 * * 7", true},
+		{"@daily", true},
+		{"@HOURLY", true},
+		{"@every 90s", true},
+		{"* * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"*/0 * * * *", false},
+		{"10-5 * * * *", false},
+		{"* * * foo *", false},
+		{"@every 500ms", false},
+		{"@every soon", false},
+		{"@fortnightly", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Parse(tt.expr)
+			if (err == nil) != tt.ok {
+				t.Errorf("Parse(%q) error = %v, want ok = %v", tt.expr, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	utc := time.UTC
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		{"every minute", "* * * * *", time.Date(2026, 1, 1, 10, 0, 30, 0, utc), []time.Time{
+			time.Date(2026, 1, 1, 10, 1, 0, 0, utc),
+			time.Date(2026, 1, 1, 10, 2, 0, 0, utc),
+		}},
+		{"step", "*/20 * * * *", time.Date(2026, 1, 1, 10, 5, 0, 0, utc), []time.Time{
+			time.Date(2026, 1, 1, 10, 20, 0, 0, utc),
+			time.Date(2026, 1, 1, 10, 40, 0, 0, utc),
+			time.Date(2026, 1, 1, 11, 0, 0, 0, utc),
+		}},
+		{"step from a start", "5/20 * * * *", time.Date(2026, 1, 1, 10, 0, 0, 0, utc), []time.Time{
+			time.Date(2026, 1, 1, 10, 5, 0, 0, utc),
+			time.Date(2026, 1, 1, 10, 25, 0, 0, utc),
+			time.Date(2026, 1, 1, 10, 45, 0, 0, utc),
+		}},
+		{"range step", "0 9-17/4 * * *", time.Date(2026, 1, 1, 10, 0, 0, 0, utc), []time.Time{
+			time.Date(2026, 1, 1, 13, 0, 0, 0, utc),
+			time.Date(2026, 1, 1, 17, 0, 0, 0, utc),
+			time.Date(2026, 1, 2, 9, 0, 0, 0, utc),
+		}},
+		{"hourly", "@hourly", time.Date(2026, 1, 1, 10, 0, 0, 0, utc), []time.Time{
+			time.Date(2026, 1, 1, 11, 0, 0, 0, utc),
+		}},
+		{"daily", "@daily", time.Date(2026, 1, 1, 10, 0, 0, 0, utc), []time.Time{
+			time.Date(2026, 1, 2, 0, 0, 0, 0, utc),
+		}},
+		// 2026-01-01 is a Thursday
+		{"weekly", "@weekly", time.Date(2026, 1, 1, 10, 0, 0, 0, utc), []time.Time{
+			time.Date(2026, 1, 4, 0, 0, 0, 0, utc),
+			time.Date(2026, 1, 11, 0, 0, 0, 0, utc),
+		}},
+		{"monthly", "@monthly", time.Date(2026, 1, 15, 0, 0, 0, 0, utc), []time.Time{
+			time.Date(2026, 2, 1, 0, 0, 0, 0, utc),
+		}},
+		{"yearly", "@yearly", time.Date(2026, 1, 1, 0, 0, 0, 0, utc), []time.Time{
+			time.Date(2027, 1, 1, 0, 0, 0, 0, utc),
+		}},
+		{"every", "@every 90s", time.Date(2026, 1, 1, 10, 0, 0, 0, utc), []time.Time{
+			time.Date(2026, 1, 1, 10, 1, 30, 0, utc),
+			time.Date(2026, 1, 1, 10, 3, 0, 0, utc),
+		}},
+		{"weekdays by name", "0 6 * * mon-fri", time.Date(2026, 1, 2, 7, 0, 0, 0, utc), []time.Time{
+			time.Date(2026, 1, 5, 6, 0, 0, 0, utc),
+			time.Date(2026, 1, 6, 6, 0, 0, 0, utc),
+		}},
+		{"sunday as 7", "0 0 * * 7", time.Date(2026, 1, 1, 0, 0, 0, 0, utc), []time.Time{
+			time.Date(2026, 1, 4, 0, 0, 0, 0, utc),
+		}},
+		{"month names", "0 0 1 jan,jul *", time.Date(2026, 1, 1, 0, 0, 0, 0, utc), []time.Time{
+			time.Date(2026, 7, 1, 0, 0, 0, 0, utc),
+			time.Date(2027, 1, 1, 0, 0, 0, 0, utc),
+		}},
+		// Both day fields restricted: the 13th or a Friday
+		{"dom or dow", "0 0 13 * fri", time.Date(2026, 2, 1, 0, 0, 0, 0, utc), []time.Time{
+			time.Date(2026, 2, 6, 0, 0, 0, 0, utc),
+			time.Date(2026, 2, 13, 0, 0, 0, 0, utc),
+			time.Date(2026, 2, 20, 0, 0, 0, 0, utc),
+		}},
+		// A "*" day of week leaves the day of month alone
+		{"dom with any dow", "0 0 13 * *", time.Date(2026, 2, 1, 0, 0, 0, 0, utc), []time.Time{
+			time.Date(2026, 2, 13, 0, 0, 0, 0, utc),
+			time.Date(2026, 3, 13, 0, 0, 0, 0, utc),
+		}},
+		{"dow with stepped dom", "0 0 */1 * fri", time.Date(2026, 2, 1, 0, 0, 0, 0, utc), []time.Time{
+			time.Date(2026, 2, 6, 0, 0, 0, 0, utc),
+			time.Date(2026, 2, 13, 0, 0, 0, 0, utc),
+		}},
+		{"leap day", "0 0 29 2 *", time.Date(2026, 1, 1, 0, 0, 0, 0, utc), []time.Time{
+			time.Date(2028, 2, 29, 0, 0, 0, 0, utc),
+		}},
+		{"never", "0 0 30 2 *", time.Date(2026, 1, 1, 0, 0, 0, 0, utc), []time.Time{
+			{},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkNext(t, tt.expr, tt.from, tt.want)
+		})
+	}
+}
+
+// TestNextDST checks schedules across daylight saving changes: in Berlin the
+// clock skips 02:00-03:00 on 2026-03-29 and repeats 02:00-03:00 on 2026-10-25,
+// in Beirut it skips 00:00-01:00 on 2026-03-29
+func TestNextDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	beirut, err := time.LoadLocation("Asia/Beirut")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cest := time.FixedZone("CEST", 2*3600)
+	cet := time.FixedZone("CET", 3600)
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		{"skipped hour runs after the gap", "30 2 * * *", time.Date(2026, 3, 28, 12, 0, 0, 0, berlin), []time.Time{
+			time.Date(2026, 3, 29, 3, 0, 0, 0, cest),
+			time.Date(2026, 3, 30, 2, 30, 0, 0, cest),
+		}},
+		{"hour after the gap", "30 3 * * *", time.Date(2026, 3, 28, 12, 0, 0, 0, berlin), []time.Time{
+			time.Date(2026, 3, 29, 3, 30, 0, 0, cest),
+			time.Date(2026, 3, 30, 3, 30, 0, 0, cest),
+		}},
+		{"every hour follows the clock forward", "0 * * * *", time.Date(2026, 3, 29, 0, 30, 0, 0, berlin), []time.Time{
+			time.Date(2026, 3, 29, 1, 0, 0, 0, cet),
+			time.Date(2026, 3, 29, 3, 0, 0, 0, cest),
+			time.Date(2026, 3, 29, 4, 0, 0, 0, cest),
+		}},
+		{"repeated hour runs once", "30 2 * * *", time.Date(2026, 10, 24, 12, 0, 0, 0, berlin), []time.Time{
+			time.Date(2026, 10, 25, 2, 30, 0, 0, cest),
+			time.Date(2026, 10, 26, 2, 30, 0, 0, cet),
+		}},
+		{"repeated hour in a range runs once", "0,30 1-3 * * *", time.Date(2026, 10, 25, 1, 45, 0, 0, berlin), []time.Time{
+			time.Date(2026, 10, 25, 2, 0, 0, 0, cest),
+			time.Date(2026, 10, 25, 2, 30, 0, 0, cest),
+			time.Date(2026, 10, 25, 3, 0, 0, 0, cet),
+		}},
+		{"every hour follows the clock back", "30 * * * *", time.Date(2026, 10, 25, 1, 45, 0, 0, berlin), []time.Time{
+			time.Date(2026, 10, 25, 2, 30, 0, 0, cest),
+			time.Date(2026, 10, 25, 2, 30, 0, 0, cet),
+			time.Date(2026, 10, 25, 3, 30, 0, 0, cet),
+		}},
+		{"skipped midnight", "@daily", time.Date(2026, 3, 28, 12, 0, 0, 0, beirut), []time.Time{
+			time.Date(2026, 3, 29, 1, 0, 0, 0, time.FixedZone("EEST", 3*3600)),
+			time.Date(2026, 3, 30, 0, 0, 0, 0, time.FixedZone("EEST", 3*3600)),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkNext(t, tt.expr, tt.from, tt.want)
+		})
+	}
+}
+
+// checkNext checks the times expr fires at after from
+func checkNext(t *testing.T, expr string, from time.Time, want []time.Time) {
+	t.Helper()
+	s, err := Parse(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := from
+	for i, w := range want {
+		next = s.Next(next)
+		if !next.Equal(w) {
+			t.Fatalf("Next #%d of %q after %v = %v, want %v", i+1, expr, from, next, w)
+		}
+		if next.IsZero() {
+			return
+		}
+	}
+}
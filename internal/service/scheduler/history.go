@@ -0,0 +1,132 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	rds "github.com/redis/go-redis/v9"
+)
+
+// Run statuses
+const (
+	StatusOK      = "ok"
+	StatusError   = "error"
+	StatusTimeout = "timeout"
+	// StatusSkipped is a run dropped because the previous one had not finished
+	StatusSkipped = "skipped"
+)
+
+// Run is an entry of a job's history
+type Run struct {
+	Scheduled time.Time `json:"scheduled"`
+	Started   time.Time `json:"started"`
+	Duration  float64   `json:"durationMs"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	// Node is the host and process id that ran the job
+	Node string `json:"node"`
+}
+
+// History keeps the last runs of every job, newest first
+type History interface {
+	// Add records a finished run of job
+	Add(ctx context.Context, job string, run Run) error
+	// List returns the last runs of job
+	List(ctx context.Context, job string) ([]Run, error)
+	// Name returns the backend name ("memory", "redis")
+	Name() string
+}
+
+// historySize is how many runs are kept per job
+const historySize = 50
+
+// MemoryHistory keeps the runs in process memory, so only the process that
+// ran the jobs lists them
+type MemoryHistory struct {
+	mutex sync.Mutex
+	runs  map[string][]Run
+}
+
+// NewMemoryHistory creates an in-memory history
+func NewMemoryHistory() *MemoryHistory {
+	return &MemoryHistory{runs: make(map[string][]Run)}
+}
+
+// Name returns "memory"
+func (m *MemoryHistory) Name() string {
+	return "memory"
+}
+
+// Add records a finished run of job
+func (m *MemoryHistory) Add(_ context.Context, job string, run Run) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	runs := append([]Run{run}, m.runs[job]...)
+	if len(runs) > historySize {
+		runs = runs[:historySize]
+	}
+	m.runs[job] = runs
+	return nil
+}
+
+// List returns the last runs of job
+func (m *MemoryHistory) List(_ context.Context, job string) ([]Run, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]Run(nil), m.runs[job]...), nil
+}
+
+const redisHistoryPrefix = "eocto:jobs:history:"
+
+// RedisHistory keeps the runs in Redis lists, so every node lists the runs
+// of the elected one
+type RedisHistory struct {
+	client *rds.Client
+}
+
+// NewRedisHistory creates a history on top of an initialized Redis client
+func NewRedisHistory(client *rds.Client) (*RedisHistory, error) {
+	if client == nil {
+		return nil, errors.New("redis client not initialized")
+	}
+	return &RedisHistory{client: client}, nil
+}
+
+// Name returns "redis"
+func (r *RedisHistory) Name() string {
+	return "redis"
+}
+
+// Add records a finished run of job
+func (r *RedisHistory) Add(ctx context.Context, job string, run Run) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	pipe := r.client.TxPipeline()
+	pipe.LPush(ctx, redisHistoryPrefix+job, data)
+	pipe.LTrim(ctx, redisHistoryPrefix+job, 0, historySize-1)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// List returns the last runs of job
+func (r *RedisHistory) List(ctx context.Context, job string) ([]Run, error) {
+	entries, err := r.client.LRange(ctx, redisHistoryPrefix+job, 0, historySize-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]Run, 0, len(entries))
+	for _, entry := range entries {
+		var run Run
+		if json.Unmarshal([]byte(entry), &run) == nil {
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
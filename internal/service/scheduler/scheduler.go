@@ -0,0 +1,403 @@
+// Package scheduler runs the jobs modules declare under jobs: on their cron
+// schedules.
+//
+// Every job runs its script on a fresh sandboxed Lua state bound to the Job
+// context: the eocto bindings that need no request (MongoDB, Redis, HTTP,
+// the cache, logging) plus eocto.job. Only the process holding the scheduler
+// lease runs jobs. With the memory locker that is the only scheduler of the
+// host, as Prefork children never start one; with the Redis locker the
+// processes of every node elect one and another takes over within the lease
+// time when it stops renewing.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/bindings"
+	"github.com/degreane/octopus/internal/service/limits"
+	"github.com/degreane/octopus/internal/service/lock"
+	"github.com/degreane/octopus/internal/service/sandbox"
+	"github.com/degreane/octopus/internal/service/scriptcache"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	// DefaultTimeout bounds a run when the job sets no timeout
+	DefaultTimeout = 5 * time.Minute
+
+	// leaderLock is the lock whose holder runs the jobs
+	leaderLock = "scheduler:leader"
+	// leaderTTL is how long the lease outlives its last renewal
+	leaderTTL = 15 * time.Second
+)
+
+// job is a scheduled job of a module
+type job struct {
+	module   config.ModulesConfig
+	config   config.Job
+	schedule *Schedule
+	location *time.Location
+	timeout  time.Duration
+
+	mutex   sync.Mutex
+	running int
+	// queued is the run waiting for the current one under the queue policy
+	queued *time.Time
+	next   time.Time
+}
+
+// key identifies the job in the history
+func (j *job) key() string {
+	return j.module.Name + ":" + j.config.Name
+}
+
+// Scheduler runs the jobs of the modules
+type Scheduler struct {
+	jobs    []*job
+	history History
+	node    string
+
+	leader atomic.Bool
+	lease  *lock.Lease
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+var (
+	mutex    sync.RWMutex
+	instance *Scheduler
+)
+
+// Build checks the jobs of modules and returns a scheduler for them. Jobs
+// that do not parse are reported and left out.
+func Build(modules []config.ModulesConfig, history History) (*Scheduler, []error) {
+	if history == nil {
+		history = NewMemoryHistory()
+	}
+	host, _ := os.Hostname()
+	s := &Scheduler{history: history, node: fmt.Sprintf("%s:%d", host, os.Getpid()), stop: make(chan struct{})}
+
+	var errs []error
+	for _, module := range modules {
+		for _, cfg := range module.Jobs {
+			if cfg.Disabled {
+				continue
+			}
+			j, err := newJob(module, cfg)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			s.jobs = append(s.jobs, j)
+		}
+	}
+	return s, errs
+}
+
+// Check returns the problems of a job's configuration
+func Check(module config.ModulesConfig, cfg config.Job) error {
+	_, err := newJob(module, cfg)
+	return err
+}
+
+func newJob(module config.ModulesConfig, cfg config.Job) (*job, error) {
+	where := fmt.Sprintf("module %s: job %q", module.Name, cfg.Name)
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("module %s: job without a name", module.Name)
+	}
+	if cfg.Script == "" {
+		return nil, fmt.Errorf("%s: script is required", where)
+	}
+	schedule, err := Parse(cfg.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", where, err)
+	}
+	location := time.Local
+	if cfg.Timezone != "" {
+		if location, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, fmt.Errorf("%s: timezone: %v", where, err)
+		}
+	}
+	switch cfg.Overlap {
+	case "", config.OverlapSkip, config.OverlapQueue, config.OverlapAllow:
+	default:
+		return nil, fmt.Errorf("%s: unknown overlap policy %q (skip, queue or allow)", where, cfg.Overlap)
+	}
+	timeout := DefaultTimeout
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	return &job{module: module, config: cfg, schedule: schedule, location: location, timeout: timeout}, nil
+}
+
+// Start builds the scheduler of modules, reports the jobs left out and
+// starts it as the application wide scheduler
+func Start(modules []config.ModulesConfig, history History) *Scheduler {
+	s, errs := Build(modules, history)
+	for _, err := range errs {
+		debug.Debug(debug.Error, err.Error())
+	}
+	if len(s.jobs) == 0 {
+		return s
+	}
+	mutex.Lock()
+	instance = s
+	mutex.Unlock()
+
+	s.wg.Add(1)
+	go s.elect()
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+	debug.Debug(debug.Important, fmt.Sprintf("scheduler: %d job(s) scheduled, history in %s", len(s.jobs), s.history.Name()))
+	return s
+}
+
+// Stop stops scheduling, waits for the running jobs and gives up the lease
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// elect takes the scheduler lease and keeps renewing it; the process holding
+// it is the leader
+func (s *Scheduler) elect() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(leaderTTL / 3)
+	defer ticker.Stop()
+	for {
+		s.campaign()
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			if s.lease != nil {
+				lock.Get().Release(context.Background(), s.lease)
+				s.leader.Store(false)
+			}
+			return
+		}
+	}
+}
+
+// campaign renews the lease, or tries to take it when it is not held
+func (s *Scheduler) campaign() {
+	ctx, cancel := context.WithTimeout(context.Background(), leaderTTL/3)
+	defer cancel()
+	locker := lock.Get()
+
+	if s.lease != nil {
+		ok, err := locker.Extend(ctx, s.lease, leaderTTL)
+		if err == nil && ok {
+			return
+		}
+		debug.Debug(debug.Warning, fmt.Sprintf("scheduler: %s lost the lease (%v)", s.node, err))
+		s.lease = nil
+		s.leader.Store(false)
+	}
+	lease, ok, err := locker.TryAcquire(ctx, leaderLock, leaderTTL)
+	if err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("scheduler: leader election: %v", err))
+		return
+	}
+	if ok {
+		s.lease = lease
+		s.leader.Store(true)
+		debug.Debug(debug.Important, fmt.Sprintf("scheduler: %s runs the jobs (%s locker)", s.node, locker.Name()))
+	}
+}
+
+// loop fires j on its schedule until the scheduler stops
+func (s *Scheduler) loop(j *job) {
+	defer s.wg.Done()
+	for {
+		next := j.schedule.Next(time.Now().In(j.location))
+		j.mutex.Lock()
+		j.next = next
+		j.mutex.Unlock()
+		if next.IsZero() {
+			debug.Debug(debug.Warning, fmt.Sprintf("scheduler: job %s never fires", j.key()))
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+		if s.leader.Load() {
+			s.fire(j, next)
+		}
+	}
+}
+
+// fire starts a run of j due at scheduled, following its overlap policy
+func (s *Scheduler) fire(j *job, scheduled time.Time) {
+	j.mutex.Lock()
+	if j.running > 0 {
+		switch j.config.Overlap {
+		case config.OverlapAllow:
+		case config.OverlapQueue:
+			j.queued = &scheduled
+			j.mutex.Unlock()
+			return
+		default:
+			j.mutex.Unlock()
+			s.record(j, Run{Scheduled: scheduled, Started: time.Now(), Status: StatusSkipped, Error: "previous run still running"})
+			return
+		}
+	}
+	j.running++
+	j.mutex.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			s.record(j, s.run(j, scheduled))
+
+			j.mutex.Lock()
+			if j.queued == nil {
+				j.running--
+				j.mutex.Unlock()
+				return
+			}
+			scheduled, j.queued = *j.queued, nil
+			j.mutex.Unlock()
+		}
+	}()
+}
+
+// run executes the job's script on a fresh state
+func (s *Scheduler) run(j *job, scheduled time.Time) Run {
+	run := Run{Scheduled: scheduled, Started: time.Now(), Node: s.node}
+	err := RunScript(j.module, j.config.Script, j.timeout, &bindings.JobInfo{
+		Name:      j.config.Name,
+		Schedule:  j.config.Schedule,
+		Scheduled: scheduled,
+	})
+	run.Duration = float64(time.Since(run.Started).Microseconds()) / 1000
+	var limitErr *limits.Error
+	switch {
+	case err == nil:
+		run.Status = StatusOK
+	case errors.As(err, &limitErr) && limitErr.Kind == limits.KindTimeout:
+		run.Status = StatusTimeout
+		run.Error = err.Error()
+	default:
+		run.Status = StatusError
+		run.Error = err.Error()
+	}
+	if err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("scheduler: job %s: %v", j.key(), err))
+	} else {
+		debug.Debug(debug.Info, fmt.Sprintf("scheduler: job %s ran in %.1fms", j.key(), run.Duration))
+	}
+	return run
+}
+
+// RunScript runs a script of module on a fresh sandboxed state bound to the
+// Job context, with eocto.job describing info; timeout bounds the run
+func RunScript(module config.ModulesConfig, script string, timeout time.Duration, info *bindings.JobInfo) error {
+	limit := limits.Resolve(module.Limits, &config.LuaLimits{Timeout: int(timeout / time.Millisecond)})
+	L := lua.NewState(limit.Options())
+	defer L.Close()
+	sandbox.Open(L, sandbox.PolicyFor(module))
+	env := &bindings.Env{Context: bindings.Job, Module: module, Job: info}
+	bindings.Install(L, func() *bindings.Env { return env })
+
+	path := filepath.Join(module.ScriptsDir(), script)
+	return limits.Run(context.Background(), L, limit, path, func() error {
+		return scriptcache.Run(L, path)
+	})
+}
+
+// record adds run to the history of j
+func (s *Scheduler) record(j *job, run Run) {
+	run.Node = s.node
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.history.Add(ctx, j.key(), run); err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("scheduler: job %s: history: %v", j.key(), err))
+	}
+}
+
+// JobStats is the state of a job published by the metrics API
+type JobStats struct {
+	Module   string    `json:"module"`
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	Timezone string    `json:"timezone"`
+	Overlap  string    `json:"overlap"`
+	Next     time.Time `json:"next"`
+	Running  int       `json:"running"`
+	Runs     []Run     `json:"runs"`
+}
+
+// Stats is the scheduler's snapshot for the metrics API
+type Stats struct {
+	Node    string     `json:"node"`
+	Leader  bool       `json:"leader"`
+	History string     `json:"history"`
+	Jobs    []JobStats `json:"jobs"`
+}
+
+// Stats returns the jobs with their next run and history. A scheduler that was
+// built but not started reports leader false and reads the history it was
+// given, the shared one with Redis storage. The runs leave the
+// error text out: the snapshot is served by the unauthenticated /metrics/stats
+// and errors can carry connection strings or data, so they only go to the log.
+func (s *Scheduler) Stats() Stats {
+	stats := Stats{Node: s.node, Leader: s.leader.Load(), History: s.history.Name(), Jobs: []JobStats{}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, j := range s.jobs {
+		j.mutex.Lock()
+		entry := JobStats{
+			Module:   j.module.Name,
+			Name:     j.config.Name,
+			Schedule: j.config.Schedule,
+			Timezone: j.location.String(),
+			Overlap:  j.config.Overlap,
+			Next:     j.next,
+			Running:  j.running,
+		}
+		j.mutex.Unlock()
+		if entry.Next.IsZero() {
+			// Not started here: a Prefork child publishing the jobs
+			entry.Next = j.schedule.Next(time.Now().In(j.location))
+		}
+		if entry.Overlap == "" {
+			entry.Overlap = config.OverlapSkip
+		}
+		runs, err := s.history.List(ctx, j.key())
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("scheduler: job %s: history: %v", j.key(), err))
+		}
+		entry.Runs = make([]Run, 0, len(runs))
+		for _, run := range runs {
+			run.Error = ""
+			entry.Runs = append(entry.Runs, run)
+		}
+		stats.Jobs = append(stats.Jobs, entry)
+	}
+	return stats
+}
+
+// Get returns the application wide scheduler, nil before Start scheduled any job
+func Get() *Scheduler {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return instance
+}
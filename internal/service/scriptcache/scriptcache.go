@@ -0,0 +1,172 @@
+// Package scriptcache compiles Lua scripts once and shares the compiled
+// FunctionProto between every Lua state.
+//
+// Entries are keyed by script path and invalidated when the file's
+// modification time or size changes. When pinned (production), files are
+// never stat'ed again after their first compilation. Compilation errors are
+// cached as well, so a broken script is reported once, when it is loaded or
+// changed, instead of on every request.
+package scriptcache
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/degreane/octopus/internal/utilities/debug"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// entry is a compiled script or its compilation error
+type entry struct {
+	proto   *lua.FunctionProto
+	err     error
+	modTime time.Time
+	size    int64
+}
+
+// Stats is a snapshot of the cache counters
+type Stats struct {
+	Scripts     int   `json:"scripts"`
+	Pinned      bool  `json:"pinned"`
+	Hits        int64 `json:"hits"`
+	Compiles    int64 `json:"compiles"`
+	Errors      int64 `json:"errors"`
+	Invalidated int64 `json:"invalidated"`
+}
+
+// Transform rewrites the statements of a parsed script before it is compiled
+type Transform func(path string, chunk []ast.Stmt) []ast.Stmt
+
+var (
+	mutex     sync.RWMutex
+	entries   = make(map[string]*entry)
+	pinned    atomic.Bool
+	transform atomic.Pointer[Transform]
+
+	hits        atomic.Int64
+	compiles    atomic.Int64
+	failures    atomic.Int64
+	invalidated atomic.Int64
+)
+
+// CompileError reports a script that does not parse or compile
+type CompileError struct {
+	Path string
+	Err  error
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("error compiling Lua script %s: %v", e.Path, e.Err)
+}
+
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// SetPinned disables (true) or enables (false) modification checks
+func SetPinned(pin bool) {
+	pinned.Store(pin)
+}
+
+// SetTransform applies t to every script compiled afterwards and drops the
+// scripts compiled before, so they are compiled again with t on next use
+func SetTransform(t Transform) {
+	transform.Store(&t)
+	mutex.Lock()
+	entries = make(map[string]*entry)
+	mutex.Unlock()
+}
+
+// Compile parses and compiles the script at path without caching it.
+// Parse and compile failures are returned as *CompileError.
+func Compile(path string) (*lua.FunctionProto, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	chunk, err := parse.Parse(bufio.NewReader(file), path)
+	if err != nil {
+		return nil, &CompileError{Path: path, Err: err}
+	}
+	if t := transform.Load(); t != nil && *t != nil {
+		chunk = (*t)(path, chunk)
+	}
+	proto, err := lua.Compile(chunk, path)
+	if err != nil {
+		return nil, &CompileError{Path: path, Err: err}
+	}
+	return proto, nil
+}
+
+// Load returns the compiled script at path, compiling it on first use or
+// after the file changed. A cached compilation error is returned as is and
+// only logged when the script is (re)compiled.
+func Load(path string) (*lua.FunctionProto, error) {
+	mutex.RLock()
+	cached, exists := entries[path]
+	mutex.RUnlock()
+
+	if exists && pinned.Load() {
+		hits.Add(1)
+		return cached.proto, cached.err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if exists && info.ModTime().Equal(cached.modTime) && info.Size() == cached.size {
+		hits.Add(1)
+		return cached.proto, cached.err
+	}
+	if exists {
+		invalidated.Add(1)
+	}
+
+	compiled := &entry{modTime: info.ModTime(), size: info.Size()}
+	compiled.proto, compiled.err = Compile(path)
+	compiles.Add(1)
+	if compiled.err != nil {
+		failures.Add(1)
+		debug.Debug(debug.Error, compiled.err.Error())
+	}
+
+	mutex.Lock()
+	entries[path] = compiled
+	mutex.Unlock()
+	return compiled.proto, compiled.err
+}
+
+// Run executes the compiled script at path on L, leaving its return values on the stack
+func Run(L *lua.LState, path string) error {
+	proto, err := Load(path)
+	if err != nil {
+		return err
+	}
+	L.Push(L.NewFunctionFromProto(proto))
+	return L.PCall(0, lua.MultRet, nil)
+}
+
+// GetStats returns a snapshot of the cache counters
+func GetStats() Stats {
+	mutex.RLock()
+	scripts := len(entries)
+	mutex.RUnlock()
+
+	return Stats{
+		Scripts:     scripts,
+		Pinned:      pinned.Load(),
+		Hits:        hits.Load(),
+		Compiles:    compiles.Load(),
+		Errors:      failures.Load(),
+		Invalidated: invalidated.Load(),
+	}
+}
@@ -0,0 +1,350 @@
+// Package workers processes the queues modules declare under workers: with
+// their Lua scripts.
+//
+// Every message runs the worker's script on a fresh sandboxed Lua state bound
+// to the Job context, with eocto.job describing the message. A script that
+// returns acknowledges the message; one that raises an error, or overruns the
+// visibility timeout, has it retried with an exponential backoff until its
+// retries are spent, then moved to the queue's dead letters. While the script
+// runs the worker keeps extending the message's visibility, so the message
+// only becomes visible again when the process stops renewing its claim.
+package workers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/bindings"
+	"github.com/degreane/octopus/internal/service/queue"
+	"github.com/degreane/octopus/internal/service/scheduler"
+	"github.com/degreane/octopus/internal/utilities/debug"
+)
+
+const (
+	// DefaultRetries is the number of retries of a message enqueued without one
+	DefaultRetries = 3
+	// DefaultBackoff is the delay before the first retry
+	DefaultBackoff = 10 * time.Second
+	// DefaultVisibility is how long a claimed message is hidden from other workers
+	DefaultVisibility = time.Minute
+
+	// maxBackoff caps the delay between retries
+	maxBackoff = time.Hour
+	// pollInterval is how long an idle worker waits before claiming again
+	pollInterval = 500 * time.Millisecond
+	// backendTimeout bounds a call to the queue backend
+	backendTimeout = 5 * time.Second
+)
+
+// worker processes a queue of a module
+type worker struct {
+	module      config.ModulesConfig
+	config      config.Worker
+	queue       string
+	concurrency int
+	retries     int
+	backoff     time.Duration
+	visibility  time.Duration
+
+	running   atomic.Int64
+	processed atomic.Int64
+	failed    atomic.Int64
+	buried    atomic.Int64
+}
+
+// Pool runs the workers of the modules
+type Pool struct {
+	workers []*worker
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+var (
+	mutex    sync.RWMutex
+	instance *Pool
+)
+
+// Build checks the workers of modules and returns a pool for them. Workers
+// with a bad configuration are reported and left out.
+func Build(modules []config.ModulesConfig) (*Pool, []error) {
+	p := &Pool{stop: make(chan struct{})}
+	var errs []error
+	for _, module := range modules {
+		for _, cfg := range module.Workers {
+			if cfg.Disabled {
+				continue
+			}
+			w, err := newWorker(module, cfg)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			p.workers = append(p.workers, w)
+		}
+	}
+	return p, errs
+}
+
+// Check returns the problems of a worker's configuration
+func Check(module config.ModulesConfig, cfg config.Worker) error {
+	_, err := newWorker(module, cfg)
+	return err
+}
+
+func newWorker(module config.ModulesConfig, cfg config.Worker) (*worker, error) {
+	where := fmt.Sprintf("module %s: worker %q", module.Name, cfg.Queue)
+	if cfg.Queue == "" {
+		return nil, fmt.Errorf("module %s: worker without a queue", module.Name)
+	}
+	if cfg.Script == "" {
+		return nil, fmt.Errorf("%s: script is required", where)
+	}
+	if cfg.Concurrency < 0 || cfg.Backoff < 0 || cfg.Visibility < 0 || (cfg.Retries != nil && *cfg.Retries < 0) {
+		return nil, fmt.Errorf("%s: concurrency, retries, backoff and visibility cannot be negative", where)
+	}
+	w := &worker{
+		module:      module,
+		config:      cfg,
+		queue:       module.Name + ":" + cfg.Queue,
+		concurrency: 1,
+		retries:     DefaultRetries,
+		backoff:     DefaultBackoff,
+		visibility:  DefaultVisibility,
+	}
+	if cfg.Concurrency > 0 {
+		w.concurrency = cfg.Concurrency
+	}
+	if cfg.Retries != nil {
+		w.retries = *cfg.Retries
+	}
+	if cfg.Backoff > 0 {
+		w.backoff = time.Duration(cfg.Backoff) * time.Second
+	}
+	if cfg.Visibility > 0 {
+		w.visibility = time.Duration(cfg.Visibility) * time.Second
+	}
+	return w, nil
+}
+
+// Start builds the pool of modules, reports the workers left out and starts
+// it as the application wide pool
+func Start(modules []config.ModulesConfig) *Pool {
+	p, errs := Build(modules)
+	for _, err := range errs {
+		debug.Debug(debug.Error, err.Error())
+	}
+	if len(p.workers) == 0 {
+		return p
+	}
+	mutex.Lock()
+	instance = p
+	mutex.Unlock()
+
+	for _, w := range p.workers {
+		for i := 0; i < w.concurrency; i++ {
+			p.wg.Add(1)
+			go p.loop(w)
+		}
+	}
+	debug.Debug(debug.Important, fmt.Sprintf("workers: %d queue(s) processed, messages in %s", len(p.workers), queue.Get().Name()))
+	return p
+}
+
+// Stop stops claiming messages and waits for the ones being processed
+func (p *Pool) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// loop claims and processes the messages of w until the pool stops
+func (p *Pool) loop(w *worker) {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+		msg, err := queue.Get().Claim(ctx, w.queue, w.visibility)
+		cancel()
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("workers: queue %s: claim: %v", w.queue, err))
+		}
+		if err != nil || msg == nil {
+			select {
+			case <-time.After(pollInterval):
+			case <-p.stop:
+				return
+			}
+			continue
+		}
+		w.process(msg)
+	}
+}
+
+// process runs the worker's script on msg, then acknowledges, retries or
+// buries it
+func (w *worker) process(msg *queue.Message) {
+	w.running.Add(1)
+	defer w.running.Add(-1)
+
+	started := time.Now()
+	stop := w.extend(msg)
+	err := scheduler.RunScript(w.module, w.config.Script, w.visibility, &bindings.JobInfo{
+		Name:      w.config.Queue,
+		Scheduled: msg.Enqueued,
+		ID:        msg.ID,
+		Attempt:   msg.Attempts,
+		Payload:   msg.Payload,
+	})
+	stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+	backend := queue.Get()
+	if err == nil {
+		w.processed.Add(1)
+		debug.Debug(debug.Info, fmt.Sprintf("workers: queue %s: message %s processed in %s", w.queue, msg.ID, time.Since(started)))
+		if err := backend.Ack(ctx, msg); err != nil {
+			w.settleFailed("ack", msg, err)
+		}
+		return
+	}
+
+	w.failed.Add(1)
+	msg.Error = err.Error()
+	retries := msg.Retries
+	if retries < 0 {
+		retries = w.retries
+	}
+	if msg.Attempts <= retries {
+		delay := w.delay(msg.Attempts)
+		debug.Debug(debug.Warning, fmt.Sprintf("workers: queue %s: message %s failed attempt %d, retry in %s: %v", w.queue, msg.ID, msg.Attempts, delay, err))
+		if err := backend.Retry(ctx, msg, delay); err != nil {
+			w.settleFailed("retry", msg, err)
+		}
+		return
+	}
+	w.buried.Add(1)
+	debug.Debug(debug.Error, fmt.Sprintf("workers: queue %s: message %s dead after %d attempt(s): %v", w.queue, msg.ID, msg.Attempts, err))
+	if err := backend.Bury(ctx, msg); err != nil {
+		w.settleFailed("bury", msg, err)
+	}
+}
+
+// extend renews the claim of msg every third of the visibility timeout until
+// the returned function is called, which waits for the renewal to stop
+func (w *worker) extend(msg *queue.Message) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(w.visibility / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+			err := queue.Get().Extend(ctx, msg, w.visibility)
+			cancel()
+			if errors.Is(err, queue.ErrClaimLost) {
+				debug.Debug(debug.Warning, fmt.Sprintf("workers: queue %s: message %s became visible again while it ran", w.queue, msg.ID))
+				return
+			}
+			if err != nil {
+				debug.Debug(debug.Error, fmt.Sprintf("workers: queue %s: extend %s: %v", w.queue, msg.ID, err))
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// settleFailed reports a failed ack, retry or bury of msg. A lost claim means
+// another worker may be processing the message, which this run leaves alone.
+func (w *worker) settleFailed(action string, msg *queue.Message, err error) {
+	if errors.Is(err, queue.ErrClaimLost) {
+		debug.Debug(debug.Warning, fmt.Sprintf("workers: queue %s: %s %s skipped: %v", w.queue, action, msg.ID, err))
+		return
+	}
+	debug.Debug(debug.Error, fmt.Sprintf("workers: queue %s: %s %s: %v", w.queue, action, msg.ID, err))
+}
+
+// delay returns the backoff after the given failed attempt: the worker's
+// backoff, doubled on every further attempt
+func (w *worker) delay(attempt int) time.Duration {
+	delay := w.backoff
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// WorkerStats is the state of a worker published by the metrics API
+type WorkerStats struct {
+	Module      string      `json:"module"`
+	Queue       string      `json:"queue"`
+	Concurrency int         `json:"concurrency"`
+	Running     int64       `json:"running"`
+	Processed   int64       `json:"processed"`
+	Failed      int64       `json:"failed"`
+	Dead        int64       `json:"dead"`
+	Messages    queue.Stats `json:"messages"`
+}
+
+// Stats is the pool's snapshot for the metrics API. Processed, Failed and
+// Dead count the messages of this process; Messages counts the queue.
+type Stats struct {
+	Backend string        `json:"backend"`
+	Workers []WorkerStats `json:"workers"`
+}
+
+// Stats returns the workers with their counters and queue sizes
+func (p *Pool) Stats() Stats {
+	backend := queue.Get()
+	stats := Stats{Backend: backend.Name(), Workers: []WorkerStats{}}
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+	for _, w := range p.workers {
+		entry := WorkerStats{
+			Module:      w.module.Name,
+			Queue:       w.config.Queue,
+			Concurrency: w.concurrency,
+			Running:     w.running.Load(),
+			Processed:   w.processed.Load(),
+			Failed:      w.failed.Load(),
+			Dead:        w.buried.Load(),
+		}
+		messages, err := backend.Stats(ctx, w.queue)
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("workers: queue %s: stats: %v", w.queue, err))
+		}
+		entry.Messages = messages
+		stats.Workers = append(stats.Workers, entry)
+	}
+	return stats
+}
+
+// Get returns the application wide pool, nil before Start started any worker
+func Get() *Pool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	return instance
+}
@@ -0,0 +1,211 @@
+package utilities
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/degreane/octopus/internal/service/cache"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// NewCacheTable builds the eocto.cache table. Keys and tags are namespaced
+// with the module name so modules sharing the storage never collide.
+//
+// Usage in Lua:
+//
+//	local cfg = eocto.cache.remember("modules", 60, function()
+//	    return eocto.decodeJSON(eocto.readYamlFile("config/modules.yaml"))
+//	end, {"config"})
+//	eocto.cache.invalidateTags("config")
+func NewCacheTable(L *lua.LState, namespace string) *lua.LTable {
+	prefix := namespace + ":"
+	tbl := L.NewTable()
+	tbl.RawSetString("get", L.NewFunction(cacheGet(prefix)))
+	tbl.RawSetString("set", L.NewFunction(cacheSet(prefix)))
+	tbl.RawSetString("delete", L.NewFunction(cacheDelete(prefix)))
+	tbl.RawSetString("remember", L.NewFunction(cacheRemember(prefix)))
+	tbl.RawSetString("invalidateTags", L.NewFunction(cacheInvalidateTags(prefix)))
+	tbl.RawSetString("stats", L.NewFunction(cacheStats))
+	tbl.RawSetString("purgeRoute", L.NewFunction(cachePurgeRoute))
+	return tbl
+}
+
+// cacheGet exposes eocto.cache.get(key), returning the cached value or nil
+func cacheGet(prefix string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		key := L.CheckString(1)
+		value, found, err := cache.Get().Get(prefix + key)
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error reading cache key %s: %v", key, err))
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		if !found {
+			L.Push(lua.LNil)
+			return 1
+		}
+		decoded, err := decodeCacheValue(L, value)
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(decoded)
+		return 1
+	}
+}
+
+// cacheSet exposes eocto.cache.set(key, value, ttl, tags)
+func cacheSet(prefix string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		key := L.CheckString(1)
+		value := L.CheckAny(2)
+		ttl := time.Duration(L.OptNumber(3, 0) * lua.LNumber(time.Second))
+		tags := cacheTagsFromValue(prefix, L.Get(4))
+
+		payload, err := json.Marshal(convertLuaValueToGo(value))
+		if err == nil {
+			err = cache.Get().Set(prefix+key, payload, ttl, tags...)
+		}
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error writing cache key %s: %v", key, err))
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(lua.LTrue)
+		return 1
+	}
+}
+
+// cacheDelete exposes eocto.cache.delete(key)
+func cacheDelete(prefix string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		key := L.CheckString(1)
+		if err := cache.Get().Delete(prefix + key); err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error deleting cache key %s: %v", key, err))
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(lua.LTrue)
+		return 1
+	}
+}
+
+// cacheRemember exposes eocto.cache.remember(key, ttl, fn, tags). On a miss fn
+// is called once, even when several requests miss the same key concurrently,
+// and its first return value is cached. A nil result or a raised error is not cached.
+func cacheRemember(prefix string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		key := L.CheckString(1)
+		ttl := time.Duration(L.CheckNumber(2) * lua.LNumber(time.Second))
+		fn := L.CheckFunction(3)
+		tags := cacheTagsFromValue(prefix, L.Get(4))
+
+		value, _, err := cache.Get().Remember(prefix+key, ttl, tags, func() ([]byte, error) {
+			if err := L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}); err != nil {
+				return nil, err
+			}
+			result := L.Get(-1)
+			L.Pop(1)
+			if result == lua.LNil {
+				return nil, errors.New("remember function returned nil")
+			}
+			return json.Marshal(convertLuaValueToGo(result))
+		})
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error remembering cache key %s: %v", key, err))
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+
+		decoded, err := decodeCacheValue(L, value)
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(decoded)
+		return 1
+	}
+}
+
+// cacheInvalidateTags exposes eocto.cache.invalidateTags(tag, ...) and
+// eocto.cache.invalidateTags({tag, ...})
+func cacheInvalidateTags(prefix string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		var tags []string
+		for i := 1; i <= L.GetTop(); i++ {
+			tags = append(tags, cacheTagsFromValue(prefix, L.Get(i))...)
+		}
+		if err := cache.Get().InvalidateTags(tags...); err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error invalidating cache tags %v: %v", tags, err))
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(lua.LTrue)
+		return 1
+	}
+}
+
+// cacheStats exposes eocto.cache.stats(), the counters shown under /metrics/stats
+func cacheStats(L *lua.LState) int {
+	stats := cache.Get().Stats()
+	tbl := L.NewTable()
+	tbl.RawSetString("backend", lua.LString(stats.Backend))
+	tbl.RawSetString("hits", lua.LNumber(stats.Hits))
+	tbl.RawSetString("misses", lua.LNumber(stats.Misses))
+	tbl.RawSetString("hitRatio", lua.LNumber(stats.HitRatio))
+	tbl.RawSetString("sets", lua.LNumber(stats.Sets))
+	tbl.RawSetString("deletes", lua.LNumber(stats.Deletes))
+	tbl.RawSetString("invalidations", lua.LNumber(stats.Invalidations))
+	tbl.RawSetString("shared", lua.LNumber(stats.Shared))
+	L.Push(tbl)
+	return 1
+}
+
+// cachePurgeRoute exposes eocto.cache.purgeRoute(path), expiring the cached
+// responses of a route pattern ("/OC/users/:id") or request path ("/OC/users/42")
+func cachePurgeRoute(L *lua.LState) int {
+	path := L.CheckString(1)
+	if err := cache.PurgeRoute(path); err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("Error purging route cache %s: %v", path, err))
+		L.Push(lua.LBool(false))
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// cacheTagsFromValue reads a tag string or an array of tags, namespaced with prefix
+func cacheTagsFromValue(prefix string, value lua.LValue) []string {
+	var tags []string
+	switch v := value.(type) {
+	case lua.LString:
+		tags = append(tags, prefix+string(v))
+	case *lua.LTable:
+		v.ForEach(func(_, tag lua.LValue) {
+			if s, ok := tag.(lua.LString); ok {
+				tags = append(tags, prefix+string(s))
+			}
+		})
+	}
+	return tags
+}
+
+// decodeCacheValue converts a JSON encoded cache value back to a Lua value
+func decodeCacheValue(L *lua.LState, value []byte) (lua.LValue, error) {
+	var decoded interface{}
+	if err := json.Unmarshal(value, &decoded); err != nil {
+		return lua.LNil, fmt.Errorf("error decoding cache value: %w", err)
+	}
+	return convertGoToLua(L, decoded), nil
+}
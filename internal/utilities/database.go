@@ -1,6 +1,8 @@
 package utilities
 
 import (
+	"context"
+
 	"github.com/degreane/octopus/internal/database"
 	lua "github.com/yuin/gopher-lua"
 )
@@ -19,15 +21,18 @@ func GetDataFromCollectionLua(L *lua.LState) int {
 	var filterData interface{}
 	filterData = tableToInterface(filter)
 
-	result, err := database.GetDataFromCollection(uri, dbName, collectionName, filterData)
-	if err != nil {
-		L.Push(lua.LNil)
-		L.Push(lua.LString(err.Error()))
-		return 2
-	}
-
-	L.Push(lua.LString(result))
-	return 1
+	return Blocking(L, func(context.Context) func(L *lua.LState) int {
+		result, err := database.GetDataFromCollection(uri, dbName, collectionName, filterData)
+		return func(L *lua.LState) int {
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+			L.Push(lua.LString(result))
+			return 1
+		}
+	})
 }
 
 // SetDataToCollectionLua is a Lua binding function that updates data in a MongoDB collection
@@ -45,15 +50,18 @@ func SetDataToCollectionLua(L *lua.LState) int {
 	filterData := tableToInterface(filter)
 	updateData := tableToInterface(data)
 
-	result, err := database.SetDataToCollection(uri, dbName, collectionName, filterData, updateData)
-	if err != nil {
-		L.Push(lua.LNil)
-		L.Push(lua.LString(err.Error()))
-		return 2
-	}
-
-	L.Push(lua.LString(result))
-	return 1
+	return Blocking(L, func(context.Context) func(L *lua.LState) int {
+		result, err := database.SetDataToCollection(uri, dbName, collectionName, filterData, updateData)
+		return func(L *lua.LState) int {
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+			L.Push(lua.LString(result))
+			return 1
+		}
+	})
 }
 
 // DelDataFromCollectionLua is a Lua binding function that deletes data from a MongoDB collection
@@ -69,15 +77,18 @@ func DelDataFromCollectionLua(L *lua.LState) int {
 
 	filterData := tableToInterface(filter)
 
-	result, err := database.DelDataFromCollection(uri, dbName, collectionName, filterData)
-	if err != nil {
-		L.Push(lua.LNil)
-		L.Push(lua.LString(err.Error()))
-		return 2
-	}
-
-	L.Push(lua.LString(result))
-	return 1
+	return Blocking(L, func(context.Context) func(L *lua.LState) int {
+		result, err := database.DelDataFromCollection(uri, dbName, collectionName, filterData)
+		return func(L *lua.LState) int {
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+			L.Push(lua.LString(result))
+			return 1
+		}
+	})
 }
 
 // InsertDataToCollectionLua is a Lua binding function that inserts data into a MongoDB collection
@@ -93,15 +104,18 @@ func InsertDataToCollectionLua(L *lua.LState) int {
 
 	insertData := tableToInterface(data)
 
-	result, err := database.InsertDataToCollection(uri, dbName, collectionName, insertData)
-	if err != nil {
-		L.Push(lua.LNil)
-		L.Push(lua.LString(err.Error()))
-		return 2
-	}
-
-	L.Push(lua.LString(result))
-	return 1
+	return Blocking(L, func(context.Context) func(L *lua.LState) int {
+		result, err := database.InsertDataToCollection(uri, dbName, collectionName, insertData)
+		return func(L *lua.LState) int {
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+			L.Push(lua.LString(result))
+			return 1
+		}
+	})
 }
 
 // Helper function to convert Lua tables to Go interface{}
@@ -0,0 +1,134 @@
+package utilities
+
+import (
+	"github.com/gofiber/fiber/v2"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Control-flow actions a preCheck script can take
+const (
+	FlowHalt     = "halt"
+	FlowAbort    = "abort"
+	FlowRedirect = "redirect"
+	FlowNext     = "next"
+)
+
+// flowLocal is the Fiber local holding the pending control-flow decision
+const flowLocal = "eocto_flow"
+
+// flowSignal is the error raised to unwind a script after a control-flow call
+const flowSignal = "eocto: control flow"
+
+// Flow is a control-flow decision taken by a script. The route handler
+// applies it once the script has unwound: halt and abort stop the handler
+// chain, redirect answers with a redirect, next continues with the chain.
+type Flow struct {
+	Action string
+	Status int
+	// Body is a string (sent as text) or a map/slice (sent as JSON)
+	Body interface{}
+	// View is a module view rendered with Data instead of Body
+	View string
+	Data fiber.Map
+	URL  string
+}
+
+// TakeFlow returns and clears the pending control-flow decision of the request
+func TakeFlow(c *fiber.Ctx) *Flow {
+	flow, ok := c.Locals(flowLocal).(*Flow)
+	if !ok {
+		return nil
+	}
+	c.Locals(flowLocal, nil)
+	return flow
+}
+
+// setFlow records a decision and unwinds the calling script
+func setFlow(c *fiber.Ctx, L *lua.LState, flow *Flow) int {
+	c.Locals(flowLocal, flow)
+	L.RaiseError(flowSignal)
+	return 0
+}
+
+// Halt returns a Lua function that stops the script, the remaining preChecks
+// and the view rendering; whatever response the script already set is sent.
+//
+// Usage in Lua:
+//
+//	eocto.renderJson({error = "quota exceeded"}, 429)
+//	eocto.halt()
+func Halt(c *fiber.Ctx) lua.LGFunction {
+	return func(L *lua.LState) int {
+		return setFlow(c, L, &Flow{Action: FlowHalt})
+	}
+}
+
+// Abort returns a Lua function that stops the request with a status and an
+// optional body: a string is sent as text, a table as JSON, and a table with
+// a view field renders that module view with its data field.
+//
+// Usage in Lua:
+//
+//	eocto.abort(401)
+//	eocto.abort(403, "forbidden")
+//	eocto.abort(422, {error = "invalid email"})
+//	eocto.abort(403, {view = "pages/forbidden", data = {reason = "plan"}})
+func Abort(c *fiber.Ctx) lua.LGFunction {
+	return func(L *lua.LState) int {
+		flow := &Flow{Action: FlowAbort, Status: L.CheckInt(1)}
+		switch body := L.Get(2).(type) {
+		case lua.LString:
+			flow.Body = string(body)
+		case *lua.LTable:
+			if view, ok := body.RawGetString("view").(lua.LString); ok {
+				flow.View = string(view)
+				flow.Data = fiber.Map{}
+				if data, ok := body.RawGetString("data").(*lua.LTable); ok {
+					flow.Data = luaTableToMap(data)
+				}
+			} else {
+				flow.Body = convertLuaTableToGo(body)
+			}
+		}
+		return setFlow(c, L, flow)
+	}
+}
+
+// Redirect returns a Lua function that stops the request with a redirect
+// (302 by default). HTMX requests get an HX-Redirect header instead, so the
+// browser navigates rather than swapping the target page into the DOM.
+//
+// Usage in Lua:
+//
+//	eocto.redirect("/OC/login")
+//	eocto.redirect("/OC/new-home", 301)
+func Redirect(c *fiber.Ctx) lua.LGFunction {
+	return func(L *lua.LState) int {
+		return setFlow(c, L, &Flow{
+			Action: FlowRedirect,
+			URL:    L.CheckString(1),
+			Status: L.OptInt(2, fiber.StatusFound),
+		})
+	}
+}
+
+// Next returns a Lua function that ends the script and continues with the
+// next preCheck or the view
+//
+// Usage in Lua:
+//
+//	if eocto.getSession("user") then eocto.next() end
+func Next(c *fiber.Ctx) lua.LGFunction {
+	return func(L *lua.LState) int {
+		return setFlow(c, L, &Flow{Action: FlowNext})
+	}
+}
+
+// ApplyRedirect answers c with a redirect, using HX-Redirect for HTMX requests
+func ApplyRedirect(c *fiber.Ctx, url string, status int) error {
+	if c.Get("HX-Request") == "true" {
+		c.Set("HX-Redirect", url)
+		return c.SendStatus(fiber.StatusOK)
+	}
+	return c.Redirect(url, status)
+}
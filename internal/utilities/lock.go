@@ -0,0 +1,57 @@
+package utilities
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/degreane/octopus/internal/service/lock"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// LockLua runs a function while holding a named lock, shared by every server
+// process when Storage is "redis". The function receives the lock's fencing
+// token; its return values are returned. The lock is released when the
+// function returns or raises an error, and expires after ttl seconds otherwise.
+//
+// Usage in Lua:
+//
+//	local doc, err = eocto.lock("order:" .. id, 10, function(fence)
+//	    return eocto.insertDataToCollection("orders", {id = id, fence = fence})
+//	end, {wait = 5})
+func LockLua(L *lua.LState) int {
+	name := L.CheckString(1)
+	ttl := time.Duration(L.CheckNumber(2) * lua.LNumber(time.Second))
+	fn := L.CheckFunction(3)
+
+	// By default wait as long as the current holder may keep the lock
+	wait := ttl
+	if opts, ok := L.Get(4).(*lua.LTable); ok {
+		if w, ok := opts.RawGetString("wait").(lua.LNumber); ok {
+			wait = time.Duration(w * lua.LNumber(time.Second))
+		}
+	}
+
+	ctx := luaContext(L)
+	locker := lock.Get()
+	lease, err := lock.Acquire(ctx, locker, name, ttl, wait)
+	if err != nil {
+		debug.Debug(debug.Warning, fmt.Sprintf("Lock %s not acquired: %v", name, err))
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	defer func() {
+		if err := locker.Release(ctx, lease); err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error releasing lock %s: %v", name, err))
+		}
+	}()
+
+	top := L.GetTop()
+	if err := L.CallByParam(lua.P{Fn: fn, NRet: lua.MultRet, Protect: true}, lua.LNumber(lease.Fence)); err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	return L.GetTop() - top
+}
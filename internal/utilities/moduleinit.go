@@ -0,0 +1,85 @@
+// Package utilities provides helper functions and utilities for the application.
+//
+// This file keeps the values returned by the modules' init scripts and
+// exposes them to request scripts as read-only tables.
+package utilities
+
+import (
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// initValues holds the value each module's init script returned, converted
+// to Go so every Lua state gets its own copy
+var initValues sync.Map
+
+// SetInitValue records the value the init script of module returned
+func SetInitValue(module string, value lua.LValue) {
+	initValues.Store(module, convertLuaValueToGo(value))
+}
+
+// NewInitTable returns eocto.init for a state of module: a read-only view of
+// the value its init script returned. Writes raise an error; calling the
+// table, or any table inside it, returns a copy that can be changed and
+// iterated with pairs.
+//
+// Usage in Lua:
+//
+//	local plan = eocto.init.plans[user.plan]
+//	for name, role in pairs(eocto.init.roles()) do ... end
+func NewInitTable(L *lua.LState, module string) *lua.LTable {
+	value, _ := initValues.Load(module)
+	if _, ok := value.(map[string]interface{}); !ok {
+		if _, ok := value.([]interface{}); !ok {
+			// Scalars and nothing at all both read as an empty table
+			value = map[string]interface{}{}
+		}
+	}
+	return readOnly(L, value)
+}
+
+// readOnly returns a proxy of the table value converts to, rejecting writes.
+// The tables inside it are proxies too.
+func readOnly(L *lua.LState, value interface{}) *lua.LTable {
+	data := L.NewTable()
+	switch v := value.(type) {
+	case []interface{}:
+		for i, item := range v {
+			data.RawSetInt(i+1, initEntry(L, item))
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			data.RawSetString(k, initEntry(L, item))
+		}
+	}
+
+	meta := L.NewTable()
+	meta.RawSetString("__index", data)
+	meta.RawSetString("__newindex", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("eocto.init is read-only; call it for a copy")
+		return 0
+	}))
+	meta.RawSetString("__len", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LNumber(data.Len()))
+		return 1
+	}))
+	meta.RawSetString("__call", L.NewFunction(func(L *lua.LState) int {
+		L.Push(convertGoToLua(L, value))
+		return 1
+	}))
+	meta.RawSetString("__metatable", lua.LString("read-only"))
+
+	proxy := L.NewTable()
+	L.SetMetatable(proxy, meta)
+	return proxy
+}
+
+// initEntry converts an entry of an init value, tables to read-only proxies
+func initEntry(L *lua.LState, value interface{}) lua.LValue {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		return readOnly(L, value)
+	}
+	return convertGoToLua(L, value)
+}
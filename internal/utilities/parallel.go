@@ -0,0 +1,268 @@
+package utilities
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/degreane/octopus/internal/service/limits"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// taskKey is the context key of the eocto.parallel task a thread runs
+type taskKey struct{}
+
+// task is a function of eocto.parallel running on its own Lua thread
+type task struct {
+	index  int
+	thread *lua.LState
+	// io is the I/O the task yielded on, started by the scheduler
+	io func(ctx context.Context) func(L *lua.LState) int
+	// result and err are set once the task is finished
+	result lua.LValue
+	err    string
+	done   bool
+}
+
+// completion is the I/O of a task, finished
+type completion struct {
+	task *task
+	push func(L *lua.LState) int
+}
+
+// Blocking runs the I/O of a binding. In a task of eocto.parallel the task
+// yields and io runs on its own goroutine while the other tasks go on;
+// anywhere else io runs in place. io must not touch the Lua state: it returns
+// the function pushing the binding's results, which runs on the state.
+func Blocking(L *lua.LState, io func(ctx context.Context) func(L *lua.LState) int) int {
+	ctx := luaContext(L)
+	if t, ok := ctx.Value(taskKey{}).(*task); ok && t.thread == L {
+		t.io = io
+		return L.Yield()
+	}
+	return io(ctx)(L)
+}
+
+// ParallelLua implements eocto.parallel(tasks, options): it runs the functions
+// of tasks as coroutines, overlapping the I/O of their bindings (HTTP, MongoDB,
+// Redis) while Lua code itself runs one task at a time. It returns the first
+// value each task returned, in order, and a table of the errors of the tasks
+// that failed or were still running at the timeout (nil when all succeeded).
+//
+// Usage in Lua:
+//
+//	local results, errs = eocto.parallel({
+//	    function() return eocto.makeRequest("GET", "https://a.example.com") end,
+//	    function() return eocto.redis.get("b") end,
+//	}, {timeout = 2})
+func ParallelLua(L *lua.LState) int {
+	fns := L.CheckTable(1)
+	ctx, cancel := withTimeout(luaContext(L), parallelTimeout(L.OptTable(2, nil)))
+	defer cancel()
+
+	var tasks []*task
+	for i := 1; i <= fns.Len(); i++ {
+		fn, ok := fns.RawGetInt(i).(*lua.LFunction)
+		if !ok {
+			L.ArgError(1, fmt.Sprintf("task %d is not a function", i))
+		}
+		thread, stop := L.NewThread()
+		if stop != nil {
+			defer stop()
+		}
+		t := &task{index: i, thread: thread}
+		// Tasks run under the script's deadline and take their instructions
+		// from its budget
+		thread.SetContext(limits.Inherit(stateContext(L), context.WithValue(ctx, taskKey{}, t)))
+		tasks = append(tasks, t)
+		resumeTask(L, t, fn)
+	}
+
+	completions := make(chan completion, len(tasks))
+	pending := startIO(ctx, tasks, completions)
+	for pending > 0 {
+		select {
+		case c := <-completions:
+			top := L.GetTop()
+			n := c.push(L)
+			values := make([]lua.LValue, n)
+			for i := range values {
+				values[i] = L.Get(top + 1 + i)
+			}
+			L.SetTop(top)
+			resumeTask(L, c.task, nil, values...)
+			pending += startIO(ctx, []*task{c.task}, completions) - 1
+		case <-ctx.Done():
+			pending = 0
+		}
+	}
+
+	results := L.NewTable()
+	var errs *lua.LTable
+	for _, t := range tasks {
+		if !t.done {
+			t.err = fmt.Sprintf("task %d did not finish: %v", t.index, ctx.Err())
+		}
+		if t.err != "" {
+			if errs == nil {
+				errs = L.NewTable()
+			}
+			errs.RawSetInt(t.index, lua.LString(t.err))
+			continue
+		}
+		results.RawSetInt(t.index, t.result)
+	}
+	L.Push(results)
+	if errs == nil {
+		L.Push(lua.LNil)
+	} else {
+		L.Push(errs)
+	}
+	return 2
+}
+
+// parallelTimeout reads the timeout option, in seconds
+func parallelTimeout(opts *lua.LTable) time.Duration {
+	if opts != nil {
+		if timeout, ok := opts.RawGetString("timeout").(lua.LNumber); ok && timeout > 0 {
+			return time.Duration(float64(timeout) * float64(time.Second))
+		}
+	}
+	return 0
+}
+
+// withTimeout bounds parent by timeout, when there is one
+func withTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(parent, timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// resumeTask runs t until it finishes or yields on I/O
+func resumeTask(L *lua.LState, t *task, fn *lua.LFunction, args ...lua.LValue) {
+	state, err, values := L.Resume(t.thread, fn, args...)
+	switch state {
+	case lua.ResumeOK:
+		t.done = true
+		t.result = lua.LNil
+		if len(values) > 0 {
+			t.result = values[0]
+		}
+	case lua.ResumeError:
+		t.done = true
+		t.err = err.Error()
+	default:
+		if t.io == nil {
+			t.done = true
+			t.err = "tasks of eocto.parallel cannot yield"
+		}
+	}
+}
+
+// startIO starts the I/O the tasks yielded on and returns how many were started
+func startIO(ctx context.Context, tasks []*task, completions chan<- completion) int {
+	started := 0
+	for _, t := range tasks {
+		if t.done || t.io == nil {
+			continue
+		}
+		run := t.io
+		t.io = nil
+		started++
+		go func(t *task) {
+			defer func() {
+				if r := recover(); r != nil {
+					debug.Debug(debug.Error, fmt.Sprintf("eocto.parallel: task %d panicked: %v", t.index, r))
+					completions <- completion{task: t, push: func(L *lua.LState) int {
+						L.Push(lua.LNil)
+						L.Push(lua.LString(fmt.Sprint(r)))
+						return 2
+					}}
+				}
+			}()
+			completions <- completion{task: t, push: run(ctx)}
+		}(t)
+	}
+	return started
+}
+
+// httpRequest is an entry of eocto.http.all
+type httpRequest struct {
+	method  string
+	url     string
+	headers map[string]string
+	body    io.Reader
+}
+
+// HTTPAllLua implements eocto.http.all(requests, options): it sends the
+// requests concurrently and returns their responses in order, shaped like the
+// responses of eocto.makeRequest. A request that fails, or is still running at
+// the timeout, has a non-empty error field.
+//
+// Usage in Lua:
+//
+//	local a, b = unpack(eocto.http.all({
+//	    {url = "https://a.example.com"},
+//	    {method = "POST", url = "https://b.example.com", headers = {["Content-Type"] = "application/json"}, body = "{}"},
+//	}, {timeout = 3}))
+func HTTPAllLua(L *lua.LState) int {
+	list := L.CheckTable(1)
+	requests := make([]httpRequest, list.Len())
+	for i := range requests {
+		entry, ok := list.RawGetInt(i + 1).(*lua.LTable)
+		if !ok {
+			L.ArgError(1, fmt.Sprintf("request %d is not a table", i+1))
+		}
+		req := httpRequest{
+			method:  strings.ToUpper(lua.LVAsString(entry.RawGetString("method"))),
+			url:     lua.LVAsString(entry.RawGetString("url")),
+			headers: make(map[string]string),
+		}
+		if req.method == "" {
+			req.method = "GET"
+		}
+		if headers, ok := entry.RawGetString("headers").(*lua.LTable); ok {
+			headers.ForEach(func(k, v lua.LValue) {
+				req.headers[k.String()] = v.String()
+			})
+		}
+		if body := entry.RawGetString("body"); body != lua.LNil {
+			req.body = strings.NewReader(body.String())
+		}
+		requests[i] = req
+	}
+	timeout := parallelTimeout(L.OptTable(2, nil))
+
+	return Blocking(L, func(parent context.Context) func(L *lua.LState) int {
+		ctx, cancel := withTimeout(parent, timeout)
+		defer cancel()
+		responses := make([]map[string]interface{}, len(requests))
+		done := make(chan int, len(requests))
+		for i, req := range requests {
+			go func(i int, req httpRequest) {
+				res := MakeHTTPRequestContext(ctx, req.method, req.url, req.headers, req.body)
+				if res["error"] != "" && ctx.Err() != nil {
+					res["status"] = 504
+					res["error"] = fmt.Sprintf("request did not finish: %v", ctx.Err())
+				}
+				responses[i] = res
+				done <- i
+			}(i, req)
+		}
+		for range requests {
+			<-done
+		}
+		return func(L *lua.LState) int {
+			tbl := L.NewTable()
+			for i, res := range responses {
+				tbl.RawSetInt(i+1, responseTable(L, res))
+			}
+			L.Push(tbl)
+			return 1
+		}
+	})
+}
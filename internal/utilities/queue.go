@@ -0,0 +1,153 @@
+package utilities
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/degreane/octopus/internal/service/queue"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// NewQueueTable builds the eocto.queue table. Queue names are namespaced with
+// the module name, like the workers the module declares.
+//
+// Usage in Lua:
+//
+//	local id, err = eocto.queue.enqueue("whatsapp", {to = phone, text = "Welcome"}, {delay = 5, retries = 2})
+//	local stats = eocto.queue.stats("whatsapp")
+func NewQueueTable(L *lua.LState, namespace string) *lua.LTable {
+	prefix := namespace + ":"
+	tbl := L.NewTable()
+	tbl.RawSetString("enqueue", L.NewFunction(queueEnqueue(prefix)))
+	tbl.RawSetString("stats", L.NewFunction(queueStats(prefix)))
+	tbl.RawSetString("dead", L.NewFunction(queueDead(prefix)))
+	tbl.RawSetString("requeue", L.NewFunction(queueRequeue(prefix)))
+	return tbl
+}
+
+// queueEnqueue exposes eocto.queue.enqueue(name, payload, {delay, retries}),
+// returning the message id or nil and an error
+func queueEnqueue(prefix string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		name := L.CheckString(1)
+		payload, err := json.Marshal(convertLuaValueToGo(L.Get(2)))
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(fmt.Sprintf("payload: %v", err)))
+			return 2
+		}
+		var delay time.Duration
+		retries := -1
+		if opts := L.OptTable(3, nil); opts != nil {
+			if d, ok := opts.RawGetString("delay").(lua.LNumber); ok && d > 0 {
+				delay = time.Duration(float64(d) * float64(time.Second))
+			}
+			if r, ok := opts.RawGetString("retries").(lua.LNumber); ok && r >= 0 {
+				retries = int(r)
+			}
+		}
+
+		return Blocking(L, func(ctx context.Context) func(L *lua.LState) int {
+			id, err := queue.Enqueue(ctx, prefix+name, payload, delay, retries)
+			return func(L *lua.LState) int {
+				if err != nil {
+					debug.Debug(debug.Error, fmt.Sprintf("Error enqueueing to %s: %v", name, err))
+					L.Push(lua.LNil)
+					L.Push(lua.LString(err.Error()))
+					return 2
+				}
+				L.Push(lua.LString(id))
+				return 1
+			}
+		})
+	}
+}
+
+// queueStats exposes eocto.queue.stats(name)
+func queueStats(prefix string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		name := L.CheckString(1)
+		return Blocking(L, func(ctx context.Context) func(L *lua.LState) int {
+			stats, err := queue.Get().Stats(ctx, prefix+name)
+			return func(L *lua.LState) int {
+				if err != nil {
+					L.Push(lua.LNil)
+					L.Push(lua.LString(err.Error()))
+					return 2
+				}
+				tbl := L.NewTable()
+				tbl.RawSetString("ready", lua.LNumber(stats.Ready))
+				tbl.RawSetString("delayed", lua.LNumber(stats.Delayed))
+				tbl.RawSetString("inFlight", lua.LNumber(stats.InFlight))
+				tbl.RawSetString("dead", lua.LNumber(stats.Dead))
+				L.Push(tbl)
+				return 1
+			}
+		})
+	}
+}
+
+// queueDead exposes eocto.queue.dead(name, limit), listing the dead letters
+// newest first
+func queueDead(prefix string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		name := L.CheckString(1)
+		limit := L.OptInt(2, 20)
+		return Blocking(L, func(ctx context.Context) func(L *lua.LState) int {
+			dead, err := queue.Get().Dead(ctx, prefix+name, limit)
+			return func(L *lua.LState) int {
+				if err != nil {
+					L.Push(lua.LNil)
+					L.Push(lua.LString(err.Error()))
+					return 2
+				}
+				list := L.NewTable()
+				for _, msg := range dead {
+					entry := L.NewTable()
+					entry.RawSetString("id", lua.LString(msg.ID))
+					entry.RawSetString("payload", DecodePayload(L, msg.Payload))
+					entry.RawSetString("attempts", lua.LNumber(msg.Attempts))
+					entry.RawSetString("error", lua.LString(msg.Error))
+					entry.RawSetString("enqueued", lua.LNumber(msg.Enqueued.Unix()))
+					list.Append(entry)
+				}
+				L.Push(list)
+				return 1
+			}
+		})
+	}
+}
+
+// queueRequeue exposes eocto.queue.requeue(name), making the dead letters due
+// again and returning how many there were
+func queueRequeue(prefix string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		name := L.CheckString(1)
+		return Blocking(L, func(ctx context.Context) func(L *lua.LState) int {
+			n, err := queue.Get().Requeue(ctx, prefix+name)
+			return func(L *lua.LState) int {
+				L.Push(lua.LNumber(n))
+				if err != nil {
+					L.Push(lua.LString(err.Error()))
+					return 2
+				}
+				return 1
+			}
+		})
+	}
+}
+
+// DecodePayload converts the JSON payload of a queue message to a Lua value
+func DecodePayload(L *lua.LState, payload []byte) lua.LValue {
+	if len(payload) == 0 {
+		return lua.LNil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		return lua.LString(payload)
+	}
+	return convertGoToLua(L, decoded)
+}
@@ -23,16 +23,18 @@ func GetRedisValueLua(L *lua.LState) int {
 		return 1
 	}
 
-	ctx := context.Background()
-	val, err := redisClient.Get(ctx, key).Result()
-	if err != nil {
-		// Key doesn't exist or other error
-		L.Push(lua.LNil)
-		return 1
-	}
-
-	L.Push(lua.LString(val))
-	return 1
+	return Blocking(L, func(ctx context.Context) func(L *lua.LState) int {
+		val, err := redisClient.Get(ctx, key).Result()
+		return func(L *lua.LState) int {
+			if err != nil {
+				// Key doesn't exist or other error
+				L.Push(lua.LNil)
+				return 1
+			}
+			L.Push(lua.LString(val))
+			return 1
+		}
+	})
 }
 
 // SetRedisValueLua sets a value in Redis with an optional TTL
@@ -57,25 +59,25 @@ func SetRedisValueLua(L *lua.LState) int {
 		return 1
 	}
 
-	ctx := context.Background()
-	var err error
-
-	if ttl > 0 {
-		// Set with expiration
-		err = redisClient.Set(ctx, key, value, ttl).Err()
-	} else {
-		// Set without expiration
-		err = redisClient.Set(ctx, key, value, 0).Err()
-	}
-
-	if err != nil {
-		debug.Debug(debug.Error, fmt.Sprintf("Error setting Redis key %s: %v", key, err))
-		L.Push(lua.LBool(false))
-		return 1
-	}
-
-	L.Push(lua.LBool(true))
-	return 1
+	return Blocking(L, func(ctx context.Context) func(L *lua.LState) int {
+		var err error
+		if ttl > 0 {
+			// Set with expiration
+			err = redisClient.Set(ctx, key, value, ttl).Err()
+		} else {
+			// Set without expiration
+			err = redisClient.Set(ctx, key, value, 0).Err()
+		}
+		return func(L *lua.LState) int {
+			if err != nil {
+				debug.Debug(debug.Error, fmt.Sprintf("Error setting Redis key %s: %v", key, err))
+				L.Push(lua.LBool(false))
+				return 1
+			}
+			L.Push(lua.LBool(true))
+			return 1
+		}
+	})
 }
 
 // DeleteRedisKeyLua deletes a key from Redis
@@ -91,14 +93,16 @@ func DeleteRedisKeyLua(L *lua.LState) int {
 		return 1
 	}
 
-	ctx := context.Background()
-	_, err := redisClient.Del(ctx, key).Result()
-	if err != nil {
-		debug.Debug(debug.Error, fmt.Sprintf("Error deleting Redis key %s: %v", key, err))
-		L.Push(lua.LBool(false))
-		return 1
-	}
-
-	L.Push(lua.LBool(true))
-	return 1
+	return Blocking(L, func(ctx context.Context) func(L *lua.LState) int {
+		_, err := redisClient.Del(ctx, key).Result()
+		return func(L *lua.LState) int {
+			if err != nil {
+				debug.Debug(debug.Error, fmt.Sprintf("Error deleting Redis key %s: %v", key, err))
+				L.Push(lua.LBool(false))
+				return 1
+			}
+			L.Push(lua.LBool(true))
+			return 1
+		}
+	})
 }
@@ -0,0 +1,626 @@
+// Package utilities provides helper functions and utilities for the application.
+//
+// This file implements the eocto.redis table, exposing the Redis data
+// structures (strings, counters, hashes, lists, sets and sorted sets) as well
+// as pipelines, MULTI transactions, EVAL and key scanning to Lua scripts.
+package utilities
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/degreane/octopus/internal/database"
+	"github.com/degreane/octopus/internal/service/limits"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	rds "github.com/redis/go-redis/v9"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// redisCommand queues or executes a single Redis command on c using the
+// arguments currently on the Lua stack. keyFn applies the module key prefix.
+type redisCommand func(ctx context.Context, L *lua.LState, c rds.Cmdable, keyFn func(string) string) rds.Cmder
+
+// redisCommands maps eocto.redis function names to their command builders.
+// Every entry is available both directly on eocto.redis and on the pipeline
+// object passed to eocto.redis.pipeline / eocto.redis.multi.
+var redisCommands = map[string]redisCommand{
+	// strings and counters
+	"get": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.Get(ctx, k(L.CheckString(1)))
+	},
+	"set": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.Set(ctx, k(L.CheckString(1)), luaToRedisArg(L.Get(2)), time.Duration(L.OptNumber(3, 0))*time.Second)
+	},
+	"setNX": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.SetNX(ctx, k(L.CheckString(1)), luaToRedisArg(L.Get(2)), time.Duration(L.OptNumber(3, 0))*time.Second)
+	},
+	"del": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.Del(ctx, redisKeysFromStack(L, 1, k)...)
+	},
+	"exists": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.Exists(ctx, redisKeysFromStack(L, 1, k)...)
+	},
+	"incr": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.IncrBy(ctx, k(L.CheckString(1)), L.OptInt64(2, 1))
+	},
+	"decr": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.DecrBy(ctx, k(L.CheckString(1)), L.OptInt64(2, 1))
+	},
+	"incrByFloat": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.IncrByFloat(ctx, k(L.CheckString(1)), float64(L.CheckNumber(2)))
+	},
+	// expiry
+	"expire": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.Expire(ctx, k(L.CheckString(1)), time.Duration(L.CheckNumber(2))*time.Second)
+	},
+	"ttl": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.TTL(ctx, k(L.CheckString(1)))
+	},
+	"persist": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.Persist(ctx, k(L.CheckString(1)))
+	},
+	// hashes
+	"hget": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.HGet(ctx, k(L.CheckString(1)), L.CheckString(2))
+	},
+	"hset": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		key := k(L.CheckString(1))
+		if tbl, ok := L.Get(2).(*lua.LTable); ok {
+			values := make([]interface{}, 0)
+			tbl.ForEach(func(field, value lua.LValue) {
+				values = append(values, field.String(), luaToRedisArg(value))
+			})
+			return c.HSet(ctx, key, values...)
+		}
+		return c.HSet(ctx, key, L.CheckString(2), luaToRedisArg(L.Get(3)))
+	},
+	"hgetAll": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.HGetAll(ctx, k(L.CheckString(1)))
+	},
+	"hdel": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.HDel(ctx, k(L.CheckString(1)), redisStringsFromStack(L, 2)...)
+	},
+	"hexists": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.HExists(ctx, k(L.CheckString(1)), L.CheckString(2))
+	},
+	"hincr": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.HIncrBy(ctx, k(L.CheckString(1)), L.CheckString(2), L.OptInt64(3, 1))
+	},
+	"hkeys": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.HKeys(ctx, k(L.CheckString(1)))
+	},
+	"hlen": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.HLen(ctx, k(L.CheckString(1)))
+	},
+	// lists
+	"lpush": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.LPush(ctx, k(L.CheckString(1)), redisArgsFromStack(L, 2)...)
+	},
+	"rpush": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.RPush(ctx, k(L.CheckString(1)), redisArgsFromStack(L, 2)...)
+	},
+	"lpop": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.LPop(ctx, k(L.CheckString(1)))
+	},
+	"rpop": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.RPop(ctx, k(L.CheckString(1)))
+	},
+	"lrange": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.LRange(ctx, k(L.CheckString(1)), L.OptInt64(2, 0), L.OptInt64(3, -1))
+	},
+	"llen": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.LLen(ctx, k(L.CheckString(1)))
+	},
+	"ltrim": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.LTrim(ctx, k(L.CheckString(1)), L.CheckInt64(2), L.CheckInt64(3))
+	},
+	"lrem": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.LRem(ctx, k(L.CheckString(1)), L.CheckInt64(2), luaToRedisArg(L.Get(3)))
+	},
+	// sets
+	"sadd": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.SAdd(ctx, k(L.CheckString(1)), redisArgsFromStack(L, 2)...)
+	},
+	"srem": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.SRem(ctx, k(L.CheckString(1)), redisArgsFromStack(L, 2)...)
+	},
+	"smembers": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.SMembers(ctx, k(L.CheckString(1)))
+	},
+	"sismember": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.SIsMember(ctx, k(L.CheckString(1)), luaToRedisArg(L.Get(2)))
+	},
+	"scard": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.SCard(ctx, k(L.CheckString(1)))
+	},
+	// sorted sets
+	"zadd": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		key := k(L.CheckString(1))
+		if tbl, ok := L.Get(2).(*lua.LTable); ok {
+			// zadd(key, {member = score, ...})
+			members := make([]rds.Z, 0)
+			tbl.ForEach(func(member, score lua.LValue) {
+				if n, ok := score.(lua.LNumber); ok {
+					members = append(members, rds.Z{Score: float64(n), Member: member.String()})
+				}
+			})
+			return c.ZAdd(ctx, key, members...)
+		}
+		return c.ZAdd(ctx, key, rds.Z{Score: float64(L.CheckNumber(2)), Member: L.CheckString(3)})
+	},
+	"zincr": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.ZIncrBy(ctx, k(L.CheckString(1)), float64(L.OptNumber(3, 1)), L.CheckString(2))
+	},
+	"zrem": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.ZRem(ctx, k(L.CheckString(1)), redisArgsFromStack(L, 2)...)
+	},
+	"zscore": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.ZScore(ctx, k(L.CheckString(1)), L.CheckString(2))
+	},
+	"zrank": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.ZRank(ctx, k(L.CheckString(1)), L.CheckString(2))
+	},
+	"zrevrank": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.ZRevRank(ctx, k(L.CheckString(1)), L.CheckString(2))
+	},
+	"zcard": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.ZCard(ctx, k(L.CheckString(1)))
+	},
+	"zrange": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		key := k(L.CheckString(1))
+		if L.OptBool(4, false) {
+			return c.ZRangeWithScores(ctx, key, L.OptInt64(2, 0), L.OptInt64(3, -1))
+		}
+		return c.ZRange(ctx, key, L.OptInt64(2, 0), L.OptInt64(3, -1))
+	},
+	"zrevrange": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		key := k(L.CheckString(1))
+		if L.OptBool(4, false) {
+			return c.ZRevRangeWithScores(ctx, key, L.OptInt64(2, 0), L.OptInt64(3, -1))
+		}
+		return c.ZRevRange(ctx, key, L.OptInt64(2, 0), L.OptInt64(3, -1))
+	},
+	"zrangeByScore": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		return c.ZRangeByScoreWithScores(ctx, k(L.CheckString(1)), &rds.ZRangeBy{
+			Min:    L.OptString(2, "-inf"),
+			Max:    L.OptString(3, "+inf"),
+			Offset: L.OptInt64(4, 0),
+			Count:  L.OptInt64(5, 0),
+		})
+	},
+	// scripting
+	"eval": func(ctx context.Context, L *lua.LState, c rds.Cmdable, k func(string) string) rds.Cmder {
+		script := L.CheckString(1)
+		var keys []string
+		if tbl, ok := L.Get(2).(*lua.LTable); ok {
+			tbl.ForEach(func(_, v lua.LValue) {
+				keys = append(keys, k(v.String()))
+			})
+		}
+		var args []interface{}
+		if tbl, ok := L.Get(3).(*lua.LTable); ok {
+			tbl.ForEach(func(_, v lua.LValue) {
+				args = append(args, luaToRedisArg(v))
+			})
+		}
+		return c.Eval(ctx, script, keys, args...)
+	},
+}
+
+// NewRedisTable builds the eocto.redis table for a Lua state.
+//
+// All keys are transparently namespaced with prefix (e.g. "myModule:"), so
+// scripts from different modules cannot clobber each other's keys. An empty
+// prefix disables namespacing.
+//
+// Results are returned with their natural Lua types: integers and floats as
+// numbers, hashes as tables, lists and sets as arrays, sorted-set ranges with
+// scores as arrays of {member = ..., score = ...}. A missing key yields nil.
+// On failure every function returns nil and an error message.
+//
+// Usage in Lua:
+//
+//	local views = eocto.redis.incr("page:views")
+//	eocto.redis.zadd("leaderboard", 120, "alice")
+//	local top = eocto.redis.zrevrange("leaderboard", 0, 9, true)
+//	local results = eocto.redis.pipeline(function(p)
+//	    p.incr("a")
+//	    p.expire("a", 60)
+//	end)
+func NewRedisTable(L *lua.LState, prefix string) *lua.LTable {
+	keyFn := redisKeyPrefixer(prefix)
+	tbl := L.NewTable()
+
+	for name, cmd := range redisCommands {
+		cmd := cmd
+		tbl.RawSetString(name, L.NewFunction(func(L *lua.LState) int {
+			client := database.GetRedisClient()
+			if client == nil {
+				debug.Debug(debug.Error, "Redis client not initialized")
+				L.Push(lua.LNil)
+				L.Push(lua.LString("redis client not initialized"))
+				return 2
+			}
+			// The command is queued with the arguments on the stack and sent
+			// concurrently when called from a task of eocto.parallel
+			pipe := client.Pipeline()
+			result := cmd(luaContext(L), L, pipe, keyFn)
+			return Blocking(L, func(ctx context.Context) func(L *lua.LState) int {
+				pipe.Exec(ctx)
+				return func(L *lua.LState) int {
+					return pushRedisResult(L, result, prefix)
+				}
+			})
+		}))
+	}
+
+	tbl.RawSetString("scan", L.NewFunction(redisScan(prefix, keyFn)))
+	tbl.RawSetString("keys", L.NewFunction(redisKeys(prefix, keyFn)))
+	tbl.RawSetString("pipeline", L.NewFunction(redisPipeline(prefix, keyFn, false)))
+	tbl.RawSetString("multi", L.NewFunction(redisPipeline(prefix, keyFn, true)))
+	tbl.RawSetString("getJSON", L.NewFunction(redisGetJSON(keyFn)))
+	tbl.RawSetString("prefix", lua.LString(prefix))
+	return tbl
+}
+
+// redisScan exposes eocto.redis.scan(cursor, pattern, count), returning one
+// page of keys and the next cursor (0 once the iteration is complete).
+func redisScan(prefix string, keyFn func(string) string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		client := database.GetRedisClient()
+		if client == nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString("redis client not initialized"))
+			return 2
+		}
+		cursor := uint64(L.OptInt64(1, 0))
+		pattern := keyFn(L.OptString(2, "*"))
+		count := L.OptInt64(3, 100)
+
+		keys, next, err := client.Scan(luaContext(L), cursor, pattern, count).Result()
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error scanning Redis keys %s: %v", pattern, err))
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(redisStringsToLua(L, keys, prefix))
+		L.Push(lua.LNumber(next))
+		return 2
+	}
+}
+
+// redisKeys exposes eocto.redis.keys(pattern, limit), iterating SCAN until
+// the keyspace is exhausted or limit keys (default 10000) have been collected.
+// Unlike the KEYS command it never blocks the Redis server.
+func redisKeys(prefix string, keyFn func(string) string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		client := database.GetRedisClient()
+		if client == nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString("redis client not initialized"))
+			return 2
+		}
+		pattern := keyFn(L.OptString(1, "*"))
+		limit := L.OptInt(2, 10000)
+
+		var all []string
+		iter := client.Scan(luaContext(L), 0, pattern, 100).Iterator()
+		for iter.Next(luaContext(L)) {
+			all = append(all, iter.Val())
+			if len(all) >= limit {
+				break
+			}
+		}
+		if err := iter.Err(); err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error scanning Redis keys %s: %v", pattern, err))
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(redisStringsToLua(L, all, prefix))
+		return 1
+	}
+}
+
+// redisPipeline exposes eocto.redis.pipeline(fn) and eocto.redis.multi(fn).
+// fn receives an object with the same command functions as eocto.redis; the
+// commands are queued and sent in a single round trip (wrapped in MULTI/EXEC
+// when transactional is true). Returns an array of results (with n set to the
+// number of commands) and a table of per-command errors keyed by index.
+func redisPipeline(prefix string, keyFn func(string) string, transactional bool) lua.LGFunction {
+	return func(L *lua.LState) int {
+		fn := L.CheckFunction(1)
+		client := database.GetRedisClient()
+		if client == nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString("redis client not initialized"))
+			return 2
+		}
+
+		var pipe rds.Pipeliner
+		if transactional {
+			pipe = client.TxPipeline()
+		} else {
+			pipe = client.Pipeline()
+		}
+		ctx := luaContext(L)
+
+		var queued []rds.Cmder
+		pipeTbl := L.NewTable()
+		for name, cmd := range redisCommands {
+			cmd := cmd
+			pipeTbl.RawSetString(name, L.NewFunction(func(L *lua.LState) int {
+				queued = append(queued, cmd(ctx, L, pipe, keyFn))
+				L.Push(lua.LNumber(len(queued)))
+				return 1
+			}))
+		}
+
+		if err := L.CallByParam(lua.P{Fn: fn, NRet: 0, Protect: true}, pipeTbl); err != nil {
+			pipe.Discard()
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		if len(queued) == 0 {
+			results := L.NewTable()
+			results.RawSetString("n", lua.LNumber(0))
+			L.Push(results)
+			L.Push(L.NewTable())
+			return 2
+		}
+
+		// Exec reports the first failed command; per-command errors are
+		// surfaced individually below.
+		_, _ = pipe.Exec(ctx)
+
+		results := L.NewTable()
+		errs := L.NewTable()
+		for i, cmd := range queued {
+			value, err := redisResultToLua(L, cmd, prefix)
+			if err != nil {
+				errs.RawSetInt(i+1, lua.LString(err.Error()))
+				continue
+			}
+			results.RawSetInt(i+1, value)
+		}
+		results.RawSetString("n", lua.LNumber(len(queued)))
+		L.Push(results)
+		L.Push(errs)
+		return 2
+	}
+}
+
+// redisGetJSON exposes eocto.redis.getJSON(key), decoding a value stored
+// from a Lua table by eocto.redis.set back into a table.
+func redisGetJSON(keyFn func(string) string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		client := database.GetRedisClient()
+		if client == nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString("redis client not initialized"))
+			return 2
+		}
+		key := keyFn(L.CheckString(1))
+		return Blocking(L, func(ctx context.Context) func(L *lua.LState) int {
+			val, err := client.Get(ctx, key).Result()
+			return func(L *lua.LState) int {
+				if err == rds.Nil {
+					L.Push(lua.LNil)
+					return 1
+				}
+				if err != nil {
+					L.Push(lua.LNil)
+					L.Push(lua.LString(err.Error()))
+					return 2
+				}
+				var decoded interface{}
+				if err := json.Unmarshal([]byte(val), &decoded); err != nil {
+					L.Push(lua.LNil)
+					L.Push(lua.LString(err.Error()))
+					return 2
+				}
+				L.Push(convertGoToLua(L, decoded))
+				return 1
+			}
+		})
+	}
+}
+
+// pushRedisResult pushes the converted result of cmd, or nil and an error.
+func pushRedisResult(L *lua.LState, cmd rds.Cmder, prefix string) int {
+	value, err := redisResultToLua(L, cmd, prefix)
+	if err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("Redis %s failed: %v", cmd.Name(), err))
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(value)
+	return 1
+}
+
+// redisResultToLua converts a completed Redis command into a typed Lua value.
+// A redis.Nil reply (missing key or field) is converted to lua.LNil.
+func redisResultToLua(L *lua.LState, cmd rds.Cmder, prefix string) (lua.LValue, error) {
+	if err := cmd.Err(); err != nil {
+		if err == rds.Nil {
+			return lua.LNil, nil
+		}
+		return lua.LNil, err
+	}
+
+	switch c := cmd.(type) {
+	case *rds.StringCmd:
+		return lua.LString(c.Val()), nil
+	case *rds.StatusCmd:
+		return lua.LBool(c.Val() == "OK"), nil
+	case *rds.IntCmd:
+		return lua.LNumber(c.Val()), nil
+	case *rds.FloatCmd:
+		return lua.LNumber(c.Val()), nil
+	case *rds.BoolCmd:
+		return lua.LBool(c.Val()), nil
+	case *rds.DurationCmd:
+		// TTL returns -1 (no expiry) and -2 (missing key) as negative durations
+		d := c.Val()
+		if d < 0 {
+			return lua.LNumber(d / time.Nanosecond), nil
+		}
+		return lua.LNumber(d.Seconds()), nil
+	case *rds.StringSliceCmd:
+		return redisStringsToLua(L, c.Val(), ""), nil
+	case *rds.MapStringStringCmd:
+		tbl := L.NewTable()
+		for field, value := range c.Val() {
+			tbl.RawSetString(field, lua.LString(value))
+		}
+		return tbl, nil
+	case *rds.ZSliceCmd:
+		tbl := L.NewTable()
+		for i, z := range c.Val() {
+			entry := L.NewTable()
+			entry.RawSetString("member", lua.LString(fmt.Sprintf("%v", z.Member)))
+			entry.RawSetString("score", lua.LNumber(z.Score))
+			tbl.RawSetInt(i+1, entry)
+		}
+		return tbl, nil
+	case *rds.Cmd:
+		return redisValueToLua(L, c.Val()), nil
+	default:
+		return lua.LString(fmt.Sprintf("%v", cmd.Args())), nil
+	}
+}
+
+// redisValueToLua converts the generic reply of EVAL into Lua values.
+func redisValueToLua(L *lua.LState, v interface{}) lua.LValue {
+	switch val := v.(type) {
+	case nil:
+		return lua.LNil
+	case int64:
+		return lua.LNumber(val)
+	case string:
+		return lua.LString(val)
+	case []byte:
+		return lua.LString(string(val))
+	case bool:
+		return lua.LBool(val)
+	case float64:
+		return lua.LNumber(val)
+	case []interface{}:
+		tbl := L.NewTable()
+		for i, item := range val {
+			tbl.RawSetInt(i+1, redisValueToLua(L, item))
+		}
+		return tbl
+	default:
+		return lua.LString(fmt.Sprintf("%v", val))
+	}
+}
+
+// redisStringsToLua converts a list of strings to a Lua array, stripping
+// prefix from each element when it is a namespaced key.
+func redisStringsToLua(L *lua.LState, values []string, prefix string) *lua.LTable {
+	tbl := L.CreateTable(len(values), 0)
+	for i, v := range values {
+		tbl.RawSetInt(i+1, lua.LString(strings.TrimPrefix(v, prefix)))
+	}
+	return tbl
+}
+
+// luaToRedisArg converts a Lua value into a Redis argument. Tables are stored
+// as JSON so they can be read back with eocto.redis.getJSON.
+func luaToRedisArg(v lua.LValue) interface{} {
+	switch val := v.(type) {
+	case lua.LNumber:
+		return strconv.FormatFloat(float64(val), 'f', -1, 64)
+	case lua.LBool:
+		if val {
+			return "1"
+		}
+		return "0"
+	case *lua.LTable:
+		b, err := json.Marshal(convertLuaTableToGo(val))
+		if err != nil {
+			return val.String()
+		}
+		return string(b)
+	case *lua.LNilType:
+		return ""
+	default:
+		return v.String()
+	}
+}
+
+// redisArgsFromStack collects the Lua arguments from position start onwards.
+// A single table argument is expanded into its array elements.
+func redisArgsFromStack(L *lua.LState, start int) []interface{} {
+	var args []interface{}
+	if tbl, ok := L.Get(start).(*lua.LTable); ok && L.GetTop() == start {
+		tbl.ForEach(func(_, v lua.LValue) {
+			args = append(args, luaToRedisArg(v))
+		})
+		return args
+	}
+	for i := start; i <= L.GetTop(); i++ {
+		args = append(args, luaToRedisArg(L.Get(i)))
+	}
+	return args
+}
+
+// redisStringsFromStack collects string arguments from position start onwards.
+func redisStringsFromStack(L *lua.LState, start int) []string {
+	var values []string
+	for _, v := range redisArgsFromStack(L, start) {
+		values = append(values, fmt.Sprintf("%v", v))
+	}
+	return values
+}
+
+// redisKeysFromStack collects key arguments and applies the key prefix.
+func redisKeysFromStack(L *lua.LState, start int, keyFn func(string) string) []string {
+	keys := redisStringsFromStack(L, start)
+	for i := range keys {
+		keys[i] = keyFn(keys[i])
+	}
+	return keys
+}
+
+// redisKeyPrefixer returns a function namespacing keys with prefix.
+func redisKeyPrefixer(prefix string) func(string) string {
+	if prefix == "" {
+		return func(key string) string { return key }
+	}
+	return func(key string) string { return prefix + key }
+}
+
+// RedisKeyPrefix derives the key prefix for a module. An explicit prefix is
+// used as-is (with a trailing ":" appended when missing).
+func RedisKeyPrefix(prefix string) string {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" || strings.HasSuffix(prefix, ":") {
+		return prefix
+	}
+	return prefix + ":"
+}
+
+// luaContext returns the context of the I/O started by a binding on L: the
+// state's context without its instruction budget, or context.Background()
+// when the state runs without one.
+func luaContext(L *lua.LState) context.Context {
+	return limits.Unwrap(stateContext(L))
+}
+
+// stateContext returns the context attached to L, instruction budget
+// included, for the threads and callbacks that run Lua on its behalf
+func stateContext(L *lua.LState) context.Context {
+	if ctx := L.Context(); ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
@@ -2,6 +2,7 @@ package utilities
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
@@ -39,8 +40,13 @@ import (
 //	response := MakeHTTPRequest("GET", "https://api.example.com",
 //	    map[string]string{"Authorization": "Bearer token"}, nil)
 func MakeHTTPRequest(method, url string, headers map[string]string, body io.Reader) map[string]interface{} {
+	return MakeHTTPRequestContext(context.Background(), method, url, headers, body)
+}
+
+// MakeHTTPRequestContext is MakeHTTPRequest, aborted when ctx is done
+func MakeHTTPRequestContext(ctx context.Context, method, url string, headers map[string]string, body io.Reader) map[string]interface{} {
 	client := &http.Client{}
-	req, err := http.NewRequest(method, url, body)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return map[string]interface{}{
 			"status": 500,
@@ -165,34 +171,41 @@ func GetRequest(c *fiber.Ctx) lua.LGFunction {
 			body = strings.NewReader(L.ToString(4))
 		}
 
-		response := MakeHTTPRequest(method, url, headers, body)
-
-		// Convert response to Lua table
-		responseTable := L.NewTable()
-		for k, v := range response {
-			switch val := v.(type) {
-			case int:
-				responseTable.RawSetString(k, lua.LNumber(val))
-			case string:
-				responseTable.RawSetString(k, lua.LString(val))
-			case http.Header:
-				headerTable := L.NewTable()
-				for hk, hv := range val {
-					headerTable.RawSetString(hk, lua.LString(strings.Join(hv, ", ")))
-				}
-				responseTable.RawSetString(k, headerTable)
-			case []string:
-				cookieTable := L.NewTable()
-				for i, cookie := range val {
-					cookieTable.RawSetInt(i+1, lua.LString(cookie))
-				}
-				responseTable.RawSetString(k, cookieTable)
+		// The request runs concurrently when called from a task of eocto.parallel
+		return Blocking(L, func(ctx context.Context) func(L *lua.LState) int {
+			response := MakeHTTPRequestContext(ctx, method, url, headers, body)
+			return func(L *lua.LState) int {
+				L.Push(responseTable(L, response))
+				return 1
 			}
-		}
+		})
+	}
+}
 
-		L.Push(responseTable)
-		return 1
+// responseTable converts a response of MakeHTTPRequest to a Lua table
+func responseTable(L *lua.LState, response map[string]interface{}) *lua.LTable {
+	responseTable := L.NewTable()
+	for k, v := range response {
+		switch val := v.(type) {
+		case int:
+			responseTable.RawSetString(k, lua.LNumber(val))
+		case string:
+			responseTable.RawSetString(k, lua.LString(val))
+		case http.Header:
+			headerTable := L.NewTable()
+			for hk, hv := range val {
+				headerTable.RawSetString(hk, lua.LString(strings.Join(hv, ", ")))
+			}
+			responseTable.RawSetString(k, headerTable)
+		case []string:
+			cookieTable := L.NewTable()
+			for i, cookie := range val {
+				cookieTable.RawSetInt(i+1, lua.LString(cookie))
+			}
+			responseTable.RawSetString(k, cookieTable)
+		}
 	}
+	return responseTable
 }
 
 // Add this to your existing requests.go file
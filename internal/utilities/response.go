@@ -1,11 +1,212 @@
 package utilities
 
 import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
 	"github.com/gofiber/fiber/v2"
 	lua "github.com/yuin/gopher-lua"
 )
 
-// GetResponse returns a Lua function that stores HTTP response data in Fiber Locals.
+// responseLocal is the Fiber local holding the response set from Lua
+const responseLocal = "lua_response"
+
+// Response is the response a script set through eocto.response,
+// eocto.setResponse, eocto.sendFile or eocto.stream. The route sends it once
+// the scripts have run: a body replaces the view, and the status and headers
+// apply to whatever is sent.
+type Response struct {
+	Status  int
+	Headers http.Header
+	// HasBody tells an empty body from none
+	HasBody     bool
+	ContentType string
+	Body        []byte
+	// File or stream replaces Body when set
+	file   *fileBody
+	stream *streamBody
+}
+
+// ResponseOf returns the response set on c from Lua, nil when none was
+func ResponseOf(c *fiber.Ctx) *Response {
+	resp, _ := c.Locals(responseLocal).(*Response)
+	return resp
+}
+
+// responseFor returns the response of c, creating it
+func responseFor(c *fiber.Ctx) *Response {
+	resp := ResponseOf(c)
+	if resp == nil {
+		resp = &Response{Headers: http.Header{}}
+		c.Locals(responseLocal, resp)
+	}
+	return resp
+}
+
+// ApplyResponseHeaders sets the status and headers of the response set on c
+func ApplyResponseHeaders(c *fiber.Ctx) {
+	resp := ResponseOf(c)
+	if resp == nil {
+		return
+	}
+	if resp.Status != 0 {
+		c.Status(resp.Status)
+	}
+	for name, values := range resp.Headers {
+		for i, value := range values {
+			if i == 0 {
+				c.Set(name, value)
+			} else {
+				c.Response().Header.Add(name, value)
+			}
+		}
+	}
+}
+
+// SendResponse applies the response set on c and reports whether it carried
+// a body, in which case the body is sent
+func SendResponse(c *fiber.Ctx) (bool, error) {
+	resp := ResponseOf(c)
+	if resp == nil {
+		return false, nil
+	}
+	switch {
+	case resp.file != nil:
+		// Ranges answer 206 or 416, which the status set from Lua must not hide
+		ApplyResponseHeaders(c)
+		return true, serveFile(c, resp.file)
+	case resp.stream != nil:
+		c.Set(fiber.HeaderContentType, resp.ContentType)
+		ApplyResponseHeaders(c)
+		startStream(c, resp.stream)
+		return true, nil
+	case resp.HasBody:
+		c.Set(fiber.HeaderContentType, resp.ContentType)
+		c.Response().SetBody(resp.Body)
+	}
+	// Headers come last so an explicit Content-Type wins
+	ApplyResponseHeaders(c)
+	return resp.HasBody, nil
+}
+
+// setBody records the body of the response of c, with the status given at
+// index statusArg when there is one (0 takes none)
+func setBody(c *fiber.Ctx, L *lua.LState, statusArg int, contentType string, body []byte) *Response {
+	resp := responseFor(c)
+	if statusArg > 0 {
+		if status := L.OptInt(statusArg, 0); status != 0 {
+			resp.Status = status
+		}
+	}
+	resp.HasBody = true
+	resp.ContentType = contentType
+	resp.Body = body
+	resp.file = nil
+	if resp.stream != nil {
+		resp.stream.abandon()
+		resp.stream = nil
+	}
+	return resp
+}
+
+// NewResponseTable builds the eocto.response table. ctx returns the request
+// the calling script serves.
+//
+// Usage in Lua:
+//
+//	eocto.response.status(201)
+//	eocto.response.header("Location", "/OC/items/" .. id)
+//	eocto.response.json({id = id, tags = {"a", "b"}, price = 9.5})
+func NewResponseTable(L *lua.LState, ctx func() *fiber.Ctx) *lua.LTable {
+	tbl := L.NewTable()
+	tbl.RawSetString("status", L.NewFunction(func(L *lua.LState) int {
+		responseFor(ctx()).Status = L.CheckInt(1)
+		return 0
+	}))
+	tbl.RawSetString("header", L.NewFunction(func(L *lua.LState) int {
+		resp := responseFor(ctx())
+		name := L.CheckString(1)
+		switch value := L.Get(2).(type) {
+		case *lua.LNilType:
+			resp.Headers.Del(name)
+		case *lua.LTable:
+			resp.Headers.Del(name)
+			value.ForEach(func(_, v lua.LValue) {
+				resp.Headers.Add(name, lua.LVAsString(v))
+			})
+		default:
+			resp.Headers.Set(name, lua.LVAsString(value))
+		}
+		return 0
+	}))
+	tbl.RawSetString("json", L.NewFunction(func(L *lua.LState) int {
+		data, err := json.Marshal(convertLuaValueToGo(L.Get(1)))
+		if err != nil {
+			L.ArgError(1, err.Error())
+		}
+		setBody(ctx(), L, 2, fiber.MIMEApplicationJSONCharsetUTF8, data)
+		return 0
+	}))
+	tbl.RawSetString("text", L.NewFunction(textBody(ctx, fiber.MIMETextPlainCharsetUTF8)))
+	tbl.RawSetString("html", L.NewFunction(textBody(ctx, fiber.MIMETextHTMLCharsetUTF8)))
+	tbl.RawSetString("xml", L.NewFunction(textBody(ctx, fiber.MIMEApplicationXMLCharsetUTF8)))
+	tbl.RawSetString("csv", L.NewFunction(func(L *lua.LState) int {
+		data, err := encodeCSV(L.CheckTable(1))
+		if err != nil {
+			L.ArgError(1, err.Error())
+		}
+		setBody(ctx(), L, 2, "text/csv; charset=utf-8", data)
+		return 0
+	}))
+	tbl.RawSetString("bytes", L.NewFunction(func(L *lua.LState) int {
+		data := L.CheckString(1)
+		setBody(ctx(), L, 3, L.CheckString(2), []byte(data))
+		return 0
+	}))
+	tbl.RawSetString("send", L.NewFunction(func(L *lua.LState) int {
+		c := ctx()
+		responseFor(c)
+		return setFlow(c, L, &Flow{Action: FlowHalt})
+	}))
+	return tbl
+}
+
+// textBody returns the Lua function setting a string body of contentType
+func textBody(ctx func() *fiber.Ctx, contentType string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		setBody(ctx(), L, 2, contentType, []byte(L.CheckString(1)))
+		return 0
+	}
+}
+
+// encodeCSV writes rows, a list of lists of values, as CSV
+func encodeCSV(rows *lua.LTable) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	for i := 1; i <= rows.Len(); i++ {
+		row, ok := rows.RawGetInt(i).(*lua.LTable)
+		if !ok {
+			return nil, fmt.Errorf("row %d is not a list", i)
+		}
+		record := make([]string, row.Len())
+		for j := range record {
+			if v := row.RawGetInt(j + 1); v != lua.LNil {
+				record[j] = lua.LVAsString(v)
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// GetResponse returns a Lua function that answers with a JSON body instead
+// of the view, the same as eocto.response.json(body, status)
 //
 // Parameters passed from Lua:
 //   - status: number - HTTP status code
@@ -13,21 +214,16 @@ import (
 //
 // Usage in Lua:
 //
-//	response(500, {error = "Internal Server Error"})
-//	response(200, {data = "Success"})
+//	eocto.setResponse(500, {error = "Internal Server Error"})
+//	eocto.setResponse(200, {data = {count = 2}})
 func GetResponse(c *fiber.Ctx) lua.LGFunction {
 	return func(L *lua.LState) int {
-		status := L.ToInt(1)
-		body := L.ToTable(2)
-
-		responseMap := fiber.Map{}
-		body.ForEach(func(k, v lua.LValue) {
-			responseMap[k.String()] = v.String()
-		})
-
-		c.Locals("lua_response", map[int]fiber.Map{
-			status: responseMap,
-		})
+		L.CheckInt(1)
+		data, err := json.Marshal(convertLuaValueToGo(L.Get(2)))
+		if err != nil {
+			L.ArgError(2, err.Error())
+		}
+		setBody(c, L, 1, fiber.MIMEApplicationJSONCharsetUTF8, data)
 		return 0
 	}
 }
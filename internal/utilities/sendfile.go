@@ -0,0 +1,160 @@
+package utilities
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/degreane/octopus/internal/utilities/debug"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// fileBody is a file eocto.sendFile answers with
+type fileBody struct {
+	path        string
+	name        string
+	inline      bool
+	contentType string
+}
+
+// SendFile returns a Lua function that answers with a file of the module
+// instead of the view. Relative paths are resolved against base, the
+// module's directory, and the resolved file must stay inside root.
+//
+// Usage in Lua:
+//
+//	local ok, err = eocto.sendFile("files/report.pdf", {name = "Report 2024.pdf", inline = true})
+func SendFile(c *fiber.Ctx, base, root string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		path, err := confinedFile(base, root, L.CheckString(1))
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		file := &fileBody{path: path, name: filepath.Base(path)}
+		if opts := L.OptTable(2, nil); opts != nil {
+			if name, ok := opts.RawGetString("name").(lua.LString); ok && name != "" {
+				file.name = string(name)
+			}
+			file.inline = lua.LVAsBool(opts.RawGetString("inline"))
+			if ct, ok := opts.RawGetString("contentType").(lua.LString); ok {
+				file.contentType = string(ct)
+			}
+		}
+		resp := setBody(c, L, 0, "", nil)
+		resp.file = file
+		L.Push(lua.LTrue)
+		return 1
+	}
+}
+
+// confinedFile resolves path against base and returns it when it names a
+// regular file that, symlinks resolved, stays inside root
+func confinedFile(base, root, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(base, path)
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if r, err := filepath.EvalSymlinks(absRoot); err == nil {
+		absRoot = r
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%s: file not found", path)
+		}
+		return "", err
+	}
+	if resolved, err = filepath.Abs(resolved); err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absRoot, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: outside the module directory", path)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s: not a file", path)
+	}
+	return resolved, nil
+}
+
+// readCloser streams part of a file and closes it once sent
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// serveFile answers c with f, honoring a single byte range and If-Range
+func serveFile(c *fiber.Ctx, f *fileBody) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("sendFile %s: %v", f.path, err))
+		return fiber.NewError(fiber.StatusNotFound, "file not found")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	size := info.Size()
+	modified := info.ModTime().UTC().Format(http.TimeFormat)
+
+	contentType := f.contentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(f.name))
+	}
+	if contentType == "" {
+		contentType = fiber.MIMEOctetStream
+	}
+	disposition := "attachment"
+	if f.inline {
+		disposition = "inline"
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{"filename": f.name}))
+	c.Set(fiber.HeaderLastModified, modified)
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+
+	rangeHeader := c.Get(fiber.HeaderRange)
+	if ifRange := c.Get(fiber.HeaderIfRange); ifRange != "" && ifRange != modified {
+		// The client's copy is stale: send the whole file
+		rangeHeader = ""
+	}
+	if rangeHeader == "" || strings.Contains(rangeHeader, ",") {
+		// Multiple ranges are answered with the whole file, as RFC 9110 allows
+		c.Response().SetBodyStream(file, int(size))
+		return nil
+	}
+	start, end, err := fasthttp.ParseByteRange([]byte(rangeHeader), int(size))
+	if err != nil {
+		file.Close()
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", size))
+		c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+		c.Response().Header.Del(fiber.HeaderContentDisposition)
+		c.Response().ResetBody()
+		return c.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+	}
+	if _, err := file.Seek(int64(start), io.SeekStart); err != nil {
+		file.Close()
+		return fmt.Errorf("sendFile %s: %w", f.path, err)
+	}
+	length := end - start + 1
+	c.Status(fiber.StatusPartialContent)
+	c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	c.Response().SetBodyStream(&readCloser{Reader: io.LimitReader(file, int64(length)), Closer: file}, length)
+	return nil
+}
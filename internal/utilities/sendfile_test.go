@@ -0,0 +1,121 @@
+package utilities
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestServeFileRange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "digits.txt")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	lastModified := modified.UTC().Format(http.TimeFormat)
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return serveFile(c, &fileBody{path: path, name: "digits.txt"})
+	})
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		ifRange      string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{name: "no range", status: 200, body: "0123456789"},
+		{name: "closed", rangeHeader: "bytes=2-5", status: 206, body: "2345", contentRange: "bytes 2-5/10"},
+		{name: "single byte", rangeHeader: "bytes=0-0", status: 206, body: "0", contentRange: "bytes 0-0/10"},
+		{name: "open-ended", rangeHeader: "bytes=7-", status: 206, body: "789", contentRange: "bytes 7-9/10"},
+		{name: "end past the file", rangeHeader: "bytes=5-100", status: 206, body: "56789", contentRange: "bytes 5-9/10"},
+		{name: "suffix", rangeHeader: "bytes=-3", status: 206, body: "789", contentRange: "bytes 7-9/10"},
+		{name: "suffix longer than the file", rangeHeader: "bytes=-20", status: 206, body: "0123456789", contentRange: "bytes 0-9/10"},
+		{name: "start at the end", rangeHeader: "bytes=10-", status: 416, contentRange: "bytes */10"},
+		{name: "start past the end", rangeHeader: "bytes=20-30", status: 416, contentRange: "bytes */10"},
+		{name: "reversed", rangeHeader: "bytes=5-2", status: 416, contentRange: "bytes */10"},
+		{name: "other unit", rangeHeader: "items=0-1", status: 416, contentRange: "bytes */10"},
+		{name: "garbage", rangeHeader: "bytes=a-b", status: 416, contentRange: "bytes */10"},
+		{name: "multiple ranges", rangeHeader: "bytes=0-1,4-5", status: 200, body: "0123456789"},
+		{name: "if-range current", rangeHeader: "bytes=2-5", ifRange: lastModified, status: 206, body: "2345", contentRange: "bytes 2-5/10"},
+		{name: "if-range stale", rangeHeader: "bytes=2-5", ifRange: "Mon, 02 Jan 2006 15:04:05 GMT", status: 200, body: "0123456789"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set(fiber.HeaderRange, tt.rangeHeader)
+			}
+			if tt.ifRange != "" {
+				req.Header.Set(fiber.HeaderIfRange, tt.ifRange)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", resp.StatusCode, tt.status, body)
+			}
+			if got := resp.Header.Get(fiber.HeaderContentRange); got != tt.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+			}
+			if tt.status != 416 && string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestConfinedFile(t *testing.T) {
+	views := t.TempDir()
+	for _, file := range []string{"files/root.txt", "pages/home.html", "Shop/files/a.txt", "Admin/secret.txt"} {
+		path := filepath.Join(views, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(views, "Admin/secret.txt"), filepath.Join(views, "Shop/files/link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	shop := filepath.Join(views, "Shop")
+	tests := []struct {
+		name             string
+		base, root, path string
+		ok               bool
+	}{
+		{"module file", shop, shop, "files/a.txt", true},
+		{"absolute inside", shop, shop, filepath.Join(shop, "files/a.txt"), true},
+		{"dot-dot to another module", shop, shop, "../Admin/secret.txt", false},
+		{"symlink to another module", shop, shop, "files/link.txt", false},
+		{"directory", shop, shop, "files", false},
+		{"missing", shop, shop, "files/none.txt", false},
+		{"root module files", views, filepath.Join(views, "files"), "files/root.txt", true},
+		{"root module views", views, filepath.Join(views, "files"), "pages/home.html", false},
+		{"root module into a module", views, filepath.Join(views, "files"), "Admin/secret.txt", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := confinedFile(tt.base, tt.root, tt.path)
+			if (err == nil) != tt.ok {
+				t.Errorf("confinedFile(%q) error = %v, want ok = %v", tt.path, err, tt.ok)
+			}
+		})
+	}
+}
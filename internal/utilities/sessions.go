@@ -13,6 +13,7 @@ import (
 
 	"github.com/degreane/octopus/internal/middleware"
 	"github.com/degreane/octopus/internal/utilities/debug"
+	"github.com/gofiber/contrib/socketio"
 	"github.com/gofiber/fiber/v2"
 	lua "github.com/yuin/gopher-lua"
 )
@@ -48,36 +49,39 @@ func GetSession(c *fiber.Ctx) lua.LGFunction {
 		// 	log.Printf("Key: %s, Value: %v", key, sess.Get(key))
 		// }
 		key := L.ToString(1)
-		raw := sess.Get(fmt.Sprintf("%s", key))
-		if raw == nil {
-			debug.Debug(debug.Info, fmt.Sprintf("Key \"%s\" not found in session locals", key))
-			L.Push(lua.LNil)
-			return 1
-		}
+		L.Push(sessionToLua(L, key, sess.Get(fmt.Sprintf("%s", key))))
+		return 1
+	}
+}
 
-		var jsonStr string
-		switch v := raw.(type) {
-		case string:
-			jsonStr = v
-		case []byte:
-			jsonStr = string(v)
-		default:
-			// Fallback: if value isn't a JSON string, treat it as string
-			jsonStr = fmt.Sprintf("%v", v)
-		}
+// sessionToLua converts the session value stored under key, JSON when it was
+// set with setSession
+func sessionToLua(L *lua.LState, key string, raw interface{}) lua.LValue {
+	if raw == nil {
+		debug.Debug(debug.Info, fmt.Sprintf("Key \"%s\" not found in session locals", key))
+		return lua.LNil
+	}
 
-		var decoded interface{}
-		if err := json.Unmarshal([]byte(jsonStr), &decoded); err != nil {
-			// If it's not valid JSON, return as plain string for robustness
-			debug.Debug(debug.Info, fmt.Sprintf("Session value for key '%s' is not JSON or unmarshal failed: %v", key, err))
-			L.Push(lua.LString(jsonStr))
-			return 1
-		}
+	var jsonStr string
+	switch v := raw.(type) {
+	case string:
+		jsonStr = v
+	case []byte:
+		jsonStr = string(v)
+	default:
+		// Fallback: if value isn't a JSON string, treat it as string
+		jsonStr = fmt.Sprintf("%v", v)
+	}
 
-		// Convert decoded Go value into a corresponding lua.LValue
-		L.Push(convertGoToLua(L, decoded))
-		return 1
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(jsonStr), &decoded); err != nil {
+		// If it's not valid JSON, return as plain string for robustness
+		debug.Debug(debug.Info, fmt.Sprintf("Session value for key '%s' is not JSON or unmarshal failed: %v", key, err))
+		return lua.LString(jsonStr)
 	}
+
+	// Convert decoded Go value into a corresponding lua.LValue
+	return convertGoToLua(L, decoded)
 }
 
 // SetSession returns a Lua function that stores values in the session.
@@ -108,26 +112,12 @@ func SetSession(c *fiber.Ctx) lua.LGFunction {
 		}
 
 		key := L.ToString(1)
-		var value interface{}
-
-		switch L.Get(2).Type() {
-		case lua.LTString:
-			value = L.ToString(2)
-		case lua.LTNumber:
-			value = float64(L.ToNumber(2))
-		case lua.LTNil:
-			value = nil
-		case lua.LTBool:
-			value = L.ToBool(2)
-		case lua.LTTable:
-			value = convertLuaTableToGo(L.ToTable(2))
-		}
 		// log.Printf("Setting session key %s to value %+v", key, value)
-		jsonBytes, mErr := json.Marshal(value)
+		jsonStr, mErr := sessionJSON(L)
 		if mErr != nil {
 			debug.Debug(debug.Error, fmt.Sprintf("Error marshaling value to JSON: %v", mErr))
 		} else {
-			sess.Set(fmt.Sprintf("%s", key), string(jsonBytes))
+			sess.Set(fmt.Sprintf("%s", key), jsonStr)
 			sess.Fresh()
 			err = sess.Save()
 			if err != nil {
@@ -138,6 +128,27 @@ func SetSession(c *fiber.Ctx) lua.LGFunction {
 	}
 }
 
+// sessionJSON encodes the value argument of setSession, which getSession
+// decodes
+func sessionJSON(L *lua.LState) (string, error) {
+	var value interface{}
+
+	switch L.Get(2).Type() {
+	case lua.LTString:
+		value = L.ToString(2)
+	case lua.LTNumber:
+		value = float64(L.ToNumber(2))
+	case lua.LTNil:
+		value = nil
+	case lua.LTBool:
+		value = L.ToBool(2)
+	case lua.LTTable:
+		value = convertLuaTableToGo(L.ToTable(2))
+	}
+	jsonBytes, err := json.Marshal(value)
+	return string(jsonBytes), err
+}
+
 // SetSessionExpiry exposes session expiration functionality to Lua scripts.
 // It allows setting session values with custom expiration times directly from Lua code.
 //
@@ -160,26 +171,9 @@ func SetSessionExpiry(c *fiber.Ctx) lua.LGFunction {
 		}
 
 		key := L.ToString(1)
-		var value interface{}
+		value := sessionExpiryValue(L)
 		expirySeconds := L.ToNumber(3)
 
-		switch L.Get(2).Type() {
-		case lua.LTString:
-			value = L.ToString(2)
-		case lua.LTNumber:
-			value = float64(L.ToNumber(2))
-		case lua.LTNil:
-			value = nil
-		case lua.LTBool:
-			value = L.ToBool(2)
-		case lua.LTTable:
-			mapValue := make(map[string]interface{})
-			L.ToTable(2).ForEach(func(k, v lua.LValue) {
-				mapValue[k.String()] = v.String()
-			})
-			value = mapValue
-		}
-
 		// log.Printf("Setting session key %s to value %+v with expiry %v seconds", key, value, expirySeconds)
 		sess.Set(key, value)
 		sess.SetExpiry(time.Duration(expirySeconds) * time.Second)
@@ -195,6 +189,26 @@ func SetSessionExpiry(c *fiber.Ctx) lua.LGFunction {
 	}
 }
 
+// sessionExpiryValue converts the value argument of setSessionExpiry, which is
+// stored as it is
+func sessionExpiryValue(L *lua.LState) interface{} {
+	switch L.Get(2).Type() {
+	case lua.LTString:
+		return L.ToString(2)
+	case lua.LTNumber:
+		return float64(L.ToNumber(2))
+	case lua.LTBool:
+		return L.ToBool(2)
+	case lua.LTTable:
+		mapValue := make(map[string]interface{})
+		L.ToTable(2).ForEach(func(k, v lua.LValue) {
+			mapValue[k.String()] = v.String()
+		})
+		return mapValue
+	}
+	return nil
+}
+
 // DeleteSession returns a Lua function that deletes a value from the session.
 // The function is exposed to Lua scripts and allows deletion of HTTP session data.
 //
@@ -245,6 +259,114 @@ func DeleteSession(c *fiber.Ctx) lua.LGFunction {
 	}
 }
 
+// wsSessionKey is the socket attribute holding the session ID of its
+// handshake, kept current as the session is rotated by HTTP requests
+const wsSessionKey = "session_id"
+
+// wsSession loads the session of the socket's handshake
+func wsSession(c *socketio.Websocket) (string, map[string]interface{}, error) {
+	id, _ := c.GetAttribute(wsSessionKey).(string)
+	if id == "" {
+		id, _ = c.Locals(middleware.SessionIDLocal).(string)
+	}
+	if id == "" {
+		return "", nil, fmt.Errorf("the socket has no session")
+	}
+	current, data, err := middleware.LoadSession(id)
+	if current != id {
+		c.SetAttribute(wsSessionKey, current)
+	}
+	if err == nil && data == nil {
+		// Expired or destroyed: writes start a session under the same ID
+		data = make(map[string]interface{})
+	}
+	return current, data, err
+}
+
+// GetWsSession is GetSession for WebSocket scripts: it reads the session the
+// socket was opened with.
+//
+// Usage in Lua:
+//
+//	local user = eocto.getSession("user")
+func GetWsSession(c *socketio.Websocket) lua.LGFunction {
+	return func(L *lua.LState) int {
+		_, data, err := wsSession(c)
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error getting session: %v", err))
+			L.Push(lua.LNil)
+			return 1
+		}
+		key := L.ToString(1)
+		L.Push(sessionToLua(L, key, data[key]))
+		return 1
+	}
+}
+
+// SetWsSession is SetSession for WebSocket scripts. The value is written to
+// the session store, so the next HTTP request of the session sees it.
+func SetWsSession(c *socketio.Websocket) lua.LGFunction {
+	return func(L *lua.LState) int {
+		id, data, err := wsSession(c)
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error getting session: %v", err))
+			return 0
+		}
+		jsonStr, err := sessionJSON(L)
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error marshaling value to JSON: %v", err))
+			return 0
+		}
+		data[L.ToString(1)] = jsonStr
+		if err := middleware.SaveSession(id, data, 0); err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error saving session: %v", err))
+		}
+		return 0
+	}
+}
+
+// SetWsSessionExpiry is SetSessionExpiry for WebSocket scripts
+func SetWsSessionExpiry(c *socketio.Websocket) lua.LGFunction {
+	return func(L *lua.LState) int {
+		id, data, err := wsSession(c)
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error getting session: %v", err))
+			return 0
+		}
+		data[L.ToString(1)] = sessionExpiryValue(L)
+		expiry := time.Duration(L.ToNumber(3)) * time.Second
+		if err := middleware.SaveSession(id, data, expiry); err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error saving session: %v", err))
+		}
+		return 0
+	}
+}
+
+// DeleteWsSession is DeleteSession for WebSocket scripts
+func DeleteWsSession(c *socketio.Websocket) lua.LGFunction {
+	return func(L *lua.LState) int {
+		key := L.ToString(1)
+		if key == "" {
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString("key cannot be empty"))
+			return 2
+		}
+		id, data, err := wsSession(c)
+		if err == nil {
+			delete(data, key)
+			err = middleware.SaveSession(id, data, 0)
+		}
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("Error saving session after delete: %v", err))
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(lua.LBool(true))
+		return 1
+	}
+}
+
 // func GetAllSessions(L *lua.LState) int {
 // 	store := middleware.Store
 // 	tbl := L.NewTable()
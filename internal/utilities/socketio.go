@@ -1,9 +1,13 @@
 package utilities
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"time"
 
+	"github.com/degreane/octopus/internal/service/broadcast"
+	"github.com/degreane/octopus/internal/utilities/debug"
 	"github.com/gofiber/contrib/socketio"
 )
 
@@ -99,10 +103,15 @@ const (
 	EventWarning      = "warning"
 )
 
-// SocketClients manages WebSocket client connections across the entire application
+// SocketClients manages WebSocket client connections across the entire application.
+// The clients map only holds the sockets connected to this process; presence,
+// room membership and emits go through a broadcast.Adapter so they reach the
+// sockets held by every other process as well.
 type SocketClients struct {
 	clients map[string]*ClientInfo
 	mutex   sync.RWMutex
+	adapter broadcast.Adapter
+	nodeID  string
 }
 
 // ClientInfo holds detailed information about each connected client
@@ -127,33 +136,101 @@ func GetSocketClients() *SocketClients {
 		//log.Print("Calling Once DO ")
 		socketClientsInstance = &SocketClients{
 			clients: make(map[string]*ClientInfo),
+			nodeID:  broadcast.NewNodeID(),
 		}
+		_ = socketClientsInstance.UseAdapter(broadcast.NewMemoryAdapter())
 		go socketClientsInstance.CleanupStaleConnections(10 * time.Minute)
 	})
 
 	return socketClientsInstance
 }
 
-// AddClient adds a new client connection
-func (s *SocketClients) AddClient(userID, uuid string) {
+// UseAdapter switches the broadcast adapter used to reach other processes.
+// Clients already connected to this process are announced on the new adapter.
+func (s *SocketClients) UseAdapter(adapter broadcast.Adapter) error {
+	if err := adapter.Subscribe(s.handleBroadcast); err != nil {
+		return err
+	}
+
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	previous := s.adapter
+	s.adapter = adapter
+	users := make([]string, 0, len(s.clients))
+	for userID := range s.clients {
+		users = append(users, userID)
+	}
+	s.mutex.Unlock()
+
+	if previous != nil {
+		_ = previous.Close()
+	}
+	for _, userID := range users {
+		if err := adapter.SetOnline(context.Background(), userID, s.nodeID, true); err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("UseAdapter: presence update failed user=%s error=%v", userID, err))
+		}
+	}
+	debug.Debug(debug.Info, fmt.Sprintf("SocketClients: using %s broadcast adapter on node %s", adapter.Name(), s.nodeID))
+	return nil
+}
+
+// Adapter returns the broadcast adapter in use
+func (s *SocketClients) Adapter() broadcast.Adapter {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.adapter
+}
 
-	s.clients[userID] = &ClientInfo{
+// NodeID returns the identifier of this process on the broadcast adapter
+func (s *SocketClients) NodeID() string {
+	return s.nodeID
+}
+
+// AddClient adds a new client connection.
+// The user is marked online on the broadcast adapter and the rooms it already
+// belongs to are restored on the local client.
+func (s *SocketClients) AddClient(userID, uuid string) {
+	s.mutex.Lock()
+	client := &ClientInfo{
 		UUID:        uuid,
 		UserID:      userID,
 		ConnectedAt: time.Now(),
 		LastSeen:    time.Now(),
 		Attributes:  make(map[string]interface{}),
 	}
+	s.clients[userID] = client
+	adapter := s.adapter
+	s.mutex.Unlock()
+
+	ctx := context.Background()
+	if err := adapter.SetOnline(ctx, userID, s.nodeID, true); err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("AddClient: presence update failed user=%s error=%v", userID, err))
+	}
+	rooms, err := adapter.Rooms(ctx, userID)
+	if err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("AddClient: loading rooms failed user=%s error=%v", userID, err))
+		return
+	}
+	for _, room := range rooms {
+		s.addLocalRoom(userID, room)
+	}
 }
 
-// RemoveClient removes a client connection
+// RemoveClient removes a client connection and its presence and room
+// memberships on the broadcast adapter.
 func (s *SocketClients) RemoveClient(userID string) {
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
 	delete(s.clients, userID)
+	adapter := s.adapter
+	s.mutex.Unlock()
+
+	ctx := context.Background()
+	if err := adapter.SetOnline(ctx, userID, s.nodeID, false); err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("RemoveClient: presence update failed user=%s error=%v", userID, err))
+	}
+	if err := adapter.LeaveAll(ctx, userID); err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("RemoveClient: leaving rooms failed user=%s error=%v", userID, err))
+	}
 }
 
 func (s *SocketClients) SetSocket(userID string, socket *socketio.Websocket) {
@@ -185,7 +262,7 @@ func (s *SocketClients) GetClientUUID(userID string) (string, bool) {
 	return "", false
 }
 
-// GetClients returns a copy of all clients (thread-safe)
+// GetClients returns a copy of all clients connected to this process (thread-safe)
 func (s *SocketClients) GetClients() map[string]*ClientInfo {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -254,63 +331,259 @@ func (s *SocketClients) GetClientAttribute(userID, key string) (interface{}, boo
 	return nil, false
 }
 
-// GetConnectedCount returns the number of connected clients
+// GetConnectedCount returns the number of clients connected to any process
 func (s *SocketClients) GetConnectedCount() int {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-
-	return len(s.clients)
+	return len(s.GetConnectedUsers())
 }
 
-// GetConnectedUsers returns a list of connected user IDs
+// GetConnectedUsers returns a list of user IDs connected to any process
 func (s *SocketClients) GetConnectedUsers() []string {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-
-	users := make([]string, 0, len(s.clients))
-	for userID := range s.clients {
-		users = append(users, userID)
+	users, err := s.Adapter().OnlineUsers(context.Background())
+	if err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("GetConnectedUsers: error=%v", err))
+		return s.localUsers()
 	}
 	return users
 }
 
-// IsUserConnected checks if a user is currently connected
+// IsUserConnected checks if a user is currently connected to any process
 func (s *SocketClients) IsUserConnected(userID string) bool {
 	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-
 	_, exists := s.clients[userID]
-	return exists
+	s.mutex.RUnlock()
+	if exists {
+		return true
+	}
+
+	online, err := s.Adapter().IsOnline(context.Background(), userID)
+	if err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("IsUserConnected: user=%s error=%v", userID, err))
+		return false
+	}
+	return online
 }
 
-// GetClientsInRoom returns clients that have a specific room attribute
+// GetClientsInRoom returns the members of a room across all processes.
+// Members connected to other processes have no Socket and only carry their UserID.
 func (s *SocketClients) GetClientsInRoom(roomID string) map[string]*ClientInfo {
+	members, err := s.Adapter().Members(context.Background(), roomID)
+	if err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("GetClientsInRoom: room=%s error=%v", roomID, err))
+		return map[string]*ClientInfo{}
+	}
+
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	roomClients := make(map[string]*ClientInfo)
-	for userID, client := range s.clients {
-		if room, exists := client.Attributes["room"]; exists && room == roomID {
+	roomClients := make(map[string]*ClientInfo, len(members))
+	for _, userID := range members {
+		if client, exists := s.clients[userID]; exists {
 			roomClients[userID] = client
+		} else {
+			roomClients[userID] = &ClientInfo{UserID: userID, Attributes: make(map[string]interface{})}
 		}
 	}
 	return roomClients
 }
 
-// CleanupStaleConnections removes connections that haven't been seen for a specified duration
-func (s *SocketClients) CleanupStaleConnections(maxIdleTime time.Duration) []string {
+// GetUserRooms returns the rooms a user joined, across all processes
+func (s *SocketClients) GetUserRooms(userID string) ([]string, error) {
+	return s.Adapter().Rooms(context.Background(), userID)
+}
+
+// IsUserInRoom checks whether a user joined a room, across all processes
+func (s *SocketClients) IsUserInRoom(userID, roomID string) (bool, error) {
+	rooms, err := s.GetUserRooms(userID)
+	if err != nil {
+		return false, err
+	}
+	for _, room := range rooms {
+		if room == roomID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// JoinRoom adds a user to a room and propagates the change to every process
+func (s *SocketClients) JoinRoom(userID, roomID string) error {
+	adapter := s.Adapter()
+	ctx := context.Background()
+	if err := adapter.Join(ctx, userID, roomID); err != nil {
+		return err
+	}
+	return adapter.Publish(ctx, broadcast.Message{Kind: broadcast.KindJoin, UserID: userID, Room: roomID, Origin: s.nodeID})
+}
+
+// LeaveRoom removes a user from a room and propagates the change to every process
+func (s *SocketClients) LeaveRoom(userID, roomID string) error {
+	adapter := s.Adapter()
+	ctx := context.Background()
+	if err := adapter.Leave(ctx, userID, roomID); err != nil {
+		return err
+	}
+	return adapter.Publish(ctx, broadcast.Message{Kind: broadcast.KindLeave, UserID: userID, Room: roomID, Origin: s.nodeID})
+}
+
+// EmitToRoom publishes an event to every member of a room on every process,
+// and to the sse connections subscribed to it. It returns the number of
+// connected room members targeted by the emit; sse subscribers are not
+// counted.
+func (s *SocketClients) EmitToRoom(roomID, event, data string, excludeUsers ...string) (int, error) {
+	adapter := s.Adapter()
+	ctx := context.Background()
+	members, err := adapter.Members(ctx, roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	excludeMap := make(map[string]bool, len(excludeUsers))
+	for _, userID := range excludeUsers {
+		excludeMap[userID] = true
+	}
+	targeted := 0
+	for _, userID := range members {
+		if !excludeMap[userID] {
+			targeted++
+		}
+	}
+	// The ID lets sse clients resume after the last event they received; an
+	// event without one is still delivered, it just cannot be replayed
+	id, err := adapter.NextID(ctx)
+	if err != nil {
+		debug.Debug(debug.Warning, fmt.Sprintf("EmitToRoom: no event ID for room %s: %v", roomID, err))
+	}
+	// Published even without members: sse connections subscribe to rooms
+	// without joining them
+	err = adapter.Publish(ctx, broadcast.Message{
+		Kind:    broadcast.KindRoom,
+		Room:    roomID,
+		Event:   event,
+		Data:    data,
+		Exclude: excludeUsers,
+		Origin:  s.nodeID,
+		ID:      id,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return targeted, nil
+}
+
+// EmitToUser publishes an event to a single user on whichever process holds its socket
+func (s *SocketClients) EmitToUser(userID, event, data string) error {
+	return s.Adapter().Publish(context.Background(), broadcast.Message{
+		Kind:   broadcast.KindUser,
+		UserID: userID,
+		Event:  event,
+		Data:   data,
+		Origin: s.nodeID,
+	})
+}
+
+// handleBroadcast applies a message received from the adapter to the clients
+// connected to this process.
+func (s *SocketClients) handleBroadcast(msg broadcast.Message) {
+	switch msg.Kind {
+	case broadcast.KindJoin:
+		s.addLocalRoom(msg.UserID, msg.Room)
+	case broadcast.KindLeave:
+		s.removeLocalRoom(msg.UserID, msg.Room)
+	case broadcast.KindUser:
+		if client, exists := s.GetClient(msg.UserID); exists && client.Socket != nil {
+			sendDirectMessage(client.Socket, msg.Event, msg.Data, msg.UserID)
+		}
+	case broadcast.KindRoom:
+		excludeMap := make(map[string]bool, len(msg.Exclude))
+		for _, userID := range msg.Exclude {
+			excludeMap[userID] = true
+		}
+		delivered := 0
+		roomKey := "room_" + msg.Room
+		for userID, client := range s.GetClients() {
+			if excludeMap[userID] || client.Socket == nil {
+				continue
+			}
+			if inRoom, ok := client.Attributes[roomKey].(bool); ok && inRoom {
+				if sendDirectMessage(client.Socket, msg.Event, msg.Data, userID) {
+					delivered++
+				}
+			}
+		}
+		// Exclude names users, which sse connections are not
+		delivered += deliverSSE(msg.Room, msg.Event, msg.Data, msg.ID)
+		debug.Debug(debug.Info, fmt.Sprintf("handleBroadcast: room=%s event=%s node=%s delivered=%d", msg.Room, msg.Event, s.nodeID, delivered))
+	default:
+		debug.Debug(debug.Warning, fmt.Sprintf("handleBroadcast: unknown message kind %q", msg.Kind))
+	}
+}
+
+// addLocalRoom mirrors a room membership onto a client connected to this process
+func (s *SocketClients) addLocalRoom(userID, roomID string) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	client, exists := s.clients[userID]
+	if !exists {
+		return
+	}
+	rooms, _ := client.Attributes["rooms"].([]string)
+	for _, room := range rooms {
+		if room == roomID {
+			return
+		}
+	}
+	client.Attributes["rooms"] = append(rooms, roomID)
+	client.Attributes["room_"+roomID] = true
+}
+
+// removeLocalRoom removes a room membership from a client connected to this process
+func (s *SocketClients) removeLocalRoom(userID, roomID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	client, exists := s.clients[userID]
+	if !exists {
+		return
+	}
+	rooms, _ := client.Attributes["rooms"].([]string)
+	var newRooms []string
+	for _, room := range rooms {
+		if room != roomID {
+			newRooms = append(newRooms, room)
+		}
+	}
+	client.Attributes["rooms"] = newRooms
+	delete(client.Attributes, "room_"+roomID)
+}
+
+// localUsers returns the user IDs connected to this process
+func (s *SocketClients) localUsers() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	users := make([]string, 0, len(s.clients))
+	for userID := range s.clients {
+		users = append(users, userID)
+	}
+	return users
+}
+
+// CleanupStaleConnections removes connections that haven't been seen for a specified duration
+func (s *SocketClients) CleanupStaleConnections(maxIdleTime time.Duration) []string {
 	var removedUsers []string
 	cutoff := time.Now().Add(-maxIdleTime)
 
+	s.mutex.RLock()
 	for userID, client := range s.clients {
 		if client.LastSeen.Before(cutoff) {
-			delete(s.clients, userID)
 			removedUsers = append(removedUsers, userID)
 		}
 	}
+	s.mutex.RUnlock()
 
+	for _, userID := range removedUsers {
+		s.RemoveClient(userID)
+	}
 	return removedUsers
 }
@@ -0,0 +1,330 @@
+package utilities
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/degreane/octopus/internal/utilities/debug"
+	"github.com/gofiber/fiber/v2"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// sseLocal is the Fiber local holding the connection of an sse route
+const sseLocal = "eocto_sse"
+
+const (
+	// sseHeartbeat is how often an idle connection gets a comment, which keeps
+	// proxies from closing it and notices clients that went away
+	sseHeartbeat = 15 * time.Second
+	// sseBuffer is the number of room events a slow client can fall behind by
+	// before further ones are dropped
+	sseBuffer = 64
+	// sseHistory is the number of recent events kept per room, which a
+	// client that reconnects receives again from the last one it got
+	sseHistory = 100
+	// sseHistoryAge is how long those events are kept
+	sseHistoryAge = 5 * time.Minute
+)
+
+// SSEConn is the connection of a request on an sse route. The route's scripts
+// queue events and subscribe it to rooms; once they have run, the events are
+// sent and the connection receives the events emitted to its rooms until the
+// client goes away.
+type SSEConn struct {
+	lastEventID string
+	retry       int
+	pending     strings.Builder
+	rooms       map[string]bool
+	events      chan string
+}
+
+// sseEvent is a room event kept for the clients that reconnect
+type sseEvent struct {
+	id    int64
+	at    time.Time
+	frame string
+}
+
+// sseRooms holds the connections of this process subscribed to each room and
+// the recent events of each room, oldest first. Every process receives every
+// room event, so each keeps the whole history whichever node the client
+// reconnects to.
+var sseRooms = struct {
+	sync.Mutex
+	members map[string]map[*SSEConn]bool
+	history map[string][]sseEvent
+	swept   time.Time
+}{members: make(map[string]map[*SSEConn]bool), history: make(map[string][]sseEvent)}
+
+// OpenSSE is the first handler of an sse route: it gives the request the
+// connection eocto.sse works on
+func OpenSSE(c *fiber.Ctx) error {
+	c.Locals(sseLocal, &SSEConn{
+		lastEventID: strings.Clone(c.Get("Last-Event-ID")),
+		rooms:       make(map[string]bool),
+		events:      make(chan string, sseBuffer),
+	})
+	return c.Next()
+}
+
+// SSEOf returns the connection of c, nil when c is not on an sse route
+func SSEOf(c *fiber.Ctx) *SSEConn {
+	conn, _ := c.Locals(sseLocal).(*SSEConn)
+	return conn
+}
+
+// ServeSSE answers c with the event stream of its connection. When live is
+// false the events queued by the scripts are sent and the response ends, which
+// is what the test harness and the console show.
+func ServeSSE(c *fiber.Ctx, live bool) error {
+	conn := SSEOf(c)
+	if conn == nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "not an sse route")
+	}
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	// Keeps nginx from buffering the stream
+	c.Set("X-Accel-Buffering", "no")
+	ApplyResponseHeaders(c)
+
+	head := conn.head()
+	if !live {
+		return c.SendString(head)
+	}
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		conn.serve(w, head)
+	})
+	return nil
+}
+
+// head returns the retry field and the events queued by the scripts
+func (conn *SSEConn) head() string {
+	var b strings.Builder
+	if conn.retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n\n", conn.retry)
+	}
+	b.WriteString(conn.pending.String())
+	return b.String()
+}
+
+// serve writes head and the room events the client missed, then the events
+// of the connection's rooms and a heartbeat, until a write fails
+func (conn *SSEConn) serve(w *bufio.Writer, head string) {
+	missed := joinSSERooms(conn)
+	defer leaveSSERooms(conn)
+
+	// The comment gets the headers to the client before any event
+	if _, err := w.WriteString(": connected\n\n" + head + strings.Join(missed, "")); err != nil {
+		return
+	}
+	if err := w.Flush(); err != nil {
+		return
+	}
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case frame := <-conn.events:
+			_, err = w.WriteString(frame)
+		case <-heartbeat.C:
+			_, err = w.WriteString(": ping\n\n")
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			debug.Debug(debug.Info, fmt.Sprintf("sse: client gone: %v", err))
+			return
+		}
+	}
+}
+
+// joinSSERooms subscribes conn to its rooms and returns the frames of the
+// room events emitted after its Last-Event-ID, in order. Both happen under
+// the lock deliverSSE takes, so no event is missed or sent twice in between.
+func joinSSERooms(conn *SSEConn) []string {
+	sseRooms.Lock()
+	defer sseRooms.Unlock()
+	// Only a room event ID can be resumed from, not one sent by a script
+	after, err := strconv.ParseInt(conn.lastEventID, 10, 64)
+	var missed []sseEvent
+	for room := range conn.rooms {
+		if sseRooms.members[room] == nil {
+			sseRooms.members[room] = make(map[*SSEConn]bool)
+		}
+		sseRooms.members[room][conn] = true
+		if err != nil {
+			continue
+		}
+		for _, event := range sseRooms.history[room] {
+			if event.id > after && time.Since(event.at) <= sseHistoryAge {
+				missed = append(missed, event)
+			}
+		}
+	}
+	// Events of several rooms interleave, and publishes from different
+	// processes can arrive out of order
+	sort.Slice(missed, func(i, j int) bool { return missed[i].id < missed[j].id })
+	frames := make([]string, len(missed))
+	for i, event := range missed {
+		frames[i] = event.frame
+	}
+	return frames
+}
+
+// leaveSSERooms unsubscribes conn from its rooms
+func leaveSSERooms(conn *SSEConn) {
+	sseRooms.Lock()
+	defer sseRooms.Unlock()
+	for room := range conn.rooms {
+		delete(sseRooms.members[room], conn)
+		if len(sseRooms.members[room]) == 0 {
+			delete(sseRooms.members, room)
+		}
+	}
+}
+
+// deliverSSE sends an event to the connections of this process subscribed to
+// room and returns how many got it. A connection whose buffer is full misses
+// the event rather than holding up the others. An event with an ID is kept in
+// the room's history for the clients that reconnect.
+func deliverSSE(room, event, data string, id int64) int {
+	sseRooms.Lock()
+	defer sseRooms.Unlock()
+	var frame string
+	if id > 0 {
+		frame = sseFrame(event, data, strconv.FormatInt(id, 10))
+		recordSSE(room, id, frame)
+	} else {
+		frame = sseFrame(event, data, "")
+	}
+	delivered := 0
+	for conn := range sseRooms.members[room] {
+		select {
+		case conn.events <- frame:
+			delivered++
+		default:
+			debug.Debug(debug.Warning, fmt.Sprintf("sse: room=%s event=%s dropped for a slow client", room, event))
+		}
+	}
+	return delivered
+}
+
+// recordSSE adds an event to the history of room, keeping the last sseHistory,
+// and drops the histories of rooms quiet for longer than sseHistoryAge. The
+// caller holds sseRooms.
+func recordSSE(room string, id int64, frame string) {
+	now := time.Now()
+	events := append(sseRooms.history[room], sseEvent{id: id, at: now, frame: frame})
+	if len(events) > sseHistory {
+		events = events[len(events)-sseHistory:]
+	}
+	sseRooms.history[room] = events
+
+	if now.Sub(sseRooms.swept) < sseHistoryAge {
+		return
+	}
+	sseRooms.swept = now
+	for name, events := range sseRooms.history {
+		if now.Sub(events[len(events)-1].at) > sseHistoryAge {
+			delete(sseRooms.history, name)
+		}
+	}
+}
+
+// sseFrame encodes an event in the text/event-stream format, one data line
+// per line of data
+func sseFrame(event, data, id string) string {
+	var b strings.Builder
+	if id != "" {
+		b.WriteString("id: " + sseField(id) + "\n")
+	}
+	if event != "" && event != "message" {
+		b.WriteString("event: " + sseField(event) + "\n")
+	}
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// sseField drops the line breaks that would end a field early
+func sseField(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// NewSSETable builds the eocto.sse table. ctx returns the request the calling
+// script serves, which must be on an sse route.
+//
+// Usage in Lua:
+//
+//	local job = eocto.getPath():match("[^/]+$")
+//	if eocto.sse.lastEventId() == nil then
+//	    eocto.sse.send("progress", "0", "1")
+//	end
+//	eocto.sse.subscribe("job:" .. job)
+func NewSSETable(L *lua.LState, ctx func() *fiber.Ctx) *lua.LTable {
+	conn := func(L *lua.LState) *SSEConn {
+		conn := SSEOf(ctx())
+		if conn == nil {
+			L.RaiseError("eocto.sse is only available on sse routes")
+		}
+		return conn
+	}
+	tbl := L.NewTable()
+	tbl.RawSetString("send", L.NewFunction(func(L *lua.LState) int {
+		c := conn(L)
+		event := L.CheckString(1)
+		data, err := sseData(L.Get(2))
+		if err != nil {
+			L.ArgError(2, err.Error())
+		}
+		c.pending.WriteString(sseFrame(event, data, L.OptString(3, "")))
+		return 0
+	}))
+	tbl.RawSetString("subscribe", L.NewFunction(func(L *lua.LState) int {
+		conn(L).rooms[L.CheckString(1)] = true
+		return 0
+	}))
+	tbl.RawSetString("unsubscribe", L.NewFunction(func(L *lua.LState) int {
+		delete(conn(L).rooms, L.CheckString(1))
+		return 0
+	}))
+	tbl.RawSetString("lastEventId", L.NewFunction(func(L *lua.LState) int {
+		if id := conn(L).lastEventID; id != "" {
+			L.Push(lua.LString(id))
+		} else {
+			L.Push(lua.LNil)
+		}
+		return 1
+	}))
+	tbl.RawSetString("retry", L.NewFunction(func(L *lua.LState) int {
+		conn(L).retry = L.CheckInt(1)
+		return 0
+	}))
+	return tbl
+}
+
+// sseData converts the data of an event sent from Lua: strings are sent as
+// they are, other values as JSON
+func sseData(v lua.LValue) (string, error) {
+	switch v := v.(type) {
+	case *lua.LNilType:
+		return "", nil
+	case lua.LString:
+		return string(v), nil
+	case lua.LNumber:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64), nil
+	}
+	data, err := json.Marshal(convertLuaValueToGo(v))
+	return string(data), err
+}
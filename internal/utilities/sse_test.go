@@ -0,0 +1,73 @@
+package utilities
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSSEReplay(t *testing.T) {
+	deliverSSE("replay:a", "step", "1", 1)
+	deliverSSE("replay:b", "step", "2", 2)
+	deliverSSE("replay:a", "step", "3", 3)
+	deliverSSE("replay:a", "step", "no id", 0)
+	// Published by another process before 4 but received after it
+	deliverSSE("replay:b", "step", "5", 5)
+	deliverSSE("replay:a", "step", "4", 4)
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		rooms       []string
+		want        []string
+	}{
+		{"first connection", "", []string{"replay:a"}, nil},
+		{"one room", "1", []string{"replay:a"}, []string{"3", "4"}},
+		{"rooms interleaved in ID order", "1", []string{"replay:a", "replay:b"}, []string{"2", "3", "4", "5"}},
+		{"up to date", "5", []string{"replay:a", "replay:b"}, nil},
+		{"script event ID", "intro", []string{"replay:a"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &SSEConn{lastEventID: tt.lastEventID, rooms: make(map[string]bool), events: make(chan string, sseBuffer)}
+			for _, room := range tt.rooms {
+				conn.rooms[room] = true
+			}
+			missed := joinSSERooms(conn)
+			defer leaveSSERooms(conn)
+			var ids []string
+			for _, frame := range missed {
+				ids = append(ids, strings.TrimPrefix(strings.SplitN(frame, "\n", 2)[0], "id: "))
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("replayed %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	t.Run("live events follow the replay", func(t *testing.T) {
+		conn := &SSEConn{lastEventID: "4", rooms: map[string]bool{"replay:a": true}, events: make(chan string, sseBuffer)}
+		joinSSERooms(conn)
+		defer leaveSSERooms(conn)
+		if n := deliverSSE("replay:a", "step", "6", 6); n != 1 {
+			t.Fatalf("delivered to %d connections, want 1", n)
+		}
+		if frame := <-conn.events; frame != "id: 6\nevent: step\ndata: 6\n\n" {
+			t.Errorf("frame = %q", frame)
+		}
+	})
+}
+
+func TestSSEHistoryBound(t *testing.T) {
+	for id := int64(1); id <= sseHistory+10; id++ {
+		deliverSSE("bound", "tick", "", id)
+	}
+	sseRooms.Lock()
+	events := sseRooms.history["bound"]
+	sseRooms.Unlock()
+	if len(events) != sseHistory {
+		t.Fatalf("kept %d events, want %d", len(events), sseHistory)
+	}
+	if events[0].id != 11 {
+		t.Errorf("oldest kept event = %d, want 11", events[0].id)
+	}
+}
@@ -0,0 +1,124 @@
+package utilities
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+
+	"github.com/degreane/octopus/internal/service/limits"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	"github.com/gofiber/fiber/v2"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// streamBody is a function eocto.stream answers with. It runs once the
+// response headers are sent, on the state of the script that set it; handoff
+// delivers that state, and the function releasing it, when the request's
+// handlers are done with it. limits is the context of the script, whose
+// deadline and instruction budget the function runs under.
+type streamBody struct {
+	L       *lua.LState
+	fn      *lua.LFunction
+	limits  context.Context
+	started bool
+	handoff chan func()
+}
+
+// Stream returns a Lua function that answers with chunks written by a Lua
+// function instead of the view. The function runs after the request's
+// scripts, once the headers are sent: the request functions (sessions,
+// cookies, eocto.response) are no longer available to it. It must finish
+// within the timeout of the script that called eocto.stream and shares its
+// instruction budget.
+//
+// Usage in Lua:
+//
+//	local rows = eocto.getDataFromCollection(uri, "shop", "orders", {})
+//	eocto.response.header("Content-Disposition", 'attachment; filename="orders.csv"')
+//	eocto.stream(function(write, flush)
+//	    write("id,total\n")
+//	    for _, row in ipairs(rows) do
+//	        write(row._id .. "," .. row.total .. "\n")
+//	    end
+//	end, "text/csv")
+func Stream(c *fiber.Ctx) lua.LGFunction {
+	return func(L *lua.LState) int {
+		fn := L.CheckFunction(1)
+		contentType := L.OptString(2, fiber.MIMETextPlainCharsetUTF8)
+		resp := setBody(c, L, 0, contentType, nil)
+		resp.stream = &streamBody{L: L, fn: fn, limits: stateContext(L), handoff: make(chan func(), 1)}
+		return 0
+	}
+}
+
+// startStream makes s the body of c. Its function waits for DetachStream
+// before it runs.
+func startStream(c *fiber.Ctx, s *streamBody) {
+	s.started = true
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		release := <-s.handoff
+		if release == nil {
+			return
+		}
+		defer release()
+		s.run(w)
+	})
+}
+
+// abandon gives up a started stream whose response was replaced: its
+// function never runs, and the Lua state stays with the request, which
+// returns it to the pool as DetachStream no longer finds the stream
+func (s *streamBody) abandon() {
+	if s.started {
+		s.handoff <- nil
+	}
+}
+
+// DetachStream hands the Lua state of the request to the stream it started,
+// which calls release once it is done with the state. It reports whether
+// there was such a stream; when there was not, the caller keeps the state.
+// Every handler binding a state to requests must call it when the request no
+// longer needs the state.
+func DetachStream(c *fiber.Ctx, release func()) bool {
+	resp := ResponseOf(c)
+	if resp == nil || resp.stream == nil || !resp.stream.started {
+		return false
+	}
+	resp.stream.handoff <- release
+	return true
+}
+
+// run calls the stream function with write and flush; writing after the
+// client went away raises an error that unwinds it
+func (s *streamBody) run(w *bufio.Writer) {
+	L := s.L
+	ctx, cancel := limits.Deadline(s.limits)
+	defer cancel()
+	L.SetContext(limits.Inherit(s.limits, ctx))
+	defer L.RemoveContext()
+
+	fail := func(L *lua.LState, err error) {
+		cancel()
+		L.RaiseError("stream: client gone: %v", err)
+	}
+	write := L.NewFunction(func(L *lua.LState) int {
+		for i := 1; i <= L.GetTop(); i++ {
+			if _, err := w.WriteString(L.CheckString(i)); err != nil {
+				fail(L, err)
+			}
+		}
+		return 0
+	})
+	flush := L.NewFunction(func(L *lua.LState) int {
+		if err := w.Flush(); err != nil {
+			fail(L, err)
+		}
+		return 0
+	})
+
+	top := L.GetTop()
+	if err := L.CallByParam(lua.P{Fn: s.fn, NRet: 0, Protect: true}, write, flush); err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("Error streaming the response: %v", err))
+	}
+	L.SetTop(top)
+}
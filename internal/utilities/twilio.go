@@ -75,9 +75,6 @@ func SendWhatsAppMessage(toNumber, message string) error {
 		return fmt.Errorf("error sending WhatsApp message: %w", err)
 	}
 
-	if err != nil { // Explicitly check the status code
-		return fmt.Errorf("failed to send message Twilio API returned status: %s, body: %s", resp.Status, *resp.Body)
-	}
 	response, _ := json.Marshal(*resp)
 	log.Printf("Twilio response: %s", string(response))
 	// log.Printf("Twilio response: %+v", resp.Body)
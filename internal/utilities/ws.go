@@ -42,48 +42,36 @@ func WsAddRoom(c *fiber.Ctx) lua.LGFunction {
 		// Get global socket clients instance
 		clients := GetSocketClients()
 
-		// Check if user exists in clients
-		_, exists := clients.GetClient(userId)
-		if !exists {
+		// Check if user is connected to any process
+		if !clients.IsUserConnected(userId) {
 			debug.Debug(debug.Warning, fmt.Sprintf("WsAddRoom: User %s not found in clients", userId))
 			L.Push(lua.LBool(false))
 			L.Push(lua.LString("user not found"))
 			return 2
 		}
 
-		// Get existing rooms or create new slice
-		var rooms []string
-		if existingRoomsInterface, ok := clients.GetClientAttribute(userId, "rooms"); ok {
-			if existingRooms, ok := existingRoomsInterface.([]string); ok {
-				rooms = existingRooms
-			} else {
-				// Handle case where rooms metadata exists but is not []string
-				rooms = []string{}
-			}
-		} else {
-			// No rooms metadata exists, create new slice
-			rooms = []string{}
-		}
-
 		// Check if room already exists for this user
-		for _, existingRoom := range rooms {
-			if existingRoom == roomId {
-				debug.Debug(debug.Info, fmt.Sprintf("WsAddRoom: User %s already in room %s", userId, roomId))
-				L.Push(lua.LBool(true))
-				L.Push(lua.LString("user already in room"))
-				return 2
-			}
+		inRoom, err := clients.IsUserInRoom(userId, roomId)
+		if err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("WsAddRoom: error=%v", err))
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		if inRoom {
+			debug.Debug(debug.Info, fmt.Sprintf("WsAddRoom: User %s already in room %s", userId, roomId))
+			L.Push(lua.LBool(true))
+			L.Push(lua.LString("user already in room"))
+			return 2
 		}
 
-		// Add the room to the user's rooms
-		rooms = append(rooms, roomId)
-
-		// Set the updated rooms metadata
-		clients.SetClientAttribute(userId, "rooms", rooms)
-
-		// Also set individual room metadata for easier lookup
-		roomKey := "room_" + roomId
-		clients.SetClientAttribute(userId, roomKey, true)
+		// Join the room; the membership is propagated to every process
+		if err := clients.JoinRoom(userId, roomId); err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("WsAddRoom: error=%v", err))
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
 
 		debug.Debug(debug.Info, fmt.Sprintf("WsAddRoom: Successfully added user %s to room %s", userId, roomId))
 
@@ -109,44 +97,30 @@ func WsRemoveRoom(c *fiber.Ctx) lua.LGFunction {
 		clients := GetSocketClients()
 
 		// Check if user exists
-		_, exists := clients.GetClient(userId)
-		if !exists {
+		if !clients.IsUserConnected(userId) {
 			L.Push(lua.LBool(false))
 			L.Push(lua.LString("user not found"))
 			return 2
 		}
 
-		// Get existing rooms
-		var rooms []string
-		if existingRoomsInterface, ok := clients.GetClientAttribute(userId, "rooms"); ok {
-			if existingRooms, ok := existingRoomsInterface.([]string); ok {
-				rooms = existingRooms
-			}
-		}
-
-		// Remove the room from the slice
-		var newRooms []string
-		roomFound := false
-		for _, existingRoom := range rooms {
-			if existingRoom != roomId {
-				newRooms = append(newRooms, existingRoom)
-			} else {
-				roomFound = true
-			}
+		roomFound, err := clients.IsUserInRoom(userId, roomId)
+		if err != nil {
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString(err.Error()))
+			return 2
 		}
-
 		if !roomFound {
 			L.Push(lua.LBool(false))
 			L.Push(lua.LString("user not in room"))
 			return 2
 		}
 
-		// Update rooms metadata
-		clients.SetClientAttribute(userId, "rooms", newRooms)
-
-		// Remove individual room metadata
-		roomKey := "room_" + roomId
-		clients.SetClientAttribute(userId, roomKey, nil)
+		// Leave the room; the membership is propagated to every process
+		if err := clients.LeaveRoom(userId, roomId); err != nil {
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
 
 		debug.Debug(debug.Info, fmt.Sprintf("WsRemoveRoom: Successfully removed user %s from room %s", userId, roomId))
 
@@ -170,19 +144,18 @@ func WsGetUserRooms(c *fiber.Ctx) lua.LGFunction {
 		clients := GetSocketClients()
 
 		// Check if user exists
-		_, exists := clients.GetClient(userId)
-		if !exists {
+		if !clients.IsUserConnected(userId) {
 			L.Push(lua.LNil)
 			L.Push(lua.LString("user not found"))
 			return 2
 		}
 
-		// Get rooms metadata
-		var rooms []string
-		if existingRoomsInterface, ok := clients.GetClientAttribute(userId, "rooms"); ok {
-			if existingRooms, ok := existingRoomsInterface.([]string); ok {
-				rooms = existingRooms
-			}
+		// Get rooms from the shared membership state
+		rooms, err := clients.GetUserRooms(userId)
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
 		}
 
 		// Convert to Lua table
@@ -212,21 +185,22 @@ func WsIsUserInRoom(c *fiber.Ctx) lua.LGFunction {
 		clients := GetSocketClients()
 
 		// Check if user exists
-		_, exists := clients.GetClient(userId)
-		if !exists {
+		if !clients.IsUserConnected(userId) {
 			L.Push(lua.LBool(false))
 			L.Push(lua.LString("user not found"))
 			return 2
 		}
 
-		// Check individual room metadata for faster lookup
-		roomKey := "room_" + roomId
-		if roomValue, ok := clients.GetClientAttribute(userId, roomKey); ok {
-			if inRoom, ok := roomValue.(bool); ok && inRoom {
-				L.Push(lua.LBool(true))
-				L.Push(lua.LString("user is in room"))
-				return 2
-			}
+		inRoom, err := clients.IsUserInRoom(userId, roomId)
+		if err != nil {
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		if inRoom {
+			L.Push(lua.LBool(true))
+			L.Push(lua.LString("user is in room"))
+			return 2
 		}
 
 		L.Push(lua.LBool(false))
@@ -235,55 +209,57 @@ func WsIsUserInRoom(c *fiber.Ctx) lua.LGFunction {
 	}
 }
 
-// WSEmitToRoom emits a message to all users in a specific room
+// WSEmitToRoom emits a message to all users in a specific room, on every process.
+// It returns the number of room members targeted by the emit.
 func WSEmitToRoom(roomId string, event string, data interface{}, excludeUsers ...string) int {
-	clients := GetSocketClients()
-	allClients := clients.GetClients()
-
-	excludeMap := make(map[string]bool)
-	for _, userID := range excludeUsers {
-		excludeMap[userID] = true
+	dataStr, err := encodeMessageData(data)
+	if err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("WSEmitToRoom: marshal data failed room=%s error=%v", roomId, err))
+		return 0
 	}
 
-	successCount := 0
-	roomKey := "room_" + roomId
+	count, err := GetSocketClients().EmitToRoom(roomId, event, dataStr, excludeUsers...)
+	if err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("WSEmitToRoom: room=%s event=%s error=%v", roomId, event, err))
+		return 0
+	}
 
-	for userID, client := range allClients {
-		// Skip excluded users
-		if excludeMap[userID] {
-			continue
-		}
+	debug.Debug(debug.Info, fmt.Sprintf("WSEmitToRoom: room=%s event=%s targeted=%d", roomId, event, count))
+	return count
+}
 
-		// Check if user is in the room
-		if roomValue, ok := clients.GetClientAttribute(userID, roomKey); ok {
-			if inRoom, ok := roomValue.(bool); ok && inRoom && client.Socket != nil {
-				if sendDirectMessage(client.Socket, event, data, userID) {
-					successCount++
-				}
-			}
-		}
+// WSEmitToUser emits a message to a single user, on whichever process holds its socket
+func WSEmitToUser(userId string, event string, data interface{}) error {
+	dataStr, err := encodeMessageData(data)
+	if err != nil {
+		return err
 	}
-
-	debug.Debug(debug.Info, fmt.Sprintf("WSEmitToRoom: room=%s event=%s delivered=%d", roomId, event, successCount))
-	return successCount
+	return GetSocketClients().EmitToUser(userId, event, dataStr)
 }
 
-func sendDirectMessage(socket *socketio.Websocket, event string, data interface{}, userID string) bool {
-	// Convert data to string if it's not already
-	var dataStr string
+// encodeMessageData converts emit data to the string carried by MessageObject
+func encodeMessageData(data interface{}) (string, error) {
 	switch v := data.(type) {
 	case string:
-		dataStr = v
+		return v, nil
 	case []byte:
-		dataStr = string(v)
+		return string(v), nil
 	default:
 		// Marshal to JSON string if it's a complex type
 		jsonBytes, err := json.Marshal(v)
 		if err != nil {
-			debug.Debug(debug.Error, fmt.Sprintf("sendDirectMessage: marshal data failed user=%s error=%v", userID, err))
-			return false
+			return "", err
 		}
-		dataStr = string(jsonBytes)
+		return string(jsonBytes), nil
+	}
+}
+
+func sendDirectMessage(socket *socketio.Websocket, event string, data interface{}, userID string) bool {
+	// Convert data to string if it's not already
+	dataStr, err := encodeMessageData(data)
+	if err != nil {
+		debug.Debug(debug.Error, fmt.Sprintf("sendDirectMessage: marshal data failed user=%s error=%v", userID, err))
+		return false
 	}
 
 	// Create the message using your MessageObject struct
@@ -368,6 +344,54 @@ func WsEmitToRoom(c *fiber.Ctx) lua.LGFunction {
 	}
 }
 
+// WsEmitToUser Lua function wrapper for WSEmitToUser
+func WsEmitToUser(c *fiber.Ctx) lua.LGFunction {
+	return func(L *lua.LState) int {
+		userId := L.CheckString(1)
+		event := L.CheckString(2)
+
+		if userId == "" || event == "" {
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString("userId and event cannot be empty"))
+			return 2
+		}
+
+		var data interface{}
+		dataValue := L.Get(3)
+		switch dataValue.Type() {
+		case lua.LTString:
+			data = dataValue.String()
+		case lua.LTNumber:
+			data = float64(dataValue.(lua.LNumber))
+		case lua.LTBool:
+			data = bool(dataValue.(lua.LBool))
+		case lua.LTTable:
+			data = luaTableToMap2(L, dataValue.(*lua.LTable))
+		case lua.LTNil:
+			data = nil
+		default:
+			data = dataValue.String()
+		}
+
+		if !GetSocketClients().IsUserConnected(userId) {
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString("user not found"))
+			return 2
+		}
+
+		if err := WSEmitToUser(userId, event, data); err != nil {
+			debug.Debug(debug.Error, fmt.Sprintf("WsEmitToUser: user=%s event=%s error=%v", userId, event, err))
+			L.Push(lua.LBool(false))
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+
+		L.Push(lua.LBool(true))
+		L.Push(lua.LString("success"))
+		return 2
+	}
+}
+
 // Helper function to convert Lua table to Go map
 func luaTableToMap2(L *lua.LState, table *lua.LTable) map[string]interface{} {
 	result := make(map[string]interface{})
@@ -0,0 +1,281 @@
+// Package api is the public interface for extending the eocto table from Go.
+//
+// An extension owns a namespace of the eocto table: an extension named "acme"
+// adds its functions as eocto.acme.*. Extensions live in their own packages
+// and register themselves at startup, usually from an init function of a
+// package blank-imported by the server binary:
+//
+//	package acme
+//
+//	func init() {
+//		api.MustRegister(Extension{})
+//	}
+//
+//	type Extension struct{}
+//
+//	func (Extension) Name() string { return "acme" }
+//
+//	func (Extension) Register(reg api.Registry) {
+//		reg.Func(api.Function{
+//			Name: "greet",
+//			Doc:  "Greet the current user",
+//			Call: func(ctx *api.Context, L *lua.LState) int {
+//				ctx.Log.Info("greeting from %s", ctx.Module.Name)
+//				L.Push(lua.LString("hello " + L.CheckString(1)))
+//				return 1
+//			},
+//		})
+//	}
+//
+// Functions receive a Context carrying the request (or WebSocket connection),
+// the settings of the module running the script and a logger tagged with the
+// extension and module names.
+package api
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/degreane/octopus/config"
+	"github.com/degreane/octopus/internal/bindings"
+	"github.com/degreane/octopus/internal/service/logger"
+	"github.com/degreane/octopus/internal/utilities/debug"
+	"github.com/gofiber/contrib/socketio"
+	"github.com/gofiber/fiber/v2"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Kind is a set of contexts a script runs in
+type Kind uint8
+
+const (
+	// HTTP is a preCheck script serving a request
+	HTTP = Kind(bindings.HTTP)
+	// WS is a script serving a WebSocket connection
+	WS = Kind(bindings.WS)
+	// Job is a scheduled or queued job
+	Job = Kind(bindings.Job)
+	// CLI is a script run from the octopus command
+	CLI = Kind(bindings.CLI)
+	// All is every context
+	All = Kind(bindings.All)
+)
+
+// String lists the contexts, e.g. "http|ws"
+func (k Kind) String() string {
+	return bindings.Context(k).String()
+}
+
+// Logger is the logger handed to extension functions
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// Context is what an extension function is called with
+type Context struct {
+	// Kind is the context the script runs in
+	Kind Kind
+	// Request is the request served by an HTTP script, nil otherwise
+	Request *fiber.Ctx
+	// Socket is the connection served by a WebSocket script, nil otherwise
+	Socket *socketio.Websocket
+	// Module is the configuration of the module running the script
+	Module config.ModulesConfig
+	// Log is tagged with the extension and module names
+	Log Logger
+}
+
+// Context returns the context of the request being served, or
+// context.Background() outside HTTP scripts
+func (c *Context) Context() context.Context {
+	if c.Request != nil {
+		return c.Request.UserContext()
+	}
+	return context.Background()
+}
+
+// Param documents a parameter or a return value. As in EmmyLua annotations,
+// a name ending in "?" is optional and "..." is variadic.
+type Param struct {
+	Name string
+	Type string
+	Doc  string
+}
+
+// Function is a function of an extension namespace
+type Function struct {
+	// Name is the key in the namespace table
+	Name string
+	// Kinds are the contexts the function is available in (all when zero)
+	Kinds Kind
+	// Call implements the function; it follows the gopher-lua calling
+	// convention and returns the number of values pushed
+	Call func(ctx *Context, L *lua.LState) int
+
+	// Doc, Params, Returns and Example feed `octopus gen lua-defs`
+	Doc     string
+	Params  []Param
+	Returns []Param
+	Example string
+}
+
+// Registry collects the functions of an extension
+type Registry interface {
+	// Func adds a function to the extension namespace
+	Func(fn Function)
+	// Doc documents the namespace table itself
+	Doc(doc string)
+}
+
+// Extension adds a namespace of functions to the eocto table
+type Extension interface {
+	// Name is the namespace, a Lua identifier such as "acme"
+	Name() string
+	// Register adds the extension's functions to reg
+	Register(reg Registry)
+}
+
+var (
+	mutex      sync.Mutex
+	extensions = map[string]bool{}
+	identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// registry implements Registry for one extension
+type registry struct {
+	name      string
+	doc       string
+	functions []Function
+	err       error
+}
+
+func (r *registry) Func(fn Function) {
+	switch {
+	case r.err != nil:
+	case !identifier.MatchString(fn.Name):
+		r.err = fmt.Errorf("api: extension %s: invalid function name %q", r.name, fn.Name)
+	case fn.Call == nil:
+		r.err = fmt.Errorf("api: extension %s: function %s has no Call", r.name, fn.Name)
+	default:
+		for _, existing := range r.functions {
+			if existing.Name == fn.Name {
+				r.err = fmt.Errorf("api: extension %s: function %s registered twice", r.name, fn.Name)
+				return
+			}
+		}
+		if fn.Kinds == 0 {
+			fn.Kinds = All
+		}
+		r.functions = append(r.functions, fn)
+	}
+}
+
+func (r *registry) Doc(doc string) {
+	r.doc = doc
+}
+
+// Register installs ext as eocto.<name> in every context one of its functions
+// supports. It fails when the name is not a Lua identifier or is already
+// taken by a core binding or another extension. Extensions must be
+// registered before the server builds its routes.
+func Register(ext Extension) error {
+	name := ext.Name()
+	if !identifier.MatchString(name) {
+		return fmt.Errorf("api: invalid extension name %q", name)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if extensions[name] {
+		return fmt.Errorf("api: extension %s registered twice", name)
+	}
+	for _, b := range bindings.Registered() {
+		if b.Name == name && b.Contexts != 0 {
+			return fmt.Errorf("api: extension %s clashes with eocto.%s", name, name)
+		}
+	}
+
+	reg := &registry{name: name}
+	ext.Register(reg)
+	if reg.err != nil {
+		return reg.err
+	}
+	if len(reg.functions) == 0 {
+		return fmt.Errorf("api: extension %s registers no functions", name)
+	}
+
+	var kinds Kind
+	fields := make([]bindings.Binding, len(reg.functions))
+	for i, fn := range reg.functions {
+		kinds |= fn.Kinds
+		fields[i] = bindings.Binding{
+			Name:     fn.Name,
+			Contexts: bindings.Context(fn.Kinds),
+			Doc:      fn.Doc,
+			Params:   params(fn.Params),
+			Returns:  params(fn.Returns),
+			Example:  fn.Example,
+		}
+	}
+	extensions[name] = true
+	bindings.Register(bindings.Binding{
+		Name:     name,
+		Contexts: bindings.Context(kinds),
+		Group:    "Extensions",
+		Doc:      reg.doc,
+		Fields:   fields,
+		Table:    namespace(name, reg.functions),
+	})
+	debug.Debug(debug.Info, fmt.Sprintf("api: registered extension eocto.%s (%d functions)", name, len(reg.functions)))
+	return nil
+}
+
+// MustRegister is Register for init functions: it panics on error
+func MustRegister(ext Extension) {
+	if err := Register(ext); err != nil {
+		panic(err)
+	}
+}
+
+// namespace builds the table of an extension, holding the functions
+// available in the context of the state
+func namespace(name string, functions []Function) func(L *lua.LState, current func() *bindings.Env) *lua.LTable {
+	return func(L *lua.LState, current func() *bindings.Env) *lua.LTable {
+		tbl := L.NewTable()
+		kind := Kind(current().Context)
+		for _, fn := range functions {
+			if fn.Kinds&kind == 0 {
+				continue
+			}
+			call := fn.Call
+			tbl.RawSetString(fn.Name, L.NewFunction(func(L *lua.LState) int {
+				env := current()
+				return call(&Context{
+					Kind:    Kind(env.Context),
+					Request: env.Ctx,
+					Socket:  env.Ws,
+					Module:  env.Module,
+					Log:     logger.WithFields(map[string]interface{}{"extension": name, "module": env.Module.Name}),
+				}, L)
+			}))
+		}
+		return tbl
+	}
+}
+
+// params converts documented parameters to the registry's form
+func params(list []Param) []bindings.Param {
+	if list == nil {
+		return nil
+	}
+	out := make([]bindings.Param, len(list))
+	for i, p := range list {
+		out[i] = bindings.Param{Name: p.Name, Type: p.Type, Doc: p.Doc}
+	}
+	return out
+}
@@ -0,0 +1,6 @@
+-- Publishes the logged-in user to the view as `user` and `loggedIn`.
+-- It guards nothing: the /OC pages are public documentation, so anonymous
+-- requests go through. Routes that need a user run auth/isLoggedIn.lua.
+local user = eocto.getSession("user")
+eocto.setLocal("user", user)
+eocto.setLocal("loggedIn", user ~= nil)
@@ -0,0 +1,12 @@
+-- Guards a route: anonymous requests are answered with 401 and never reach
+-- the route. The login flow stores the user in the session as `user`.
+local user = eocto.getSession("user")
+if user == nil then
+    if eocto.abort == nil then
+        -- WebSocket messages: the error rejects the message
+        error("not logged in")
+    end
+    return eocto.abort(401, "login required")
+end
+eocto.setLocal("user", user)
+eocto.setLocal("loggedIn", true)
@@ -4,7 +4,7 @@
 --- DateTime: 10/17/25 4:43 PM
 ---
 
-local pp = require('views.utils.prettyPrinter')
+local pp = require('prettyPrinter')
 pp.print(eocto.getLocals())
 eocto.deleteLocal("project")
 eocto.deleteLocal("projects")
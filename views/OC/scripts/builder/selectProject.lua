@@ -3,7 +3,7 @@
 --- Created by fbanna.
 --- DateTime: 10/17/25 7:35 PM
 ---
-local pp = require('views.utils.prettyPrinter')
+local pp = require('prettyPrinter')
 local projects = eocto.getLocal("projects")
 local project = {
     ["proceed"] = true,
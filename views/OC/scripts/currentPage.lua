@@ -1,4 +1,4 @@
-local Inspect = require('views.utils.inspect')
+local Inspect = require('inspect')
 local path=eocto.getPath()
 print("path: " .. path)
 local paths={
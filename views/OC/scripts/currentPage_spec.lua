@@ -0,0 +1,32 @@
+-- Run with `octopus test`
+describe("currentPage.lua", function()
+  it("names the page of a known path", function()
+    local res = run("currentPage.lua", request{ path = "/OC/lua" })
+    assert.equals("lua", res.locals.currentPage)
+    assert.is_true(res.next)
+  end)
+
+  it("leaves unknown paths unnamed", function()
+    local res = run("currentPage.lua", request{ path = "/OC/nowhere" })
+    assert.is_nil(res.locals.currentPage)
+  end)
+
+  it("renders the route's view", function()
+    local res = run(request{ path = "/OC/yaml" })
+    assert.equals(200, res.status)
+    assert.matches("pages/yaml$", res.view)
+  end)
+end)
+
+describe("stubs", function()
+  it("replace bindings reaching external services", function()
+    local redis = stub(eocto, "getRedis", "cached")
+    assert.equals("cached", eocto.getRedis("visits"))
+    assert.equals(1, #redis.calls)
+    assert.equals("visits", redis.calls[1][1])
+  end)
+
+  it("are reverted after each test", function()
+    assert.has_error(function() eocto.getRedis("visits") end, "reaches an external service")
+  end)
+end)
@@ -0,0 +1,9 @@
+-- Publishes the client details the views show as `client_info`
+if eocto.getHeader == nil then
+    -- WebSocket messages carry no request headers
+    return
+end
+eocto.setLocal("client_info", {
+    userAgent = eocto.getHeader("User-Agent"),
+    ip = eocto.getHeader("X-Real-IP") or eocto.getHeader("X-Forwarded-For"),
+})